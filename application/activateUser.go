@@ -27,7 +27,7 @@ func (app *Application) activateUserHandler(writer http.ResponseWriter, request
 		return
 	}
 
-	user, err := app.Models.Users.GetForToken(data.ScopeActivation, input.TokenPlain)
+	user, err := app.Models.Users.GetForToken(request.Context(), data.ScopeActivation, input.TokenPlain)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -44,7 +44,7 @@ func (app *Application) activateUserHandler(writer http.ResponseWriter, request
 
 	// Save the updated user record in our database, checking for any edit conflicts in
 	// the same way that we did for our movie records.
-	err = app.Models.Users.Update(user)
+	err = app.Models.Users.Update(request.Context(), user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -55,16 +55,16 @@ func (app *Application) activateUserHandler(writer http.ResponseWriter, request
 		return
 	}
 
-	// If everything went successfully, then we delete all activation tokens for the
-	// user.
-	err = app.Models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	// If everything went successfully, then we queue deleting all activation tokens for
+	// the user.
+	err = enqueue(app, request.Context(), userTokensJob, userTokens{UserID: user.ID, Scope: data.ScopeActivation})
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 		return
 	}
 
 	// Send the updated user details to the client in a JSON response.
-	err = app.writeJSON(writer, http.StatusOK, envelope{"user": user}, nil)
+	err = app.writeResponse(writer, request, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 		return
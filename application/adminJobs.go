@@ -0,0 +1,139 @@
+package application
+
+import (
+	"Meow/internal/data"
+	"Meow/internal/validator"
+	"errors"
+	"net/http"
+	"sort"
+)
+
+// The showJobQueuesHandler() returns the depth of every job queue, and the number of
+// workers of the queues this server runs.
+func (app *Application) showJobQueuesHandler(writer http.ResponseWriter, request *http.Request) {
+	stats, err := app.Models.Jobs.Stats(request.Context())
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+
+	type queue struct {
+		*data.QueueStats
+		Workers int `json:"workers"`
+	}
+
+	queues := make(map[string]*queue)
+	for _, queueStats := range stats {
+		queues[queueStats.Queue] = &queue{QueueStats: queueStats}
+	}
+
+	if app.jobs != nil {
+		for name, workers := range app.jobs.Queues() {
+			if _, found := queues[name]; !found {
+				queues[name] = &queue{QueueStats: &data.QueueStats{Queue: name}}
+			}
+			queues[name].Workers = workers
+		}
+	}
+
+	list := make([]*queue, 0, len(queues))
+	for _, queue := range queues {
+		list = append(list, queue)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Queue < list[j].Queue
+	})
+
+	err = app.writeResponse(writer, request, http.StatusOK, envelope{"queues": list}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// The listFailedJobsHandler() returns a page of the jobs which ran out of attempts,
+// optionally of one queue (?queue=mail).
+func (app *Application) listFailedJobsHandler(writer http.ResponseWriter, request *http.Request) {
+	var input struct {
+		Queue string
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := request.URL.Query()
+
+	input.Queue = app.readString(qs, "queue", "")
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-updated_at")
+	input.Filters.SortSafeList = []string{"id", "-id", "updated_at", "-updated_at", "created_at", "-created_at"}
+
+	if validator.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+
+	jobs, metadata, err := app.Models.Jobs.GetFailed(request.Context(), input.Queue, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+
+	err = app.writeResponse(writer, request, http.StatusOK, envelope{"jobs": jobs, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// The retryFailedJobHandler() puts a failed job back in its queue with a fresh set of
+// attempts, e.g. once the SMTP server works again.
+func (app *Application) retryFailedJobHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIdParam(request)
+	if err != nil {
+		app.notFoundResponse(writer, request)
+		return
+	}
+
+	job, err := app.Models.Jobs.RetryFailed(request.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, request)
+		case errors.Is(err, data.ErrDuplicateJob):
+			app.errorResponse(writer, request, http.StatusConflict, "an equal job is already waiting")
+		default:
+			app.serverErrorResponse(writer, request, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(writer, request, http.StatusOK, envelope{"job": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// The deleteFailedJobHandler() discards a failed job.
+func (app *Application) deleteFailedJobHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIdParam(request)
+	if err != nil {
+		app.notFoundResponse(writer, request)
+		return
+	}
+
+	err = app.Models.Jobs.DeleteFailed(request.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, request)
+		default:
+			app.serverErrorResponse(writer, request, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(writer, request, http.StatusOK, envelope{"message": "job deleted successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
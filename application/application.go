@@ -3,16 +3,49 @@ package application
 import (
 	"Meow/config"
 	"Meow/internal/data"
+	"Meow/internal/jobs"
+	"Meow/internal/stream"
 	jlog "Meow/log"
 	"Meow/mailer"
+	"context"
+	"database/sql"
 	"sync"
+	"sync/atomic"
 )
 
 type Application struct {
 	Config  *config.Config
 	Logger  *jlog.Logger
 	Version string
+	DB      *sql.DB
 	Models  data.Models
 	Mailer  mailer.Mailer
 	Wg      sync.WaitGroup
+
+	// The email templates, for choosing the locale of users and for previews.
+	Templates *mailer.Templates
+
+	// ConfigSource loads the configuration again for a reload on SIGHUP or through the
+	// admin endpoint. Reloads are not supported if it is nil.
+	ConfigSource func() (*config.Config, error)
+
+	// The settings which can be changed by a reload.
+	live     atomic.Pointer[liveSettings]
+	reloadMu sync.Mutex
+
+	// The running background tasks and the context cancelling them on shutdown.
+	tasksMu     sync.Mutex
+	tasks       map[uint64]backgroundTask
+	taskSeq     uint64
+	tasksCtx    context.Context
+	cancelTasks context.CancelFunc
+
+	// The runner of the job queues, started by Serve().
+	jobs *jobs.Runner
+
+	// The broker of the movie event streams, started by Serve().
+	streams *stream.Broker
+
+	// Whether the server is shutting down, reported by the readiness check.
+	draining atomic.Bool
 }
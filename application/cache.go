@@ -0,0 +1,75 @@
+package application
+
+import (
+	"sync"
+	"time"
+)
+
+// A cacheEntry holds a cached value and the time it should be dropped.
+type cacheEntry struct {
+	value  interface{}
+	expiry time.Time
+}
+
+// The ttlCache type is a small in-process cache which keeps values for a fixed
+// duration. It is safe for concurrent use.
+type ttlCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	entries map[string]cacheEntry
+}
+
+// Return a new ttlCache which keeps at most maxSize values for ttl.
+func newTTLCache(ttl time.Duration, maxSize int) *ttlCache {
+	return &ttlCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// Get the value stored for key. The second returned value is false if there is no
+// value or it has expired.
+func (cache *ttlCache) get(key string) (interface{}, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, found := cache.entries[key]
+	if !found {
+		return nil, false
+	}
+
+	if time.Now().After(entry.expiry) {
+		delete(cache.entries, key)
+		return nil, false
+	}
+
+	return entry.value, true
+}
+
+// Store value for key. If the cache is full, expired values are removed first and if
+// that is not enough the whole cache is cleared.
+func (cache *ttlCache) set(key string, value interface{}) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := time.Now()
+
+	if len(cache.entries) >= cache.maxSize {
+		for k, entry := range cache.entries {
+			if now.After(entry.expiry) {
+				delete(cache.entries, k)
+			}
+		}
+
+		if len(cache.entries) >= cache.maxSize {
+			cache.entries = make(map[string]cacheEntry)
+		}
+	}
+
+	cache.entries[key] = cacheEntry{
+		value:  value,
+		expiry: now.Add(cache.ttl),
+	}
+}
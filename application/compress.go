@@ -0,0 +1,211 @@
+package application
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Responses smaller than this are sent uncompressed, because compressing them costs
+// more than it saves.
+const minCompressSize = 1024
+
+// Define the content encodings we support in the order we prefer them when the client
+// accepts several with the same quality.
+var contentEncodings = []string{"br", "gzip", "deflate"}
+
+// Content types which are already compressed or must be streamed as written.
+var uncompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/octet-stream",
+	"text/event-stream",
+}
+
+// The negotiateEncoding() function returns the content encoding to use for the given
+// Accept-Encoding header, or "" if the response should not be compressed.
+func negotiateEncoding(header string) string {
+	if strings.TrimSpace(header) == "" {
+		return ""
+	}
+
+	// parseAccept() handles quality values of Accept-Encoding the same way as Accept.
+	ranges := parseAccept(header)
+
+	qualities := make(map[string]float64)
+	for _, r := range ranges {
+		if _, found := qualities[r.mediaType]; !found {
+			qualities[r.mediaType] = r.q
+		}
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range contentEncodings {
+		q, found := qualities[encoding]
+		if !found {
+			q, found = qualities["*"]
+		}
+
+		if found && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+
+	return best
+}
+
+// The compressWriter type wraps http.ResponseWriter. It buffers the start of the body
+// until it knows whether the response is worth compressing, and then writes either
+// through a compressor or directly to the wrapped writer.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding   string
+	status     int
+	buffer     []byte
+	decided    bool
+	compressor io.WriteCloser
+}
+
+// Record the status code. It is sent once we decide about compression, because the
+// Content-Encoding header must be set before it.
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+// Buffer the body until it reaches minCompressSize, then decide about compression.
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		cw.buffer = append(cw.buffer, b...)
+		if len(cw.buffer) < minCompressSize {
+			return len(b), nil
+		}
+
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+
+		return len(b), nil
+	}
+
+	if cw.compressor != nil {
+		return cw.compressor.Write(b)
+	}
+
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush sends everything written so far to the client. Flushing before the body reached
+// minCompressSize means the handler streams its response, so it is not compressed.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(false)
+	}
+
+	if flusher, ok := cw.compressor.(interface{ Flush() error }); ok {
+		flusher.Flush()
+	}
+
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close writes a small buffered body uncompressed and finishes the compressed stream.
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if err := cw.decide(false); err != nil {
+			return err
+		}
+	}
+
+	if cw.compressor != nil {
+		return cw.compressor.Close()
+	}
+
+	return nil
+}
+
+// Send the headers and the buffered body, through a compressor if compress is true and
+// the response can be compressed.
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	header := cw.Header()
+	if compress && cw.compressible() {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+
+		switch cw.encoding {
+		case "br":
+			cw.compressor = brotli.NewWriterLevel(cw.ResponseWriter, 5)
+		case "gzip":
+			cw.compressor, _ = gzip.NewWriterLevel(cw.ResponseWriter, gzip.DefaultCompression)
+		case "deflate":
+			// HTTP's deflate is the zlib format of RFC 1950, not a raw deflate stream.
+			cw.compressor, _ = zlib.NewWriterLevel(cw.ResponseWriter, zlib.DefaultCompression)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buffer := cw.buffer
+	cw.buffer = nil
+
+	if len(buffer) == 0 {
+		return nil
+	}
+
+	if cw.compressor != nil {
+		_, err := cw.compressor.Write(buffer)
+		return err
+	}
+
+	_, err := cw.ResponseWriter.Write(buffer)
+	return err
+}
+
+// Report whether the response can be compressed based on its status and headers.
+func (cw *compressWriter) compressible() bool {
+	if cw.status < http.StatusOK || cw.status == http.StatusNoContent || cw.status == http.StatusNotModified {
+		return false
+	}
+
+	header := cw.Header()
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+
+	contentType := strings.ToLower(header.Get("Content-Type"))
+	for _, prefix := range uncompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+
+	return true
+}
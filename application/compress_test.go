@@ -0,0 +1,67 @@
+package application
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestCompress(t *testing.T) {
+	body := strings.Repeat("Casablanca, Vertigo, Rear Window. ", 100)
+
+	tests := []struct {
+		name     string
+		encoding string
+		decode   func(io.Reader) (io.Reader, error)
+	}{
+		{"br", "br", func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil }},
+		{"gzip", "gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"deflate", "deflate", func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) }},
+		{"identity", "identity", func(r io.Reader) (io.Reader, error) { return r, nil }},
+	}
+
+	app := &Application{}
+	handler := app.compress(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain")
+		io.WriteString(writer, body)
+	}))
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept-Encoding", test.encoding)
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			want := test.encoding
+			if want == "identity" {
+				want = ""
+			}
+
+			if got := recorder.Header().Get("Content-Encoding"); got != want {
+				t.Fatalf("got Content-Encoding %q, want %q", got, want)
+			}
+
+			reader, err := test.decode(recorder.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(decoded) != body {
+				t.Errorf("got %d bytes, want the %d bytes written", len(decoded), len(body))
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package application
 
 import (
 	"Meow/internal/data"
+	jlog "Meow/log"
 	"context"
 	"net/http"
 )
@@ -9,7 +10,11 @@ import (
 // Define a custom contextKey type, with the underlying type string.
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey        = contextKey("user")
+	formatContextKey      = contextKey("format")
+	requestInfoContextKey = contextKey("request_info")
+)
 
 // The contextSetUser() method returns a new copy of the request with the provided
 // User struct added to the context.
@@ -26,3 +31,70 @@ func (app *Application) contextGetUser(request *http.Request) *data.User {
 
 	return user
 }
+
+// A responseFormat holds the encoder negotiated for a request and whether the output
+// should be indented.
+type responseFormat struct {
+	encoder *responseEncoder
+	pretty  bool
+}
+
+// The contextSetFormat() method returns a new copy of the request with the response
+// format added to the context.
+func (app *Application) contextSetFormat(request *http.Request, encoder *responseEncoder, pretty bool) *http.Request {
+	ctx := context.WithValue(request.Context(), formatContextKey, responseFormat{encoder: encoder, pretty: pretty})
+	return request.WithContext(ctx)
+}
+
+// The contextGetFormat() method returns the response format of the request. Requests
+// which never passed through negotiateContent() (e.g. a panic recovered before it) get
+// compact JSON.
+func (app *Application) contextGetFormat(request *http.Request) responseFormat {
+	format, ok := request.Context().Value(formatContextKey).(responseFormat)
+	if !ok {
+		return responseFormat{encoder: jsonEncoder}
+	}
+
+	return format
+}
+
+// A requestInfo holds details of a request which are only known deep in the
+// middleware chain but are needed by the outer middleware, like the httprouter pattern
+// of the matched route (e.g. "/v1/movies/:id") and the authenticated user. requestID()
+// middleware adds it to the context and the inner handlers fill it in.
+type requestInfo struct {
+	id     string
+	route  string
+	userID int64
+}
+
+// The contextSetRequestInfo() method returns a new copy of the request with the
+// request info added to the context.
+func (app *Application) contextSetRequestInfo(request *http.Request, info *requestInfo) *http.Request {
+	ctx := context.WithValue(request.Context(), requestInfoContextKey, info)
+	return request.WithContext(ctx)
+}
+
+// The contextGetRequestInfo() method returns the request info of the request, or nil
+// if there is none.
+func (app *Application) contextGetRequestInfo(request *http.Request) *requestInfo {
+	return requestInfoFromContext(request.Context())
+}
+
+// The requestInfoFromContext() function returns the request info stored in ctx. It is
+// also used by background tasks, which only have the context of the request.
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoContextKey).(*requestInfo)
+	return info
+}
+
+// The loggerFor() method returns a logger which adds the request id stored in ctx (if
+// any) to every entry.
+func (app *Application) loggerFor(ctx context.Context) *jlog.Logger {
+	info := requestInfoFromContext(ctx)
+	if info == nil {
+		return app.Logger
+	}
+
+	return app.Logger.With(jlog.Fields{"request_id": info.id})
+}
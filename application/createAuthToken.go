@@ -33,7 +33,7 @@ func (app *Application) createAuthenticationTokenHandler(writer http.ResponseWri
 	}
 
 	// Search for user in database
-	user, err := app.Models.Users.GetByEmail(input.Email)
+	user, err := app.Models.Users.GetByEmail(request.Context(), input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -55,13 +55,13 @@ func (app *Application) createAuthenticationTokenHandler(writer http.ResponseWri
 		return
 	}
 
-	token, err := app.Models.Tokens.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	token, err := app.Models.Tokens.New(request.Context(), user.ID, 24*time.Hour, data.ScopeAuthentication)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 		return
 	}
 
-	err = app.writeJSON(writer, http.StatusCreated, envelope{"auth_token": token}, nil)
+	err = app.writeResponse(writer, request, http.StatusCreated, envelope{"auth_token": token}, nil)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 		return
@@ -47,7 +47,7 @@ func (app *Application) createNewMovieHandler(writer http.ResponseWriter, reques
 	}
 
 	// Call the Insert() method on our movies model.
-	err = app.Models.Movies.Insert(movie)
+	err = app.Models.Movies.Insert(request.Context(), movie)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 		return
@@ -62,7 +62,7 @@ func (app *Application) createNewMovieHandler(writer http.ResponseWriter, reques
 
 	// Write a JSON response with a 201 Created status code, the movie data in the
 	// response body, and the Location header.
-	err = app.writeJSON(writer, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeResponse(writer, request, http.StatusCreated, envelope{"movie": movie}, headers)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 		return
@@ -17,7 +17,7 @@ func (app *Application) deleteMovieHandler(writer http.ResponseWriter, request *
 	// Try delete movie with this id from database with Delete()
 	// function. if returned an error check it and send appropriate
 	// error response.
-	err = app.Models.Movies.Delete(id)
+	err = app.Models.Movies.Delete(request.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -29,7 +29,7 @@ func (app *Application) deleteMovieHandler(writer http.ResponseWriter, request *
 	}
 
 	// Ok. return 200 status code and success message.
-	err = app.writeJSON(writer, http.StatusOK, envelope{"message": "movie deleted successfully"}, nil)
+	err = app.writeResponse(writer, request, http.StatusOK, envelope{"message": "movie deleted successfully"}, nil)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 	}
@@ -0,0 +1,468 @@
+package application
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errNotEncodable is returned by an encoder which can't represent the given envelope,
+// e.g. the CSV encoder for a single movie.
+var errNotEncodable = errors.New("response can not be encoded in the requested format")
+
+// A responseEncoder converts an envelope to the bytes of the response body for one
+// media type.
+type responseEncoder struct {
+	contentType string
+	encode      func(value envelope, pretty bool) ([]byte, error)
+}
+
+// Map every media type which a client can choose with the Accept header to its
+// encoder.
+var responseEncoders = map[string]*responseEncoder{
+	"application/json":        {contentType: "application/json", encode: encodeJSON},
+	"application/xml":         {contentType: "application/xml", encode: encodeXML},
+	"text/xml":                {contentType: "text/xml", encode: encodeXML},
+	"text/csv":                {contentType: "text/csv", encode: encodeCSV},
+	"application/msgpack":     {contentType: "application/msgpack", encode: encodeMsgpack},
+	"application/x-msgpack":   {contentType: "application/x-msgpack", encode: encodeMsgpack},
+	"application/vnd.msgpack": {contentType: "application/vnd.msgpack", encode: encodeMsgpack},
+}
+
+// JSON is the default format when the client accepts anything.
+var jsonEncoder = responseEncoders["application/json"]
+
+// Order in which media types are tried for wildcard media ranges.
+var encoderPreference = []string{
+	"application/json",
+	"application/xml",
+	"application/msgpack",
+	"text/xml",
+	"text/csv",
+}
+
+// A mediaRange is one entry of the Accept header.
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// The parseAccept() function parses the Accept header into media ranges sorted by
+// their quality, most preferred first. Ranges with the same quality keep the order
+// the client sent them in.
+func parseAccept(header string) []mediaRange {
+	ranges := []mediaRange{}
+
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if !found || strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				parsed = 0
+			}
+			q = parsed
+		}
+
+		ranges = append(ranges, mediaRange{mediaType: mediaType, q: q})
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	return ranges
+}
+
+// The negotiateEncoder() function returns the encoder for the most preferred media type
+// in the Accept header, or nil if none of them can be served. A missing header means
+// the client accepts anything, so it gets JSON. CSV is only considered if csv is true,
+// since it can only represent lists.
+func negotiateEncoder(header string, csv bool) *responseEncoder {
+	if strings.TrimSpace(header) == "" {
+		return jsonEncoder
+	}
+
+	// Collect media types which the client explicitly refused with q=0, so a wildcard
+	// doesn't bring them back.
+	refused := make(map[string]bool)
+	ranges := parseAccept(header)
+	for _, r := range ranges {
+		if r.q == 0 {
+			refused[r.mediaType] = true
+		}
+	}
+
+	if !csv {
+		refused["text/csv"] = true
+	}
+
+	for _, r := range ranges {
+		if r.q == 0 {
+			break
+		}
+
+		if encoder, found := responseEncoders[r.mediaType]; found && !refused[r.mediaType] {
+			return encoder
+		}
+
+		// Handle "*/*" and "type/*" ranges in our own preference order.
+		if !strings.HasSuffix(r.mediaType, "/*") {
+			continue
+		}
+
+		prefix := strings.TrimSuffix(r.mediaType, "*")
+		if r.mediaType == "*/*" {
+			prefix = ""
+		}
+
+		for _, mediaType := range encoderPreference {
+			if strings.HasPrefix(mediaType, prefix) && !refused[mediaType] {
+				return responseEncoders[mediaType]
+			}
+		}
+	}
+
+	return nil
+}
+
+// Encode the envelope as JSON. Pretty output is indented with tabs.
+func encodeJSON(value envelope, pretty bool) ([]byte, error) {
+	if pretty {
+		return json.MarshalIndent(value, "", "\t")
+	}
+
+	return json.Marshal(value)
+}
+
+// An objectField is one key of a JSON object along with its value.
+type objectField struct {
+	key   string
+	value interface{}
+}
+
+// An orderedObject is a JSON object which keeps its keys in the order they were
+// encoded in.
+type orderedObject []objectField
+
+// The normalize() function turns any value into a tree of orderedObject, []interface{},
+// json.Number, string, bool and nil by passing it through the JSON encoder. This way
+// the other formats see exactly what the JSON response contains, including custom
+// marshalling such as the "<runtime> mins" of data.Runtime and the field order of
+// structs.
+func normalize(value interface{}) (interface{}, error) {
+	js, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(js))
+	decoder.UseNumber()
+
+	return decodeOrdered(decoder)
+}
+
+// Read the next JSON value from decoder keeping the key order of objects.
+func decodeOrdered(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return token, nil
+	}
+
+	switch delim {
+	case '{':
+		object := orderedObject{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+
+			object = append(object, objectField{key: key.(string), value: value})
+		}
+
+		// Consume the closing '}'.
+		_, err = decoder.Token()
+		return object, err
+
+	case '[':
+		array := []interface{}{}
+		for decoder.More() {
+			value, err := decodeOrdered(decoder)
+			if err != nil {
+				return nil, err
+			}
+
+			array = append(array, value)
+		}
+
+		// Consume the closing ']'.
+		_, err = decoder.Token()
+		return array, err
+	}
+
+	return nil, fmt.Errorf("unexpected JSON delimiter %q", delim)
+}
+
+// Encode the envelope as XML. Every key becomes an element and every item of an array
+// becomes an <item> element, e.g. <response><movies><item><id>1</id>...</item></movies>.
+func encodeXML(value envelope, pretty bool) ([]byte, error) {
+	tree, err := normalize(value)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := bytes.NewBufferString(xml.Header)
+	encoder := xml.NewEncoder(buffer)
+	if pretty {
+		encoder.Indent("", "\t")
+	}
+
+	err = writeXMLElement(encoder, "response", tree)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = encoder.Flush(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// Write a single value of the normalized tree as an XML element. Keys which are not
+// valid element names are written as <entry key="...">.
+func writeXMLElement(encoder *xml.Encoder, name string, value interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if !isXMLName(name) {
+		start = xml.StartElement{
+			Name: xml.Name{Local: "entry"},
+			Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: name}},
+		}
+	}
+
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch value := value.(type) {
+	case orderedObject:
+		for _, field := range value {
+			if err := writeXMLElement(encoder, field.key, field.value); err != nil {
+				return err
+			}
+		}
+
+	case []interface{}:
+		for _, item := range value {
+			if err := writeXMLElement(encoder, "item", item); err != nil {
+				return err
+			}
+		}
+
+	case nil:
+		// Leave the element empty.
+
+	default:
+		if err := encoder.EncodeToken(xml.CharData(fmt.Sprint(value))); err != nil {
+			return err
+		}
+	}
+
+	return encoder.EncodeToken(start.End())
+}
+
+// Report whether name can be used as an XML element name as is.
+func isXMLName(name string) bool {
+	if name == "" || strings.HasPrefix(strings.ToLower(name), "xml") {
+		return false
+	}
+
+	for i, r := range name {
+		isLetter := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_'
+		isOther := (r >= '0' && r <= '9') || r == '-' || r == '.'
+		if !isLetter && (i == 0 || !isOther) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Encode the envelope as CSV. Only envelopes holding a list of records, such as the
+// movies of the list endpoint, have a natural CSV form: one row per record with the
+// union of record keys as header. Error envelopes are written as key/value rows so a
+// CSV client still gets a readable error. Anything else can't be encoded.
+func encodeCSV(value envelope, pretty bool) ([]byte, error) {
+	tree, err := normalize(value)
+	if err != nil {
+		return nil, err
+	}
+
+	object, _ := tree.(orderedObject)
+
+	// JSON encodes the envelope map with sorted keys, so this picks the same list on
+	// every call.
+	for _, field := range object {
+		records, ok := csvRecords(field.value)
+		if ok {
+			return writeCSVRecords(records)
+		}
+	}
+
+	if _, isError := value["error"]; isError {
+		rows := [][]string{{"key", "value"}}
+		rows = flattenCSV(rows, "", object)
+		return writeCSV(rows)
+	}
+
+	return nil, errNotEncodable
+}
+
+// Return the items of value if it is a list where every item is an object.
+func csvRecords(value interface{}) ([]orderedObject, bool) {
+	array, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	records := make([]orderedObject, len(array))
+	for i := range array {
+		record, ok := array[i].(orderedObject)
+		if !ok {
+			return nil, false
+		}
+
+		records[i] = record
+	}
+
+	return records, true
+}
+
+// Write records as CSV rows under a header containing every key in order of first
+// appearance.
+func writeCSVRecords(records []orderedObject) ([]byte, error) {
+	header := []string{}
+	columns := make(map[string]int)
+
+	for _, record := range records {
+		for _, field := range record {
+			if _, found := columns[field.key]; !found {
+				columns[field.key] = len(header)
+				header = append(header, field.key)
+			}
+		}
+	}
+
+	rows := [][]string{header}
+	for _, record := range records {
+		row := make([]string, len(header))
+		for _, field := range record {
+			row[columns[field.key]] = csvCell(field.value)
+		}
+
+		rows = append(rows, row)
+	}
+
+	return writeCSV(rows)
+}
+
+// Format a single value for a CSV cell. Lists of plain values, like genres, are joined
+// with "|" and nested objects are written as JSON.
+func csvCell(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+
+	case []interface{}:
+		parts := make([]string, len(value))
+		for i := range value {
+			parts[i] = csvCell(value[i])
+		}
+		return strings.Join(parts, "|")
+
+	case orderedObject:
+		parts := make([]string, len(value))
+		for i, field := range value {
+			key, _ := json.Marshal(field.key)
+			parts[i] = string(key) + ":" + csvCell(field.value)
+		}
+		return "{" + strings.Join(parts, ",") + "}"
+
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// Flatten nested objects into key/value rows with dotted keys.
+func flattenCSV(rows [][]string, prefix string, value interface{}) [][]string {
+	object, ok := value.(orderedObject)
+	if !ok {
+		return append(rows, []string{prefix, csvCell(value)})
+	}
+
+	for _, field := range object {
+		key := field.key
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		rows = flattenCSV(rows, key, field.value)
+	}
+
+	return rows
+}
+
+// Write rows using the encoding/csv writer.
+func writeCSV(rows [][]string) ([]byte, error) {
+	buffer := new(bytes.Buffer)
+
+	writer := csv.NewWriter(buffer)
+	if err := writer.WriteAll(rows); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// Encode the envelope as MessagePack.
+func encodeMsgpack(value envelope, pretty bool) ([]byte, error) {
+	tree, err := normalize(value)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := new(bytes.Buffer)
+	if err = writeMsgpack(buffer, tree); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,38 @@
+package application
+
+import "testing"
+
+func TestNegotiateEncoder(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept string
+		csv    bool
+		want   string
+	}{
+		{"no header", "", false, "application/json"},
+		{"anything", "*/*", true, "application/json"},
+		{"xml", "application/xml", false, "application/xml"},
+		{"csv on a list", "text/csv", true, "text/csv"},
+		{"csv elsewhere", "text/csv", false, ""},
+		{"csv elsewhere with a fallback", "text/csv, application/json;q=0.5", false, "application/json"},
+		{"text wildcard on a list", "text/*", true, "text/xml"},
+		{"text wildcard elsewhere", "text/*;q=1, text/xml;q=0", false, ""},
+		{"refused json", "application/json;q=0, */*", true, "application/xml"},
+		{"unsupported", "image/png", true, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoder := negotiateEncoder(test.accept, test.csv)
+
+			got := ""
+			if encoder != nil {
+				got = encoder.contentType
+			}
+
+			if got != test.want {
+				t.Fatalf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -1,13 +1,14 @@
 package application
 
 import (
+	jlog "Meow/log"
 	"fmt"
 	"net/http"
 )
 
 // The logError() method is a generic helper for logging an error message.
 func (app *Application) logError(request *http.Request, err error) {
-	app.Logger.PrintError(err, map[string]string{
+	app.loggerFor(request.Context()).Error(err, jlog.Fields{
 		"request_method": request.Method,
 		"request_url":    request.URL.String(),
 	})
@@ -20,10 +21,10 @@ func (app *Application) logError(request *http.Request, err error) {
 func (app *Application) errorResponse(writer http.ResponseWriter, request *http.Request, status int, message interface{}) {
 	env := envelope{"error": message}
 
-	// Write the response using the writeJSON() helper. If this happens to return an
+	// Write the response using the writeResponse() helper. If this happens to return an
 	// error then log it, and fall back to sending the client an empty response with a
 	// 500 Internal Server Error status code.
-	err := app.writeJSON(writer, status, env, nil)
+	err := app.writeResponse(writer, request, status, env, nil)
 	if err != nil {
 		app.logError(request, err)
 		writer.WriteHeader(http.StatusInternalServerError)
@@ -100,3 +101,10 @@ func (app *Application) inActiveAccountResponse(writer http.ResponseWriter, requ
 func (app *Application) notPermittedResponse(writer http.ResponseWriter, request *http.Request) {
 	app.errorResponse(writer, request, http.StatusForbidden, "your user account doesn't have the necessary permissions to access this resource")
 }
+
+// The notAcceptableResponse() method will be used when none of the media types in the
+// Accept header can be served.
+func (app *Application) notAcceptableResponse(writer http.ResponseWriter, request *http.Request) {
+	message := "the requested resource is only available as application/json, application/xml, text/csv (lists) or application/msgpack"
+	app.errorResponse(writer, request, http.StatusNotAcceptable, message)
+}
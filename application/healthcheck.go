@@ -1,7 +1,14 @@
 package application
 
 import (
+	"Meow/internal/data"
+	"Meow/migrations"
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"sync"
+	"time"
 )
 
 // Declare a handler which writes a plain-text response with information about the
@@ -15,10 +22,196 @@ func (app *Application) healthcheckHandler(writer http.ResponseWriter, request *
 		"version":     app.Version,
 	}
 
-	// Using writeJSON() helper to write data and set
+	// Using writeResponse() helper to write data and set
 	// headers to HTTP response body.
-	err := app.writeJSON(writer, http.StatusOK, envelope{"info": data}, nil)
+	err := app.writeResponse(writer, request, http.StatusOK, envelope{"info": data}, nil)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 	}
 }
+
+// Readiness fails once this many background tasks (e.g. emails) are running, because
+// the instance is falling behind.
+const maxBackgroundTasks = 100
+
+// Every readiness check must finish within this time.
+const readinessCheckTimeout = 2 * time.Second
+
+// The result of the mail check is reused for this long, since pinging the SMTP server
+// opens a connection and probes come every few seconds from every load balancer.
+const mailCheckTTL = 30 * time.Second
+
+// A healthCheck is the result of checking one dependency.
+type healthCheck struct {
+	Status    string                 `json:"status"`
+	LatencyMS float64                `json:"latency_ms"`
+	Error     string                 `json:"error,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+}
+
+// Declare a handler for liveness probes. It only shows that the process serves
+// requests, so it never checks dependencies: restarting the process wouldn't fix them.
+func (app *Application) livenessHandler(writer http.ResponseWriter, request *http.Request) {
+	err := app.writeResponse(writer, request, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// Return a handler for readiness probes. It checks every dependency concurrently and
+// responds with 503 Service Unavailable if any of them is down or the server is
+// shutting down. The database is checked on every probe, the mail transport at most
+// every mailCheckTTL.
+func (app *Application) readinessHandler() http.HandlerFunc {
+	checkMail := app.cachedMailCheck(mailCheckTTL)
+
+	return func(writer http.ResponseWriter, request *http.Request) {
+		checks := map[string]func(ctx context.Context) (map[string]interface{}, error){
+			"mail":             checkMail,
+			"background_tasks": app.checkBackgroundTasks,
+		}
+
+		if app.DB != nil {
+			checks["database"] = app.checkDatabase
+			checks["migrations"] = app.checkMigrations
+		}
+
+		results := make(map[string]healthCheck, len(checks))
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+
+		for name, check := range checks {
+			wg.Add(1)
+
+			go func(name string, check func(ctx context.Context) (map[string]interface{}, error)) {
+				defer wg.Done()
+
+				ctx, cancel := context.WithTimeout(request.Context(), readinessCheckTimeout)
+				defer cancel()
+
+				start := time.Now()
+				details, err := check(ctx)
+
+				result := healthCheck{
+					Status:    "up",
+					LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+					Details:   details,
+				}
+
+				// The probe isn't authenticated, so it only tells that a check failed or
+				// timed out. The error itself, which may name hosts, goes to the log.
+				if err != nil {
+					result.Status = "down"
+					result.Error = "check failed"
+					if errors.Is(err, context.DeadlineExceeded) {
+						result.Error = "check timed out"
+					}
+
+					app.Logger.PrintError(err, map[string]string{"check": name})
+				}
+
+				mu.Lock()
+				results[name] = result
+				mu.Unlock()
+			}(name, check)
+		}
+
+		wg.Wait()
+
+		status := "ready"
+		for _, result := range results {
+			if result.Status != "up" {
+				status = "unavailable"
+			}
+		}
+
+		if app.draining.Load() {
+			status = "draining"
+		}
+
+		code := http.StatusOK
+		if status != "ready" {
+			code = http.StatusServiceUnavailable
+		}
+
+		err := app.writeResponse(writer, request, code, envelope{"status": status, "checks": results}, nil)
+		if err != nil {
+			app.serverErrorResponse(writer, request, err)
+		}
+	}
+}
+
+// Check the database with a ping.
+func (app *Application) checkDatabase(ctx context.Context) (map[string]interface{}, error) {
+	return nil, app.DB.PingContext(ctx)
+}
+
+// Check that the database schema has every migration this version was built with.
+func (app *Application) checkMigrations(ctx context.Context) (map[string]interface{}, error) {
+	latest, err := migrations.Latest()
+	if err != nil {
+		return nil, err
+	}
+
+	version, dirty, err := data.SchemaVersion(ctx, app.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	details := map[string]interface{}{"version": version, "latest": latest}
+
+	switch {
+	case dirty:
+		return details, fmt.Errorf("migration %d failed and must be fixed manually", version)
+	case version < latest:
+		return details, fmt.Errorf("%d pending migrations", latest-version)
+	}
+
+	return details, nil
+}
+
+// Return a check that the mail transport, e.g. the SMTP server, takes messages. The
+// result of a ping is reused for ttl, and concurrent probes wait for the same ping.
+// The details tell when the transport was last pinged.
+func (app *Application) cachedMailCheck(ttl time.Duration) func(ctx context.Context) (map[string]interface{}, error) {
+	var (
+		mu        sync.Mutex
+		checkedAt time.Time
+		lastErr   error
+	)
+
+	return func(ctx context.Context) (map[string]interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if checkedAt.IsZero() || time.Since(checkedAt) >= ttl {
+			err := app.Mailer.Ping(ctx)
+
+			// A probe which went away says nothing about the transport, so the next one
+			// pings again.
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return map[string]interface{}{"driver": app.Config.Mail.Driver}, err
+			}
+
+			checkedAt, lastErr = time.Now(), err
+		}
+
+		return map[string]interface{}{
+			"driver":     app.Config.Mail.Driver,
+			"checked_at": checkedAt.UTC().Format(time.RFC3339),
+		}, lastErr
+	}
+}
+
+// Check that background tasks aren't piling up.
+func (app *Application) checkBackgroundTasks(ctx context.Context) (map[string]interface{}, error) {
+	running := len(app.runningBackgroundTasks())
+	details := map[string]interface{}{"running": running, "max": maxBackgroundTasks}
+
+	if running >= maxBackgroundTasks {
+		return details, errors.New("too many background tasks")
+	}
+
+	return details, nil
+}
@@ -0,0 +1,122 @@
+package application
+
+import (
+	"Meow/config"
+	jlog "Meow/log"
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A mailer counting its pings, which fail with err.
+type pingCounter struct {
+	pings atomic.Int32
+	err   error
+}
+
+func (mailer *pingCounter) Send(ctx context.Context, recipient string, locale string, name string, data interface{}) error {
+	return nil
+}
+
+func (mailer *pingCounter) Ping(ctx context.Context) error {
+	mailer.pings.Add(1)
+	return mailer.err
+}
+
+func TestCachedMailCheck(t *testing.T) {
+	down := errors.New("connection refused")
+
+	tests := []struct {
+		name  string
+		ttl   time.Duration
+		err   error
+		pings int32
+	}{
+		{"cached", time.Hour, nil, 1},
+		{"failure cached", time.Hour, down, 1},
+		{"expired", 0, nil, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mailer := &pingCounter{err: test.err}
+			app := &Application{Config: &config.Config{}, Mailer: mailer}
+			app.Config.Mail.Driver = "smtp"
+
+			check := app.cachedMailCheck(test.ttl)
+
+			for i := 0; i < 3; i++ {
+				details, err := check(context.Background())
+				if !errors.Is(err, test.err) {
+					t.Fatalf("got %v, want %v", err, test.err)
+				}
+
+				if details["driver"] != "smtp" || details["checked_at"] == nil {
+					t.Fatalf("got details %v", details)
+				}
+			}
+
+			if pings := mailer.pings.Load(); pings != test.pings {
+				t.Errorf("got %d pings, want %d", pings, test.pings)
+			}
+		})
+	}
+}
+
+// A probe cancelled by its client isn't cached as the result of the transport.
+func TestCachedMailCheckCancelled(t *testing.T) {
+	mailer := &pingCounter{}
+	app := &Application{Config: &config.Config{}, Mailer: mailer}
+
+	check := app.cachedMailCheck(time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	mailer.err = context.Canceled
+	if _, err := check(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+
+	mailer.err = nil
+	if _, err := check(context.Background()); err != nil {
+		t.Fatalf("got %v after a cancelled probe", err)
+	}
+
+	if pings := mailer.pings.Load(); pings != 2 {
+		t.Errorf("got %d pings, want 2", pings)
+	}
+}
+
+// The public probe hides the errors of the checks, which are logged instead.
+func TestReadinessHidesErrors(t *testing.T) {
+	log := new(bytes.Buffer)
+
+	app := &Application{
+		Config: &config.Config{},
+		Logger: jlog.New(log, jlog.LevelInfo),
+		Mailer: &pingCounter{err: errors.New("dial tcp 10.0.0.5:25: connection refused")},
+	}
+
+	recorder := httptest.NewRecorder()
+	app.readinessHandler()(recorder, httptest.NewRequest(http.MethodGet, "/v1/healthcheck/ready", nil))
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got status %d, want %d", recorder.Code, http.StatusServiceUnavailable)
+	}
+
+	body := recorder.Body.String()
+	if strings.Contains(body, "10.0.0.5") || !strings.Contains(body, `"check failed"`) {
+		t.Errorf("got body %s, want a generic error", body)
+	}
+
+	if !strings.Contains(log.String(), "10.0.0.5") || !strings.Contains(log.String(), `"check":"mail"`) {
+		t.Errorf("got log %s, want the error of the mail check", log)
+	}
+}
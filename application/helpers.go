@@ -1,7 +1,10 @@
 package application
 
 import (
+	"Meow/internal/tracing"
 	"Meow/internal/validator"
+	jlog "Meow/log"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +13,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -38,10 +42,34 @@ func (app *Application) readIdParam(request *http.Request) (int64, error) {
 	return id, nil
 }
 
-// Define writeJSON() function for json encoding and write in response body.
-func (app *Application) writeJSON(writer http.ResponseWriter, status int, value envelope, headers http.Header) error {
-	// Encoding data to json and check for errors.
-	js, err := json.Marshal(value)
+// The readNamedIdParam() method reads an ID from the URL parameter name, for routes
+// with more than one ID like "/v1/webhooks/:id/deliveries/:delivery_id".
+func (app *Application) readNamedIdParam(request *http.Request, name string) (int64, error) {
+	params := httprouter.ParamsFromContext(request.Context())
+
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
+	}
+
+	return id, nil
+}
+
+// Define writeResponse() function for encoding data in the format negotiated by
+// negotiateContent() middleware (JSON by default) and write in response body.
+func (app *Application) writeResponse(writer http.ResponseWriter, request *http.Request, status int, value envelope, headers http.Header) error {
+	format := app.contextGetFormat(request)
+
+	// Encoding data with the negotiated encoder and check for errors. Formats are
+	// checked against the route before the handler runs, but a response the format
+	// can't represent anyway is sent as JSON with its status, since the handler may
+	// have changed something already.
+	body, err := format.encoder.encode(value, format.pretty)
+	if errors.Is(err, errNotEncodable) && format.encoder != jsonEncoder {
+		format.encoder = jsonEncoder
+		body, err = jsonEncoder.encode(value, format.pretty)
+	}
+
 	if err != nil {
 		return err
 	}
@@ -55,11 +83,11 @@ func (app *Application) writeJSON(writer http.ResponseWriter, status int, value
 		writer.Header()[key] = value
 	}
 
-	// Add "Content-Type: application/json" header. Then write
+	// Add "Content-Type" header of the encoder. Then write
 	// status code.
-	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Content-Type", format.encoder.contentType)
 	writer.WriteHeader(status)
-	writer.Write(js)
+	writer.Write(body)
 
 	return nil
 }
@@ -200,21 +228,164 @@ func (app *Application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
-// The background() helper accepts an arbitrary function as a parameter.
-func (app *Application) background(fun func()) {
+// The readBool() helper reads a boolean value like "true" or "1" from the query
+// string.
+func (app *Application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// A backgroundTask describes a running background task, for reporting the tasks
+// still running when shutdown gives up waiting.
+type backgroundTask struct {
+	name    string
+	started time.Time
+}
+
+// The background() helper accepts an arbitrary function as a parameter and runs it in
+// its own goroutine and tracing span named name. The function gets a context which
+// keeps the trace of ctx but is not cancelled when the request finishes. It is
+// cancelled instead when shutdown stops waiting for background tasks, and the task
+// should then return as soon as possible.
+func (app *Application) background(ctx context.Context, name string, fun func(ctx context.Context)) {
 
 	app.Wg.Add(1)
+	id := app.addBackgroundTask(name)
 
 	go func() {
 
 		defer app.Wg.Done()
+		defer app.removeBackgroundTask(id)
+
+		ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		defer cancel()
+
+		stop := context.AfterFunc(app.backgroundContext(), cancel)
+		defer stop()
+
+		ctx, span := tracing.Start(ctx, name, tracing.KindInternal)
+		defer span.End()
 
 		defer func() {
 			if err := recover(); err != nil {
-				app.Logger.PrintError(fmt.Errorf("%s", err), nil)
+				span.RecordError(fmt.Errorf("%s", err))
+				app.loggerFor(ctx).ErrorStack(fmt.Errorf("%s", err), nil)
 			}
 		}()
 
-		fun()
+		fun(ctx)
+	}()
+}
+
+// The runWorker() method runs a long running worker, like the job runner, in its own
+// goroutine until run returns. It counts as a background task, and ctx is cancelled
+// when shutdown stops waiting for background tasks.
+func (app *Application) runWorker(name string, run func(ctx context.Context)) {
+	app.Wg.Add(1)
+	id := app.addBackgroundTask(name)
+
+	go func() {
+		defer app.Wg.Done()
+		defer app.removeBackgroundTask(id)
+
+		run(app.backgroundContext())
 	}()
 }
+
+// The backgroundContext() method returns the context which is cancelled when
+// background tasks must stop.
+func (app *Application) backgroundContext() context.Context {
+	app.tasksMu.Lock()
+	defer app.tasksMu.Unlock()
+
+	if app.tasksCtx == nil {
+		app.tasksCtx, app.cancelTasks = context.WithCancel(context.Background())
+	}
+
+	return app.tasksCtx
+}
+
+// Record a started background task and return its id.
+func (app *Application) addBackgroundTask(name string) uint64 {
+	app.tasksMu.Lock()
+	defer app.tasksMu.Unlock()
+
+	if app.tasks == nil {
+		app.tasks = make(map[uint64]backgroundTask)
+	}
+
+	app.taskSeq++
+	app.tasks[app.taskSeq] = backgroundTask{name: name, started: time.Now()}
+
+	return app.taskSeq
+}
+
+// Forget a finished background task.
+func (app *Application) removeBackgroundTask(id uint64) {
+	app.tasksMu.Lock()
+	defer app.tasksMu.Unlock()
+
+	delete(app.tasks, id)
+}
+
+// The runningBackgroundTasks() method returns the background tasks which haven't
+// finished yet.
+func (app *Application) runningBackgroundTasks() []backgroundTask {
+	app.tasksMu.Lock()
+	defer app.tasksMu.Unlock()
+
+	tasks := make([]backgroundTask, 0, len(app.tasks))
+	for _, task := range app.tasks {
+		tasks = append(tasks, task)
+	}
+
+	return tasks
+}
+
+// The waitForBackground() method waits for the background tasks to finish until ctx
+// is done. Then it cancels the contexts of the remaining tasks, gives them grace to
+// return, and reports the tasks which are still running as abandoned. It returns the
+// number of abandoned tasks.
+func (app *Application) waitForBackground(ctx context.Context, grace time.Duration) int {
+	done := make(chan struct{})
+	go func() {
+		app.Wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return 0
+	case <-ctx.Done():
+	}
+
+	app.backgroundContext()
+	app.cancelTasks()
+
+	select {
+	case <-done:
+		return 0
+	case <-time.After(grace):
+	}
+
+	tasks := app.runningBackgroundTasks()
+	for _, task := range tasks {
+		app.Logger.Warn("abandoned background task", jlog.Fields{
+			"task":    task.name,
+			"running": time.Since(task.started).Round(time.Millisecond),
+		})
+	}
+
+	return len(tasks)
+}
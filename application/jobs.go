@@ -0,0 +1,156 @@
+package application
+
+import (
+	"Meow/internal/data"
+	"Meow/internal/jobs"
+	"Meow/internal/webhooks"
+	jlog "Meow/log"
+	"Meow/mailer"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// The kinds of jobs run by the server.
+var (
+	welcomeEmailJob = jobs.Kind[welcomeEmail]{Name: "send_welcome_email", Queue: "mail", MaxAttempts: 8}
+	userTokensJob   = jobs.Kind[userTokens]{Name: "delete_user_tokens", Queue: "default", MaxAttempts: 5}
+	webhookJob      = jobs.Kind[data.WebhookJob]{Name: "deliver_webhook", Queue: "webhooks", MaxAttempts: 10}
+)
+
+// The payload of a welcome email job. The activation token is created when the email
+// is sent, so it isn't stored in the jobs table and is valid for the full hour.
+type welcomeEmail struct {
+	UserID int64  `json:"user_id"`
+	Email  string `json:"email"`
+	Locale string `json:"locale"`
+}
+
+// The payload of a job deleting the tokens of a user in a scope.
+type userTokens struct {
+	UserID int64  `json:"user_id"`
+	Scope  string `json:"scope"`
+}
+
+// The jobRunner() method returns a runner for the jobs of the server, configured
+// from app.Config.
+func (app *Application) jobRunner() *jobs.Runner {
+	queues, _ := jobs.ParseQueues(app.Config.Jobs.Queues)
+
+	runner := jobs.NewRunner(app.Models.Jobs, app.Logger, jobs.Options{
+		Queues:       queues,
+		PollInterval: app.Config.Jobs.PollInterval,
+		LockTimeout:  app.Config.Jobs.LockTimeout,
+		MinBackoff:   app.Config.Jobs.MinBackoff,
+		MaxBackoff:   app.Config.Jobs.MaxBackoff,
+	})
+
+	jobs.Handle(runner, welcomeEmailJob, app.sendWelcomeEmail)
+	jobs.Handle(runner, userTokensJob, app.deleteUserTokens)
+	jobs.Handle(runner, webhookJob, app.deliverWebhook(webhooks.NewSender(app.Config.Webhooks.Timeout)))
+
+	return runner
+}
+
+// The eventRelay() method returns the relay which hands the movie events over to the
+// webhook delivery jobs.
+func (app *Application) eventRelay() *webhooks.Relay {
+	return webhooks.NewRelay(app.Models.Events, app.Logger, webhooks.RelayOptions{
+		Queue:       webhookJob.Queue,
+		Kind:        webhookJob.Name,
+		MaxAttempts: webhookJob.MaxAttempts,
+		BatchSize:   100,
+		Interval:    app.Config.Webhooks.RelayInterval,
+	})
+}
+
+// The enqueue() function adds a job of kind with payload to its queue.
+func enqueue[T any](app *Application, ctx context.Context, kind jobs.Kind[T], payload T) error {
+	job, err := kind.New(ctx, payload)
+	if err != nil {
+		return err
+	}
+
+	return app.Models.Jobs.Enqueue(ctx, job)
+}
+
+// Send the welcome email with a new activation token.
+func (app *Application) sendWelcomeEmail(ctx context.Context, payload welcomeEmail) error {
+	token, err := app.Models.Tokens.New(ctx, payload.UserID, 1*time.Hour, data.ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"userID":          payload.UserID,
+		"activationToken": token.Plaintext,
+	}
+
+	err = app.Mailer.Send(ctx, payload.Email, payload.Locale, "user_welcome", data)
+	if errors.Is(err, mailer.ErrSuppressed) {
+		app.loggerFor(ctx).Info("welcome email skipped", jlog.Fields{"user_id": payload.UserID, "reason": "suppressed address"})
+		return nil
+	}
+
+	return err
+}
+
+// Delete the tokens of a user in a scope, e.g. the activation tokens once the user is
+// activated.
+func (app *Application) deleteUserTokens(ctx context.Context, payload userTokens) error {
+	return app.Models.Tokens.DeleteAllForUser(ctx, payload.Scope, payload.UserID)
+}
+
+// The deliverWebhook() method returns the handler of the jobs sending an event to a
+// webhook with sender. Every attempt is logged as a delivery of the webhook. Events
+// of webhooks which were deleted or deactivated meanwhile are dropped.
+func (app *Application) deliverWebhook(sender *webhooks.Sender) func(context.Context, data.WebhookJob) error {
+	return func(ctx context.Context, payload data.WebhookJob) error {
+		webhook, err := app.Models.Webhooks.Get(ctx, payload.WebhookID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return nil
+			default:
+				return err
+			}
+		}
+
+		if !webhook.Active {
+			return nil
+		}
+
+		// The event is gone if it was purged before a redelivery ran.
+		event, err := app.Models.Events.Get(ctx, payload.EventID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				return jobs.Permanent(err)
+			default:
+				return err
+			}
+		}
+
+		delivery, err := sender.Send(ctx, webhook, event)
+		delivery.Attempt = jobs.Attempt(ctx)
+
+		// Failing to log the delivery doesn't make the delivery fail.
+		if logErr := app.Models.Webhooks.InsertDelivery(context.WithoutCancel(ctx), delivery); logErr != nil {
+			app.loggerFor(ctx).Error(logErr, jlog.Fields{"event": "logging webhook delivery failed", "webhook_id": webhook.ID})
+		}
+
+		// A webhook responding 410 Gone asks not to be called anymore.
+		if delivery.StatusCode == http.StatusGone {
+			webhook.Active = false
+			if updateErr := app.Models.Webhooks.Update(ctx, webhook); updateErr != nil {
+				return updateErr
+			}
+
+			app.loggerFor(ctx).Warn("webhook deactivated", jlog.Fields{"webhook_id": webhook.ID, "url": webhook.URL})
+			return jobs.Permanent(err)
+		}
+
+		return err
+	}
+}
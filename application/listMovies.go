@@ -28,6 +28,13 @@ func (app *Application) listMoviesHandler(writer http.ResponseWriter, request *h
 	input.Title = app.readString(qs, "title", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
 
+	// Read the facets which client wants counts for (e.g. ?facets=genres,decade).
+	input.Filters.Facets = app.readCSV(qs, "facets", []string{})
+
+	// Unfiltered counts may come from the periodically refreshed view if client opts
+	// in with ?facets_cached=true. Otherwise they are live, like the total records.
+	input.Filters.CachedFacets = app.readBool(qs, "facets_cached", false, v)
+
 	// Get the page and page_size query string values as integers. Notice that we set
 	// the default page value to 1 and default page_size to 20, and that we pass the
 	// validator instance as the final argument here.
@@ -38,6 +45,9 @@ func (app *Application) listMoviesHandler(writer http.ResponseWriter, request *h
 	// by the client (which will imply a ascending sort on movie ID).
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 
+	// Read the fields which client wants for each movie (e.g. ?fields=id,title).
+	input.Filters.Fields = app.readCSV(qs, "fields", []string{})
+
 	// Add safe sorts to safeSort slice for validation in follow.
 	input.Filters.SortSafeList = []string{
 		"id",
@@ -53,6 +63,8 @@ func (app *Application) listMoviesHandler(writer http.ResponseWriter, request *h
 	// Validating filters and if any error exists
 	// write that in v.
 	validator.ValidateFilters(v, input.Filters)
+	validator.ValidateFacets(v, input.Filters.Facets)
+	validator.ValidateMovieFields(v, input.Filters.Fields)
 
 	// Check for validation errors. if any error
 	// exist send failedValidationResponse() response.
@@ -61,15 +73,32 @@ func (app *Application) listMoviesHandler(writer http.ResponseWriter, request *h
 		return
 	}
 
-	// Get Movies based on query parameters by calling GetAll() method.
-	movies, metadata, err := app.Models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	// Get Movies based on query parameters by calling GetAll() method, with the facet
+	// counts if client asked for them.
+	movies, metadata, facets, err := app.Models.Movies.GetAll(request.Context(), input.Title, input.Genres, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 		return
 	}
 
+	env := envelope{"movies": movies, "metadata": metadata}
+
+	// Only send the requested fields of each movie if client asked for specific ones.
+	if len(input.Filters.Fields) > 0 {
+		projections := make([]map[string]interface{}, len(movies))
+		for i := range movies {
+			projections[i] = movies[i].Project(input.Filters.Fields)
+		}
+
+		env["movies"] = projections
+	}
+
+	if len(input.Filters.Facets) > 0 {
+		env["facets"] = facets
+	}
+
 	// Write JSON response to Body of response.
-	err = app.writeJSON(writer, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	err = app.writeResponse(writer, request, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 		return
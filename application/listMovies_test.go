@@ -0,0 +1,110 @@
+package application_test
+
+import (
+	"Meow/internal/apitest"
+	"Meow/internal/data"
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// The harnesses to run a test on: PostgreSQL, which is skipped without MEOW_TEST_DSN,
+// and the data in memory.
+var harnesses = []struct {
+	name string
+	new  func(t testing.TB, flags ...string) *apitest.Harness
+}{
+	{"postgres", apitest.New},
+	{"memory", apitest.NewMemory},
+}
+
+type movieList struct {
+	Movies   []data.Movie  `json:"movies"`
+	Metadata data.Metadata `json:"metadata"`
+	Facets   data.Facets   `json:"facets"`
+}
+
+func TestListMoviesFacets(t *testing.T) {
+	for _, h := range harnesses {
+		t.Run(h.name, func(t *testing.T) {
+			harness := h.new(t)
+			harness.CreateMovie("Casablanca", 1942, 102, "drama", "romance")
+			harness.CreateMovie("Vertigo", 1958, 128, "thriller", "mystery")
+			harness.CreateMovie("Rear Window", 1954, 112, "thriller", "mystery")
+
+			client := harness.ClientFor(harness.CreateUser("alice@example.com", true, "movies:read"))
+
+			// The counts are over every matching movie, not only the page.
+			var list movieList
+			client.Get("/v1/movies?genres=thriller&facets=genres,decade,runtime_bucket&page_size=1&sort=title").
+				Expect(http.StatusOK).Decode(&list)
+
+			if len(list.Movies) != 1 || list.Movies[0].Title != "Rear Window" || list.Metadata.TotalRecords != 2 {
+				t.Fatalf("got movies %+v and metadata %+v", list.Movies, list.Metadata)
+			}
+
+			want := data.Facets{
+				"genres":         {{Value: "mystery", Count: 2}, {Value: "thriller", Count: 2}},
+				"decade":         {{Value: "1950s", Count: 2}},
+				"runtime_bucket": {{Value: "120-149", Count: 1}, {Value: "90-119", Count: 1}},
+			}
+			if !reflect.DeepEqual(list.Facets, want) {
+				t.Errorf("got facets %+v, want %+v", list.Facets, want)
+			}
+
+			// Without matches the facets are there, with no counts.
+			list = movieList{}
+			client.Get("/v1/movies?title=psycho&facets=decade").Expect(http.StatusOK).Decode(&list)
+			if counts, ok := list.Facets["decade"]; !ok || len(counts) != 0 {
+				t.Errorf("got facets %+v, want no decades", list.Facets)
+			}
+
+			// Facets are only sent when asked for.
+			list = movieList{}
+			client.Get("/v1/movies?genres=thriller").Expect(http.StatusOK).Decode(&list)
+			if list.Facets != nil {
+				t.Errorf("got facets %+v", list.Facets)
+			}
+		})
+	}
+}
+
+// Unfiltered counts are live unless the client opts in to the view.
+func TestListMoviesCachedFacets(t *testing.T) {
+	for _, h := range harnesses {
+		t.Run(h.name, func(t *testing.T) {
+			harness := h.new(t)
+			harness.CreateMovie("Casablanca", 1942, 102, "drama")
+			harness.CreateMovie("Vertigo", 1958, 128, "thriller")
+
+			client := harness.ClientFor(harness.CreateUser("alice@example.com", true, "movies:read"))
+
+			decades := func(path string) []data.FacetCount {
+				var list movieList
+				client.Get(path).Expect(http.StatusOK).Decode(&list)
+				return list.Facets["decade"]
+			}
+
+			live := []data.FacetCount{{Value: "1940s", Count: 1}, {Value: "1950s", Count: 1}}
+			if got := decades("/v1/movies?facets=decade"); !reflect.DeepEqual(got, live) {
+				t.Errorf("got live counts %+v, want %+v", got, live)
+			}
+
+			// The view hasn't been refreshed since the movies were added.
+			if got := decades("/v1/movies?facets=decade&facets_cached=true"); len(got) != 0 {
+				t.Errorf("got cached counts %+v before a refresh", got)
+			}
+
+			if err := harness.Models.Movies.RefreshFacets(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := decades("/v1/movies?facets=decade&facets_cached=true"); !reflect.DeepEqual(got, live) {
+				t.Errorf("got cached counts %+v, want %+v", got, live)
+			}
+
+			client.Get("/v1/movies?facets=decade&facets_cached=maybe").Expect(http.StatusUnprocessableEntity)
+		})
+	}
+}
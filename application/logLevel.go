@@ -0,0 +1,49 @@
+package application
+
+import (
+	jlog "Meow/log"
+	"net/http"
+)
+
+// The showLogLevelHandler() returns the current minimum level of the logger.
+func (app *Application) showLogLevelHandler(writer http.ResponseWriter, request *http.Request) {
+	err := app.writeResponse(writer, request, http.StatusOK, envelope{"level": app.Logger.Level().String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// The updateLogLevelHandler() changes the minimum level of the logger without a
+// restart, e.g. to turn on DEBUG entries while investigating a problem. The level is
+// kept across configuration reloads unless -log-level itself is changed.
+func (app *Application) updateLogLevelHandler(writer http.ResponseWriter, request *http.Request) {
+	var input struct {
+		Level string `json:"level"`
+	}
+
+	err := app.readJSON(writer, request, &input)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+
+	level, err := jlog.ParseLevel(input.Level)
+	if err != nil {
+		app.failedValidationResponse(writer, request, map[string]string{"level": "must be one of debug, info, warn, error, fatal or off"})
+		return
+	}
+
+	previous := app.Logger.Level()
+	app.Logger.SetLevel(level)
+
+	// Log at WARN so the change is visible even when the new level is ERROR.
+	app.loggerFor(request.Context()).Warn("log level changed", jlog.Fields{
+		"from": previous.String(),
+		"to":   level.String(),
+	})
+
+	err = app.writeResponse(writer, request, http.StatusOK, envelope{"level": level.String()}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
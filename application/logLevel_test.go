@@ -0,0 +1,61 @@
+package application_test
+
+import (
+	"Meow/config"
+	"Meow/internal/apitest"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// A level set through the admin endpoint is kept by reloads until -log-level changes.
+func TestLogLevelSurvivesReload(t *testing.T) {
+	harness := apitest.NewMemory(t, "-log-level=info")
+	admin := harness.ClientFor(harness.CreateAdmin("admin@example.com"))
+
+	var mu sync.Mutex
+	configured := "info"
+
+	harness.App.ConfigSource = func() (*config.Config, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return config.Reload([]string{"-db=memory", "-log-level=" + configured}, func(string) (string, bool) { return "", false })
+	}
+
+	reload := func() (string, string) {
+		var reloaded struct {
+			Config struct {
+				LogLevel           string `json:"log_level"`
+				ConfiguredLogLevel string `json:"configured_log_level"`
+			} `json:"config"`
+		}
+
+		admin.Post("/v1/admin/config/reload", nil).Expect(http.StatusOK).Decode(&reloaded)
+		return reloaded.Config.LogLevel, reloaded.Config.ConfiguredLogLevel
+	}
+
+	admin.Put("/v1/admin/log-level", map[string]string{"level": "debug"}).Expect(http.StatusOK)
+
+	if level, configuredLevel := reload(); level != "DEBUG" || configuredLevel != "INFO" {
+		t.Fatalf("got level %s configured %s after a reload, want DEBUG and INFO", level, configuredLevel)
+	}
+
+	var shown struct {
+		Level string `json:"level"`
+	}
+
+	admin.Get("/v1/admin/log-level").Expect(http.StatusOK).Decode(&shown)
+	if shown.Level != "DEBUG" {
+		t.Fatalf("got level %s, want DEBUG", shown.Level)
+	}
+
+	// Editing the configured level applies it again.
+	mu.Lock()
+	configured = "warn"
+	mu.Unlock()
+
+	if level, configuredLevel := reload(); level != "WARN" || configuredLevel != "WARN" {
+		t.Fatalf("got level %s configured %s after changing it, want WARN", level, configuredLevel)
+	}
+}
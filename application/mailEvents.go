@@ -0,0 +1,118 @@
+package application
+
+import (
+	"Meow/internal/data"
+	"Meow/internal/validator"
+	"Meow/internal/webhooks"
+	jlog "Meow/log"
+	"Meow/mailer"
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+)
+
+// How old the signature of a bounce or complaint notification may be.
+const mailEventsTolerance = 5 * time.Minute
+
+// The mailEventsHandler() receives bounce and complaint notifications, e.g. from a
+// small adapter for the notifications of the email provider. The body is signed like
+// the webhooks we send, with -mail-events-secret in the X-Meow-Signature header.
+// Permanent bounces and complaints make the address undeliverable; temporary bounces
+// are ignored since the job queue retries them.
+func (app *Application) mailEventsHandler(writer http.ResponseWriter, request *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(writer, request.Body, 1_048_576))
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+
+	err = webhooks.Verify(app.Config.Mail.EventsSecret, request.Header.Get(webhooks.SignatureHeader), body, mailEventsTolerance)
+	if err != nil {
+		app.errorResponse(writer, request, http.StatusUnauthorized, "invalid or expired signature")
+		return
+	}
+
+	var input struct {
+		Events []struct {
+			Type      string `json:"type"`
+			Email     string `json:"email"`
+			Permanent bool   `json:"permanent"`
+		} `json:"events"`
+	}
+
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	err = app.readJSON(writer, request, &input)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.Events) > 0, "events", "must contain at least one event")
+	v.Check(len(input.Events) <= 1000, "events", "must not contain more than 1000 events")
+
+	for _, event := range input.Events {
+		v.Check(validator.In(event.Type, "bounce", "complaint"), "type", "must be bounce or complaint")
+		v.Check(validator.Matches(event.Email, validator.EmailRX), "email", "must be a valid email address")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+
+	var suppressed int
+	for _, event := range input.Events {
+		status := data.EmailComplained
+		if event.Type == "bounce" {
+			if !event.Permanent {
+				continue
+			}
+			status = data.EmailBounced
+		}
+
+		changed, err := app.Models.Users.SetEmailStatus(request.Context(), event.Email, status)
+		if err != nil {
+			app.serverErrorResponse(writer, request, err)
+			return
+		}
+
+		if changed {
+			suppressed++
+			app.loggerFor(request.Context()).Info("email address suppressed", jlog.Fields{"email": event.Email, "status": status})
+		}
+	}
+
+	err = app.writeResponse(writer, request, http.StatusOK, envelope{"received": len(input.Events), "suppressed": suppressed}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// The unsubscribeHandler() is the one-click unsubscribe endpoint of the
+// List-Unsubscribe header of bulk emails (RFC 8058). The email address and its token
+// are in the query string of the link; mail clients POST
+// "List-Unsubscribe=One-Click" to it. Transactional emails are still sent.
+func (app *Application) unsubscribeHandler(writer http.ResponseWriter, request *http.Request) {
+	qs := request.URL.Query()
+
+	email := app.readString(qs, "email", "")
+	token := app.readString(qs, "token", "")
+
+	if email == "" || !mailer.VerifyUnsubscribeToken(app.Config.Mail.UnsubscribeSecret, email, token) {
+		app.errorResponse(writer, request, http.StatusForbidden, "invalid unsubscribe link")
+		return
+	}
+
+	_, err := app.Models.Users.SetEmailStatus(request.Context(), email, data.EmailUnsubscribed)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+
+	err = app.writeResponse(writer, request, http.StatusOK, envelope{"message": "you have been unsubscribed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
@@ -0,0 +1,58 @@
+package application
+
+import (
+	"Meow/internal/validator"
+	"Meow/mailer"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// The previewMailHandler() renders an email template with its sample data, as HTML or
+// with ?part=text as plain text. The locale is ?locale= or the best match for the
+// Accept-Language header. It is only routed in development.
+func (app *Application) previewMailHandler(writer http.ResponseWriter, request *http.Request) {
+	name := httprouter.ParamsFromContext(request.Context()).ByName("template")
+
+	qs := request.URL.Query()
+	v := validator.New()
+
+	locale := app.readString(qs, "locale", "")
+	if locale == "" {
+		locale = app.Templates.MatchLocale(request.Header.Get("Accept-Language"))
+	}
+
+	part := app.readString(qs, "part", "html")
+
+	v.Check(app.Templates.HasLocale(locale), "locale", "must be one of the template locales")
+	v.Check(validator.In(part, "html", "text"), "part", "must be html or text")
+
+	if !v.Valid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+
+	message, err := app.Templates.Render(name, locale, app.Templates.Sample(name))
+	if err != nil {
+		switch {
+		case errors.Is(err, mailer.ErrUnknownTemplate):
+			app.notFoundResponse(writer, request)
+		default:
+			app.serverErrorResponse(writer, request, err)
+		}
+		return
+	}
+
+	writer.Header().Set("Content-Language", locale)
+
+	if part == "text" {
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(writer, "Subject: %s\n\n%s", message.Subject, message.PlainBody)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	writer.Write([]byte(message.HTMLBody))
+}
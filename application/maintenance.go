@@ -0,0 +1,88 @@
+package application
+
+import (
+	"Meow/internal/data"
+	"Meow/internal/scheduler"
+	jlog "Meow/log"
+	"context"
+	"hash/fnv"
+	"time"
+)
+
+// The maintenanceScheduler() method returns the scheduler of the maintenance tasks
+// which are enabled in app.Config. The leader is elected with an advisory lock, so
+// only one server runs them. Without a database there is only this server, so a
+// lock in memory is enough.
+func (app *Application) maintenanceScheduler() *scheduler.Scheduler {
+	key := fnv.New64a()
+	key.Write([]byte("meow maintenance scheduler"))
+
+	var lock scheduler.Lock = &data.AdvisoryLock{DB: app.DB, Key: int64(key.Sum64())}
+	if app.DB == nil {
+		lock = &data.MemoryLock{Key: int64(key.Sum64())}
+	}
+
+	candidates := []struct {
+		spec string
+		task scheduler.Task
+	}{
+		{app.Config.Scheduler.PurgeTokens, scheduler.Task{Name: "purge_expired_tokens", Timeout: time.Minute, Run: app.purgeExpiredTokens}},
+		{app.Config.Scheduler.DeleteUnactivated, scheduler.Task{Name: "delete_unactivated_users", Timeout: time.Minute, Run: app.deleteUnactivatedUsers}},
+		{app.Config.Scheduler.RefreshFacets, scheduler.Task{Name: "refresh_movie_facets", Timeout: 2 * time.Minute, Run: app.refreshMovieFacets}},
+		{app.Config.Scheduler.PurgeEvents, scheduler.Task{Name: "purge_movie_events", Timeout: 2 * time.Minute, Run: app.purgeMovieEvents}},
+	}
+
+	tasks := []scheduler.Task{}
+	for _, candidate := range candidates {
+		if candidate.spec == "" {
+			continue
+		}
+
+		// The schedules were checked when the configuration was loaded.
+		candidate.task.Schedule, _ = scheduler.Parse(candidate.spec)
+		tasks = append(tasks, candidate.task)
+	}
+
+	return scheduler.New(lock, app.Logger, scheduler.Options{LockInterval: app.Config.Scheduler.LockInterval}, tasks...)
+}
+
+// Delete the tokens which have expired. They can't be used anyway.
+func (app *Application) purgeExpiredTokens(ctx context.Context) (jlog.Fields, error) {
+	deleted, err := app.Models.Tokens.DeleteExpired(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return jlog.Fields{"deleted": deleted}, nil
+}
+
+// Delete the users who didn't activate their account within the configured number
+// of days.
+func (app *Application) deleteUnactivatedUsers(ctx context.Context) (jlog.Fields, error) {
+	createdBefore := time.Now().AddDate(0, 0, -app.Config.Scheduler.UnactivatedDays)
+
+	deleted, err := app.Models.Users.DeleteUnactivated(ctx, createdBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	return jlog.Fields{"deleted": deleted, "created_before": createdBefore.Format(time.RFC3339)}, nil
+}
+
+// Recalculate the facet counts over every movie.
+func (app *Application) refreshMovieFacets(ctx context.Context) (jlog.Fields, error) {
+	return nil, app.Models.Movies.RefreshFacets(ctx)
+}
+
+// Delete the movie events which were dispatched before the configured number of days,
+// with their webhook delivery logs.
+func (app *Application) purgeMovieEvents(ctx context.Context) (jlog.Fields, error) {
+	before := time.Now().AddDate(0, 0, -app.Config.Scheduler.EventDays)
+
+	deleted, err := app.Models.Events.DeleteDispatched(ctx, before)
+	if err != nil {
+		return nil, err
+	}
+
+	return jlog.Fields{"deleted": deleted, "dispatched_before": before.Format(time.RFC3339)}, nil
+}
@@ -0,0 +1,41 @@
+package application_test
+
+import (
+	"Meow/internal/apitest"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMetricsNeedAdmin(t *testing.T) {
+	harness := apitest.NewMemory(t)
+
+	reader := harness.ClientFor(harness.CreateUser("alice@example.com", true, "movies:read"))
+	admin := harness.ClientFor(harness.CreateAdmin("admin@example.com"))
+
+	for _, path := range []string{"/metrics", "/debug"} {
+		harness.Client().Get(path).Expect(http.StatusUnauthorized)
+		reader.Get(path).Expect(http.StatusForbidden)
+		admin.Get(path).Expect(http.StatusOK)
+	}
+}
+
+func TestMetricsMethodLabel(t *testing.T) {
+	harness := apitest.NewMemory(t)
+	admin := harness.ClientFor(harness.CreateAdmin("admin@example.com"))
+
+	admin.Do("PURGE", "/v1/movies", nil)
+	admin.Do("DELETE", "/v1/movies", nil)
+
+	body := string(admin.Get("/metrics").Expect(http.StatusOK).Body)
+
+	if strings.Contains(body, `method="PURGE"`) {
+		t.Error("the client's method is a label value")
+	}
+
+	for _, want := range []string{`method="OTHER"`, `method="DELETE"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("no %s in the metrics", want)
+		}
+	}
+}
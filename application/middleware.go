@@ -2,12 +2,18 @@ package application
 
 import (
 	"Meow/internal/data"
+	"Meow/internal/metrics"
+	"Meow/internal/tracing"
 	"Meow/internal/validator"
+	jlog "Meow/log"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"expvar"
 	"fmt"
 	"net"
 	"net/http"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
@@ -31,11 +37,15 @@ func (app *Application) recoverPanic(next http.Handler) http.Handler {
 				writer.Header().Set("Connection", "close")
 
 				// The value returned by recover() has the type interface{}, so we use
-				// fmt.Errorf() to normalize it into an error and call our
-				// serverErrorResponse() helper. In turn, this will log the error using
-				// our custom Logger type at the ERROR level and send the client a 500
-				// Internal Server Error response.
-				app.serverErrorResponse(writer, request, fmt.Errorf("%s", err))
+				// fmt.Errorf() to normalize it into an error. A panic is never expected,
+				// so log it with a stack trace before sending the client a 500 Internal
+				// Server Error response.
+				app.loggerFor(request.Context()).ErrorStack(fmt.Errorf("%s", err), jlog.Fields{
+					"request_method": request.Method,
+					"request_url":    request.URL.String(),
+				})
+
+				app.errorResponse(writer, request, http.StatusInternalServerError, "Internal server error")
 			}
 		}()
 
@@ -44,13 +54,24 @@ func (app *Application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// Paths which are never rate limited.
+var rateLimitExempt = map[string]bool{
+	"/v1/healthcheck/live":  true,
+	"/v1/healthcheck/ready": true,
+}
+
 // rateLimit() middleware
 func (app *Application) rateLimit(next http.Handler) http.Handler {
 	// Define a client struct to hold the rate limiter and last seen time for each
 	// client.
+	// Typeahead requests come with every keystroke, so they have a limiter of their
+	// own with the more lenient -limiter-suggest-rps and -limiter-suggest-burst, and
+	// don't use up the budget of the other requests.
 	type client struct {
-		limiter  *rate.Limiter
-		lastSeen time.Time
+		limiter        *rate.Limiter
+		suggestLimiter *rate.Limiter
+		lastSeen       time.Time
+		settings       *liveSettings
 	}
 
 	// Declare a mutex and a map to hold the clients' IP addresses and clients.
@@ -59,6 +80,9 @@ func (app *Application) rateLimit(next http.Handler) http.Handler {
 		clients = make(map[string]*client)
 	)
 
+	// Count the requests rejected by the rate limiter.
+	rejections := metrics.NewCounterVec("rate_limit_rejections_total", "Total number of requests rejected by the rate limiter.")
+
 	// Launch a background goroutine which removes old entries from
 	// the clients map every minute.
 	go func() {
@@ -85,8 +109,10 @@ func (app *Application) rateLimit(next http.Handler) http.Handler {
 	// The function we are returning is a closure, which 'closes over' the limiter
 	// variable.
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		// Check is rate limiter enabled?
-		if app.Config.Limiter.Enabled {
+		// Check is rate limiter enabled? Health probes are not limited here.
+		settings := app.settings()
+
+		if settings.limiterEnabled && !rateLimitExempt[request.URL.Path] {
 			// Extract the client's IP address from the request.
 			ip, _, err := net.SplitHostPort(request.RemoteAddr)
 			if err != nil {
@@ -101,18 +127,35 @@ func (app *Application) rateLimit(next http.Handler) http.Handler {
 			// initialize a new rate limiter and add the IP address and limiter to the map.
 			if _, found := clients[ip]; !found {
 				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.Config.Limiter.Rps), app.Config.Limiter.Burst),
+					limiter:        rate.NewLimiter(rate.Limit(settings.limiterRps), settings.limiterBurst),
+					suggestLimiter: rate.NewLimiter(rate.Limit(settings.limiterSuggestRps), settings.limiterSuggestBurst),
+					settings:       settings,
 				}
 			}
 
+			// Apply reloaded limits to clients we already know.
+			if clients[ip].settings != settings {
+				clients[ip].limiter.SetLimit(rate.Limit(settings.limiterRps))
+				clients[ip].limiter.SetBurst(settings.limiterBurst)
+				clients[ip].suggestLimiter.SetLimit(rate.Limit(settings.limiterSuggestRps))
+				clients[ip].suggestLimiter.SetBurst(settings.limiterSuggestBurst)
+				clients[ip].settings = settings
+			}
+
 			// Update last seen for current request.
 			clients[ip].lastSeen = time.Now()
 
+			limiter := clients[ip].limiter
+			if request.URL.Path == SUGGEST_PATH {
+				limiter = clients[ip].suggestLimiter
+			}
+
 			// Call the Allow() method on the rate limiter for the current IP address. If
 			// the request isn't allowed, unlock the mutex and send a 429 Too Many Requests
 			// response, just like before.
-			if !clients[ip].limiter.Allow() {
+			if !limiter.Allow() {
 				mu.Unlock()
+				rejections.Inc()
 				app.rateLimitExceededResponse(writer, request)
 				return
 			}
@@ -168,7 +211,11 @@ func (app *Application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.Models.Users.GetForToken(data.ScopeAuthentication, token)
+		ctx, span := tracing.Start(request.Context(), "authenticate", tracing.KindInternal)
+		user, err := app.Models.Users.GetForToken(ctx, data.ScopeAuthentication, token)
+		span.RecordError(err)
+		span.End()
+
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -180,6 +227,11 @@ func (app *Application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// Record the user for the access log.
+		if info := app.contextGetRequestInfo(request); info != nil {
+			info.userID = user.ID
+		}
+
 		request = app.contextSetUser(request, user)
 		next.ServeHTTP(writer, request)
 	})
@@ -207,7 +259,12 @@ func (app *Application) requirePermission(code string, next http.HandlerFunc) ht
 	fun := func(writer http.ResponseWriter, request *http.Request) {
 		user := app.contextGetUser(request)
 
-		permissions, err := app.Models.Permissions.GetAllForUser(user.ID)
+		ctx, span := tracing.Start(request.Context(), "requirePermission", tracing.KindInternal)
+		span.SetAttribute("permission", code)
+		permissions, err := app.Models.Permissions.GetAllForUser(ctx, user.ID)
+		span.RecordError(err)
+		span.End()
+
 		if err != nil {
 			app.serverErrorResponse(writer, request, err)
 			return
@@ -226,14 +283,16 @@ func (app *Application) requirePermission(code string, next http.HandlerFunc) ht
 
 func (app *Application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		writer.Header().Set("Vary", "Origin")
-		writer.Header().Set("Vary", "Access-Control-Request-Method")
+		// Use Add() so the Vary values set by other middleware are kept.
+		writer.Header().Add("Vary", "Origin")
+		writer.Header().Add("Vary", "Access-Control-Request-Method")
 
 		origin := request.Header.Get("Origin")
 
 		if origin != "" {
-			for i := range app.Config.Cors.TrustedOrigins {
-				if origin == app.Config.Cors.TrustedOrigins[i] {
+			trustedOrigins := app.settings().trustedOrigins
+			for i := range trustedOrigins {
+				if origin == trustedOrigins[i] {
 					writer.Header().Set("Access-Control-Allow-Origin", origin)
 
 					// Check if the request has the HTTP method OPTIONS and contains the
@@ -255,19 +314,247 @@ func (app *Application) enableCORS(next http.Handler) http.Handler {
 	})
 }
 
+// Return the expvar.Int published as name, publishing it first if needed. Like the
+// metrics package, this makes it safe to call Routes() more than once, e.g. for
+// several test servers in one process.
+func expvarInt(name string) *expvar.Int {
+	if existing, ok := expvar.Get(name).(*expvar.Int); ok {
+		return existing
+	}
+
+	return expvar.NewInt(name)
+}
+
+// Return the expvar.Map published as name, publishing it first if needed.
+func expvarMap(name string) *expvar.Map {
+	if existing, ok := expvar.Get(name).(*expvar.Map); ok {
+		return existing
+	}
+
+	return expvar.NewMap(name)
+}
+
 func (app *Application) metrics(next http.Handler) http.Handler {
-	totalRequestsReceived := expvar.NewInt("total_requests_received")
-	totalResponsesSend := expvar.NewInt("total_responses_send")
-	totalProcessingTimeMicroseconds := expvar.NewInt("total_processing_time_microseconds")
-	inflightRequests := expvar.NewInt("inflight_requests")
-	totalResponsesSentByStatus := expvar.NewMap("total_responses_sent_by_status")
+	totalRequestsReceived := expvarInt("total_requests_received")
+	totalResponsesSend := expvarInt("total_responses_send")
+	totalProcessingTimeMicroseconds := expvarInt("total_processing_time_microseconds")
+	inflightRequests := expvarInt("inflight_requests")
+	totalResponsesSentByStatus := expvarMap("total_responses_sent_by_status")
+	totalResponseBytesSent := expvarInt("total_response_bytes_sent")
+
+	// Per-route metrics in Prometheus format served by "/metrics".
+	httpRequests := metrics.NewCounterVec("http_requests_total", "Total number of HTTP requests.", "route", "method", "status")
+	httpDuration := metrics.NewHistogramVec("http_request_duration_seconds", "Latency of HTTP requests in seconds.", metrics.DefaultBuckets, "route", "method", "status")
+	httpResponseBytes := metrics.NewCounterVec("http_response_bytes_total", "Total bytes of HTTP responses sent.", "route", "method", "status")
 
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		totalRequestsReceived.Add(1)
+
 		metrics := httpsnoop.CaptureMetrics(next, writer, request)
 		totalResponsesSend.Add(1)
 		totalProcessingTimeMicroseconds.Add(metrics.Duration.Microseconds())
 		inflightRequests.Set(totalRequestsReceived.Value() - totalResponsesSend.Value())
 		totalResponsesSentByStatus.Add(strconv.Itoa(metrics.Code), 1)
+
+		// compress() runs inside this middleware, so these are the bytes actually sent
+		// to the client after compression.
+		totalResponseBytesSent.Add(metrics.Written)
+
+		// Requests which don't match any route keep the "unmatched" label, so random
+		// URLs can't create unlimited series.
+		route := "unmatched"
+		if info := app.contextGetRequestInfo(request); info != nil {
+			route = info.route
+		}
+
+		method := methodLabel(request.Method)
+		status := strconv.Itoa(metrics.Code)
+		httpRequests.Inc(route, method, status)
+		httpDuration.Observe(metrics.Duration.Seconds(), route, method, status)
+		httpResponseBytes.Add(float64(metrics.Written), route, method, status)
+	})
+}
+
+// Return the method label of a request. Clients can send any token as the method, so
+// everything but the standard methods is counted as "OTHER", like unmatched routes.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+
+	return "OTHER"
+}
+
+// recordRoute() middleware stores the pattern of the matched route in the request info
+// added by requestID() middleware.
+func (app *Application) recordRoute(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if info := app.contextGetRequestInfo(request); info != nil {
+			info.route = pattern
+		}
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// compress() middleware compresses response bodies with br, gzip or deflate based on
+// the Accept-Encoding header. Small bodies and already compressed types are sent as is.
+func (app *Application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// The body depends on the Accept-Encoding header, so caches must take it into
+		// account even when this response ends up uncompressed.
+		writer.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(request.Header.Get("Accept-Encoding"))
+		if encoding == "" || request.Method == http.MethodHead {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: writer, encoding: encoding}
+
+		// Close the writer even if the handler panics, so the buffered part of the
+		// response is not lost.
+		defer func() {
+			if err := cw.Close(); err != nil {
+				app.logError(request, err)
+			}
+		}()
+
+		next.ServeHTTP(cw, request)
+	})
+}
+
+// negotiateContent() middleware picks the response encoder from the Accept header and
+// the "pretty" query parameter. Clients which accept none of our formats get a 406 Not
+// Acceptable response before the handler runs. CSV is allowed here, and taken back
+// by withoutCSV() on the routes which don't respond with a list.
+func (app *Application) negotiateContent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		// The body depends on the Accept header, so caches must take it into account.
+		writer.Header().Add("Vary", "Accept")
+
+		// Event stream clients accept text/event-stream, and get errors as JSON.
+		encoder := negotiateEncoder(request.Header.Get("Accept"), true)
+		if encoder == nil && request.URL.Path == EVENTS_PATH {
+			encoder = jsonEncoder
+		}
+
+		if encoder == nil {
+			app.notAcceptableResponse(writer, request)
+			return
+		}
+
+		pretty := request.URL.Query().Get("pretty") == "true"
+		request = app.contextSetFormat(request, encoder, pretty)
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// withoutCSV() middleware negotiates the format again without CSV for a route which
+// doesn't respond with a list, so a client accepting only CSV gets 406 Not Acceptable
+// before the handler runs, instead of after it changed something.
+func (app *Application) withoutCSV(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		format := app.contextGetFormat(request)
+
+		if format.encoder == responseEncoders["text/csv"] {
+			encoder := negotiateEncoder(request.Header.Get("Accept"), false)
+			if encoder == nil {
+				app.notAcceptableResponse(writer, app.contextSetFormat(request, jsonEncoder, format.pretty))
+				return
+			}
+
+			request = app.contextSetFormat(request, encoder, format.pretty)
+		}
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// trace() middleware starts the server span of every request. It continues the trace
+// of the client if the request has a valid traceparent header, and sends the ids back
+// in the traceresponse header so clients can find the trace.
+func (app *Application) trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ctx := tracing.Extract(request.Context(), request.Header)
+		ctx, span := tracing.Start(ctx, "HTTP "+request.Method, tracing.KindServer)
+		defer span.End()
+
+		span.SetAttribute("http.request.method", request.Method)
+		span.SetAttribute("url.path", request.URL.Path)
+
+		writer.Header().Set("traceresponse", tracing.FormatTraceparent(span.SpanContext()))
+
+		metrics := httpsnoop.CaptureMetrics(next, writer, request.WithContext(ctx))
+
+		// The route is filled in by recordRoute() once the router matched.
+		if info := app.contextGetRequestInfo(request); info != nil {
+			span.SetName(request.Method + " " + info.route)
+			span.SetAttribute("http.route", info.route)
+			span.SetAttribute("request.id", info.id)
+		}
+
+		span.SetAttribute("http.response.status_code", metrics.Code)
+		if metrics.Code >= http.StatusInternalServerError {
+			span.RecordError(errors.New(http.StatusText(metrics.Code)))
+		}
+	})
+}
+
+// Request ids sent by clients are only kept if they match this pattern.
+var requestIDRX = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestID() middleware gives every request an id. It keeps the X-Request-ID header of
+// the client if it looks safe to log, or generates a new one, and sends it back in the
+// response. The id is stored in the request info with the other details of the
+// request.
+func (app *Application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		id := request.Header.Get("X-Request-ID")
+		if !requestIDRX.MatchString(id) {
+			randomBytes := make([]byte, 16)
+			rand.Read(randomBytes)
+			id = hex.EncodeToString(randomBytes)
+		}
+
+		writer.Header().Set("X-Request-ID", id)
+
+		request = app.contextSetRequestInfo(request, &requestInfo{id: id, route: "unmatched"})
+		next.ServeHTTP(writer, request)
+	})
+}
+
+// accessLog() middleware writes a log entry for every completed request.
+func (app *Application) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		metrics := httpsnoop.CaptureMetrics(next, writer, request)
+
+		// Use the same client address as the rate limiter.
+		ip, _, err := net.SplitHostPort(request.RemoteAddr)
+		if err != nil {
+			ip = request.RemoteAddr
+		}
+
+		fields := jlog.Fields{
+			"method":      request.Method,
+			"url":         request.URL.RequestURI(),
+			"status":      metrics.Code,
+			"bytes":       metrics.Written,
+			"duration_ms": float64(metrics.Duration.Microseconds()) / 1000,
+			"client_ip":   ip,
+		}
+
+		if info := app.contextGetRequestInfo(request); info != nil {
+			fields["route"] = info.route
+			if info.userID != 0 {
+				fields["user_id"] = info.userID
+			}
+		}
+
+		app.loggerFor(request.Context()).Info("request completed", fields)
 	})
 }
@@ -0,0 +1,187 @@
+package application
+
+import (
+	"Meow/internal/data"
+	"Meow/internal/stream"
+	"Meow/internal/validator"
+	jlog "Meow/log"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Path of the movie event stream. Like SUGGEST_PATH it is registered as
+// "/v1/movies/:id" and dispatched by showOrSuggestMovieHandler().
+const EVENTS_PATH = "/v1/movies/events"
+
+// The movieEventsHandler() streams the movie events as Server-Sent Events, optionally
+// only those of movies having all of some genres (?genres=drama,crime). A client
+// resuming with the Last-Event-ID header (or ?last_event_id=) first gets the events
+// it missed from the outbox table.
+func (app *Application) movieEventsHandler(writer http.ResponseWriter, request *http.Request) {
+	qs := request.URL.Query()
+	v := validator.New()
+
+	genres := app.readCSV(qs, "genres", []string{})
+
+	lastEventID := request.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = app.readString(qs, "last_event_id", "")
+	}
+
+	var after int64
+	if lastEventID != "" {
+		var err error
+		after, err = strconv.ParseInt(lastEventID, 10, 64)
+		v.Check(err == nil && after >= 0, "last_event_id", "must be a non-negative integer")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+
+	if app.streams == nil {
+		app.errorResponse(writer, request, http.StatusServiceUnavailable, "the event stream is not available")
+		return
+	}
+
+	// Subscribe before reading the missed events, so nothing falls in between.
+	subscription, err := app.streams.Subscribe(app.Config.Events.Buffer)
+	if err != nil {
+		app.errorResponse(writer, request, http.StatusServiceUnavailable, "the event stream is not available")
+		return
+	}
+	defer app.streams.Unsubscribe(subscription)
+
+	// The stream outlives the read and write timeouts of the server. The read deadline
+	// is removed, and every write gets its own deadline instead, so a client which
+	// stops reading is still disconnected.
+	controller := http.NewResponseController(writer)
+	controller.SetReadDeadline(time.Time{})
+
+	write := func(format string, args ...interface{}) error {
+		err := controller.SetWriteDeadline(time.Now().Add(app.Config.Server.WriteTimeout))
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(writer, format, args...); err != nil {
+			return err
+		}
+
+		return controller.Flush()
+	}
+
+	send := func(event *data.Event) error {
+		if !eventHasGenres(event, genres) {
+			return nil
+		}
+
+		encoded, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		return write("id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, encoded)
+	}
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.Header().Set("X-Accel-Buffering", "no")
+	writer.WriteHeader(http.StatusOK)
+
+	// Tell the client how long to wait before reconnecting.
+	if err := write("retry: 5000\n\n"); err != nil {
+		return
+	}
+
+	// Send the missed events. Live events up to the last of them were sent already.
+	replayed := after
+	for resuming := after > 0; resuming; {
+		events, err := app.Models.Events.GetAfter(request.Context(), replayed, 100)
+		if err != nil {
+			if request.Context().Err() == nil {
+				app.logError(request, err)
+			}
+			return
+		}
+
+		for _, event := range events {
+			if err := send(event); err != nil {
+				return
+			}
+			replayed = event.ID
+		}
+
+		resuming = len(events) == 100
+	}
+
+	heartbeat := time.NewTicker(app.Config.Events.HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+
+		case <-subscription.Done():
+			// A client which fell behind reconnects and resumes from the outbox.
+			if errors.Is(subscription.Err(), stream.ErrSlowConsumer) {
+				app.loggerFor(request.Context()).Warn("event stream client too slow", jlog.Fields{"buffer": app.Config.Events.Buffer})
+				write(": too slow, reconnect to resume\n\n")
+			}
+			return
+
+		case event := <-subscription.Events():
+			if event.ID <= replayed {
+				continue
+			}
+
+			if err := send(event); err != nil {
+				return
+			}
+
+		case <-heartbeat.C:
+			if err := write(": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Report whether the movie of event has every genre in genres.
+func eventHasGenres(event *data.Event, genres []string) bool {
+	if len(genres) == 0 {
+		return true
+	}
+
+	var payload struct {
+		Movie struct {
+			Genres []string `json:"genres"`
+		} `json:"movie"`
+	}
+
+	if err := json.Unmarshal(event.Data, &payload); err != nil {
+		return false
+	}
+
+	for _, genre := range genres {
+		found := false
+		for _, movieGenre := range payload.Movie.Genres {
+			if movieGenre == genre {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,58 @@
+package application_test
+
+import (
+	"Meow/internal/apitest"
+	"Meow/internal/data"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+type movieEnvelope struct {
+	Movie data.Movie `json:"movie"`
+}
+
+// Create, list, update and delete a movie through the API, with the data in memory.
+func TestMovieLifecycle(t *testing.T) {
+	harness := apitest.NewMemory(t)
+	harness.CreateMovie("Casablanca", 1942, 102, "drama")
+
+	client := harness.ClientFor(harness.CreateUser("alice@example.com", true, "movies:read", "movies:write"))
+
+	var created movieEnvelope
+	response := client.Post("/v1/movies", map[string]interface{}{"title": "Vertigo", "year": 1958, "runtime": "128 mins", "genres": []string{"thriller"}}).
+		Expect(http.StatusCreated)
+	response.Decode(&created)
+
+	path := fmt.Sprintf("/v1/movies/%d", created.Movie.ID)
+	if response.Header.Get("Location") != path || created.Movie.Version != 1 {
+		t.Fatalf("got movie %+v at %q", created.Movie, response.Header.Get("Location"))
+	}
+
+	var list struct {
+		Movies   []data.Movie  `json:"movies"`
+		Metadata data.Metadata `json:"metadata"`
+	}
+
+	client.Get("/v1/movies?genres=thriller").Expect(http.StatusOK).Decode(&list)
+	if len(list.Movies) != 1 || list.Movies[0].Title != "Vertigo" || list.Metadata.TotalRecords != 1 {
+		t.Fatalf("got %+v", list)
+	}
+
+	var updated movieEnvelope
+	client.Patch(path, map[string]interface{}{"year": 1959}).Expect(http.StatusOK).Decode(&updated)
+	if updated.Movie.Year != 1959 || updated.Movie.Version != 2 {
+		t.Fatalf("got movie %+v", updated.Movie)
+	}
+
+	client.Patch(path, map[string]interface{}{"year": 3000}).Expect(http.StatusUnprocessableEntity)
+
+	client.Delete(path).Expect(http.StatusOK)
+	client.Get(path).Expect(http.StatusNotFound)
+	client.Delete(path).Expect(http.StatusNotFound)
+
+	// Only the movies:read permission isn't enough to write.
+	reader := harness.ClientFor(harness.CreateUser("bob@example.com", true, "movies:read"))
+	reader.Get("/v1/movies/1").Expect(http.StatusOK)
+	reader.Delete("/v1/movies/1").Expect(http.StatusForbidden)
+}
@@ -0,0 +1,111 @@
+package application
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// The writeMsgpack() function writes a value of the normalized tree (see normalize())
+// in the MessagePack format. Only the types which can come out of the JSON decoder are
+// supported, so a small in-house implementation is enough.
+func writeMsgpack(buffer *bytes.Buffer, value interface{}) error {
+	switch value := value.(type) {
+	case nil:
+		buffer.WriteByte(0xc0)
+
+	case bool:
+		if value {
+			buffer.WriteByte(0xc3)
+		} else {
+			buffer.WriteByte(0xc2)
+		}
+
+	case json.Number:
+		// Integers keep their integer type, everything else is sent as float64.
+		if i, err := value.Int64(); err == nil {
+			writeMsgpackInt(buffer, i)
+			return nil
+		}
+
+		f, err := value.Float64()
+		if err != nil {
+			return err
+		}
+
+		buffer.WriteByte(0xcb)
+		binary.Write(buffer, binary.BigEndian, math.Float64bits(f))
+
+	case string:
+		writeMsgpackHeader(buffer, len(value), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		buffer.WriteString(value)
+
+	case []interface{}:
+		writeMsgpackHeader(buffer, len(value), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, item := range value {
+			if err := writeMsgpack(buffer, item); err != nil {
+				return err
+			}
+		}
+
+	case orderedObject:
+		writeMsgpackHeader(buffer, len(value), 0x80, 15, 0, 0xde, 0xdf)
+		for _, field := range value {
+			if err := writeMsgpack(buffer, field.key); err != nil {
+				return err
+			}
+
+			if err := writeMsgpack(buffer, field.value); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("msgpack: unsupported type %T", value)
+	}
+
+	return nil
+}
+
+// Write the smallest MessagePack integer representation of i.
+func writeMsgpackInt(buffer *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 127:
+		buffer.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buffer.WriteByte(byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buffer.WriteByte(0xd0)
+		buffer.WriteByte(byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buffer.WriteByte(0xd1)
+		binary.Write(buffer, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buffer.WriteByte(0xd2)
+		binary.Write(buffer, binary.BigEndian, int32(i))
+	default:
+		buffer.WriteByte(0xd3)
+		binary.Write(buffer, binary.BigEndian, i)
+	}
+}
+
+// Write the header of a string, array or map holding length items. fixed is the marker
+// of the fix-size form which can hold up to fixedMax items, and marker8, marker16 and
+// marker32 are the markers of the sized forms (marker8 is 0 if there is no 8-bit form).
+func writeMsgpackHeader(buffer *bytes.Buffer, length int, fixed byte, fixedMax int, marker8, marker16, marker32 byte) {
+	switch {
+	case length <= fixedMax:
+		buffer.WriteByte(fixed | byte(length))
+	case marker8 != 0 && length <= math.MaxUint8:
+		buffer.WriteByte(marker8)
+		buffer.WriteByte(byte(length))
+	case length <= math.MaxUint16:
+		buffer.WriteByte(marker16)
+		binary.Write(buffer, binary.BigEndian, uint16(length))
+	default:
+		buffer.WriteByte(marker32)
+		binary.Write(buffer, binary.BigEndian, uint32(length))
+	}
+}
@@ -0,0 +1,50 @@
+package application_test
+
+import (
+	"Meow/internal/apitest"
+	"Meow/internal/data"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCSVOnlyForLists(t *testing.T) {
+	harness := apitest.NewMemory(t)
+	harness.CreateMovie("Casablanca", 1942, 102, "drama")
+
+	client := harness.ClientFor(harness.CreateUser("alice@example.com", true, "movies:read", "movies:write"))
+	client.Header = http.Header{"Accept": {"text/csv"}}
+
+	response := client.Get("/v1/movies?fields=id,title").Expect(http.StatusOK)
+	if response.Header.Get("Content-Type") != "text/csv" || !strings.Contains(string(response.Body), "Casablanca") {
+		t.Errorf("got %s: %s", response.Header.Get("Content-Type"), response.Body)
+	}
+
+	// A client accepting only CSV is refused before the movie is created.
+	client.Post("/v1/movies", map[string]interface{}{"title": "Vertigo", "year": 1958, "runtime": "128 mins", "genres": []string{"thriller"}}).
+		Expect(http.StatusNotAcceptable)
+
+	_, metadata, _, err := harness.Models.Movies.GetAll(context.Background(), "vertigo", nil, data.Filters{Page: 1, PageSize: 20, Sort: "id", SortSafeList: []string{"id"}})
+	if err != nil || metadata.TotalRecords != 0 {
+		t.Fatalf("got %d movies named Vertigo (%v), want none", metadata.TotalRecords, err)
+	}
+
+	client.Get("/v1/movies/1").Expect(http.StatusNotAcceptable)
+
+	// With a fallback, a single movie is sent as JSON.
+	client.Header.Set("Accept", "text/csv, application/json;q=0.5")
+	response = client.Get("/v1/movies/1").Expect(http.StatusOK)
+	if response.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("got %s", response.Header.Get("Content-Type"))
+	}
+
+	// Errors of lists are CSV too, with their status.
+	anonymous := harness.Client()
+	anonymous.Header = http.Header{"Accept": {"text/csv"}}
+
+	response = anonymous.Get("/v1/movies").Expect(http.StatusUnauthorized)
+	if response.Header.Get("Content-Type") != "text/csv" || !strings.Contains(string(response.Body), "error") {
+		t.Errorf("got %s for an error: %s", response.Header.Get("Content-Type"), response.Body)
+	}
+}
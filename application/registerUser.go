@@ -5,7 +5,7 @@ import (
 	"Meow/internal/validator"
 	"errors"
 	"net/http"
-	"time"
+	"strings"
 )
 
 func (app *Application) registerUserHandler(writer http.ResponseWriter, request *http.Request) {
@@ -14,6 +14,7 @@ func (app *Application) registerUserHandler(writer http.ResponseWriter, request
 		Name     string `json:"name"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Locale   string `json:"locale"`
 	}
 
 	// Parse the request body to `input` struct.
@@ -28,6 +29,12 @@ func (app *Application) registerUserHandler(writer http.ResponseWriter, request
 		Name:      input.Name,
 		Email:     input.Email,
 		Activated: false,
+		Locale:    input.Locale,
+	}
+
+	// Without a locale the user gets the emails in the language of their browser.
+	if user.Locale == "" {
+		user.Locale = app.Templates.MatchLocale(request.Header.Get("Accept-Language"))
 	}
 
 	// Use the Password.Set() method to generate and store the hashed and plaintext
@@ -42,13 +49,20 @@ func (app *Application) registerUserHandler(writer http.ResponseWriter, request
 
 	// Validate the user struct and return the error messages to the client if any of
 	// the checks fail.
-	if validator.ValidateUser(v, user); !v.Valid() {
+	validator.ValidateUser(v, user)
+	v.Check(app.Templates.HasLocale(user.Locale), "locale", "must be one of "+strings.Join(app.Templates.Locales(), ", "))
+
+	if !v.Valid() {
 		app.failedValidationResponse(writer, request, v.Errors)
 		return
 	}
 
-	// Insert the user data into the database.
-	err = app.Models.Users.Insert(user)
+	// Insert the user with the read permission and queue the welcome email with the
+	// activation token, all in one transaction. The job survives restarts and is
+	// retried if the SMTP server is unavailable.
+	err = app.Models.Users.Register(request.Context(), user, []string{READ_PERMISSION}, func(user *data.User) (*data.Job, error) {
+		return welcomeEmailJob.New(request.Context(), welcomeEmail{UserID: user.ID, Email: user.Email, Locale: user.Locale})
+	})
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrDuplicateEmail):
@@ -60,36 +74,9 @@ func (app *Application) registerUserHandler(writer http.ResponseWriter, request
 		return
 	}
 
-	err = app.Models.Permissions.AddForUsers(user.ID, READ_PERMISSION)
-	if err != nil {
-		app.serverErrorResponse(writer, request, err)
-		return
-	}
-
-	// Generate a token for acvivation.
-	token, err := app.Models.Tokens.New(user.ID, 1*time.Hour, data.ScopeActivation)
-	if err != nil {
-		app.serverErrorResponse(writer, request, err)
-		return
-	}
-
-	// Run sending email and panic recover for that in background.
-	app.background(func() {
-
-		data := map[string]interface{}{
-			"userID":          user.ID,
-			"activationToken": token.Plaintext,
-		}
-
-		err := app.Mailer.Send(user.Email, "user_welcome.tmpl", data)
-		if err != nil {
-			app.Logger.PrintError(err, nil)
-		}
-	})
-
 	// Write a JSON response containing the user data along with a 201 Created status
 	// code.
-	err = app.writeJSON(writer, http.StatusAccepted, envelope{"user": user}, nil)
+	err = app.writeResponse(writer, request, http.StatusAccepted, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 		return
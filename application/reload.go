@@ -0,0 +1,127 @@
+package application
+
+import (
+	"Meow/config"
+	jlog "Meow/log"
+	"errors"
+	"net/http"
+)
+
+// The liveSettings type holds the settings which can change while the server runs.
+// Middleware reads them through app.settings() and a reload swaps in a new value
+// atomically, so requests always see a consistent set.
+type liveSettings struct {
+	limiterRps          float64
+	limiterBurst        int
+	limiterSuggestRps   float64
+	limiterSuggestBurst int
+	limiterEnabled      bool
+	trustedOrigins      []string
+	logLevel            jlog.Level
+}
+
+// Return the tunable settings of cfg.
+func newLiveSettings(cfg *config.Config) *liveSettings {
+	level, _ := jlog.ParseLevel(cfg.Log.Level)
+
+	return &liveSettings{
+		limiterRps:          cfg.Limiter.Rps,
+		limiterBurst:        cfg.Limiter.Burst,
+		limiterSuggestRps:   cfg.Limiter.SuggestRps,
+		limiterSuggestBurst: cfg.Limiter.SuggestBurst,
+		limiterEnabled:      cfg.Limiter.Enabled,
+		trustedOrigins:      append([]string{}, cfg.Cors.TrustedOrigins...),
+		logLevel:            level,
+	}
+}
+
+// The settings() method returns the current tunable settings, taken from app.Config
+// until the first reload.
+func (app *Application) settings() *liveSettings {
+	if settings := app.live.Load(); settings != nil {
+		return settings
+	}
+
+	app.live.CompareAndSwap(nil, newLiveSettings(app.Config))
+	return app.live.Load()
+}
+
+// The reloadConfig() method loads the configuration again from ConfigSource and swaps
+// in its tunable settings. Other settings need a restart and are ignored. If the new
+// configuration is invalid the current settings are kept and the error is logged and
+// returned.
+//
+// The log level is only set when -log-level itself changed, so a level set through
+// the admin endpoint survives reloads of other settings until the configured level
+// is edited.
+func (app *Application) reloadConfig() (*liveSettings, error) {
+	app.reloadMu.Lock()
+	defer app.reloadMu.Unlock()
+
+	if app.ConfigSource == nil {
+		return nil, errors.New("configuration reload is not supported")
+	}
+
+	cfg, err := app.ConfigSource()
+	if err != nil {
+		app.Logger.Error(err, jlog.Fields{"event": "configuration reload failed"})
+		return nil, err
+	}
+
+	previousLevel := app.Logger.Level()
+	previous := app.settings()
+	settings := newLiveSettings(cfg)
+
+	app.live.Store(settings)
+	if settings.logLevel != previous.logLevel {
+		app.Logger.SetLevel(settings.logLevel)
+	}
+
+	app.Logger.Info("configuration reloaded", jlog.Fields{
+		"limiter_rps":           settings.limiterRps,
+		"limiter_burst":         settings.limiterBurst,
+		"limiter_suggest_rps":   settings.limiterSuggestRps,
+		"limiter_suggest_burst": settings.limiterSuggestBurst,
+		"limiter_enabled":       settings.limiterEnabled,
+		"cors_trusted_origins":  (*config.StringList)(&settings.trustedOrigins).String(),
+		"log_level":             app.Logger.Level().String(),
+		"configured_log_level":  settings.logLevel.String(),
+		"previous_log_level":    previousLevel.String(),
+	})
+
+	return settings, nil
+}
+
+// The reloadConfigHandler() reloads the configuration like SIGHUP does, and responds
+// with the new tunable settings or the problems of the new configuration. The
+// log_level is the one in effect, which differs from configured_log_level while a
+// level set through the admin endpoint is kept.
+func (app *Application) reloadConfigHandler(writer http.ResponseWriter, request *http.Request) {
+	settings, err := app.reloadConfig()
+	if err != nil {
+		var validationErr *config.ValidationError
+		switch {
+		case errors.As(err, &validationErr):
+			app.failedValidationResponse(writer, request, validationErr.Problems)
+		default:
+			app.serverErrorResponse(writer, request, err)
+		}
+		return
+	}
+
+	env := envelope{"config": map[string]interface{}{
+		"limiter_rps":           settings.limiterRps,
+		"limiter_burst":         settings.limiterBurst,
+		"limiter_suggest_rps":   settings.limiterSuggestRps,
+		"limiter_suggest_burst": settings.limiterSuggestBurst,
+		"limiter_enabled":       settings.limiterEnabled,
+		"cors_trusted_origins":  settings.trustedOrigins,
+		"log_level":             app.Logger.Level().String(),
+		"configured_log_level":  settings.logLevel.String(),
+	}}
+
+	err = app.writeResponse(writer, request, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
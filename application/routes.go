@@ -1,6 +1,7 @@
 package application
 
 import (
+	"Meow/internal/metrics"
 	"expvar"
 	"net/http"
 
@@ -10,6 +11,7 @@ import (
 const (
 	READ_PERMISSION  = "movies:read"
 	WRITE_PERMISSION = "movies:write"
+	ADMIN_PERMISSION = "admin"
 )
 
 func (app *Application) Routes() http.Handler {
@@ -23,24 +25,74 @@ func (app *Application) Routes() http.Handler {
 	// it as the custom error handler for 405 Method Not Allowed responses.
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
 
+	// The handleList() function registers a handler for a method and URL pattern, and
+	// records the pattern in the request context for metrics() middleware. Its
+	// responses are lists, which can be sent as CSV.
+	handleList := func(method string, pattern string, handler http.Handler) {
+		router.Handler(method, pattern, app.recordRoute(pattern, handler))
+	}
+
+	// The handle() function registers a handler like handleList() whose responses
+	// aren't lists, so CSV is refused before it runs.
+	handle := func(method string, pattern string, handler http.Handler) {
+		handleList(method, pattern, app.withoutCSV(handler))
+	}
+
 	// Register the relevant methods, URL patterns and handler functions for our
-	// endpoints using the HandlerFunc() method. Note that http.MethodGet and
-	// http.MethodPost are constants which equate to the strings "GET" and "POST"
-	// respectively.
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission(WRITE_PERMISSION, app.createNewMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission(READ_PERMISSION, app.showMovieHandler))
-	router.HandlerFunc(http.MethodPut, "/v1/movies/:id", app.requirePermission(WRITE_PERMISSION, app.updateMovieHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission(WRITE_PERMISSION, app.updateMovieHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission(WRITE_PERMISSION, app.deleteMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission(READ_PERMISSION, app.listMoviesHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
-	router.Handler(http.MethodGet, "/debug", expvar.Handler())
+	// endpoints. Note that http.MethodGet and http.MethodPost are constants which
+	// equate to the strings "GET" and "POST" respectively.
+	handle(http.MethodGet, "/v1/healthcheck", http.HandlerFunc(app.healthcheckHandler))
+	handle(http.MethodGet, "/v1/healthcheck/live", http.HandlerFunc(app.livenessHandler))
+	handle(http.MethodGet, "/v1/healthcheck/ready", app.readinessHandler())
+	handle(http.MethodPost, "/v1/movies", app.requirePermission(WRITE_PERMISSION, app.createNewMovieHandler))
+	handleList(http.MethodGet, "/v1/movies/:id", app.showOrSuggestMovieHandler(app.suggestMoviesHandler()))
+	handle(http.MethodPut, "/v1/movies/:id", app.requirePermission(WRITE_PERMISSION, app.updateMovieHandler))
+	handle(http.MethodPatch, "/v1/movies/:id", app.requirePermission(WRITE_PERMISSION, app.updateMovieHandler))
+	handle(http.MethodDelete, "/v1/movies/:id", app.requirePermission(WRITE_PERMISSION, app.deleteMovieHandler))
+	handleList(http.MethodGet, "/v1/movies", app.requirePermission(READ_PERMISSION, app.listMoviesHandler))
+	handle(http.MethodPost, "/v1/users", http.HandlerFunc(app.registerUserHandler))
+	handle(http.MethodPut, "/v1/users/activated", http.HandlerFunc(app.activateUserHandler))
+	handle(http.MethodPost, "/v1/tokens/authentication", http.HandlerFunc(app.createAuthenticationTokenHandler))
+	handle(http.MethodGet, "/v1/admin/log-level", app.requirePermission(ADMIN_PERMISSION, app.showLogLevelHandler))
+	handle(http.MethodPut, "/v1/admin/log-level", app.requirePermission(ADMIN_PERMISSION, app.updateLogLevelHandler))
+	handle(http.MethodPost, "/v1/admin/config/reload", app.requirePermission(ADMIN_PERMISSION, app.reloadConfigHandler))
+	handleList(http.MethodGet, "/v1/admin/jobs", app.requirePermission(ADMIN_PERMISSION, app.showJobQueuesHandler))
+	handleList(http.MethodGet, "/v1/admin/jobs/failed", app.requirePermission(ADMIN_PERMISSION, app.listFailedJobsHandler))
+	handle(http.MethodPost, "/v1/admin/jobs/failed/:id/retry", app.requirePermission(ADMIN_PERMISSION, app.retryFailedJobHandler))
+	handle(http.MethodDelete, "/v1/admin/jobs/failed/:id", app.requirePermission(ADMIN_PERMISSION, app.deleteFailedJobHandler))
+	handleList(http.MethodGet, "/v1/webhooks", app.requirePermission(ADMIN_PERMISSION, app.listWebhooksHandler))
+	handle(http.MethodPost, "/v1/webhooks", app.requirePermission(ADMIN_PERMISSION, app.createWebhookHandler))
+	handle(http.MethodGet, "/v1/webhooks/:id", app.requirePermission(ADMIN_PERMISSION, app.showWebhookHandler))
+	handle(http.MethodPatch, "/v1/webhooks/:id", app.requirePermission(ADMIN_PERMISSION, app.updateWebhookHandler))
+	handle(http.MethodDelete, "/v1/webhooks/:id", app.requirePermission(ADMIN_PERMISSION, app.deleteWebhookHandler))
+	handleList(http.MethodGet, "/v1/webhooks/:id/deliveries", app.requirePermission(ADMIN_PERMISSION, app.listWebhookDeliveriesHandler))
+	handle(http.MethodPost, "/v1/webhooks/:id/deliveries/:delivery_id/redeliver", app.requirePermission(ADMIN_PERMISSION, app.redeliverWebhookHandler))
+
+	// The mail endpoints are only routed when their secrets are configured.
+	if app.Config.Mail.EventsSecret != "" {
+		handle(http.MethodPost, "/v1/mail/events", http.HandlerFunc(app.mailEventsHandler))
+	}
+
+	if app.Config.Mail.UnsubscribeURL != "" {
+		handle(http.MethodPost, "/v1/mail/unsubscribe", http.HandlerFunc(app.unsubscribeHandler))
+	}
+
+	// Email previews show sample tokens and are only useful while writing templates.
+	if app.Config.Env == "development" {
+		handle(http.MethodGet, "/v1/admin/mail/preview/:template", app.requirePermission(ADMIN_PERMISSION, app.previewMailHandler))
+	}
+
+	// The metrics and the expvar variables show the command line, traffic and pool
+	// stats, so they are for admins too. Prometheus can send a token with
+	// authorization.credentials_file in its scrape config.
+	handle(http.MethodGet, "/debug", app.requirePermission(ADMIN_PERMISSION, expvar.Handler().ServeHTTP))
+	handle(http.MethodGet, "/metrics", app.requirePermission(ADMIN_PERMISSION, metrics.Handler().ServeHTTP))
 
 	// Return the http.Handler instance.
 	// Wrapped with recoverPanic() middleware.
 	// Also wrap that with rateLimit() middleware. (v2)
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+	// negotiateContent() runs after CORS so preflight requests are never rejected.
+	// compress() runs inside metrics() so the counted bytes are the compressed ones.
+	// requestID() runs first so every other middleware can read the request info.
+	return app.requestID(app.accessLog(app.metrics(app.trace(app.compress(app.recoverPanic(app.enableCORS(app.negotiateContent(app.rateLimit(app.authenticate(router))))))))))
 }
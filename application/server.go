@@ -1,30 +1,151 @@
 package application
 
 import (
+	"Meow/internal/data"
+	"Meow/internal/scheduler"
+	"Meow/internal/stream"
+	jlog "Meow/log"
 	"context"
+	"crypto/tls"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 )
 
+// Start starts the background workers: the job runner, the event relay, the movie
+// event streams, the maintenance scheduler and, with a database, the event listener.
+// Serve() calls it; call it yourself to serve Routes() with another server, like
+// httptest.Server in tests. The returned function stops the workers and waits up to
+// -shutdown-task-timeout for the background tasks.
+func (app *Application) Start() (stop func()) {
+	// Run the job queues, and hand the movie events over to webhooks.
+	app.jobs = app.jobRunner()
+	app.runWorker("job runner", app.jobs.Run)
+
+	relay := app.eventRelay()
+	app.runWorker("event relay", relay.Run)
+
+	// Publish the movie events of every server to the event streams of this one.
+	app.streams = stream.NewBroker()
+
+	var listener *stream.Listener
+	if app.DB != nil {
+		listener = stream.NewListener(app.Config.DB.DSN, app.Models.Events, app.streams, app.Logger)
+		app.runWorker("event listener", listener.Run)
+	} else if events, ok := app.Models.Events.(data.MemoryEventModel); ok {
+		// Without a database this is the only server, and the in-memory model hands
+		// its events over itself.
+		events.Listen(app.streams.Publish)
+	}
+
+	// Run the maintenance tasks on whichever server holds the scheduler lock.
+	var maintenance *scheduler.Scheduler
+	if app.Config.Scheduler.Enabled {
+		maintenance = app.maintenanceScheduler()
+		app.runWorker("maintenance scheduler", maintenance.Run)
+	}
+
+	return func() {
+		// Stop taking jobs. Running jobs count as background tasks below, and are
+		// put back in their queues if they don't finish in time.
+		app.jobs.Stop()
+		relay.Stop()
+		app.streams.Close()
+
+		if listener != nil {
+			listener.Stop()
+		}
+
+		if maintenance != nil {
+			maintenance.Stop()
+		}
+
+		app.Logger.PrintInfo("completing background tasks", nil)
+
+		// Wait for background tasks up to their own deadline. Tasks still running
+		// after that are cancelled, and reported if they don't return shortly after.
+		ctx, cancel := context.WithTimeout(context.Background(), app.Config.Shutdown.TaskTimeout)
+		defer cancel()
+
+		app.waitForBackground(ctx, time.Second)
+	}
+}
+
 func (app *Application) Serve() error {
 	// Declare a http server with settings.
 	srv := &http.Server{
 		Addr:         app.Config.GetSport(),
 		Handler:      app.Routes(),
 		ErrorLog:     log.New(app.Logger, "", 0),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 20 * time.Second,
+		IdleTimeout:  app.Config.Server.IdleTimeout,
+		ReadTimeout:  app.Config.Server.ReadTimeout,
+		WriteTimeout: app.Config.Server.WriteTimeout,
 	}
 
+	// Serve HTTPS if a certificate is configured, optionally with a plain HTTP
+	// listener redirecting to it.
+	useTLS := app.Config.TLS.CertFile != ""
+	stopWatching := make(chan struct{})
+
+	var redirect *http.Server
+
+	if useTLS {
+		tlsConfig, err := app.tlsConfig(stopWatching)
+		if err != nil {
+			return err
+		}
+
+		srv.TLSConfig = tlsConfig
+
+		// A non-nil empty map stops net/http from enabling HTTP/2.
+		if !app.Config.TLS.HTTP2 {
+			srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		}
+
+		if app.Config.TLS.RedirectPort != 0 {
+			redirect = &http.Server{
+				Addr:         fmt.Sprintf(":%d", app.Config.TLS.RedirectPort),
+				Handler:      app.redirectToHTTPS(),
+				ErrorLog:     log.New(app.Logger, "", 0),
+				IdleTimeout:  time.Minute,
+				ReadTimeout:  5 * time.Second,
+				WriteTimeout: 5 * time.Second,
+			}
+
+			go func() {
+				err := redirect.ListenAndServe()
+				if !errors.Is(err, http.ErrServerClosed) {
+					app.Logger.PrintError(err, map[string]string{"addr": redirect.Addr})
+				}
+			}()
+		}
+	}
+
+	// Run the background workers until shutdown, and end the movie event streams when
+	// shutdown starts, since srv.Shutdown() waits for them.
+	stopWorkers := app.Start()
+	srv.RegisterOnShutdown(app.streams.Close)
+
 	// Create a shutdown error channel for any error returned from
-	// Shutdown() function.
-	shutdownErr := make(chan error)
+	// Shutdown() function. It is buffered so the shutdown goroutine never blocks on it.
+	shutdownErr := make(chan error, 1)
+
+	// Reload the tunable settings on SIGHUP. A failed reload is logged by
+	// reloadConfig() and keeps the current settings.
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+
+		for range hup {
+			app.reloadConfig()
+		}
+	}()
 
 	// Start a background goroutine.
 	go func() {
@@ -47,32 +168,53 @@ func (app *Application) Serve() error {
 			"signal": s.String(),
 		})
 
-		// Create a context with 5-second timeout.
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		// A second signal means the operator doesn't want to wait any longer.
+		go func() {
+			s := <-quit
+			app.Logger.Warn("forced exit", jlog.Fields{"signal": s.String()})
+			app.Logger.Close()
+			os.Exit(1)
+		}()
+
+		// Fail readiness checks from now on, and give load balancers time to notice
+		// before we stop accepting connections.
+		app.draining.Store(true)
+		time.Sleep(app.Config.Shutdown.DrainDelay)
+
+		// Give in-flight requests up to the shutdown timeout to complete.
+		ctx, cancel := context.WithTimeout(context.Background(), app.Config.Shutdown.Timeout)
 		defer cancel()
 
-		err := srv.Shutdown(ctx)
-		if err != nil {
-			shutdownErr <- err
+		close(stopWatching)
+
+		if redirect != nil {
+			redirect.Shutdown(ctx)
 		}
 
-		app.Logger.PrintInfo("completing background tasks", map[string]string{
-			"addr": srv.Addr,
-		})
+		err := srv.Shutdown(ctx)
 
-		app.Wg.Wait()
-		shutdownErr <- nil
+		// Stop the workers and wait for the background tasks, like sending emails.
+		stopWorkers()
+
+		shutdownErr <- err
 	}()
 
 	// Logging start serving message.
 	app.Logger.PrintInfo("start server", map[string]string{
 		"addr": srv.Addr,
 		"env":  app.Config.Env,
+		"tls":  strconv.FormatBool(useTLS),
 	})
 
 	// Calling Shutdown() on our server will cause ListenAndServe() to immediately
-	// return a http.ErrServerClosed error.
-	err := srv.ListenAndServe()
+	// return a http.ErrServerClosed error. The certificates come from TLSConfig, so
+	// no files are passed to ListenAndServeTLS().
+	var err error
+	if useTLS {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
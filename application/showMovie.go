@@ -2,6 +2,7 @@ package application
 
 import (
 	"Meow/internal/data"
+	"Meow/internal/validator"
 	"errors"
 	"net/http"
 )
@@ -16,8 +17,17 @@ func (app *Application) showMovieHandler(writer http.ResponseWriter, request *ht
 		return
 	}
 
+	// Read and validate the fields which client wants (e.g. ?fields=id,title).
+	v := validator.New()
+	fields := app.readCSV(request.URL.Query(), "fields", []string{})
+
+	if validator.ValidateMovieFields(v, fields); !v.Valid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+
 	// Use Get method to check for movie with this id.
-	movie, err := app.Models.Movies.Get(id)
+	movie, err := app.Models.Movies.Get(request.Context(), id, fields...)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -28,8 +38,14 @@ func (app *Application) showMovieHandler(writer http.ResponseWriter, request *ht
 		return
 	}
 
+	// Only send the requested fields if client asked for specific ones.
+	env := envelope{"movie": movie}
+	if len(fields) > 0 {
+		env["movie"] = movie.Project(fields)
+	}
+
 	// Encoding struct to json and write it in HTTP response body.
-	err = app.writeJSON(writer, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeResponse(writer, request, http.StatusOK, env, nil)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 	}
@@ -0,0 +1,80 @@
+package application
+
+import (
+	"Meow/internal/validator"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Path of the typeahead endpoint. It is exempt from the rate limiter because clients
+// call it on every key stroke.
+const SUGGEST_PATH = "/v1/movies/suggest"
+
+// Return a handler for the "GET /v1/movies/suggest" endpoint. Results are cached
+// in-process for a short time, so the same prefix typed by many users only hits the
+// database once.
+func (app *Application) suggestMoviesHandler() http.HandlerFunc {
+	cache := newTTLCache(30*time.Second, 1000)
+
+	return func(writer http.ResponseWriter, request *http.Request) {
+		qs := request.URL.Query()
+		v := validator.New()
+
+		// Read the query and the maximum number of suggestions.
+		query := strings.TrimSpace(app.readString(qs, "q", ""))
+		limit := app.readInt(qs, "limit", 5, v)
+
+		if validator.ValidateSuggestion(v, query, limit); !v.Valid() {
+			app.failedValidationResponse(writer, request, v.Errors)
+			return
+		}
+
+		key := fmt.Sprintf("%d:%s", limit, strings.ToLower(query))
+
+		suggestions, found := cache.get(key)
+		if !found {
+			var err error
+
+			suggestions, err = app.Models.Movies.Suggest(request.Context(), query, limit)
+			if err != nil {
+				app.serverErrorResponse(writer, request, err)
+				return
+			}
+
+			cache.set(key, suggestions)
+		}
+
+		err := app.writeResponse(writer, request, http.StatusOK, envelope{"suggestions": suggestions}, nil)
+		if err != nil {
+			app.serverErrorResponse(writer, request, err)
+		}
+	}
+}
+
+// httprouter (v1.3) does not allow a static segment next to the ":id" parameter, so
+// "/v1/movies/suggest" and "/v1/movies/events" are registered as "/v1/movies/:id" and
+// dispatched here. Their own paths are recorded as the route, before the permission
+// check, so the metrics, traces and access log tell them apart from showing a movie.
+// Only the suggestions are a list which can be sent as CSV.
+func (app *Application) showOrSuggestMovieHandler(suggest http.HandlerFunc) http.HandlerFunc {
+	suggestions := app.recordRoute(SUGGEST_PATH, app.requirePermission(READ_PERMISSION, suggest))
+	events := app.recordRoute(EVENTS_PATH, app.requirePermission(READ_PERMISSION, app.withoutCSV(http.HandlerFunc(app.movieEventsHandler)).ServeHTTP))
+	show := app.requirePermission(READ_PERMISSION, app.withoutCSV(http.HandlerFunc(app.showMovieHandler)).ServeHTTP)
+
+	return func(writer http.ResponseWriter, request *http.Request) {
+		switch httprouter.ParamsFromContext(request.Context()).ByName("id") {
+		case "suggest":
+			suggestions.ServeHTTP(writer, request)
+			return
+		case "events":
+			events.ServeHTTP(writer, request)
+			return
+		}
+
+		show(writer, request)
+	}
+}
@@ -0,0 +1,53 @@
+package application_test
+
+import (
+	"Meow/internal/apitest"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Suggestions have a limiter of their own, so typing neither is limited like the other
+// requests nor uses up their budget.
+func TestSuggestRateLimit(t *testing.T) {
+	harness := apitest.NewMemory(t, "-limiter-enabled=true",
+		"-limiter-rps=0.01", "-limiter-burst=2", "-limiter-suggest-rps=0.01", "-limiter-suggest-burst=4")
+	harness.CreateMovie("Casablanca", 1942, 102, "drama")
+
+	client := harness.ClientFor(harness.CreateUser("alice@example.com", true, "movies:read"))
+
+	for i := 0; i < 4; i++ {
+		client.Get("/v1/movies/suggest?q=cas").Expect(http.StatusOK)
+	}
+	client.Get("/v1/movies/suggest?q=casa").Expect(http.StatusTooManyRequests)
+
+	client.Get("/v1/movies/1").Expect(http.StatusOK)
+	client.Get("/v1/movies").Expect(http.StatusOK)
+	client.Get("/v1/movies").Expect(http.StatusTooManyRequests)
+}
+
+// The paths dispatched by showOrSuggestMovieHandler() are routes of their own in the
+// metrics, even when the permission check fails.
+func TestSuggestRoute(t *testing.T) {
+	harness := apitest.NewMemory(t)
+	harness.CreateMovie("Casablanca", 1942, 102, "drama")
+
+	admin := harness.ClientFor(harness.CreateAdmin("admin@example.com"))
+	admin.Get("/v1/movies/suggest?q=cas").Expect(http.StatusOK)
+	harness.Client().Get("/v1/movies/suggest?q=cas").Expect(http.StatusUnauthorized)
+	harness.Client().Get("/v1/movies/events").Expect(http.StatusUnauthorized)
+	admin.Get("/v1/movies/1").Expect(http.StatusOK)
+
+	body := string(admin.Get("/metrics").Expect(http.StatusOK).Body)
+
+	for _, want := range []string{
+		`http_requests_total{route="/v1/movies/suggest",method="GET",status="200"}`,
+		`http_requests_total{route="/v1/movies/suggest",method="GET",status="401"}`,
+		`http_requests_total{route="/v1/movies/events",method="GET",status="401"}`,
+		`http_requests_total{route="/v1/movies/:id",method="GET",status="200"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("no %s in the metrics", want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package application
+
+import (
+	"Meow/internal/tlsconfig"
+	jlog "Meow/log"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// The tlsConfig() method returns the TLS configuration of the server. The certificate
+// files are checked for changes every -tls-reload-interval until stop is closed, so
+// renewed certificates are used without a restart.
+func (app *Application) tlsConfig(stop <-chan struct{}) (*tls.Config, error) {
+	reloader, err := tlsconfig.NewReloader(app.Config.TLS.CertFile, app.Config.TLS.KeyFile, app.Config.TLS.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := tlsconfig.New(tlsconfig.Options{
+		MinVersion:   app.Config.TLS.MinVersion,
+		CipherSuites: app.Config.TLS.CipherSuites,
+		ClientAuth:   app.Config.TLS.ClientAuth,
+		HTTP2:        app.Config.TLS.HTTP2,
+	}, reloader)
+	if err != nil {
+		return nil, err
+	}
+
+	// A failed reload keeps the current certificate, so only log it.
+	go reloader.Watch(app.Config.TLS.ReloadInterval, stop, func(err error) {
+		if err != nil {
+			app.Logger.Error(err, jlog.Fields{"event": "certificate reload failed"})
+			return
+		}
+
+		app.Logger.Info("certificate reloaded", jlog.Fields{"cert": app.Config.TLS.CertFile})
+	})
+
+	return config, nil
+}
+
+// The redirectToHTTPS() method returns the handler of the plain HTTP listener. It
+// redirects every request to the same URL on the HTTPS port, with 308 Permanent
+// Redirect so clients keep the method and body.
+func (app *Application) redirectToHTTPS() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		host := request.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+
+		if app.Config.Port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(app.Config.Port))
+		}
+
+		target := url.URL{
+			Scheme:   "https",
+			Host:     host,
+			Path:     request.URL.Path,
+			RawQuery: request.URL.RawQuery,
+		}
+
+		http.Redirect(writer, request, target.String(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,82 @@
+package application_test
+
+import (
+	"Meow/internal/apitest"
+	"Meow/internal/tracing"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// The trace of a client is continued by the request, the job it enqueues and the
+// email the job sends.
+func TestTracePropagation(t *testing.T) {
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		remoteID = "00f067aa0ba902b7"
+	)
+
+	for _, h := range harnesses {
+		t.Run(h.name, func(t *testing.T) {
+			exporter := tracing.NewInMemoryExporter()
+			tracing.SetExporter(exporter, nil)
+			t.Cleanup(func() { tracing.Shutdown(context.Background()) })
+
+			harness := h.new(t)
+
+			client := harness.Client()
+			client.Header = http.Header{"Traceparent": {"00-" + traceID + "-" + remoteID + "-01"}}
+
+			response := client.Post("/v1/users", map[string]string{"name": "Alice", "email": "alice@example.com", "password": apitest.Password}).
+				Expect(http.StatusAccepted)
+
+			sc, ok := tracing.ParseTraceparent(response.Header.Get("traceresponse"))
+			if !ok || sc.TraceID.String() != traceID {
+				t.Fatalf("got traceresponse %q, want the trace %s", response.Header.Get("traceresponse"), traceID)
+			}
+
+			if _, err := harness.Mail.WaitFor("alice@example.com", 5*time.Second); err != nil {
+				t.Fatal(err)
+			}
+
+			// The job span ends just after the email was sent.
+			spans := make(map[string]tracing.SpanData)
+			for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+				tracing.ForceFlush()
+				for _, span := range exporter.Spans() {
+					if span.TraceID.String() == traceID {
+						spans[span.Name] = span
+					}
+				}
+
+				if _, found := spans["job send_welcome_email"]; found {
+					break
+				}
+			}
+
+			server, found := spans["POST /v1/users"]
+			if !found || server.ParentSpanID.String() != remoteID || server.SpanID != sc.SpanID {
+				t.Fatalf("got server span %+v, want a child of %s", server, remoteID)
+			}
+
+			job, found := spans["job send_welcome_email"]
+			if !found || job.Kind != tracing.KindConsumer {
+				t.Fatalf("got job span %+v in the trace", job)
+			}
+
+			send, found := spans["send email user_welcome"]
+			if !found || send.ParentSpanID != job.SpanID {
+				t.Errorf("got email span %+v, want a child of the job span", send)
+			}
+
+			// The queries run in the request are children of its spans.
+			if h.name == "postgres" {
+				insert, found := spans["UserModel.Insert"]
+				if !found || insert.Kind != tracing.KindClient || insert.Attributes["db.statement.name"] != "INSERT_USER_QUERY" {
+					t.Errorf("got query span %+v in the trace", insert)
+				}
+			}
+		})
+	}
+}
@@ -18,7 +18,7 @@ func (app *Application) updateMovieHandler(writer http.ResponseWriter, request *
 
 	// Fetch the existing movie record from the database, sending a 404 Not Found
 	// response to the client if we couldn't find a matching record.
-	movie, err := app.Models.Movies.Get(id)
+	movie, err := app.Models.Movies.Get(request.Context(), id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -71,7 +71,7 @@ func (app *Application) updateMovieHandler(writer http.ResponseWriter, request *
 	}
 
 	// Passing updated movie instance to Update() method.
-	err = app.Models.Movies.Update(movie)
+	err = app.Models.Movies.Update(request.Context(), movie)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -83,7 +83,7 @@ func (app *Application) updateMovieHandler(writer http.ResponseWriter, request *
 	}
 
 	// Returning back updated movie in JSON format.
-	err = app.writeJSON(writer, http.StatusOK, envelope{"movie": movie}, nil)
+	err = app.writeResponse(writer, request, http.StatusOK, envelope{"movie": movie}, nil)
 	if err != nil {
 		app.serverErrorResponse(writer, request, err)
 	}
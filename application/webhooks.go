@@ -0,0 +1,280 @@
+package application
+
+import (
+	"Meow/internal/data"
+	"Meow/internal/validator"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// The listWebhooksHandler() returns every registered webhook.
+func (app *Application) listWebhooksHandler(writer http.ResponseWriter, request *http.Request) {
+	webhooks, err := app.Models.Webhooks.GetAll(request.Context())
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+
+	err = app.writeResponse(writer, request, http.StatusOK, envelope{"webhooks": webhooks}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// The createWebhookHandler() registers a webhook for some movie events. A secret is
+// generated unless one is given, and the response is the only time it is shown.
+func (app *Application) createWebhookHandler(writer http.ResponseWriter, request *http.Request) {
+	var input struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+		Active *bool    `json:"active"`
+	}
+
+	err := app.readJSON(writer, request, &input)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+
+	webhook := &data.Webhook{
+		URL:    input.URL,
+		Events: input.Events,
+		Secret: input.Secret,
+		Active: true,
+	}
+
+	if input.Active != nil {
+		webhook.Active = *input.Active
+	}
+
+	if webhook.Secret == "" {
+		webhook.Secret, err = data.GenerateWebhookSecret()
+		if err != nil {
+			app.serverErrorResponse(writer, request, err)
+			return
+		}
+	}
+
+	v := validator.New()
+	if validator.ValidateWebhook(v, webhook); !v.Valid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+
+	err = app.Models.Webhooks.Insert(request.Context(), webhook)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/webhooks/%d", webhook.ID))
+
+	err = app.writeResponse(writer, request, http.StatusCreated, envelope{"webhook": webhook, "secret": webhook.Secret}, headers)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// The readWebhook() method returns the webhook whose ID is in the URL. It sends the
+// error response itself and returns nil if there is no such webhook.
+func (app *Application) readWebhook(writer http.ResponseWriter, request *http.Request) *data.Webhook {
+	id, err := app.readIdParam(request)
+	if err != nil {
+		app.notFoundResponse(writer, request)
+		return nil
+	}
+
+	webhook, err := app.Models.Webhooks.Get(request.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, request)
+		default:
+			app.serverErrorResponse(writer, request, err)
+		}
+		return nil
+	}
+
+	return webhook
+}
+
+// The showWebhookHandler() returns a webhook.
+func (app *Application) showWebhookHandler(writer http.ResponseWriter, request *http.Request) {
+	webhook := app.readWebhook(writer, request)
+	if webhook == nil {
+		return
+	}
+
+	err := app.writeResponse(writer, request, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// The updateWebhookHandler() changes the URL, events, secret or active flag of a
+// webhook. Deliveries which are already queued use the new settings.
+func (app *Application) updateWebhookHandler(writer http.ResponseWriter, request *http.Request) {
+	webhook := app.readWebhook(writer, request)
+	if webhook == nil {
+		return
+	}
+
+	var input struct {
+		URL    *string  `json:"url"`
+		Events []string `json:"events"`
+		Secret *string  `json:"secret"`
+		Active *bool    `json:"active"`
+	}
+
+	err := app.readJSON(writer, request, &input)
+	if err != nil {
+		app.badRequestResponse(writer, request, err)
+		return
+	}
+
+	if input.URL != nil {
+		webhook.URL = *input.URL
+	}
+
+	if input.Events != nil {
+		webhook.Events = input.Events
+	}
+
+	if input.Secret != nil {
+		webhook.Secret = *input.Secret
+	}
+
+	if input.Active != nil {
+		webhook.Active = *input.Active
+	}
+
+	v := validator.New()
+	if validator.ValidateWebhook(v, webhook); !v.Valid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+
+	err = app.Models.Webhooks.Update(request.Context(), webhook)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(writer, request)
+		default:
+			app.serverErrorResponse(writer, request, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(writer, request, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// The deleteWebhookHandler() deletes a webhook and its delivery logs.
+func (app *Application) deleteWebhookHandler(writer http.ResponseWriter, request *http.Request) {
+	id, err := app.readIdParam(request)
+	if err != nil {
+		app.notFoundResponse(writer, request)
+		return
+	}
+
+	err = app.Models.Webhooks.Delete(request.Context(), id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, request)
+		default:
+			app.serverErrorResponse(writer, request, err)
+		}
+		return
+	}
+
+	err = app.writeResponse(writer, request, http.StatusOK, envelope{"message": "webhook deleted successfully"}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// The listWebhookDeliveriesHandler() returns a page of the delivery logs of a
+// webhook, newest first by default.
+func (app *Application) listWebhookDeliveriesHandler(writer http.ResponseWriter, request *http.Request) {
+	webhook := app.readWebhook(writer, request)
+	if webhook == nil {
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+	qs := request.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-created_at")
+	input.Filters.SortSafeList = []string{"id", "-id", "created_at", "-created_at"}
+
+	if validator.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(writer, request, v.Errors)
+		return
+	}
+
+	deliveries, metadata, err := app.Models.Webhooks.GetDeliveries(request.Context(), webhook.ID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+
+	err = app.writeResponse(writer, request, http.StatusOK, envelope{"deliveries": deliveries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
+
+// The redeliverWebhookHandler() sends the event of a delivery to its webhook again,
+// with a fresh set of attempts, e.g. once the receiver was fixed.
+func (app *Application) redeliverWebhookHandler(writer http.ResponseWriter, request *http.Request) {
+	webhook := app.readWebhook(writer, request)
+	if webhook == nil {
+		return
+	}
+
+	deliveryID, err := app.readNamedIdParam(request, "delivery_id")
+	if err != nil {
+		app.notFoundResponse(writer, request)
+		return
+	}
+
+	delivery, err := app.Models.Webhooks.GetDelivery(request.Context(), webhook.ID, deliveryID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(writer, request)
+		default:
+			app.serverErrorResponse(writer, request, err)
+		}
+		return
+	}
+
+	job, err := webhookJob.New(request.Context(), data.WebhookJob{WebhookID: webhook.ID, EventID: delivery.EventID})
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+
+	err = app.Models.Jobs.Enqueue(request.Context(), job)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+		return
+	}
+
+	err = app.writeResponse(writer, request, http.StatusAccepted, envelope{"job": job}, nil)
+	if err != nil {
+		app.serverErrorResponse(writer, request, err)
+	}
+}
@@ -0,0 +1,211 @@
+package application_test
+
+import (
+	"Meow/internal/apitest"
+	"Meow/internal/data"
+	"Meow/internal/webhooks"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A receiver of webhooks which responds with the statuses it is given, one per request,
+// and with the last one when they run out.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	received []*http.Request
+	arrived  chan struct{}
+}
+
+func newReceiver(t *testing.T, statuses ...int) (*receiver, *httptest.Server) {
+	receiver := &receiver{statuses: statuses, arrived: make(chan struct{}, 100)}
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		io.Copy(io.Discard, request.Body)
+
+		receiver.mu.Lock()
+		status := receiver.statuses[0]
+		if len(receiver.statuses) > 1 {
+			receiver.statuses = receiver.statuses[1:]
+		}
+		receiver.received = append(receiver.received, request)
+		receiver.mu.Unlock()
+
+		writer.WriteHeader(status)
+		receiver.arrived <- struct{}{}
+	}))
+	t.Cleanup(server.Close)
+
+	return receiver, server
+}
+
+// Wait for n more requests.
+func (receiver *receiver) wait(t *testing.T, n int) {
+	t.Helper()
+
+	for i := 0; i < n; i++ {
+		select {
+		case <-receiver.arrived:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("got %d of %d webhook requests", i, n)
+		}
+	}
+}
+
+// Start the API with fast retries, an admin client and a webhook for url subscribed
+// to the created movies.
+func newWebhookHarness(t *testing.T, url string) (*apitest.Harness, *apitest.Client, int64) {
+	harness := apitest.NewMemory(t, "-jobs-min-backoff=10ms", "-jobs-max-backoff=20ms", "-webhooks-relay-interval=10ms")
+	admin := harness.ClientFor(harness.CreateAdmin("admin@example.com"))
+
+	var created struct {
+		Webhook data.Webhook `json:"webhook"`
+	}
+
+	admin.Post("/v1/webhooks", map[string]interface{}{"url": url, "events": []string{data.EventMovieCreated}}).
+		Expect(http.StatusCreated).Decode(&created)
+
+	return harness, admin, created.Webhook.ID
+}
+
+// Poll the deliveries of a webhook until there are n of them, and return them oldest
+// first.
+func waitForDeliveries(t *testing.T, admin *apitest.Client, webhookID int64, n int) []data.Delivery {
+	t.Helper()
+
+	var page struct {
+		Deliveries []data.Delivery `json:"deliveries"`
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		admin.Get(fmt.Sprintf("/v1/webhooks/%d/deliveries?sort=id", webhookID)).Expect(http.StatusOK).Decode(&page)
+		if len(page.Deliveries) >= n {
+			return page.Deliveries
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("got %d deliveries, want %d", len(page.Deliveries), n)
+	return nil
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	receiver, server := newReceiver(t, http.StatusOK)
+	harness, admin, webhookID := newWebhookHarness(t, server.URL)
+
+	movie := harness.CreateMovie("Casablanca", 1942, 102, "drama")
+	receiver.wait(t, 1)
+
+	request := receiver.received[0]
+	if request.Header.Get(webhooks.EventHeader) != data.EventMovieCreated {
+		t.Errorf("got %s %q", webhooks.EventHeader, request.Header.Get(webhooks.EventHeader))
+	}
+
+	if request.Header.Get(webhooks.SignatureHeader) == "" || request.Header.Get(webhooks.EventIDHeader) == "" {
+		t.Errorf("got headers %v", request.Header)
+	}
+
+	deliveries := waitForDeliveries(t, admin, webhookID, 1)
+	if deliveries[0].StatusCode != http.StatusOK || deliveries[0].Attempt != 1 || deliveries[0].Error != "" {
+		t.Errorf("got delivery %+v of movie %d", deliveries[0], movie.ID)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	receiver, server := newReceiver(t, http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusOK)
+	harness, admin, webhookID := newWebhookHarness(t, server.URL)
+
+	harness.CreateMovie("Casablanca", 1942, 102, "drama")
+	receiver.wait(t, 3)
+
+	deliveries := waitForDeliveries(t, admin, webhookID, 3)
+
+	for i, want := range []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusOK} {
+		if deliveries[i].StatusCode != want || deliveries[i].Attempt != i+1 {
+			t.Errorf("got delivery %d %+v, want status %d", i, deliveries[i], want)
+		}
+	}
+
+	if deliveries[0].Error == "" || deliveries[2].Error != "" {
+		t.Errorf("got errors %q and %q", deliveries[0].Error, deliveries[2].Error)
+	}
+
+	// The retries are of the same event.
+	if deliveries[0].EventID != deliveries[2].EventID {
+		t.Errorf("got events %d and %d", deliveries[0].EventID, deliveries[2].EventID)
+	}
+}
+
+func TestWebhookGoneDeactivates(t *testing.T) {
+	receiver, server := newReceiver(t, http.StatusGone)
+	harness, admin, webhookID := newWebhookHarness(t, server.URL)
+
+	harness.CreateMovie("Casablanca", 1942, 102, "drama")
+	receiver.wait(t, 1)
+
+	var shown struct {
+		Webhook data.Webhook `json:"webhook"`
+	}
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		admin.Get("/v1/webhooks/" + strconv.FormatInt(webhookID, 10)).Expect(http.StatusOK).Decode(&shown)
+		if !shown.Webhook.Active {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("webhook still active")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 410 isn't retried, and a deactivated webhook gets no more events.
+	harness.CreateMovie("Vertigo", 1958, 128, "thriller")
+	time.Sleep(200 * time.Millisecond)
+
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+
+	if len(receiver.received) != 1 {
+		t.Errorf("got %d requests, want 1", len(receiver.received))
+	}
+}
+
+func TestWebhookRedeliver(t *testing.T) {
+	receiver, server := newReceiver(t, http.StatusOK)
+	harness, admin, webhookID := newWebhookHarness(t, server.URL)
+
+	harness.CreateMovie("Casablanca", 1942, 102, "drama")
+	receiver.wait(t, 1)
+
+	delivery := waitForDeliveries(t, admin, webhookID, 1)[0]
+
+	admin.Post(fmt.Sprintf("/v1/webhooks/%d/deliveries/%d/redeliver", webhookID, delivery.ID), nil).Expect(http.StatusAccepted)
+	receiver.wait(t, 1)
+
+	deliveries := waitForDeliveries(t, admin, webhookID, 2)
+	if deliveries[1].EventID != delivery.EventID || deliveries[1].Attempt != 1 {
+		t.Errorf("got redelivery %+v of %+v", deliveries[1], delivery)
+	}
+
+	receiver.mu.Lock()
+	first, second := receiver.received[0].Header.Get(webhooks.EventIDHeader), receiver.received[1].Header.Get(webhooks.EventIDHeader)
+	receiver.mu.Unlock()
+
+	if first != second {
+		t.Errorf("got event IDs %s and %s", first, second)
+	}
+
+	// Deliveries of other webhooks aren't found.
+	admin.Post(fmt.Sprintf("/v1/webhooks/%d/deliveries/%d/redeliver", webhookID+1, delivery.ID), nil).Expect(http.StatusNotFound)
+	admin.Post(fmt.Sprintf("/v1/webhooks/%d/deliveries/%d/redeliver", webhookID, delivery.ID+100), nil).Expect(http.StatusNotFound)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"Meow/config"
+	"Meow/internal/metrics"
+	jlog "Meow/log"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// The number of entries queued for each file or syslog sink before entries are dropped.
+const logQueueSize = 4096
+
+// The logSink() function builds the sink of the logger from the configuration: stdout
+// in JSON or console format, plus a rotating file and syslog if configured. The file
+// and syslog sinks write from their own goroutines, so a full disk or unreachable
+// daemon never blocks requests or the other sinks. The returned function closes the
+// log file and must be called after the logger is closed.
+func logSink(cfg *config.Config) (jlog.Sink, func(), error) {
+	format := cfg.Log.Format
+	if format == "auto" {
+		format = "json"
+		if cfg.Env == "development" {
+			format = "console"
+		}
+	}
+
+	sinks := []jlog.Sink{}
+	switch format {
+	case "json":
+		sinks = append(sinks, jlog.NewJSONSink(os.Stdout))
+	case "console":
+		sinks = append(sinks, jlog.NewConsoleSink(os.Stdout, isTerminal(os.Stdout)))
+	default:
+		return nil, nil, fmt.Errorf("unknown log format %q", cfg.Log.Format)
+	}
+
+	// Report sink errors on stderr, at most once a minute so a dead sink doesn't flood
+	// it.
+	sometimes := &rate.Sometimes{Interval: time.Minute}
+	onError := func(err error) {
+		sometimes.Do(func() {
+			fmt.Fprintf(os.Stderr, "log sink error: %v\n", err)
+		})
+	}
+
+	var asyncSinks []*jlog.AsyncSink
+	closeFile := func() {}
+
+	if cfg.Log.File.Path != "" {
+		file, err := jlog.OpenRotatingFile(cfg.Log.File.Path, jlog.RotateOptions{
+			MaxSize:    int64(cfg.Log.File.MaxSize) * 1024 * 1024,
+			Interval:   cfg.Log.File.Interval,
+			MaxAge:     cfg.Log.File.MaxAge,
+			MaxBackups: cfg.Log.File.MaxBackups,
+			Compress:   cfg.Log.File.Compress,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		closeFile = func() { file.Close() }
+		asyncSinks = append(asyncSinks, jlog.NewAsyncSink(jlog.NewJSONSink(file), logQueueSize, onError))
+	}
+
+	if cfg.Log.Syslog != "" {
+		syslog, err := jlog.NewSyslogSink(cfg.Log.Syslog, "meow")
+		if err != nil {
+			closeFile()
+			return nil, nil, err
+		}
+
+		asyncSinks = append(asyncSinks, jlog.NewAsyncSink(syslog, logQueueSize, onError))
+	}
+
+	for _, async := range asyncSinks {
+		sinks = append(sinks, async)
+	}
+
+	metrics.NewCounterFunc("log_entries_dropped_total", "Total number of log entries dropped because a sink fell behind.", func() float64 {
+		var dropped int64
+		for _, async := range asyncSinks {
+			dropped += async.Dropped()
+		}
+
+		return float64(dropped)
+	})
+
+	if len(sinks) == 1 {
+		return sinks[0], closeFile, nil
+	}
+
+	return jlog.NewMultiSink(sinks...), closeFile, nil
+}
+
+// The isTerminal() function reports whether file is a terminal which should get
+// coloured output. Setting NO_COLOR turns colours off.
+func isTerminal(file *os.File) bool {
+	if _, found := os.LookupEnv("NO_COLOR"); found || strings.EqualFold(os.Getenv("TERM"), "dumb") {
+		return false
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -4,15 +4,18 @@ import (
 	"Meow/application"
 	"Meow/config"
 	"Meow/internal/data"
+	"Meow/internal/metrics"
+	"Meow/internal/tracing"
 	jlog "Meow/log"
 	"Meow/mailer"
+	"context"
 	"database/sql"
 	"expvar"
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
 	"runtime"
-	"strings"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -32,91 +35,199 @@ func expvarValues(db *sql.DB) {
 		return runtime.NumGoroutine()
 	}))
 
-	expvar.Publish("database", expvar.Func(func() interface{} {
-		return db.Stats()
-	}))
+	// There are no pool stats when the data is kept in memory.
+	if db != nil {
+		expvar.Publish("database", expvar.Func(func() interface{} {
+			return db.Stats()
+		}))
+	}
 
 	expvar.Publish("timestamp", expvar.Func(func() interface{} {
 		return time.Now().Unix()
 	}))
 }
 
+// Publish the stats of the database pool, if there is one, in Prometheus format.
+func metricsValues(db *sql.DB) {
+	if db != nil {
+		metrics.NewGaugeFunc("db_open_connections", "Number of established database connections.", func() float64 {
+			return float64(db.Stats().OpenConnections)
+		})
+
+		metrics.NewGaugeFunc("db_in_use_connections", "Number of database connections currently in use.", func() float64 {
+			return float64(db.Stats().InUse)
+		})
+
+		metrics.NewGaugeFunc("db_idle_connections", "Number of idle database connections.", func() float64 {
+			return float64(db.Stats().Idle)
+		})
+
+		metrics.NewGaugeFunc("db_max_open_connections", "Maximum number of open database connections.", func() float64 {
+			return float64(db.Stats().MaxOpenConnections)
+		})
+
+		metrics.NewCounterFunc("db_wait_count_total", "Total number of connections waited for.", func() float64 {
+			return float64(db.Stats().WaitCount)
+		})
+
+		metrics.NewCounterFunc("db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.", func() float64 {
+			return db.Stats().WaitDuration.Seconds()
+		})
+
+		metrics.NewCounterFunc("db_max_idle_time_closed_total", "Total number of connections closed due to max idle time.", func() float64 {
+			return float64(db.Stats().MaxIdleTimeClosed)
+		})
+	}
+
+	metrics.NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+}
+
+// Return the mail transport chosen by -mail-driver.
+func mailTransport(cfg *config.Config, logger *jlog.Logger) mailer.Transport {
+	switch cfg.Mail.Driver {
+	case "file":
+		return mailer.NewFile(cfg.Mail.Dir)
+	case "memory":
+		return mailer.NewMemory()
+	case "log":
+		return mailer.NewLog(logger)
+	default:
+		return mailer.NewSMTP(cfg.Smtp.Host, cfg.Smtp.Port, cfg.Smtp.Username, cfg.Smtp.Password)
+	}
+}
+
 func main() {
 	// Declare an instance of config struct.
 	cfg := new(config.Config)
 
-	// expvar customization
-
-	// Read the value of the port and env command-line flags into the config struct. We
-	// default to using the port number 4000 and the environment "development" if no
-	// corresponding flags are provided.
-	flag.IntVar(&cfg.Port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.Env, "env", "development", "Environment (development|staging|production)")
-
-	// Read the DSN from command line flags into config struct.
-	flag.StringVar(&cfg.DB.DSN, "dsn", os.Getenv("dsn"), "PostgreSQL DSN")
-
-	// Read the connection pool settings from command-line flags.
-	flag.IntVar(&cfg.DB.MaxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.DB.MaxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.StringVar(&cfg.DB.MaxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max idle time")
-	flag.Float64Var(&cfg.Limiter.Rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.Limiter.Burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.Limiter.Enabled, "limiter-enabled", true, "Rate limiter enable-disable")
-
-	flag.StringVar(&cfg.Smtp.Host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
-	flag.IntVar(&cfg.Smtp.Port, "smtp-port", 2525, "SMTP port")
-	flag.StringVar(&cfg.Smtp.Username, "smtp-username", "-", "SMTP username")
-	flag.StringVar(&cfg.Smtp.Password, "smtp-password", "-", "SMTP password")
-	flag.StringVar(&cfg.Smtp.Sender, "smtp-sender", "Meow <no-reply@meow.com>", "SMTP sender")
-
-	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(value string) error {
-		cfg.Cors.TrustedOrigins = strings.Fields(value)
-		return nil
-	})
-
-	displayVersion := flag.Bool("version", false, "Dispaly version and exit")
+	// Define the command-line flags for every setting, plus -config, -print-config
+	// and -version.
+	options := new(config.Options)
+	fs := config.Flags(cfg, options, flag.ExitOnError)
 
-	flag.Parse()
+	// Fill the config from the flag defaults, the config file, MEOW_ environment
+	// variables and the command line, in that order.
+	err := config.Load(cfg, fs, os.Args[1:], os.LookupEnv)
 
-	if *displayVersion {
+	if options.Version {
 		fmt.Printf("Version:\t%s\n", version)
 		fmt.Printf("Build time:\t%s\n", buildTime)
 		os.Exit(0)
 	}
 
+	if options.PrintConfig {
+		config.Print(os.Stdout, fs)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if options.PrintConfig {
+		os.Exit(0)
+	}
+
 	// Initialize a new logger which writes messages to the standard out stream,
 	// prefixed with the current date and time.
-	logger := jlog.New(os.Stdout, jlog.LevelInfo)
+	level, _ := jlog.ParseLevel(cfg.Log.Level)
 
-	// Get connection pool from OpenDB() function.
-	// If any error exists, we should exit application immediately.
-	db, err := config.OpenDB(cfg)
+	sink, closeLogFile, err := logSink(cfg)
 	if err != nil {
-		logger.PrintFatal(err, nil)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-	// defering closing database pool.
-	defer db.Close()
+	defer closeLogFile()
+
+	logger := jlog.NewWithSink(sink, level)
+	defer logger.Close()
+
+	logger.SetSampling(cfg.Log.SampleInitial, cfg.Log.SampleThereafter, time.Second)
+
+	// Send entries written with the standard library's log/slog to the same logger.
+	slog.SetDefault(slog.New(logger.Handler()))
 
-	// Also log a message to say that the connection pool has been successfully
-	// established.
-	logger.PrintInfo("database connection pool established", nil)
+	// Keep the data in memory with -db=memory, for demos. The DB stays nil then, and
+	// everything depending on it is left out.
+	var db *sql.DB
+	var models data.Models
+
+	if cfg.DB.Driver == "postgres" {
+		// Get connection pool from OpenDB() function.
+		// If any error exists, we should exit application immediately.
+		db, err = config.OpenDB(cfg)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		// defering closing database pool.
+		defer db.Close()
+
+		// Also log a message to say that the connection pool has been successfully
+		// established.
+		logger.PrintInfo("database connection pool established", nil)
+
+		models = data.NewModels(db)
+	} else {
+		logger.PrintInfo("keeping the data in memory, it is lost on exit", nil)
+
+		models = data.NewMemoryModels()
+	}
 
 	expvarValues(db)
+	metricsValues(db)
+
+	// Export traces to the collector if an endpoint is configured, and flush the
+	// remaining spans on exit.
+	if cfg.Tracing.Endpoint != "" {
+		tracing.SetExporter(tracing.NewOTLPExporter(cfg.Tracing.Endpoint, cfg.Tracing.ServiceName), func(err error) {
+			logger.PrintError(err, nil)
+		})
+
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			tracing.Shutdown(ctx)
+		}()
+	}
+
+	// Parse the email templates once, so broken templates stop the server from starting.
+	templates, err := mailer.LoadTemplates()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	mailOptions := mailer.Options{
+		Sender:            cfg.Smtp.Sender,
+		Timeout:           cfg.Mail.Timeout,
+		Suppressions:      models.Users,
+		UnsubscribeURL:    cfg.Mail.UnsubscribeURL,
+		UnsubscribeSecret: cfg.Mail.UnsubscribeSecret,
+	}
+
+	// Sign the emails if a DKIM key is configured.
+	if cfg.DKIM.KeyFile != "" {
+		mailOptions.DKIM, err = mailer.LoadDKIM(cfg.DKIM.Domain, cfg.DKIM.Selector, cfg.DKIM.KeyFile)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+	}
+
 	// Declare an instance of the application struct, containing the config struct and
 	// the logger.
 	application := &application.Application{
 		Config:  cfg,
 		Logger:  logger,
 		Version: version,
-		Models:  data.NewModels(db),
-		Mailer: mailer.New(
-			cfg.Smtp.Host,
-			cfg.Smtp.Port,
-			cfg.Smtp.Username,
-			cfg.Smtp.Password,
-			cfg.Smtp.Sender,
-		),
+		DB:      db,
+		ConfigSource: func() (*config.Config, error) {
+			return config.Reload(os.Args[1:], os.LookupEnv)
+		},
+		Models:    models,
+		Mailer:    mailer.New(mailTransport(cfg, logger), templates, mailOptions),
+		Templates: templates,
 	}
 
 	// Start server with serve() method in app instance.
@@ -11,6 +11,7 @@ type Config struct {
 	Port int
 	Env  string
 	DB   struct {
+		Driver       string
 		DSN          string
 		MaxOpenConns int
 		MaxIdleConns int
@@ -21,6 +22,10 @@ type Config struct {
 		Rps     float64
 		Burst   int
 		Enabled bool
+
+		// The limits of the suggest route, which is called on every keystroke.
+		SuggestRps   float64
+		SuggestBurst int
 	}
 
 	Smtp struct {
@@ -31,9 +36,98 @@ type Config struct {
 		Sender   string
 	}
 
+	Mail struct {
+		Driver            string
+		Dir               string
+		Timeout           time.Duration
+		UnsubscribeURL    string
+		UnsubscribeSecret string
+		EventsSecret      string
+	}
+
+	DKIM struct {
+		Domain   string
+		Selector string
+		KeyFile  string
+	}
+
 	Cors struct {
 		TrustedOrigins []string
 	}
+
+	Log struct {
+		Level            string
+		SampleInitial    int
+		SampleThereafter int
+		Format           string
+		Syslog           string
+		File             struct {
+			Path       string
+			MaxSize    int
+			MaxAge     time.Duration
+			MaxBackups int
+			Interval   time.Duration
+			Compress   bool
+		}
+	}
+
+	TLS struct {
+		CertFile       string
+		KeyFile        string
+		MinVersion     string
+		CipherSuites   []string
+		ClientCAFile   string
+		ClientAuth     string
+		HTTP2          bool
+		ReloadInterval time.Duration
+		RedirectPort   int
+	}
+
+	Server struct {
+		ReadTimeout  time.Duration
+		WriteTimeout time.Duration
+		IdleTimeout  time.Duration
+	}
+
+	Shutdown struct {
+		DrainDelay  time.Duration
+		Timeout     time.Duration
+		TaskTimeout time.Duration
+	}
+
+	Jobs struct {
+		Queues       []string
+		PollInterval time.Duration
+		LockTimeout  time.Duration
+		MinBackoff   time.Duration
+		MaxBackoff   time.Duration
+	}
+
+	Scheduler struct {
+		Enabled           bool
+		LockInterval      time.Duration
+		PurgeTokens       string
+		DeleteUnactivated string
+		UnactivatedDays   int
+		RefreshFacets     string
+		PurgeEvents       string
+		EventDays         int
+	}
+
+	Webhooks struct {
+		RelayInterval time.Duration
+		Timeout       time.Duration
+	}
+
+	Events struct {
+		HeartbeatInterval time.Duration
+		Buffer            int
+	}
+
+	Tracing struct {
+		Endpoint    string
+		ServiceName string
+	}
 }
 
 func (cfg *Config) GetSport() string {
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"time"
+)
+
+// Options holds the flags which control the program rather than the server.
+type Options struct {
+	ConfigFile  string
+	PrintConfig bool
+	Version     bool
+}
+
+// The Flags() function returns a flag set with a flag for every setting of cfg, plus
+// the flags of options. The flag defaults are the default settings.
+func Flags(cfg *Config, options *Options, handling flag.ErrorHandling) *flag.FlagSet {
+	fs := flag.NewFlagSet(os.Args[0], handling)
+
+	// Read the value of the port and env command-line flags into the config struct. We
+	// default to using the port number 4000 and the environment "development" if no
+	// corresponding flags are provided.
+	fs.IntVar(&cfg.Port, "port", 4000, "API server port")
+	fs.StringVar(&cfg.Env, "env", "development", "Environment (development|staging|production)")
+
+	fs.StringVar(&cfg.DB.Driver, "db", "postgres", "Where the data is kept (postgres|memory); memory needs no database and loses everything on exit")
+
+	// Read the DSN from command line flags into config struct.
+	fs.StringVar(&cfg.DB.DSN, "dsn", os.Getenv("dsn"), "PostgreSQL DSN")
+
+	// Read the connection pool settings from command-line flags.
+	fs.IntVar(&cfg.DB.MaxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
+	fs.IntVar(&cfg.DB.MaxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
+	fs.StringVar(&cfg.DB.MaxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max idle time")
+	fs.Float64Var(&cfg.Limiter.Rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	fs.IntVar(&cfg.Limiter.Burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.BoolVar(&cfg.Limiter.Enabled, "limiter-enabled", true, "Rate limiter enable-disable")
+	fs.Float64Var(&cfg.Limiter.SuggestRps, "limiter-suggest-rps", 10, "Rate limiter maximum requests per second of movie suggestions")
+	fs.IntVar(&cfg.Limiter.SuggestBurst, "limiter-suggest-burst", 20, "Rate limiter maximum burst of movie suggestions")
+
+	fs.StringVar(&cfg.Smtp.Host, "smtp-host", "sandbox.smtp.mailtrap.io", "SMTP host")
+	fs.IntVar(&cfg.Smtp.Port, "smtp-port", 2525, "SMTP port")
+	fs.StringVar(&cfg.Smtp.Username, "smtp-username", "-", "SMTP username")
+	fs.StringVar(&cfg.Smtp.Password, "smtp-password", "-", "SMTP password")
+	fs.StringVar(&cfg.Smtp.Sender, "smtp-sender", "Meow <no-reply@meow.com>", "SMTP sender")
+
+	fs.StringVar(&cfg.Mail.Driver, "mail-driver", "smtp", "How emails are sent (smtp|file|memory|log); file writes .eml files to -mail-dir")
+	fs.StringVar(&cfg.Mail.Dir, "mail-dir", "tmp/mail", "Directory of the .eml files written by -mail-driver=file")
+	fs.DurationVar(&cfg.Mail.Timeout, "mail-timeout", 30*time.Second, "Maximum time for sending one email, retries included")
+	fs.StringVar(&cfg.Mail.UnsubscribeURL, "mail-unsubscribe-url", "", "Public URL of POST /v1/mail/unsubscribe, sent as List-Unsubscribe in bulk emails (disabled if empty)")
+	fs.StringVar(&cfg.Mail.UnsubscribeSecret, "mail-unsubscribe-secret", "", "Secret signing the unsubscribe links")
+	fs.StringVar(&cfg.Mail.EventsSecret, "mail-events-secret", "", "Secret of the signatures of bounce and complaint notifications to POST /v1/mail/events (disabled if empty)")
+
+	fs.StringVar(&cfg.DKIM.Domain, "dkim-domain", "", "Domain signing the emails with DKIM")
+	fs.StringVar(&cfg.DKIM.Selector, "dkim-selector", "", "DKIM selector; the public key is published at <selector>._domainkey.<domain>")
+	fs.StringVar(&cfg.DKIM.KeyFile, "dkim-key-file", "", "PEM encoded RSA or Ed25519 private key for DKIM signing (disabled if empty)")
+
+	fs.Var((*StringList)(&cfg.Cors.TrustedOrigins), "cors-trusted-origins", "Trusted CORS origins (space separated)")
+
+	fs.StringVar(&cfg.Log.Level, "log-level", "info", "Minimum log level (debug|info|warn|error|fatal|off)")
+	fs.IntVar(&cfg.Log.SampleInitial, "log-sample-initial", 0, "Log the first N identical messages per second before sampling (0 disables sampling)")
+	fs.IntVar(&cfg.Log.SampleThereafter, "log-sample-thereafter", 100, "After the initial messages, log every Nth identical message per second")
+
+	fs.StringVar(&cfg.Log.Format, "log-format", "auto", "Format of stdout logs (json|console|auto); auto uses console for -env=development")
+	fs.StringVar(&cfg.Log.Syslog, "log-syslog", "", "Also send logs to a syslog daemon, e.g. udp://localhost:514, tcp://host:601 or unix:///dev/log")
+	fs.StringVar(&cfg.Log.File.Path, "log-file", "", "Also write JSON logs to this file")
+	fs.IntVar(&cfg.Log.File.MaxSize, "log-file-max-size", 100, "Rotate the log file when it reaches this many megabytes (0 disables)")
+	fs.DurationVar(&cfg.Log.File.Interval, "log-file-interval", 24*time.Hour, "Rotate the log file after this long (0 disables)")
+	fs.DurationVar(&cfg.Log.File.MaxAge, "log-file-max-age", 7*24*time.Hour, "Remove rotated log files older than this (0 keeps them)")
+	fs.IntVar(&cfg.Log.File.MaxBackups, "log-file-max-backups", 10, "Number of rotated log files to keep (0 keeps all)")
+	fs.BoolVar(&cfg.Log.File.Compress, "log-file-compress", true, "Gzip rotated log files")
+
+	fs.StringVar(&cfg.TLS.CertFile, "tls-cert", "", "TLS certificate file; serves HTTPS when set together with -tls-key")
+	fs.StringVar(&cfg.TLS.KeyFile, "tls-key", "", "TLS private key file")
+	fs.StringVar(&cfg.TLS.MinVersion, "tls-min-version", "1.2", "Minimum TLS version (1.2|1.3)")
+	fs.Var((*StringList)(&cfg.TLS.CipherSuites), "tls-cipher-suites", "TLS 1.2 cipher suites (space separated, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256); Go's defaults if empty")
+	fs.StringVar(&cfg.TLS.ClientCAFile, "tls-client-ca", "", "CA certificates for verifying client certificates (mutual TLS)")
+	fs.StringVar(&cfg.TLS.ClientAuth, "tls-client-auth", "none", "Client certificate verification (none|optional|require)")
+	fs.BoolVar(&cfg.TLS.HTTP2, "http2", true, "Enable HTTP/2 over TLS")
+	fs.DurationVar(&cfg.TLS.ReloadInterval, "tls-reload-interval", 10*time.Second, "How often to check the certificate files for changes")
+	fs.IntVar(&cfg.TLS.RedirectPort, "tls-redirect-port", 0, "Port of a plain HTTP listener redirecting to HTTPS (0 disables)")
+
+	fs.DurationVar(&cfg.Server.ReadTimeout, "server-read-timeout", 10*time.Second, "Maximum time for reading a whole request")
+	fs.DurationVar(&cfg.Server.WriteTimeout, "server-write-timeout", 20*time.Second, "Maximum time for writing a response")
+	fs.DurationVar(&cfg.Server.IdleTimeout, "server-idle-timeout", time.Minute, "Maximum time to keep an idle keep-alive connection open")
+
+	fs.DurationVar(&cfg.Shutdown.DrainDelay, "shutdown-drain-delay", 0, "Time between failing readiness checks and stopping the server on shutdown, so load balancers stop sending requests")
+	fs.DurationVar(&cfg.Shutdown.Timeout, "shutdown-timeout", 5*time.Second, "Maximum time to wait for in-flight requests on shutdown")
+	fs.DurationVar(&cfg.Shutdown.TaskTimeout, "shutdown-task-timeout", 15*time.Second, "Maximum time to wait for background tasks, like sending emails, on shutdown")
+
+	cfg.Jobs.Queues = []string{"default=2", "mail=2", "webhooks=2"}
+	fs.Var((*StringList)(&cfg.Jobs.Queues), "jobs-queues", "Workers per job queue (space separated, e.g. \"default=2 mail=4\")")
+	fs.DurationVar(&cfg.Jobs.PollInterval, "jobs-poll-interval", time.Second, "How often idle job workers look for due jobs")
+	fs.DurationVar(&cfg.Jobs.LockTimeout, "jobs-lock-timeout", 5*time.Minute, "Maximum duration of a job attempt; jobs of crashed workers are retried after it")
+	fs.DurationVar(&cfg.Jobs.MinBackoff, "jobs-min-backoff", 5*time.Second, "Delay before the first retry of a failed job, doubled for every attempt")
+	fs.DurationVar(&cfg.Jobs.MaxBackoff, "jobs-max-backoff", time.Hour, "Maximum delay between retries of a failed job")
+
+	fs.BoolVar(&cfg.Scheduler.Enabled, "scheduler-enabled", true, "Run the maintenance tasks on this server when it holds the scheduler lock")
+	fs.DurationVar(&cfg.Scheduler.LockInterval, "scheduler-lock-interval", 15*time.Second, "How often standby servers try to take over the scheduler")
+	fs.StringVar(&cfg.Scheduler.PurgeTokens, "schedule-purge-tokens", "@hourly", "When to delete expired tokens (cron format, @hourly or @every 30m; empty disables)")
+	fs.StringVar(&cfg.Scheduler.DeleteUnactivated, "schedule-delete-unactivated", "@daily", "When to delete users who never activated their account (empty disables)")
+	fs.IntVar(&cfg.Scheduler.UnactivatedDays, "unactivated-user-days", 7, "Delete unactivated users this many days after they registered")
+	fs.StringVar(&cfg.Scheduler.RefreshFacets, "schedule-refresh-facets", "*/10 * * * *", "When to refresh the movie facet counts (empty disables)")
+	fs.StringVar(&cfg.Scheduler.PurgeEvents, "schedule-purge-events", "@daily", "When to delete old movie events and their webhook delivery logs (empty disables)")
+	fs.IntVar(&cfg.Scheduler.EventDays, "webhook-event-days", 30, "Keep movie events for redelivery this many days")
+
+	fs.DurationVar(&cfg.Webhooks.RelayInterval, "webhooks-relay-interval", time.Second, "How often new movie events are handed over to webhooks")
+	fs.DurationVar(&cfg.Webhooks.Timeout, "webhooks-timeout", 10*time.Second, "Maximum time for a webhook to respond")
+
+	fs.DurationVar(&cfg.Events.HeartbeatInterval, "events-heartbeat-interval", 15*time.Second, "How often an idle movie event stream sends a comment to keep the connection open")
+	fs.IntVar(&cfg.Events.Buffer, "events-buffer", 64, "Events buffered for each event stream client; slower clients are disconnected")
+
+	fs.StringVar(&cfg.Tracing.Endpoint, "otlp-endpoint", "", "OTLP/HTTP traces endpoint, e.g. http://localhost:4318/v1/traces (disabled if empty)")
+	fs.StringVar(&cfg.Tracing.ServiceName, "otlp-service-name", "meow", "Service name reported with traces")
+
+	fs.StringVar(&options.ConfigFile, "config", "", "Read settings from a YAML, TOML or JSON file (also MEOW_CONFIG)")
+	fs.BoolVar(&options.PrintConfig, "print-config", false, "Print the effective configuration with secrets redacted and exit")
+	fs.BoolVar(&options.Version, "version", false, "Dispaly version and exit")
+
+	return fs
+}
+
+// The Reload() function loads a new Config from the same sources as Load(), for
+// picking up changes while the server runs.
+func Reload(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := new(Config)
+
+	fs := Flags(cfg, new(Options), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	if err := Load(cfg, fs, args, lookupEnv); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,266 @@
+package config
+
+import (
+	"Meow/internal/validator"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Prefix of the environment variables holding settings, e.g. MEOW_SMTP_PASSWORD for
+// the smtp-password flag.
+const envPrefix = "MEOW_"
+
+// Flags which control loading itself and are never read from a file or the
+// environment.
+var loaderFlags = map[string]bool{
+	"config":       true,
+	"print-config": true,
+	"version":      true,
+}
+
+// Settings which are redacted by Print().
+var secretSettings = map[string]bool{
+	"dsn":                     true,
+	"smtp-password":           true,
+	"mail-unsubscribe-secret": true,
+	"mail-events-secret":      true,
+}
+
+// A ValidationError lists every problem found while loading the configuration, keyed
+// by setting name.
+type ValidationError struct {
+	Problems map[string]string
+}
+
+// Return the problems, one per line, sorted by setting.
+func (err *ValidationError) Error() string {
+	keys := make([]string, 0, len(err.Problems))
+	for key := range err.Problems {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = fmt.Sprintf("  %s: %s", key, err.Problems[key])
+	}
+
+	return "invalid configuration:\n" + strings.Join(lines, "\n")
+}
+
+// The Load() function fills cfg through the flags defined in fs. Each layer overrides
+// the one before: the flag defaults, the file named by -config (or MEOW_CONFIG), MEOW_
+// environment variables and finally the command-line arguments. Instead of a value a
+// setting can name a file holding it, with a "-file" suffix in the configuration file
+// or a "_FILE" suffix in the environment, e.g. MEOW_SMTP_PASSWORD_FILE. Every problem
+// is reported at once in a *ValidationError.
+func Load(cfg *Config, fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) error {
+	// Parse the arguments once to find -config. They are parsed again at the end so
+	// they override the other layers.
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	v := validator.New()
+
+	path := ""
+	if f := fs.Lookup("config"); f != nil {
+		path = f.Value.String()
+	}
+
+	if value, found := lookupEnv(envPrefix + "CONFIG"); found && path == "" {
+		path = value
+	}
+
+	if path != "" {
+		settings, err := readFile(path)
+		if err != nil {
+			v.AddError("config", err.Error())
+		}
+
+		keys := make([]string, 0, len(settings))
+		for key := range settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			name, value := key, settings[key]
+
+			if fs.Lookup(name) == nil && strings.HasSuffix(name, "-file") {
+				name = strings.TrimSuffix(name, "-file")
+
+				content, err := readSecret(value)
+				if err != nil {
+					v.AddError(name, err.Error())
+					continue
+				}
+				value = content
+			}
+
+			if fs.Lookup(name) == nil || loaderFlags[name] {
+				v.AddError(key, fmt.Sprintf("unknown setting in %s", path))
+				continue
+			}
+
+			if err := fs.Set(name, value); err != nil {
+				v.AddError(name, err.Error())
+			}
+		}
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if loaderFlags[f.Name] {
+			return
+		}
+
+		env := EnvName(f.Name)
+
+		value, found := lookupEnv(env)
+		if !found {
+			file, fileFound := lookupEnv(env + "_FILE")
+			if !fileFound {
+				return
+			}
+
+			content, err := readSecret(file)
+			if err != nil {
+				v.AddError(f.Name, err.Error())
+				return
+			}
+			value = content
+		}
+
+		if err := fs.Set(f.Name, value); err != nil {
+			v.AddError(f.Name, fmt.Sprintf("%s: %s", env, err))
+		}
+	})
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg.Validate(v)
+
+	if !v.Valid() {
+		return &ValidationError{Problems: v.Errors}
+	}
+
+	return nil
+}
+
+// The EnvName() function returns the environment variable of a setting, e.g.
+// MEOW_DB_MAX_OPEN_CONNS for db-max-open-conns.
+func EnvName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Read a secret from a file, without the trailing newline most editors add.
+func readSecret(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// Read a YAML, TOML or JSON configuration file, chosen by the extension, and return its
+// settings with flattened keys. Nested tables are joined with "-", so
+//
+//	smtp:
+//	  max_idle_time: 15m
+//
+// becomes "smtp-max-idle-time". Lists become space separated values.
+func readFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var document map[string]interface{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &document)
+	case ".toml":
+		err = toml.Unmarshal(content, &document)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.UseNumber()
+		err = decoder.Decode(&document)
+	default:
+		return nil, fmt.Errorf("unsupported configuration file type %q (use .yaml, .toml or .json)", filepath.Ext(path))
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	settings := make(map[string]string)
+	flatten(settings, "", document)
+
+	return settings, nil
+}
+
+// Add the values of document to settings, prefixing the keys with prefix.
+func flatten(settings map[string]string, prefix string, document map[string]interface{}) {
+	for key, value := range document {
+		name := prefix + strings.ToLower(strings.ReplaceAll(key, "_", "-"))
+
+		switch value := value.(type) {
+		case map[string]interface{}:
+			flatten(settings, name+"-", value)
+		case []interface{}:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			settings[name] = strings.Join(items, " ")
+		case nil:
+			settings[name] = ""
+		default:
+			settings[name] = fmt.Sprint(value)
+		}
+	}
+}
+
+// The Print() function writes the effective value of every setting in fs as YAML,
+// which can be used as a configuration file. Secrets are redacted.
+func Print(w io.Writer, fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if loaderFlags[f.Name] {
+			return
+		}
+
+		fmt.Fprintf(w, "%s: %q\n", f.Name, redact(f.Name, f.Value.String()))
+	})
+}
+
+// Hide the value of a secret setting. Only the password of a DSN is hidden, so the
+// printed DSN still shows which database is used.
+func redact(name string, value string) string {
+	if !secretSettings[name] || value == "" {
+		return value
+	}
+
+	if u, err := url.Parse(value); err == nil && u.Scheme != "" && u.Host != "" {
+		if _, hasPassword := u.User.Password(); hasPassword {
+			u.User = url.UserPassword(u.User.Username(), "REDACTED")
+		}
+		return u.String()
+	}
+
+	return "REDACTED"
+}
@@ -0,0 +1,170 @@
+package config
+
+import (
+	"Meow/internal/jobs"
+	"Meow/internal/scheduler"
+	"Meow/internal/tlsconfig"
+	"Meow/internal/validator"
+	jlog "Meow/log"
+	"Meow/mailer"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// StringList is a flag.Value for a space separated list, like the trusted CORS
+// origins.
+type StringList []string
+
+// Return the list joined with spaces.
+func (list *StringList) String() string {
+	return strings.Join(*list, " ")
+}
+
+// Replace the list with the fields of value.
+func (list *StringList) Set(value string) error {
+	*list = strings.Fields(value)
+	return nil
+}
+
+// The Validate() method adds a problem to v for every invalid setting, keyed by the
+// name of its flag.
+func (cfg *Config) Validate(v *validator.Validator) {
+	v.Check(cfg.Port > 0 && cfg.Port <= 65535, "port", "must be between 1 and 65535")
+	v.Check(validator.In(cfg.Env, "development", "staging", "production"), "env", "must be development, staging or production")
+
+	v.Check(validator.In(cfg.DB.Driver, "postgres", "memory"), "db", "must be postgres or memory")
+	v.Check(cfg.DB.Driver != "postgres" || cfg.DB.DSN != "", "dsn", "must be provided")
+	v.Check(cfg.DB.MaxOpenConns > 0, "db-max-open-conns", "must be greater than zero")
+	v.Check(cfg.DB.MaxIdleConns >= 0, "db-max-idle-conns", "must not be negative")
+	v.Check(cfg.DB.MaxIdleConns <= cfg.DB.MaxOpenConns, "db-max-idle-conns", "must not be greater than db-max-open-conns")
+
+	if duration, err := time.ParseDuration(cfg.DB.MaxIdleTime); err != nil {
+		v.AddError("db-max-idle-time", "must be a duration like 15m")
+	} else {
+		v.Check(duration >= 0, "db-max-idle-time", "must not be negative")
+	}
+
+	v.Check(cfg.Limiter.Rps > 0, "limiter-rps", "must be greater than zero")
+	v.Check(cfg.Limiter.Burst > 0, "limiter-burst", "must be greater than zero")
+	v.Check(cfg.Limiter.SuggestRps > 0, "limiter-suggest-rps", "must be greater than zero")
+	v.Check(cfg.Limiter.SuggestBurst > 0, "limiter-suggest-burst", "must be greater than zero")
+
+	v.Check(cfg.Smtp.Host != "", "smtp-host", "must be provided")
+	v.Check(cfg.Smtp.Port > 0 && cfg.Smtp.Port <= 65535, "smtp-port", "must be between 1 and 65535")
+
+	_, err := mail.ParseAddress(cfg.Smtp.Sender)
+	v.Check(err == nil, "smtp-sender", "must be an email address like Meow <no-reply@meow.com>")
+
+	v.Check(validator.In(cfg.Mail.Driver, mailer.Drivers...), "mail-driver", "must be smtp, file, memory or log")
+	v.Check(cfg.Mail.Driver != "file" || cfg.Mail.Dir != "", "mail-dir", "must be provided for the file driver")
+	v.Check(cfg.Mail.Timeout > 0, "mail-timeout", "must be greater than zero")
+
+	if cfg.Mail.UnsubscribeURL != "" {
+		u, err := url.Parse(cfg.Mail.UnsubscribeURL)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "mail-unsubscribe-url", "must be an http:// or https:// URL")
+		v.Check(len(cfg.Mail.UnsubscribeSecret) >= 16, "mail-unsubscribe-secret", "must be at least 16 characters long with mail-unsubscribe-url")
+	}
+
+	v.Check(cfg.Mail.EventsSecret == "" || len(cfg.Mail.EventsSecret) >= 16, "mail-events-secret", "must be at least 16 characters long")
+
+	if cfg.DKIM.KeyFile != "" {
+		v.Check(cfg.DKIM.Domain != "", "dkim-domain", "must be provided with dkim-key-file")
+		v.Check(cfg.DKIM.Selector != "", "dkim-selector", "must be provided with dkim-key-file")
+	}
+
+	for _, origin := range cfg.Cors.TrustedOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			v.AddError("cors-trusted-origins", "must be origins like https://example.com")
+		}
+	}
+
+	_, err = jlog.ParseLevel(cfg.Log.Level)
+	v.Check(err == nil, "log-level", "must be debug, info, warn, error, fatal or off")
+	v.Check(validator.In(cfg.Log.Format, "auto", "json", "console"), "log-format", "must be auto, json or console")
+	v.Check(cfg.Log.SampleInitial >= 0, "log-sample-initial", "must not be negative")
+	v.Check(cfg.Log.SampleThereafter >= 0, "log-sample-thereafter", "must not be negative")
+
+	if cfg.Log.Syslog != "" {
+		u, err := url.Parse(cfg.Log.Syslog)
+		v.Check(err == nil && validator.In(u.Scheme, "udp", "tcp", "unix"), "log-syslog", "must be a udp://, tcp:// or unix:// address")
+	}
+
+	v.Check(cfg.Log.File.MaxSize >= 0, "log-file-max-size", "must not be negative")
+	v.Check(cfg.Log.File.Interval >= 0, "log-file-interval", "must not be negative")
+	v.Check(cfg.Log.File.MaxAge >= 0, "log-file-max-age", "must not be negative")
+	v.Check(cfg.Log.File.MaxBackups >= 0, "log-file-max-backups", "must not be negative")
+
+	if cfg.TLS.CertFile != "" || cfg.TLS.KeyFile != "" {
+		v.Check(cfg.TLS.CertFile != "", "tls-cert", "must be provided with tls-key")
+		v.Check(cfg.TLS.KeyFile != "", "tls-key", "must be provided with tls-cert")
+	}
+
+	_, err = tlsconfig.ParseVersion(cfg.TLS.MinVersion)
+	v.Check(err == nil && validator.In(cfg.TLS.MinVersion, "1.2", "1.3"), "tls-min-version", "must be 1.2 or 1.3")
+
+	_, err = tlsconfig.ParseCipherSuites(cfg.TLS.CipherSuites)
+	if err != nil {
+		v.AddError("tls-cipher-suites", err.Error())
+	}
+
+	_, err = tlsconfig.ParseClientAuth(cfg.TLS.ClientAuth)
+	v.Check(err == nil, "tls-client-auth", "must be none, optional or require")
+	v.Check(cfg.TLS.ClientAuth == "none" || cfg.TLS.ClientCAFile != "", "tls-client-ca", "must be provided unless tls-client-auth is none")
+	v.Check(cfg.TLS.ReloadInterval > 0, "tls-reload-interval", "must be greater than zero")
+	v.Check(cfg.TLS.RedirectPort >= 0 && cfg.TLS.RedirectPort <= 65535, "tls-redirect-port", "must be between 0 and 65535")
+	v.Check(cfg.TLS.RedirectPort == 0 || cfg.TLS.CertFile != "", "tls-redirect-port", "needs tls-cert and tls-key")
+	v.Check(cfg.TLS.RedirectPort != cfg.Port, "tls-redirect-port", "must differ from port")
+
+	v.Check(cfg.Server.ReadTimeout > 0, "server-read-timeout", "must be greater than zero")
+	v.Check(cfg.Server.WriteTimeout > 0, "server-write-timeout", "must be greater than zero")
+	v.Check(cfg.Server.IdleTimeout > 0, "server-idle-timeout", "must be greater than zero")
+
+	v.Check(cfg.Shutdown.DrainDelay >= 0, "shutdown-drain-delay", "must not be negative")
+	v.Check(cfg.Shutdown.Timeout > 0, "shutdown-timeout", "must be greater than zero")
+	v.Check(cfg.Shutdown.TaskTimeout > 0, "shutdown-task-timeout", "must be greater than zero")
+
+	_, err = jobs.ParseQueues(cfg.Jobs.Queues)
+	if err != nil {
+		v.AddError("jobs-queues", err.Error())
+	}
+
+	v.Check(cfg.Jobs.PollInterval > 0, "jobs-poll-interval", "must be greater than zero")
+	v.Check(cfg.Jobs.LockTimeout > 0, "jobs-lock-timeout", "must be greater than zero")
+	v.Check(cfg.Jobs.MinBackoff > 0, "jobs-min-backoff", "must be greater than zero")
+	v.Check(cfg.Jobs.MaxBackoff >= cfg.Jobs.MinBackoff, "jobs-max-backoff", "must not be less than jobs-min-backoff")
+
+	v.Check(cfg.Scheduler.LockInterval > 0, "scheduler-lock-interval", "must be greater than zero")
+	v.Check(cfg.Scheduler.UnactivatedDays > 0, "unactivated-user-days", "must be greater than zero")
+	v.Check(cfg.Scheduler.EventDays > 0, "webhook-event-days", "must be greater than zero")
+
+	schedules := map[string]string{
+		"schedule-purge-tokens":       cfg.Scheduler.PurgeTokens,
+		"schedule-delete-unactivated": cfg.Scheduler.DeleteUnactivated,
+		"schedule-refresh-facets":     cfg.Scheduler.RefreshFacets,
+		"schedule-purge-events":       cfg.Scheduler.PurgeEvents,
+	}
+
+	for name, spec := range schedules {
+		if spec == "" {
+			continue
+		}
+
+		if _, err := scheduler.Parse(spec); err != nil {
+			v.AddError(name, err.Error())
+		}
+	}
+
+	v.Check(cfg.Webhooks.RelayInterval > 0, "webhooks-relay-interval", "must be greater than zero")
+	v.Check(cfg.Webhooks.Timeout > 0, "webhooks-timeout", "must be greater than zero")
+
+	v.Check(cfg.Events.HeartbeatInterval > 0, "events-heartbeat-interval", "must be greater than zero")
+	v.Check(cfg.Events.Buffer > 0, "events-buffer", "must be greater than zero")
+
+	if cfg.Tracing.Endpoint != "" {
+		u, err := url.Parse(cfg.Tracing.Endpoint)
+		v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "otlp-endpoint", "must be an http:// or https:// URL")
+	}
+}
@@ -12,6 +12,12 @@ require golang.org/x/crypto v0.17.0
 
 require github.com/go-gomail/gomail v0.0.0-20160411212932-81ebce5c23df
 
+require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/andybalholm/brotli v1.1.1
+	gopkg.in/yaml.v3 v3.0.1
+)
+
 require (
 	github.com/felixge/httpsnoop v1.0.4
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
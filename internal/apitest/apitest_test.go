@@ -0,0 +1,72 @@
+package apitest_test
+
+import (
+	"Meow/internal/apitest"
+	"Meow/internal/smtptest"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Go through the API like a new user, step by step: register, activate with the token
+// of the welcome email, log in and list the movies.
+func TestSignUpFlow(t *testing.T) {
+	harness := apitest.New(t)
+	harness.CreateMovie("Casablanca", 1942, 102, "drama")
+
+	client := harness.Client()
+
+	client.Post("/v1/users", map[string]string{"name": "Alice", "email": "alice@example.com", "password": apitest.Password}).
+		Expect(http.StatusAccepted)
+
+	message, err := harness.Mail.WaitFor("alice@example.com", 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := smtptest.ActivationToken(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Not activated yet, so the user can't read the movies.
+	var authenticated struct {
+		Token struct {
+			Plaintext string `json:"token"`
+		} `json:"auth_token"`
+	}
+
+	client.Post("/v1/tokens/authentication", map[string]string{"email": "alice@example.com", "password": apitest.Password}).
+		Expect(http.StatusCreated).Decode(&authenticated)
+
+	alice := harness.Client()
+	alice.Token = authenticated.Token.Plaintext
+	alice.Get("/v1/movies").Expect(http.StatusForbidden)
+
+	client.Put("/v1/users/activated", map[string]string{"token": token}).Expect(http.StatusOK)
+
+	var list struct {
+		Movies []struct {
+			Title string `json:"title"`
+		} `json:"movies"`
+	}
+
+	alice.Get("/v1/movies").Expect(http.StatusOK).Decode(&list)
+	if len(list.Movies) != 1 || list.Movies[0].Title != "Casablanca" {
+		t.Fatalf("got movies %+v, want Casablanca", list.Movies)
+	}
+}
+
+// Start a second harness in the same test binary, which must not collide with the
+// first one over the published metrics.
+func TestSignUp(t *testing.T) {
+	harness := apitest.New(t)
+
+	user, client := harness.SignUp("Bob", "bob@example.com")
+	if !user.Activated || user.Email != "bob@example.com" {
+		t.Fatalf("got user %+v", user)
+	}
+
+	client.Get("/v1/movies").Expect(http.StatusOK)
+	harness.Client().Get("/v1/movies").Expect(http.StatusUnauthorized)
+}
@@ -0,0 +1,187 @@
+package apitest
+
+import (
+	"Meow/internal/data"
+	"Meow/internal/smtptest"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+)
+
+// How long SignUp() waits for the welcome email.
+const mailTimeout = 10 * time.Second
+
+// A Client calls the API of a Harness, with the authentication token of a user if
+// Token isn't empty and with the headers in Header, like Accept. Requests which fail
+// to be sent fail the test.
+type Client struct {
+	harness *Harness
+	Token   string
+	Header  http.Header
+}
+
+// A Response is a response of the API with its body read.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	harness *Harness
+}
+
+// Client returns a client which isn't authenticated.
+func (harness *Harness) Client() *Client {
+	return &Client{harness: harness}
+}
+
+// ClientFor returns a client authenticated as user, with a new authentication token.
+func (harness *Harness) ClientFor(user *data.User) *Client {
+	harness.T.Helper()
+
+	return &Client{
+		harness: harness,
+		Token:   harness.CreateToken(user, data.ScopeAuthentication, 24*time.Hour),
+	}
+}
+
+// SignUp goes through the API like a new user: it registers, activates the account
+// with the token of the welcome email and logs in. It returns the user, with the
+// fields the API shows, and a client authenticated as them.
+func (harness *Harness) SignUp(name string, email string) (*data.User, *Client) {
+	harness.T.Helper()
+
+	client := harness.Client()
+
+	var registered struct {
+		User *data.User `json:"user"`
+	}
+
+	client.Post("/v1/users", map[string]string{"name": name, "email": email, "password": Password}).
+		Expect(http.StatusAccepted).Decode(&registered)
+
+	message, err := harness.Mail.WaitFor(email, mailTimeout)
+	if err != nil {
+		harness.T.Fatalf("apitest: %v", err)
+	}
+
+	token, err := smtptest.ActivationToken(message)
+	if err != nil {
+		harness.T.Fatalf("apitest: %v", err)
+	}
+
+	client.Put("/v1/users/activated", map[string]string{"token": token}).Expect(http.StatusOK)
+
+	var authenticated struct {
+		Token data.Token `json:"auth_token"`
+	}
+
+	client.Post("/v1/tokens/authentication", map[string]string{"email": email, "password": Password}).
+		Expect(http.StatusCreated).Decode(&authenticated)
+
+	registered.User.Activated = true
+	return registered.User, &Client{harness: harness, Token: authenticated.Token.Plaintext}
+}
+
+// Do sends a request with body, unless nil, encoded as JSON. The path is relative to
+// the API, like "/v1/movies?page=2".
+func (client *Client) Do(method string, path string, body interface{}) *Response {
+	t := client.harness.T
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		js, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("apitest: %v", err)
+		}
+		reader = bytes.NewReader(js)
+	}
+
+	request, err := http.NewRequest(method, client.harness.URL()+path, reader)
+	if err != nil {
+		t.Fatalf("apitest: %v", err)
+	}
+
+	for key, values := range client.Header {
+		request.Header[key] = values
+	}
+
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	if client.Token != "" {
+		request.Header.Set("Authorization", "Bearer "+client.Token)
+	}
+
+	response, err := client.harness.Server.Client().Do(request)
+	if err != nil {
+		t.Fatalf("apitest: %s %s: %v", method, path, err)
+	}
+	defer response.Body.Close()
+
+	content, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("apitest: %s %s: %v", method, path, err)
+	}
+
+	return &Response{
+		StatusCode: response.StatusCode,
+		Header:     response.Header,
+		Body:       content,
+		harness:    client.harness,
+	}
+}
+
+// Get sends a GET request.
+func (client *Client) Get(path string) *Response {
+	client.harness.T.Helper()
+	return client.Do(http.MethodGet, path, nil)
+}
+
+// Post sends a POST request with a JSON body.
+func (client *Client) Post(path string, body interface{}) *Response {
+	client.harness.T.Helper()
+	return client.Do(http.MethodPost, path, body)
+}
+
+// Put sends a PUT request with a JSON body.
+func (client *Client) Put(path string, body interface{}) *Response {
+	client.harness.T.Helper()
+	return client.Do(http.MethodPut, path, body)
+}
+
+// Patch sends a PATCH request with a JSON body.
+func (client *Client) Patch(path string, body interface{}) *Response {
+	client.harness.T.Helper()
+	return client.Do(http.MethodPatch, path, body)
+}
+
+// Delete sends a DELETE request.
+func (client *Client) Delete(path string) *Response {
+	client.harness.T.Helper()
+	return client.Do(http.MethodDelete, path, nil)
+}
+
+// Expect fails the test unless the response has status.
+func (response *Response) Expect(status int) *Response {
+	response.harness.T.Helper()
+
+	if response.StatusCode != status {
+		response.harness.T.Fatalf("apitest: got status %d, want %d: %s", response.StatusCode, status, response.Body)
+	}
+
+	return response
+}
+
+// Decode decodes the JSON body into dest, failing the test if it can't.
+func (response *Response) Decode(dest interface{}) {
+	response.harness.T.Helper()
+
+	err := json.Unmarshal(response.Body, dest)
+	if err != nil {
+		response.harness.T.Fatalf("apitest: decoding %s: %v", response.Body, err)
+	}
+}
@@ -0,0 +1,151 @@
+package apitest
+
+import (
+	"Meow/migrations"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// DSNEnv names the environment variable with the DSN of the PostgreSQL database the
+// tests create their schemas in. Tests needing a database are skipped without it.
+const DSNEnv = "MEOW_TEST_DSN"
+
+// The NewDB() function creates a schema for the test in the database of DSNEnv,
+// applies the migrations to it and returns a pool whose connections use it. The
+// schema is dropped when the test ends, so tests can run in parallel without seeing
+// each other's rows. It returns the DSN of the pool too, for config.Config.
+func NewDB(t testing.TB) (*sql.DB, string) {
+	t.Helper()
+
+	dsn := os.Getenv(DSNEnv)
+	if dsn == "" {
+		t.Skipf("%s is not set", DSNEnv)
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("apitest: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// citext is created in the default schema once, rather than in the schema of the
+	// test where dropping the schema would drop it for the other tests too.
+	_, err = admin.ExecContext(ctx, "CREATE EXTENSION IF NOT EXISTS citext")
+	if err != nil {
+		admin.Close()
+		t.Fatalf("apitest: creating the citext extension: %v", err)
+	}
+
+	schema := "test_" + randomHex(8)
+
+	_, err = admin.ExecContext(ctx, "CREATE SCHEMA "+schema)
+	if err != nil {
+		admin.Close()
+		t.Fatalf("apitest: creating schema %s: %v", schema, err)
+	}
+
+	t.Cleanup(func() {
+		defer admin.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		_, err := admin.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE")
+		if err != nil {
+			t.Errorf("apitest: dropping schema %s: %v", schema, err)
+		}
+	})
+
+	// lib/pq sends the settings it doesn't know to the server, so every connection
+	// of the pool looks the tables up in the schema of the test first.
+	dsn, err = withSearchPath(dsn, schema+",public")
+	if err != nil {
+		t.Fatalf("apitest: %v", err)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("apitest: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	err = migrate(ctx, db)
+	if err != nil {
+		t.Fatalf("apitest: %v", err)
+	}
+
+	return db, dsn
+}
+
+// Return dsn, a URL or "key=value" DSN, with the search_path setting.
+func withSearchPath(dsn string, searchPath string) (string, error) {
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return dsn + " search_path=" + searchPath, nil
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	query.Set("search_path", searchPath)
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// Apply the up migrations in order, and record the version like golang-migrate so
+// the readiness check passes.
+func migrate(ctx context.Context, db *sql.DB) error {
+	names, err := fs.Glob(migrations.FS, "*.up.sql")
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		script, err := fs.ReadFile(migrations.FS, name)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.ExecContext(ctx, string(script))
+		if err != nil {
+			return fmt.Errorf("migration %s: %w", name, err)
+		}
+	}
+
+	latest, err := migrations.Latest()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, "CREATE TABLE schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, "INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)", latest)
+	return err
+}
+
+// Return n random bytes in hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,92 @@
+package apitest
+
+import (
+	"Meow/internal/data"
+	"Meow/mailer"
+	"strings"
+	"time"
+)
+
+// Password is the password of the users created by CreateUser().
+const Password = "pa55word"
+
+// CreateUser inserts a user with Password and the given permissions, like
+// "movies:read". The name is the local part of email.
+func (harness *Harness) CreateUser(email string, activated bool, permissions ...string) *data.User {
+	harness.T.Helper()
+
+	ctx, cancel := harness.context()
+	defer cancel()
+
+	name, _, _ := strings.Cut(email, "@")
+
+	user := &data.User{
+		Name:      name,
+		Email:     email,
+		Activated: activated,
+		Locale:    mailer.DefaultLocale,
+	}
+
+	err := user.Password.Set(Password)
+	if err != nil {
+		harness.T.Fatalf("apitest: %v", err)
+	}
+
+	err = harness.Models.Users.Insert(ctx, user)
+	if err != nil {
+		harness.T.Fatalf("apitest: creating user %s: %v", email, err)
+	}
+
+	if len(permissions) > 0 {
+		err = harness.Models.Permissions.AddForUsers(ctx, user.ID, permissions...)
+		if err != nil {
+			harness.T.Fatalf("apitest: adding permissions for %s: %v", email, err)
+		}
+	}
+
+	return user
+}
+
+// CreateAdmin inserts an activated user with every permission.
+func (harness *Harness) CreateAdmin(email string) *data.User {
+	harness.T.Helper()
+
+	return harness.CreateUser(email, true, "movies:read", "movies:write", "admin")
+}
+
+// CreateToken returns a new token of user with scope, like data.ScopeActivation.
+func (harness *Harness) CreateToken(user *data.User, scope string, ttl time.Duration) string {
+	harness.T.Helper()
+
+	ctx, cancel := harness.context()
+	defer cancel()
+
+	token, err := harness.Models.Tokens.New(ctx, user.ID, ttl, scope)
+	if err != nil {
+		harness.T.Fatalf("apitest: creating %s token for %s: %v", scope, user.Email, err)
+	}
+
+	return token.Plaintext
+}
+
+// CreateMovie inserts a movie.
+func (harness *Harness) CreateMovie(title string, year int32, runtime int32, genres ...string) *data.Movie {
+	harness.T.Helper()
+
+	ctx, cancel := harness.context()
+	defer cancel()
+
+	movie := &data.Movie{
+		Title:   title,
+		Year:    year,
+		Runtime: data.Runtime(runtime),
+		Genres:  genres,
+	}
+
+	err := harness.Models.Movies.Insert(ctx, movie)
+	if err != nil {
+		harness.T.Fatalf("apitest: creating movie %q: %v", title, err)
+	}
+
+	return movie
+}
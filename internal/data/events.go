@@ -0,0 +1,227 @@
+package data
+
+import (
+	"Meow/internal/tracing"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Define constants for the types of movie events.
+const (
+	EventMovieCreated = "movie.created"
+	EventMovieUpdated = "movie.updated"
+	EventMovieDeleted = "movie.deleted"
+)
+
+// EventSafeList holds every event type webhooks can subscribe to.
+var EventSafeList = []string{
+	EventMovieCreated,
+	EventMovieUpdated,
+	EventMovieDeleted,
+}
+
+// EventsChannel is the PostgreSQL notification channel on which the ID of every new
+// event is sent when its transaction commits.
+const EventsChannel = "movie_events"
+
+// Define Queries for outbox table.
+const (
+	INSERT_EVENT_QUERY = `WITH event AS (
+		INSERT INTO outbox (event, movie_id, data, trace_parent)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	)
+	SELECT pg_notify('` + EventsChannel + `', id::text) FROM event
+	`
+
+	GET_EVENT_QUERY = `SELECT id, event, movie_id, data, trace_parent, created_at
+	FROM outbox WHERE id = $1
+	`
+
+	GET_EVENTS_AFTER_QUERY = `SELECT id, event, movie_id, data, trace_parent, created_at
+	FROM outbox WHERE id > $1
+	ORDER BY id
+	LIMIT $2
+	`
+
+	// Mark the oldest $4 events which weren't dispatched yet, skipping the rows other
+	// servers are dispatching, and enqueue a job of kind $2 on queue $1 for every
+	// active webhook subscribed to each of them. The job payload is a WebhookJob.
+	DISPATCH_EVENTS_QUERY = `WITH events AS (
+		UPDATE outbox SET dispatched_at = NOW()
+		WHERE id IN (
+			SELECT id FROM outbox WHERE dispatched_at IS NULL
+			ORDER BY id
+			FOR UPDATE SKIP LOCKED
+			LIMIT $4
+		)
+		RETURNING id, event, trace_parent
+	), deliveries AS (
+		INSERT INTO jobs (queue, kind, payload, max_attempts, trace_parent)
+		SELECT $1, $2, jsonb_build_object('webhook_id', webhooks.id, 'event_id', events.id), $3, events.trace_parent
+		FROM events JOIN webhooks ON webhooks.active AND events.event = ANY(webhooks.events)
+		RETURNING id
+	)
+	SELECT (SELECT count(*) FROM events), (SELECT count(*) FROM deliveries)
+	`
+
+	DELETE_DISPATCHED_EVENTS_QUERY = `DELETE FROM outbox WHERE dispatched_at < $1`
+)
+
+// An Event records a change of a movie in the outbox table. Data holds the movie as
+// JSON, as it was after the change or before it was deleted.
+type Event struct {
+	ID          int64           `json:"id"`
+	Type        string          `json:"type"`
+	MovieID     int64           `json:"-"`
+	Data        json.RawMessage `json:"data"`
+	TraceParent string          `json:"-"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// A WebhookJob is the payload of a job delivering an event to a webhook.
+type WebhookJob struct {
+	WebhookID int64 `json:"webhook_id"`
+	EventID   int64 `json:"event_id"`
+}
+
+type EventModel struct {
+	DB *sql.DB
+}
+
+// The insertEvent() function adds an event of a movie to the outbox table as part of
+// tx, so the event is recorded if and only if the change of the movie is committed.
+// Listeners of EventsChannel are notified on commit. The event continues the trace of
+// ctx when it is delivered.
+func insertEvent(ctx context.Context, tx *sql.Tx, event string, movieID int64, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	traceParent := ""
+	if span := tracing.SpanFromContext(ctx); span != nil {
+		traceParent = tracing.FormatTraceparent(span.SpanContext())
+	}
+
+	ctx, span := startQuerySpan(ctx, "EventModel.insert", "INSERT_EVENT_QUERY")
+	defer span.End()
+
+	_, err = tx.ExecContext(ctx, INSERT_EVENT_QUERY, event, movieID, string(encoded), traceParent)
+	return err
+}
+
+// Return the destinations for scanning an outbox row into event.
+func (event *Event) scanDestinations() []interface{} {
+	return []interface{}{
+		&event.ID,
+		&event.Type,
+		&event.MovieID,
+		(*[]byte)(&event.Data),
+		&event.TraceParent,
+		&event.CreatedAt,
+	}
+}
+
+// Return the event with id.
+func (eventModel EventModel) Get(ctx context.Context, id int64) (*Event, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, span := startQuerySpan(ctx, "EventModel.Get", "GET_EVENT_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var event Event
+
+	err := eventModel.DB.QueryRowContext(ctx, GET_EVENT_QUERY, id).Scan(event.scanDestinations()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &event, nil
+}
+
+// Return up to limit events with an ID greater than afterID, oldest first.
+func (eventModel EventModel) GetAfter(ctx context.Context, afterID int64, limit int) ([]*Event, error) {
+	ctx, span := startQuerySpan(ctx, "EventModel.GetAfter", "GET_EVENTS_AFTER_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := eventModel.DB.QueryContext(ctx, GET_EVENTS_AFTER_QUERY, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*Event{}
+
+	for rows.Next() {
+		var event Event
+
+		err := rows.Scan(event.scanDestinations()...)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, &event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// Dispatch() hands up to limit events over to the webhooks subscribed to them, by
+// enqueueing a job of kind on queue for every delivery. It returns the number of
+// events and of deliveries. Events are dispatched once, to the webhooks which exist
+// at that moment.
+func (eventModel EventModel) Dispatch(ctx context.Context, queue string, kind string, maxAttempts int, limit int) (int64, int64, error) {
+	ctx, span := startQuerySpan(ctx, "EventModel.Dispatch", "DISPATCH_EVENTS_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var events, deliveries int64
+
+	err := eventModel.DB.QueryRowContext(ctx, DISPATCH_EVENTS_QUERY, queue, kind, maxAttempts, limit).Scan(&events, &deliveries)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return events, deliveries, nil
+}
+
+// Delete the events dispatched before a time, along with their delivery logs. They
+// can't be redelivered after that.
+func (eventModel EventModel) DeleteDispatched(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := startQuerySpan(ctx, "EventModel.DeleteDispatched", "DELETE_DISPATCHED_EVENTS_QUERY")
+	defer span.End()
+
+	// There may be many old events, so allow more time than other queries.
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	result, err := eventModel.DB.ExecContext(ctx, DELETE_DISPATCHED_EVENTS_QUERY, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,156 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Define the facet names that clients can ask for in the facets query parameter.
+const (
+	FacetGenres        = "genres"
+	FacetDecade        = "decade"
+	FacetRuntimeBucket = "runtime_bucket"
+)
+
+// FacetSafeList holds every facet name that GetAll() knows how to compute.
+var FacetSafeList = []string{
+	FacetGenres,
+	FacetDecade,
+	FacetRuntimeBucket,
+}
+
+// A FacetCount is a single value of a facet along with the number of movies having it.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facets maps a facet name to its counts.
+type Facets map[string][]FacetCount
+
+var (
+	// Define the query for a page of movies together with the facet counts over every
+	// movie matching the same filters, in one round trip. The filtered CTE is used by
+	// both, and the counts are aggregated into one JSON column repeated on every row.
+	// Like the total of count(*) OVER(), they are only known if the page has rows.
+	GET_ALL_WITH_FACETS_QUERY = `WITH filtered AS (
+	SELECT * FROM movies WHERE ` + FILTER_MOVIES_CONDITION + `
+	), facet_counts AS (
+	SELECT json_agg(json_build_object('facet', facet, 'value', value, 'count', count)
+		ORDER BY facet, count DESC, value ASC) AS facets
+	FROM (%s) AS counts (facet, value, count)
+	)
+	SELECT count(*) OVER(), %s, facet_counts.facets
+	FROM filtered, facet_counts
+	ORDER BY %s %s, id ASC
+	LIMIT $3 OFFSET $4
+	`
+
+	// Read the facets over every movie from the movie_facets materialized view,
+	// which is refreshed periodically by REFRESH_FACETS_QUERY. It is used in place of
+	// the FACET_QUERIES only when the caller opts in with Filters.CachedFacets and the
+	// movies aren't filtered.
+	VIEW_FACETS_QUERY = `SELECT facet, value, count FROM movie_facets WHERE facet = ANY($5)`
+
+	REFRESH_FACETS_QUERY = `REFRESH MATERIALIZED VIEW CONCURRENTLY movie_facets`
+
+	// Define the SQL fragments for each facet over the filtered CTE. Every fragment
+	// returns the facet name, the facet value and the count of movies.
+	FACET_QUERIES = map[string]string{
+		FacetGenres: `SELECT 'genres', genre, count(*)
+	FROM filtered, unnest(genres) AS genre GROUP BY genre`,
+
+		FacetDecade: `SELECT 'decade', ((year / 10) * 10)::text || 's', count(*)
+	FROM filtered GROUP BY year / 10`,
+
+		FacetRuntimeBucket: `SELECT 'runtime_bucket', CASE
+		WHEN runtime < 90 THEN '0-89'
+		WHEN runtime < 120 THEN '90-119'
+		WHEN runtime < 150 THEN '120-149'
+		ELSE '150+' END AS bucket, count(*)
+	FROM filtered GROUP BY bucket`,
+	}
+)
+
+// Return the known facets of names, which the validator should have checked already,
+// with an empty list of counts for each.
+func newFacets(names []string) (Facets, []string) {
+	result := make(Facets)
+	known := []string{}
+
+	for _, name := range names {
+		if _, ok := FACET_QUERIES[name]; ok {
+			result[name] = []FacetCount{}
+			known = append(known, name)
+		}
+	}
+
+	return result, known
+}
+
+// Report whether the facets of GetAll() are read from the movie_facets view. The view
+// counts every movie, so it can only stand in for unfiltered counts.
+func useFacetsView(title string, genres []string, filters Filters) bool {
+	return filters.CachedFacets && title == "" && len(genres) == 0
+}
+
+// Return the query of GetAll() calculating the facets, the name of the statement and
+// the extra arguments. The counts are live unless useFacetsView() says otherwise, in
+// which case they may lag behind recent changes until the view is refreshed.
+func facetsQuery(title string, genres []string, names []string, filters Filters) (string, string, []interface{}) {
+	source, statement, args := VIEW_FACETS_QUERY, "GET_ALL_WITH_FACETS_QUERY(movie_facets)", []interface{}{pq.Array(names)}
+
+	if !useFacetsView(title, genres, filters) {
+		parts := make([]string, len(names))
+		for i, name := range names {
+			parts[i] = FACET_QUERIES[name]
+		}
+
+		source, statement, args = strings.Join(parts, "\n\tUNION ALL\n\t"), "GET_ALL_WITH_FACETS_QUERY", nil
+	}
+
+	query := fmt.Sprintf(GET_ALL_WITH_FACETS_QUERY, source, movieSelect(filters.Fields), filters.sortColumn(), filters.sortDirection())
+	return query, statement, args
+}
+
+// Add the counts of the JSON column of GET_ALL_WITH_FACETS_QUERY to facets.
+func decodeFacets(facets Facets, column []byte) error {
+	// No movie matched, so there is nothing to count.
+	if column == nil {
+		return nil
+	}
+
+	var counts []struct {
+		Facet string `json:"facet"`
+		FacetCount
+	}
+
+	if err := json.Unmarshal(column, &counts); err != nil {
+		return err
+	}
+
+	for _, count := range counts {
+		facets[count.Facet] = append(facets[count.Facet], count.FacetCount)
+	}
+
+	return nil
+}
+
+// RefreshFacets() recalculates the movie_facets view. Reads of the view aren't blocked
+// while it runs.
+func (movieModel MovieModel) RefreshFacets(ctx context.Context) error {
+	ctx, span := startQuerySpan(ctx, "MovieModel.RefreshFacets", "REFRESH_FACETS_QUERY")
+	defer span.End()
+
+	// Refreshing reads every movie, so allow more time than other queries.
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	_, err := movieModel.DB.ExecContext(ctx, REFRESH_FACETS_QUERY)
+	return err
+}
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Define the columns of the movies table which can be selected for each field of the
+// Movie struct. The keys are the JSON names of the fields.
+var movieColumns = map[string]string{
+	"id":         "id",
+	"created_at": "created_at",
+	"title":      "title",
+	"year":       "year",
+	"runtime":    "runtime",
+	"genres":     "genres",
+	"version":    "version",
+}
+
+// Fields which are selected when the client doesn't ask for specific fields.
+var defaultMovieFields = []string{"id", "created_at", "title", "year", "runtime", "genres", "version"}
+
+// The movieSelect() function returns the column list for the SELECT clause of a movie
+// query. It panics for a field which is not in movieColumns, in the same way as
+// sortColumn(), because fields must be validated before reaching here.
+func movieSelect(fields []string) string {
+	if len(fields) == 0 {
+		fields = defaultMovieFields
+	}
+
+	columns := make([]string, len(fields))
+	for i, field := range fields {
+		column, ok := movieColumns[field]
+		if !ok {
+			panic("unsafe field: " + field)
+		}
+
+		columns[i] = column
+	}
+
+	return strings.Join(columns, ", ")
+}
+
+// The scanDestinations() method returns pointers to the movie struct fields in the same
+// order as the columns returned by movieSelect().
+func (movie *Movie) scanDestinations(fields []string) []interface{} {
+	if len(fields) == 0 {
+		fields = defaultMovieFields
+	}
+
+	destinations := make([]interface{}, len(fields))
+	for i, field := range fields {
+		switch field {
+		case "id":
+			destinations[i] = &movie.ID
+		case "created_at":
+			destinations[i] = &movie.CreatedAt
+		case "title":
+			destinations[i] = &movie.Title
+		case "year":
+			destinations[i] = &movie.Year
+		case "runtime":
+			destinations[i] = &movie.Runtime
+		case "genres":
+			destinations[i] = pq.Array(&movie.Genres)
+		case "version":
+			destinations[i] = &movie.Version
+		default:
+			panic("unsafe field: " + field)
+		}
+	}
+
+	return destinations
+}
+
+// Project() returns only the requested fields of the movie keyed by their JSON names.
+// Values keep their own types, so the runtime is still encoded as "<runtime> mins".
+func (movie *Movie) Project(fields []string) map[string]interface{} {
+	projection := make(map[string]interface{}, len(fields))
+
+	for _, field := range fields {
+		switch field {
+		case "id":
+			projection[field] = movie.ID
+		case "title":
+			projection[field] = movie.Title
+		case "year":
+			projection[field] = movie.Year
+		case "runtime":
+			projection[field] = movie.Runtime
+		case "genres":
+			projection[field] = movie.Genres
+		case "version":
+			projection[field] = movie.Version
+		}
+	}
+
+	return projection
+}
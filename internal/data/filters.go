@@ -19,6 +19,14 @@ type Filters struct {
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	Fields       []string
+
+	// The facets to count over every matching row along with the page, if any.
+	Facets []string
+
+	// Whether unfiltered facet counts may be read from their periodically refreshed
+	// view, which is cheaper but can lag behind the rows.
+	CachedFacets bool
 }
 
 //	Check that the client-provided Sort field matches one of the entries in our safelist.
@@ -0,0 +1,395 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrDuplicateJob is returned when a job with the same unique key is already
+	// waiting or running.
+	ErrDuplicateJob = errors.New("duplicate job")
+
+	// ErrJobLockLost is returned when a job changed since it was claimed, because its
+	// lock expired and another worker claimed it again.
+	ErrJobLockLost = errors.New("job lock lost")
+)
+
+// Define constants for the status of a job. Completed jobs are deleted.
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobFailed  = "failed"
+)
+
+// ExhaustedJobError is the last error of a job whose lock expired on its last attempt,
+// e.g. because the worker running it crashed.
+const ExhaustedJobError = "lock expired on the last attempt"
+
+// Define Queries for jobs table.
+const (
+	JOB_COLUMNS = `id, queue, kind, payload, status, attempts, max_attempts, run_at, last_error,
+	COALESCE(unique_key, ''), trace_parent, created_at, updated_at`
+
+	INSERT_JOB_QUERY = `INSERT INTO jobs (queue, kind, payload, max_attempts, run_at, unique_key, trace_parent)
+	VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7)
+	ON CONFLICT (unique_key) WHERE status <> 'failed' DO NOTHING
+	RETURNING id, status, attempts, created_at, updated_at
+	`
+
+	// Claim the next due job of a queue, or a running job whose lock expired after $2
+	// seconds, skipping the rows which other workers have locked. Running jobs whose
+	// lock expired on their last attempt are failed with the error $3 instead.
+	CLAIM_JOB_QUERY = `WITH exhausted AS (
+		UPDATE jobs SET status = 'failed', last_error = $3,
+		locked_at = NULL, updated_at = NOW()
+		WHERE queue = $1 AND status = 'running' AND attempts >= max_attempts
+		AND locked_at < NOW() - make_interval(secs => $2)
+	)
+	UPDATE jobs SET status = 'running', attempts = attempts + 1,
+	locked_at = NOW(), updated_at = NOW()
+	WHERE id = (
+		SELECT id FROM jobs
+		WHERE queue = $1 AND (
+			(status = 'pending' AND run_at <= NOW()) OR
+			(status = 'running' AND locked_at < NOW() - make_interval(secs => $2) AND attempts < max_attempts)
+		)
+		ORDER BY run_at, id
+		FOR UPDATE SKIP LOCKED
+		LIMIT 1
+	)
+	RETURNING ` + JOB_COLUMNS
+
+	// The queries which finish an attempt only match the attempt which claimed the
+	// job, so a worker whose lock expired can't overwrite the next attempt.
+	COMPLETE_JOB_QUERY = `DELETE FROM jobs WHERE id = $1 AND attempts = $2 AND status = 'running'`
+
+	RETRY_JOB_QUERY = `UPDATE jobs SET status = 'pending', run_at = $3, last_error = $4,
+	locked_at = NULL, updated_at = NOW()
+	WHERE id = $1 AND attempts = $2 AND status = 'running'
+	`
+
+	RELEASE_JOB_QUERY = `UPDATE jobs SET status = 'pending', attempts = attempts - 1,
+	locked_at = NULL, updated_at = NOW()
+	WHERE id = $1 AND attempts = $2 AND status = 'running'
+	`
+
+	FAIL_JOB_QUERY = `UPDATE jobs SET status = 'failed', last_error = $3,
+	locked_at = NULL, updated_at = NOW()
+	WHERE id = $1 AND attempts = $2 AND status = 'running'
+	`
+
+	JOB_STATS_QUERY = `SELECT queue,
+	count(*) FILTER (WHERE status = 'pending' AND run_at <= NOW()),
+	count(*) FILTER (WHERE status = 'pending' AND run_at > NOW()),
+	count(*) FILTER (WHERE status = 'running'),
+	count(*) FILTER (WHERE status = 'failed'),
+	COALESCE(EXTRACT(EPOCH FROM NOW() - min(run_at) FILTER (WHERE status = 'pending' AND run_at <= NOW())), 0)
+	FROM jobs GROUP BY queue ORDER BY queue
+	`
+
+	GET_FAILED_JOBS_QUERY = `SELECT count(*) OVER(), ` + JOB_COLUMNS + `
+	FROM jobs WHERE status = 'failed' AND (queue = $1 OR $1 = '')
+	ORDER BY %s %s, id DESC
+	LIMIT $2 OFFSET $3
+	`
+
+	RETRY_FAILED_JOB_QUERY = `UPDATE jobs SET status = 'pending', attempts = 0, run_at = NOW(),
+	last_error = '', locked_at = NULL, updated_at = NOW()
+	WHERE id = $1 AND status = 'failed'
+	RETURNING ` + JOB_COLUMNS
+
+	DELETE_FAILED_JOB_QUERY = `DELETE FROM jobs WHERE id = $1 AND status = 'failed'`
+)
+
+// A Job is a unit of work in the jobs table. Payload holds the arguments of the
+// job's handler as JSON.
+type Job struct {
+	ID          int64           `json:"id"`
+	Queue       string          `json:"queue"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	RunAt       time.Time       `json:"run_at"`
+	LastError   string          `json:"last_error,omitempty"`
+	UniqueKey   string          `json:"unique_key,omitempty"`
+	TraceParent string          `json:"-"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// QueueStats counts the jobs of a queue by state.
+type QueueStats struct {
+	Queue              string  `json:"queue"`
+	Ready              int     `json:"ready"`
+	Scheduled          int     `json:"scheduled"`
+	Running            int     `json:"running"`
+	Failed             int     `json:"failed"`
+	OldestReadySeconds float64 `json:"oldest_ready_seconds"`
+}
+
+type JobModel struct {
+	DB *sql.DB
+}
+
+// Return the destinations for scanning JOB_COLUMNS into job.
+func (job *Job) scanDestinations() []interface{} {
+	return []interface{}{
+		&job.ID,
+		&job.Queue,
+		&job.Kind,
+		(*[]byte)(&job.Payload),
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LastError,
+		&job.UniqueKey,
+		&job.TraceParent,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+	}
+}
+
+// Insert a new job. A job without RunAt runs as soon as possible. If job has a
+// UniqueKey and another job with that key is pending or running, nothing is inserted
+// and ErrDuplicateJob is returned.
+func (jobModel JobModel) Enqueue(ctx context.Context, job *Job) error {
+	return enqueueJob(ctx, jobModel.DB, job)
+}
+
+// The enqueueJob() function inserts job on q, the pool or a transaction, so a job can
+// be enqueued with the change which needs it.
+func enqueueJob(ctx context.Context, q queryer, job *Job) error {
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now()
+	}
+
+	args := []interface{}{
+		job.Queue,
+		job.Kind,
+		string(job.Payload),
+		job.MaxAttempts,
+		job.RunAt,
+		job.UniqueKey,
+		job.TraceParent,
+	}
+
+	ctx, span := startQuerySpan(ctx, "JobModel.Enqueue", "INSERT_JOB_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := q.QueryRowContext(ctx, INSERT_JOB_QUERY, args...).Scan(&job.ID, &job.Status, &job.Attempts, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrDuplicateJob
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Claim the next job of queue which is due, marking it running and counting the
+// attempt. Running jobs which were claimed more than lockTimeout ago are claimed again,
+// or failed with ExhaustedJobError if that was their last attempt. If there is no such
+// job ErrRecordNotFound is returned.
+func (jobModel JobModel) Claim(ctx context.Context, queue string, lockTimeout time.Duration) (*Job, error) {
+	ctx, span := startQuerySpan(ctx, "JobModel.Claim", "CLAIM_JOB_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var job Job
+
+	err := jobModel.DB.QueryRowContext(ctx, CLAIM_JOB_QUERY, queue, lockTimeout.Seconds(), ExhaustedJobError).Scan(job.scanDestinations()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+// Run a query which finishes the current attempt of job, and return ErrJobLockLost if
+// the attempt isn't current anymore.
+func (jobModel JobModel) finish(ctx context.Context, name string, statement string, query string, job *Job, args ...interface{}) error {
+	ctx, span := startQuerySpan(ctx, name, statement)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := jobModel.DB.ExecContext(ctx, query, append([]interface{}{job.ID, job.Attempts}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrJobLockLost
+	}
+
+	return nil
+}
+
+// Delete a job which completed successfully.
+func (jobModel JobModel) Complete(ctx context.Context, job *Job) error {
+	return jobModel.finish(ctx, "JobModel.Complete", "COMPLETE_JOB_QUERY", COMPLETE_JOB_QUERY, job)
+}
+
+// Schedule another attempt of a job which failed with lastError at runAt.
+func (jobModel JobModel) Retry(ctx context.Context, job *Job, runAt time.Time, lastError string) error {
+	return jobModel.finish(ctx, "JobModel.Retry", "RETRY_JOB_QUERY", RETRY_JOB_QUERY, job, runAt, lastError)
+}
+
+// Put a job which was interrupted back in its queue without counting the attempt.
+func (jobModel JobModel) Release(ctx context.Context, job *Job) error {
+	return jobModel.finish(ctx, "JobModel.Release", "RELEASE_JOB_QUERY", RELEASE_JOB_QUERY, job)
+}
+
+// Move a job which won't be attempted again to the failed jobs (dead letters).
+func (jobModel JobModel) Fail(ctx context.Context, job *Job, lastError string) error {
+	return jobModel.finish(ctx, "JobModel.Fail", "FAIL_JOB_QUERY", FAIL_JOB_QUERY, job, lastError)
+}
+
+// Return the number of jobs in every queue which has any.
+func (jobModel JobModel) Stats(ctx context.Context) ([]*QueueStats, error) {
+	ctx, span := startQuerySpan(ctx, "JobModel.Stats", "JOB_STATS_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := jobModel.DB.QueryContext(ctx, JOB_STATS_QUERY)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []*QueueStats{}
+
+	for rows.Next() {
+		var queue QueueStats
+
+		err := rows.Scan(&queue.Queue, &queue.Ready, &queue.Scheduled, &queue.Running, &queue.Failed, &queue.OldestReadySeconds)
+		if err != nil {
+			return nil, err
+		}
+
+		stats = append(stats, &queue)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// Return a page of the failed jobs, of every queue if queue is empty.
+func (jobModel JobModel) GetFailed(ctx context.Context, queue string, filters Filters) ([]*Job, Metadata, error) {
+	query := fmt.Sprintf(GET_FAILED_JOBS_QUERY, filters.sortColumn(), filters.sortDirection())
+
+	ctx, span := startQuerySpan(ctx, "JobModel.GetFailed", "GET_FAILED_JOBS_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := jobModel.DB.QueryContext(ctx, query, queue, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	jobs := []*Job{}
+
+	for rows.Next() {
+		var job Job
+
+		err := rows.Scan(append([]interface{}{&totalRecords}, job.scanDestinations()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		jobs = append(jobs, &job)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return jobs, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
+
+// Put a failed job back in its queue with a fresh set of attempts. ErrDuplicateJob is
+// returned if another job with its unique key is waiting.
+func (jobModel JobModel) RetryFailed(ctx context.Context, id int64) (*Job, error) {
+	ctx, span := startQuerySpan(ctx, "JobModel.RetryFailed", "RETRY_FAILED_JOB_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var job Job
+
+	err := jobModel.DB.QueryRowContext(ctx, RETRY_FAILED_JOB_QUERY, id).Scan(job.scanDestinations()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		case isUniqueViolation(err, "jobs_unique_key_idx"):
+			return nil, ErrDuplicateJob
+		default:
+			return nil, err
+		}
+	}
+
+	return &job, nil
+}
+
+// Delete a failed job.
+func (jobModel JobModel) DeleteFailed(ctx context.Context, id int64) error {
+	ctx, span := startQuerySpan(ctx, "JobModel.DeleteFailed", "DELETE_FAILED_JOB_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := jobModel.DB.ExecContext(ctx, DELETE_FAILED_JOB_QUERY, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
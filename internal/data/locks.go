@@ -0,0 +1,154 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Define Queries for advisory locks.
+const (
+	TRY_ADVISORY_LOCK_QUERY = `SELECT pg_try_advisory_lock($1)`
+
+	ADVISORY_UNLOCK_QUERY = `SELECT pg_advisory_unlock($1)`
+)
+
+// An AdvisoryLock is a PostgreSQL session level advisory lock. The session is a
+// connection taken from the pool for as long as the lock is held, so the lock is
+// released by the server if the connection dies.
+type AdvisoryLock struct {
+	DB  *sql.DB
+	Key int64
+
+	conn *sql.Conn
+}
+
+// TryAcquire takes the lock if no other session holds it, and reports whether it did.
+func (lock *AdvisoryLock) TryAcquire(ctx context.Context) (bool, error) {
+	if lock.conn != nil {
+		return true, nil
+	}
+
+	ctx, span := startQuerySpan(ctx, "AdvisoryLock.TryAcquire", "TRY_ADVISORY_LOCK_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	conn, err := lock.DB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	var acquired bool
+
+	err = conn.QueryRowContext(ctx, TRY_ADVISORY_LOCK_QUERY, lock.Key).Scan(&acquired)
+	if err != nil || !acquired {
+		conn.Close()
+		return false, err
+	}
+
+	lock.conn = conn
+	return true, nil
+}
+
+// Check returns an error if the connection holding the lock is broken, in which case
+// the lock is gone and Release() must be called.
+func (lock *AdvisoryLock) Check(ctx context.Context) error {
+	if lock.conn == nil {
+		return errors.New("advisory lock not held")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return lock.conn.PingContext(ctx)
+}
+
+// Release gives the lock up and returns its connection to the pool.
+func (lock *AdvisoryLock) Release(ctx context.Context) error {
+	if lock.conn == nil {
+		return nil
+	}
+
+	ctx, span := startQuerySpan(ctx, "AdvisoryLock.Release", "ADVISORY_UNLOCK_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	_, err := lock.conn.ExecContext(ctx, ADVISORY_UNLOCK_QUERY, lock.Key)
+
+	// If unlocking failed, discard the connection instead of returning it to the pool
+	// so the server ends the session and releases the lock.
+	if err != nil {
+		lock.conn.Raw(func(interface{}) error {
+			return driver.ErrBadConn
+		})
+	}
+
+	lock.conn.Close()
+	lock.conn = nil
+
+	return err
+}
+
+// The keys of the MemoryLocks held in this process.
+var memoryLocks = struct {
+	sync.Mutex
+	held map[int64]bool
+}{held: make(map[int64]bool)}
+
+// A MemoryLock stands in for an AdvisoryLock without a database. Only one MemoryLock
+// with a key is held at a time in the process, which is the only replica then.
+type MemoryLock struct {
+	Key int64
+
+	held bool
+}
+
+// TryAcquire takes the lock if no other MemoryLock with its key holds it.
+func (lock *MemoryLock) TryAcquire(ctx context.Context) (bool, error) {
+	if lock.held {
+		return true, nil
+	}
+
+	memoryLocks.Lock()
+	defer memoryLocks.Unlock()
+
+	if memoryLocks.held[lock.Key] {
+		return false, nil
+	}
+
+	memoryLocks.held[lock.Key] = true
+	lock.held = true
+
+	return true, nil
+}
+
+// Check returns an error if the lock isn't held. It can't be lost otherwise.
+func (lock *MemoryLock) Check(ctx context.Context) error {
+	if !lock.held {
+		return errors.New("memory lock not held")
+	}
+
+	return nil
+}
+
+// Release gives the lock up.
+func (lock *MemoryLock) Release(ctx context.Context) error {
+	if !lock.held {
+		return nil
+	}
+
+	memoryLocks.Lock()
+	defer memoryLocks.Unlock()
+
+	delete(memoryLocks.held, lock.Key)
+	lock.held = false
+
+	return nil
+}
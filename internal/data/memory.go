@@ -0,0 +1,1548 @@
+package data
+
+import (
+	"Meow/internal/tracing"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Errors of the constraints the in-memory models check like the database does. They
+// are server errors for the handlers, as with PostgreSQL.
+var (
+	errMemoryTokenUser      = errors.New(`memory: insert on table "tokens" violates foreign key constraint "tokens_user_id_fkey"`)
+	errMemoryDuplicateToken = errors.New(`memory: duplicate key value violates unique constraint "tokens_pkey"`)
+	errMemoryPermissionUser = errors.New(`memory: insert on table "users_permissions" violates foreign key constraint "users_permissions_user_id_fkey"`)
+	errMemoryDuplicateGrant = errors.New(`memory: duplicate key value violates unique constraint "users_permissions_pkey"`)
+	errMemoryDeliveryRefs   = errors.New(`memory: insert on table "webhook_deliveries" violates a foreign key constraint`)
+)
+
+// The permissions the migrations create, in the order of their IDs.
+var memoryPermissionCodes = []string{"movies:read", "movies:write", "admin"}
+
+// The email statuses from weakest to strongest, as in SET_EMAIL_STATUS_QUERY.
+var emailStatusRanks = map[string]int{EmailOK: 1, EmailUnsubscribed: 2, EmailBounced: 3, EmailComplained: 4}
+
+// The memoryStore holds the tables of the in-memory models. One mutex guards all of
+// them, so every method is atomic like a statement in its own transaction, and a
+// change of a movie and its event are seen together. Rows are copied in and out, so
+// callers can't change them behind the store's back.
+type memoryStore struct {
+	mu sync.Mutex
+
+	movies map[int64]*Movie
+	users  map[int64]*User
+	tokens map[string]*Token
+
+	// The permission codes granted to each user.
+	grants map[int64]map[string]bool
+
+	jobs       map[int64]*memoryJob
+	events     []*memoryEvent
+	webhooks   map[int64]*Webhook
+	deliveries map[int64]*Delivery
+
+	// The facet counts as of the last RefreshFacets(), like the movie_facets view.
+	facets Facets
+
+	// The last ID of every table, like bigserial sequences.
+	sequences map[string]int64
+
+	// Called with every new event after it is stored.
+	listeners []func(*Event)
+}
+
+// A job with the time it was claimed.
+type memoryJob struct {
+	Job
+	lockedAt time.Time
+}
+
+// An event with the time it was dispatched, zero until then.
+type memoryEvent struct {
+	Event
+	dispatchedAt time.Time
+}
+
+// The NewMemoryModels() function returns a Models instance keeping every table in
+// memory. The models follow the semantics of the PostgreSQL ones, errors included,
+// so the API can run without a database (-db=memory) for demos and fast tests. The
+// data is lost when the process exits.
+func NewMemoryModels() Models {
+	store := &memoryStore{
+		movies:     make(map[int64]*Movie),
+		users:      make(map[int64]*User),
+		tokens:     make(map[string]*Token),
+		grants:     make(map[int64]map[string]bool),
+		jobs:       make(map[int64]*memoryJob),
+		webhooks:   make(map[int64]*Webhook),
+		deliveries: make(map[int64]*Delivery),
+		facets:     make(Facets),
+		sequences:  make(map[string]int64),
+	}
+
+	return Models{
+		Movies:      MemoryMovieModel{store: store},
+		Users:       MemoryUserModel{store: store},
+		Tokens:      MemoryTokenModel{store: store},
+		Permissions: MemoryPermissionModel{store: store},
+		Jobs:        MemoryJobModel{store: store},
+		Events:      MemoryEventModel{store: store},
+		Webhooks:    MemoryWebhookModel{store: store},
+	}
+}
+
+// Lock the store for a method, unless ctx is done already, in which case a query
+// would have failed too.
+func (store *memoryStore) lock(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	return nil
+}
+
+// Return the next ID of table.
+func (store *memoryStore) nextID(table string) int64 {
+	store.sequences[table]++
+	return store.sequences[table]
+}
+
+// Return the current time with the precision of a timestamp(0) column.
+func nowSeconds() time.Time {
+	return time.Now().Round(time.Second)
+}
+
+// Split a title into its lower case words, like to_tsvector('simple', ...).
+func titleWords(title string) []string {
+	return strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Report whether every element of sub is in set, like the @> operator.
+func containsAll(set []string, sub []string) bool {
+	for _, element := range sub {
+		found := false
+		for _, candidate := range set {
+			if candidate == element {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Return the page of filters of rows, which are sorted already, and the metadata of
+// the page. Like count(*) OVER(), the total is only known if the page has rows.
+func memoryPage[T any](rows []T, filters Filters) ([]T, Metadata) {
+	page := []T{}
+	if offset := filters.offset(); offset < len(rows) {
+		page = rows[offset:min(offset+filters.limit(), len(rows))]
+	}
+
+	total := 0
+	if len(page) > 0 {
+		total = len(rows)
+	}
+
+	return page, calculateMetadata(total, filters.Page, filters.PageSize)
+}
+
+// Compare two values of a sort column, returning a negative number, zero or a positive
+// number.
+func compareValues[T int | int32 | int64 | string](a T, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
+
+// Compare two times of a sort column.
+func compareTimes(a time.Time, b time.Time) int {
+	return a.Compare(b)
+}
+
+// Sort rows by the column of filters in its direction, then by id in idDirection.
+func sortRows[T any](rows []T, filters Filters, compare func(a T, b T, column string) int, id func(T) int64, idDirection string) {
+	column, direction := filters.sortColumn(), filters.sortDirection()
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		if c := compare(rows[i], rows[j], column); c != 0 {
+			return (c < 0) == (direction == "ASC")
+		}
+
+		return (id(rows[i]) < id(rows[j])) == (idDirection == "ASC")
+	})
+}
+
+// Return the traceparent of the span of ctx, which events and jobs carry on.
+func traceParentOf(ctx context.Context) string {
+	if span := tracing.SpanFromContext(ctx); span != nil {
+		return tracing.FormatTraceparent(span.SpanContext())
+	}
+
+	return ""
+}
+
+// ----------------------------------------------------------------------------------
+// Movies
+
+type MemoryMovieModel struct {
+	store *memoryStore
+}
+
+// Return a copy of movie with only fields set, like a SELECT of their columns.
+func (movie *Movie) selectFields(fields []string) *Movie {
+	if len(fields) == 0 {
+		fields = defaultMovieFields
+	}
+
+	selected := &Movie{}
+	for _, field := range fields {
+		switch field {
+		case "id":
+			selected.ID = movie.ID
+		case "created_at":
+			selected.CreatedAt = movie.CreatedAt
+		case "title":
+			selected.Title = movie.Title
+		case "year":
+			selected.Year = movie.Year
+		case "runtime":
+			selected.Runtime = movie.Runtime
+		case "genres":
+			selected.Genres = append([]string{}, movie.Genres...)
+		case "version":
+			selected.Version = movie.Version
+		default:
+			panic("unsafe field: " + field)
+		}
+	}
+
+	return selected
+}
+
+// Check the constraints of the movies table.
+func checkMovie(movie *Movie) error {
+	switch {
+	case movie.Runtime < 0:
+		return errors.New(`memory: new row for relation "movies" violates check constraint "movies_runtime_check"`)
+	case movie.Year < 1888 || int(movie.Year) > time.Now().Year():
+		return errors.New(`memory: new row for relation "movies" violates check constraint "movies_year_check"`)
+	case len(movie.Genres) < 1 || len(movie.Genres) > 5:
+		return errors.New(`memory: new row for relation "movies" violates check constraint "genres_length_check"`)
+	}
+
+	return nil
+}
+
+// Report whether movie matches the filters of FILTER_MOVIES_CONDITION.
+func matchesMovieFilters(movie *Movie, title string, genres []string) bool {
+	return containsAll(titleWords(movie.Title), titleWords(title)) && containsAll(movie.Genres, genres)
+}
+
+// Add an event of a movie, like insertEvent(). The listeners are called when the
+// store is unlocked, see notify().
+func (store *memoryStore) insertEvent(ctx context.Context, event string, movieID int64, data interface{}) (*Event, error) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	stored := &memoryEvent{Event: Event{
+		ID:          store.nextID("outbox"),
+		Type:        event,
+		MovieID:     movieID,
+		Data:        encoded,
+		TraceParent: traceParentOf(ctx),
+		CreatedAt:   nowSeconds(),
+	}}
+
+	store.events = append(store.events, stored)
+
+	copied := stored.Event
+	return &copied, nil
+}
+
+// Hand a new event to the listeners, like a notification on commit.
+func (store *memoryStore) notify(event *Event) {
+	store.mu.Lock()
+	listeners := append([]func(*Event){}, store.listeners...)
+	store.mu.Unlock()
+
+	for _, listener := range listeners {
+		copied := *event
+		listener(&copied)
+	}
+}
+
+func (memory MemoryMovieModel) Insert(ctx context.Context, movie *Movie) error {
+	if err := checkMovie(movie); err != nil {
+		return err
+	}
+
+	if err := memory.store.lock(ctx); err != nil {
+		return err
+	}
+
+	movie.ID = memory.store.nextID("movies")
+	movie.CreatedAt = nowSeconds()
+	movie.Version = 1
+
+	memory.store.movies[movie.ID] = movie.selectFields(nil)
+
+	event, err := memory.store.insertEvent(ctx, EventMovieCreated, movie.ID, map[string]interface{}{"movie": movie})
+	memory.store.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	memory.store.notify(event)
+	return nil
+}
+
+func (memory MemoryMovieModel) Get(ctx context.Context, id int64, fields ...string) (*Movie, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	if err := memory.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer memory.store.mu.Unlock()
+
+	movie, found := memory.store.movies[id]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+
+	return movie.selectFields(fields), nil
+}
+
+func (memory MemoryMovieModel) Update(ctx context.Context, movie *Movie) error {
+	if err := checkMovie(movie); err != nil {
+		return err
+	}
+
+	if err := memory.store.lock(ctx); err != nil {
+		return err
+	}
+
+	stored, found := memory.store.movies[movie.ID]
+	if !found || stored.Version != movie.Version {
+		memory.store.mu.Unlock()
+		return ErrEditConflict
+	}
+
+	movie.Version++
+	memory.store.movies[movie.ID] = &Movie{
+		ID:        stored.ID,
+		CreatedAt: stored.CreatedAt,
+		Title:     movie.Title,
+		Year:      movie.Year,
+		Runtime:   movie.Runtime,
+		Genres:    append([]string{}, movie.Genres...),
+		Version:   movie.Version,
+	}
+
+	event, err := memory.store.insertEvent(ctx, EventMovieUpdated, movie.ID, map[string]interface{}{"movie": movie})
+	memory.store.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	memory.store.notify(event)
+	return nil
+}
+
+func (memory MemoryMovieModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	if err := memory.store.lock(ctx); err != nil {
+		return err
+	}
+
+	movie, found := memory.store.movies[id]
+	if !found {
+		memory.store.mu.Unlock()
+		return ErrRecordNotFound
+	}
+
+	delete(memory.store.movies, id)
+
+	event, err := memory.store.insertEvent(ctx, EventMovieDeleted, id, map[string]interface{}{"movie": movie})
+	memory.store.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	memory.store.notify(event)
+	return nil
+}
+
+// Return the movies matching title and genres, sorted by the column of filters and id,
+// and the counts of filters.Facets over them. Titles are compared byte by byte, like
+// the C collation. With Filters.CachedFacets and without filters the counts are the
+// ones of the last RefreshFacets(), like the view.
+func (memory MemoryMovieModel) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, Facets, error) {
+	if err := memory.store.lock(ctx); err != nil {
+		return nil, Metadata{}, nil, err
+	}
+
+	movies := []*Movie{}
+	for _, movie := range memory.store.movies {
+		if matchesMovieFilters(movie, title, genres) {
+			movies = append(movies, movie)
+		}
+	}
+
+	sortRows(movies, filters, func(a *Movie, b *Movie, column string) int {
+		switch column {
+		case "title":
+			return compareValues(a.Title, b.Title)
+		case "year":
+			return compareValues(a.Year, b.Year)
+		case "runtime":
+			return compareValues(int32(a.Runtime), int32(b.Runtime))
+		}
+
+		return compareValues(a.ID, b.ID)
+	}, func(movie *Movie) int64 { return movie.ID }, "ASC")
+
+	page, metadata := memoryPage(movies, filters)
+
+	selected := make([]*Movie, len(page))
+	for i, movie := range page {
+		selected[i] = movie.selectFields(filters.Fields)
+	}
+
+	// Like GET_ALL_WITH_FACETS_QUERY, the counts are only known if the page has rows.
+	facets, names := newFacets(filters.Facets)
+	if len(page) > 0 && len(names) > 0 {
+		if useFacetsView(title, genres, filters) {
+			for _, name := range names {
+				facets[name] = append([]FacetCount{}, memory.store.facets[name]...)
+			}
+		} else {
+			facets = countFacets(movies, names)
+		}
+	}
+
+	memory.store.mu.Unlock()
+	return selected, metadata, facets, nil
+}
+
+// Return the value of a facet for a movie.
+func facetValues(movie *Movie, facet string) []string {
+	switch facet {
+	case FacetGenres:
+		return movie.Genres
+	case FacetDecade:
+		return []string{fmt.Sprintf("%ds", (movie.Year/10)*10)}
+	case FacetRuntimeBucket:
+		switch {
+		case movie.Runtime < 90:
+			return []string{"0-89"}
+		case movie.Runtime < 120:
+			return []string{"90-119"}
+		case movie.Runtime < 150:
+			return []string{"120-149"}
+		default:
+			return []string{"150+"}
+		}
+	}
+
+	return nil
+}
+
+// Count the values of facets over movies, sorted like GET_ALL_WITH_FACETS_QUERY.
+func countFacets(movies []*Movie, facets []string) Facets {
+	result := make(Facets)
+
+	for _, facet := range facets {
+		counts := map[string]int{}
+		for _, movie := range movies {
+			for _, value := range facetValues(movie, facet) {
+				counts[value]++
+			}
+		}
+
+		result[facet] = []FacetCount{}
+		for value, count := range counts {
+			result[facet] = append(result[facet], FacetCount{Value: value, Count: count})
+		}
+
+		sort.Slice(result[facet], func(i, j int) bool {
+			a, b := result[facet][i], result[facet][j]
+			if a.Count != b.Count {
+				return a.Count > b.Count
+			}
+			return a.Value < b.Value
+		})
+	}
+
+	return result
+}
+
+// Suggest() returns at most limit movies whose title words start with the words of
+// query. Titles where the matched words are a larger part of the title rank first,
+// which approximates ts_rank().
+func (memory MemoryMovieModel) Suggest(ctx context.Context, query string, limit int) ([]*Suggestion, error) {
+	prefixes := titleWords(query)
+	if len(prefixes) == 0 {
+		return []*Suggestion{}, nil
+	}
+
+	if err := memory.store.lock(ctx); err != nil {
+		return nil, err
+	}
+
+	type ranked struct {
+		movie *Movie
+		rank  float64
+	}
+
+	matches := []ranked{}
+	for _, movie := range memory.store.movies {
+		words := titleWords(movie.Title)
+
+		matched := 0
+		for _, prefix := range prefixes {
+			found := false
+			for _, word := range words {
+				if strings.HasPrefix(word, prefix) {
+					found = true
+					matched++
+					break
+				}
+			}
+
+			if !found {
+				matched = 0
+				break
+			}
+		}
+
+		if matched > 0 {
+			matches = append(matches, ranked{movie: movie, rank: float64(matched) / float64(len(words))})
+		}
+	}
+
+	memory.store.mu.Unlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		switch {
+		case a.rank != b.rank:
+			return a.rank > b.rank
+		case a.movie.Title != b.movie.Title:
+			return a.movie.Title < b.movie.Title
+		}
+		return a.movie.ID < b.movie.ID
+	})
+
+	suggestions := []*Suggestion{}
+	for _, match := range matches[:min(limit, len(matches))] {
+		suggestions = append(suggestions, &Suggestion{ID: match.movie.ID, Title: match.movie.Title, Year: match.movie.Year})
+	}
+
+	return suggestions, nil
+}
+
+// RefreshFacets() recalculates the facet counts over every movie.
+func (memory MemoryMovieModel) RefreshFacets(ctx context.Context) error {
+	if err := memory.store.lock(ctx); err != nil {
+		return err
+	}
+	defer memory.store.mu.Unlock()
+
+	movies := make([]*Movie, 0, len(memory.store.movies))
+	for _, movie := range memory.store.movies {
+		movies = append(movies, movie)
+	}
+
+	memory.store.facets = countFacets(movies, FacetSafeList)
+	return nil
+}
+
+// ----------------------------------------------------------------------------------
+// Users, tokens and permissions
+
+type MemoryUserModel struct {
+	store *memoryStore
+}
+
+// Return the user with email, compared case insensitively like citext.
+func (store *memoryStore) userByEmail(email string) *User {
+	for _, user := range store.users {
+		if strings.EqualFold(user.Email, email) {
+			return user
+		}
+	}
+
+	return nil
+}
+
+// Return a copy of user without the plaintext password, which isn't stored.
+func copyUser(user *User) *User {
+	copied := *user
+	copied.Password = password{Hash: append([]byte{}, user.Password.Hash...)}
+
+	return &copied
+}
+
+// Insert a user. The caller holds the lock.
+func (store *memoryStore) insertUser(user *User) error {
+	if store.userByEmail(user.Email) != nil {
+		return ErrDuplicateEmail
+	}
+
+	user.ID = store.nextID("users")
+	user.CreatedAt = nowSeconds()
+	user.EmailStatus = EmailOK
+	user.Version = 1
+
+	store.users[user.ID] = copyUser(user)
+	return nil
+}
+
+func (memory MemoryUserModel) Insert(ctx context.Context, user *User) error {
+	if err := memory.store.lock(ctx); err != nil {
+		return err
+	}
+	defer memory.store.mu.Unlock()
+
+	return memory.store.insertUser(user)
+}
+
+// Register a user with their permissions and job under one lock. If a step fails the
+// user is removed again, as the transaction of UserModel.Register is rolled back.
+func (memory MemoryUserModel) Register(ctx context.Context, user *User, codes []string, newJob func(*User) (*Job, error)) error {
+	if err := memory.store.lock(ctx); err != nil {
+		return err
+	}
+	defer memory.store.mu.Unlock()
+
+	if err := memory.store.insertUser(user); err != nil {
+		return err
+	}
+
+	err := func() error {
+		if err := memory.store.grant(user.ID, codes); err != nil {
+			return err
+		}
+
+		job, err := newJob(user)
+		if err != nil {
+			return err
+		}
+
+		return memory.store.enqueue(job)
+	}()
+
+	if err != nil {
+		delete(memory.store.users, user.ID)
+		delete(memory.store.grants, user.ID)
+		return err
+	}
+
+	return nil
+}
+
+func (memory MemoryUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	if err := memory.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer memory.store.mu.Unlock()
+
+	user := memory.store.userByEmail(email)
+	if user == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+// Update a user. As with UPDATE_USER_QUERY, a version mismatch is ErrRecordNotFound.
+func (memory MemoryUserModel) Update(ctx context.Context, user *User) error {
+	if err := memory.store.lock(ctx); err != nil {
+		return err
+	}
+	defer memory.store.mu.Unlock()
+
+	stored, found := memory.store.users[user.ID]
+	if !found || stored.Version != user.Version {
+		return ErrRecordNotFound
+	}
+
+	if other := memory.store.userByEmail(user.Email); other != nil && other.ID != user.ID {
+		return ErrDuplicateEmail
+	}
+
+	user.Version++
+
+	updated := copyUser(user)
+	updated.CreatedAt = stored.CreatedAt
+	updated.EmailStatus = stored.EmailStatus
+
+	memory.store.users[user.ID] = updated
+	return nil
+}
+
+func (memory MemoryUserModel) GetForToken(ctx context.Context, scope string, plainToken string) (*User, error) {
+	tokenHash := sha256.Sum256([]byte(plainToken))
+
+	if err := memory.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer memory.store.mu.Unlock()
+
+	token, found := memory.store.tokens[string(tokenHash[:])]
+	if !found || token.Scope != scope || !token.Expiry.After(time.Now()) {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyUser(memory.store.users[token.UserID]), nil
+}
+
+// Delete a user with their tokens and permissions, like ON DELETE CASCADE. The caller
+// holds the lock.
+func (store *memoryStore) deleteUser(id int64) {
+	delete(store.users, id)
+	delete(store.grants, id)
+
+	for hash, token := range store.tokens {
+		if token.UserID == id {
+			delete(store.tokens, hash)
+		}
+	}
+}
+
+func (memory MemoryUserModel) DeleteUnactivated(ctx context.Context, createdBefore time.Time) (int64, error) {
+	if err := memory.store.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer memory.store.mu.Unlock()
+
+	var deleted int64
+	for id, user := range memory.store.users {
+		if !user.Activated && user.CreatedAt.Before(createdBefore) {
+			memory.store.deleteUser(id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// Set the email status of the user with an email address if it is stronger than the
+// current one. An unknown status changes nothing, as in SET_EMAIL_STATUS_QUERY.
+func (memory MemoryUserModel) SetEmailStatus(ctx context.Context, email string, status string) (bool, error) {
+	if err := memory.store.lock(ctx); err != nil {
+		return false, err
+	}
+	defer memory.store.mu.Unlock()
+
+	user := memory.store.userByEmail(email)
+	if user == nil || emailStatusRanks[status] == 0 || emailStatusRanks[user.EmailStatus] >= emailStatusRanks[status] {
+		return false, nil
+	}
+
+	user.EmailStatus = status
+	user.Version++
+
+	return true, nil
+}
+
+func (memory MemoryUserModel) Suppressed(ctx context.Context, email string, bulk bool) (bool, error) {
+	if err := memory.store.lock(ctx); err != nil {
+		return false, err
+	}
+	defer memory.store.mu.Unlock()
+
+	user := memory.store.userByEmail(email)
+	if user == nil {
+		return false, nil
+	}
+
+	return user.Undeliverable() || (bulk && user.EmailStatus == EmailUnsubscribed), nil
+}
+
+type MemoryTokenModel struct {
+	store *memoryStore
+}
+
+func (memory MemoryTokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
+	token, err := generateToken(userID, ttl, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	err = memory.Insert(ctx, token)
+	return token, err
+}
+
+func (memory MemoryTokenModel) Insert(ctx context.Context, token *Token) error {
+	if err := memory.store.lock(ctx); err != nil {
+		return err
+	}
+	defer memory.store.mu.Unlock()
+
+	if _, found := memory.store.users[token.UserID]; !found {
+		return errMemoryTokenUser
+	}
+
+	if _, found := memory.store.tokens[string(token.Hash)]; found {
+		return errMemoryDuplicateToken
+	}
+
+	memory.store.tokens[string(token.Hash)] = &Token{
+		Hash:   append([]byte{}, token.Hash...),
+		UserID: token.UserID,
+		Expiry: token.Expiry.Round(time.Second),
+		Scope:  token.Scope,
+	}
+
+	return nil
+}
+
+func (memory MemoryTokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	if err := memory.store.lock(ctx); err != nil {
+		return err
+	}
+	defer memory.store.mu.Unlock()
+
+	for hash, token := range memory.store.tokens {
+		if token.Scope == scope && token.UserID == userID {
+			delete(memory.store.tokens, hash)
+		}
+	}
+
+	return nil
+}
+
+func (memory MemoryTokenModel) DeleteExpired(ctx context.Context) (int64, error) {
+	if err := memory.store.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer memory.store.mu.Unlock()
+
+	now := time.Now()
+
+	var deleted int64
+	for hash, token := range memory.store.tokens {
+		if token.Expiry.Before(now) {
+			delete(memory.store.tokens, hash)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+type MemoryPermissionModel struct {
+	store *memoryStore
+}
+
+func (memory MemoryPermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	if err := memory.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer memory.store.mu.Unlock()
+
+	var permissions Permissions
+	for _, code := range memoryPermissionCodes {
+		if memory.store.grants[userID][code] {
+			permissions = append(permissions, code)
+		}
+	}
+
+	return permissions, nil
+}
+
+// Grant the permissions with codes to a user. Unknown codes are skipped; granting a
+// permission twice fails and grants none, as the insert of ADD_FOR_USERS_QUERY does.
+func (memory MemoryPermissionModel) AddForUsers(ctx context.Context, userID int64, codes ...string) error {
+	if err := memory.store.lock(ctx); err != nil {
+		return err
+	}
+	defer memory.store.mu.Unlock()
+
+	return memory.store.grant(userID, codes)
+}
+
+// Grant permissions for AddForUsers(). The caller holds the lock.
+func (store *memoryStore) grant(userID int64, codes []string) error {
+	granted := []string{}
+	for _, code := range memoryPermissionCodes {
+		if containsAll(codes, []string{code}) {
+			granted = append(granted, code)
+		}
+	}
+
+	if len(granted) == 0 {
+		return nil
+	}
+
+	if _, found := store.users[userID]; !found {
+		return errMemoryPermissionUser
+	}
+
+	for _, code := range granted {
+		if store.grants[userID][code] {
+			return errMemoryDuplicateGrant
+		}
+	}
+
+	if store.grants[userID] == nil {
+		store.grants[userID] = make(map[string]bool)
+	}
+
+	for _, code := range granted {
+		store.grants[userID][code] = true
+	}
+
+	return nil
+}
+
+// ----------------------------------------------------------------------------------
+// Jobs
+
+type MemoryJobModel struct {
+	store *memoryStore
+}
+
+// Return a copy of a job.
+func (job *memoryJob) copy() *Job {
+	copied := job.Job
+	copied.Payload = append(json.RawMessage{}, job.Payload...)
+
+	return &copied
+}
+
+// Report whether another job which isn't failed has key, like jobs_unique_key_idx.
+// The caller holds the lock.
+func (store *memoryStore) uniqueKeyTaken(key string, exceptID int64) bool {
+	if key == "" {
+		return false
+	}
+
+	for _, job := range store.jobs {
+		if job.ID != exceptID && job.UniqueKey == key && job.Status != JobFailed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Insert a job. The caller holds the lock.
+func (store *memoryStore) enqueue(job *Job) error {
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now()
+	}
+
+	if store.uniqueKeyTaken(job.UniqueKey, 0) {
+		return ErrDuplicateJob
+	}
+
+	now := nowSeconds()
+
+	job.ID = store.nextID("jobs")
+	job.Status = JobPending
+	job.Attempts = 0
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	stored := &memoryJob{Job: *job}
+	stored.Payload = append(json.RawMessage{}, job.Payload...)
+	stored.LastError = ""
+
+	store.jobs[job.ID] = stored
+	return nil
+}
+
+func (memory MemoryJobModel) Enqueue(ctx context.Context, job *Job) error {
+	if err := memory.store.lock(ctx); err != nil {
+		return err
+	}
+	defer memory.store.mu.Unlock()
+
+	return memory.store.enqueue(job)
+}
+
+func (memory MemoryJobModel) Claim(ctx context.Context, queue string, lockTimeout time.Duration) (*Job, error) {
+	if err := memory.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer memory.store.mu.Unlock()
+
+	now := time.Now()
+
+	var next *memoryJob
+	for _, job := range memory.store.jobs {
+		expired := job.Status == JobRunning && job.lockedAt.Before(now.Add(-lockTimeout))
+
+		if job.Queue == queue && expired && job.Attempts >= job.MaxAttempts {
+			job.Status = JobFailed
+			job.LastError = ExhaustedJobError
+			job.lockedAt = time.Time{}
+			job.UpdatedAt = nowSeconds()
+			continue
+		}
+
+		due := (job.Status == JobPending && !job.RunAt.After(now)) || expired
+		if job.Queue != queue || !due {
+			continue
+		}
+
+		if next == nil || job.RunAt.Before(next.RunAt) || (job.RunAt.Equal(next.RunAt) && job.ID < next.ID) {
+			next = job
+		}
+	}
+
+	if next == nil {
+		return nil, ErrRecordNotFound
+	}
+
+	next.Status = JobRunning
+	next.Attempts++
+	next.lockedAt = now
+	next.UpdatedAt = nowSeconds()
+
+	return next.copy(), nil
+}
+
+// Run finish on job if the attempt of job is the current one, and return
+// ErrJobLockLost otherwise.
+func (memory MemoryJobModel) finish(ctx context.Context, job *Job, finish func(stored *memoryJob)) error {
+	if err := memory.store.lock(ctx); err != nil {
+		return err
+	}
+	defer memory.store.mu.Unlock()
+
+	stored, found := memory.store.jobs[job.ID]
+	if !found || stored.Attempts != job.Attempts || stored.Status != JobRunning {
+		return ErrJobLockLost
+	}
+
+	finish(stored)
+	return nil
+}
+
+func (memory MemoryJobModel) Complete(ctx context.Context, job *Job) error {
+	return memory.finish(ctx, job, func(stored *memoryJob) {
+		delete(memory.store.jobs, stored.ID)
+	})
+}
+
+func (memory MemoryJobModel) Retry(ctx context.Context, job *Job, runAt time.Time, lastError string) error {
+	return memory.finish(ctx, job, func(stored *memoryJob) {
+		stored.Status = JobPending
+		stored.RunAt = runAt
+		stored.LastError = lastError
+		stored.lockedAt = time.Time{}
+		stored.UpdatedAt = nowSeconds()
+	})
+}
+
+func (memory MemoryJobModel) Release(ctx context.Context, job *Job) error {
+	return memory.finish(ctx, job, func(stored *memoryJob) {
+		stored.Status = JobPending
+		stored.Attempts--
+		stored.lockedAt = time.Time{}
+		stored.UpdatedAt = nowSeconds()
+	})
+}
+
+func (memory MemoryJobModel) Fail(ctx context.Context, job *Job, lastError string) error {
+	return memory.finish(ctx, job, func(stored *memoryJob) {
+		stored.Status = JobFailed
+		stored.LastError = lastError
+		stored.lockedAt = time.Time{}
+		stored.UpdatedAt = nowSeconds()
+	})
+}
+
+func (memory MemoryJobModel) Stats(ctx context.Context) ([]*QueueStats, error) {
+	if err := memory.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer memory.store.mu.Unlock()
+
+	now := time.Now()
+
+	queues := map[string]*QueueStats{}
+	oldest := map[string]time.Time{}
+
+	for _, job := range memory.store.jobs {
+		queue, found := queues[job.Queue]
+		if !found {
+			queue = &QueueStats{Queue: job.Queue}
+			queues[job.Queue] = queue
+		}
+
+		switch {
+		case job.Status == JobPending && !job.RunAt.After(now):
+			queue.Ready++
+			if oldest[job.Queue].IsZero() || job.RunAt.Before(oldest[job.Queue]) {
+				oldest[job.Queue] = job.RunAt
+			}
+		case job.Status == JobPending:
+			queue.Scheduled++
+		case job.Status == JobRunning:
+			queue.Running++
+		case job.Status == JobFailed:
+			queue.Failed++
+		}
+	}
+
+	stats := []*QueueStats{}
+	for name, queue := range queues {
+		if !oldest[name].IsZero() {
+			queue.OldestReadySeconds = now.Sub(oldest[name]).Seconds()
+		}
+		stats = append(stats, queue)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Queue < stats[j].Queue
+	})
+
+	return stats, nil
+}
+
+func (memory MemoryJobModel) GetFailed(ctx context.Context, queue string, filters Filters) ([]*Job, Metadata, error) {
+	if err := memory.store.lock(ctx); err != nil {
+		return nil, Metadata{}, err
+	}
+	defer memory.store.mu.Unlock()
+
+	failed := []*memoryJob{}
+	for _, job := range memory.store.jobs {
+		if job.Status == JobFailed && (queue == "" || job.Queue == queue) {
+			failed = append(failed, job)
+		}
+	}
+
+	sortRows(failed, filters, func(a *memoryJob, b *memoryJob, column string) int {
+		switch column {
+		case "updated_at":
+			return compareTimes(a.UpdatedAt, b.UpdatedAt)
+		case "created_at":
+			return compareTimes(a.CreatedAt, b.CreatedAt)
+		}
+
+		return compareValues(a.ID, b.ID)
+	}, func(job *memoryJob) int64 { return job.ID }, "DESC")
+
+	page, metadata := memoryPage(failed, filters)
+
+	jobs := make([]*Job, len(page))
+	for i, job := range page {
+		jobs[i] = job.copy()
+	}
+
+	return jobs, metadata, nil
+}
+
+func (memory MemoryJobModel) RetryFailed(ctx context.Context, id int64) (*Job, error) {
+	if err := memory.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer memory.store.mu.Unlock()
+
+	job, found := memory.store.jobs[id]
+	if !found || job.Status != JobFailed {
+		return nil, ErrRecordNotFound
+	}
+
+	if memory.store.uniqueKeyTaken(job.UniqueKey, job.ID) {
+		return nil, ErrDuplicateJob
+	}
+
+	job.Status = JobPending
+	job.Attempts = 0
+	job.RunAt = time.Now()
+	job.LastError = ""
+	job.lockedAt = time.Time{}
+	job.UpdatedAt = nowSeconds()
+
+	return job.copy(), nil
+}
+
+func (memory MemoryJobModel) DeleteFailed(ctx context.Context, id int64) error {
+	if err := memory.store.lock(ctx); err != nil {
+		return err
+	}
+	defer memory.store.mu.Unlock()
+
+	job, found := memory.store.jobs[id]
+	if !found || job.Status != JobFailed {
+		return ErrRecordNotFound
+	}
+
+	delete(memory.store.jobs, id)
+	return nil
+}
+
+// ----------------------------------------------------------------------------------
+// Events
+
+type MemoryEventModel struct {
+	store *memoryStore
+}
+
+// Listen makes the model call listener with every new event, after the change of the
+// movie is stored. It takes the place of the notifications on EventsChannel, which
+// there is no database for.
+func (memory MemoryEventModel) Listen(listener func(*Event)) {
+	memory.store.mu.Lock()
+	defer memory.store.mu.Unlock()
+
+	memory.store.listeners = append(memory.store.listeners, listener)
+}
+
+// Return the index of the first event with an ID greater than id. The caller holds the
+// lock.
+func (store *memoryStore) eventIndexAfter(id int64) int {
+	return sort.Search(len(store.events), func(i int) bool {
+		return store.events[i].ID > id
+	})
+}
+
+func (memory MemoryEventModel) Get(ctx context.Context, id int64) (*Event, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	if err := memory.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer memory.store.mu.Unlock()
+
+	i := memory.store.eventIndexAfter(id - 1)
+	if i == len(memory.store.events) || memory.store.events[i].ID != id {
+		return nil, ErrRecordNotFound
+	}
+
+	event := memory.store.events[i].Event
+	return &event, nil
+}
+
+func (memory MemoryEventModel) GetAfter(ctx context.Context, afterID int64, limit int) ([]*Event, error) {
+	if err := memory.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer memory.store.mu.Unlock()
+
+	events := []*Event{}
+	for _, stored := range memory.store.events[memory.store.eventIndexAfter(afterID):] {
+		if len(events) == limit {
+			break
+		}
+
+		event := stored.Event
+		events = append(events, &event)
+	}
+
+	return events, nil
+}
+
+// Dispatch() marks up to limit events dispatched and enqueues a job delivering each of
+// them to every active webhook subscribed to it, like DISPATCH_EVENTS_QUERY.
+func (memory MemoryEventModel) Dispatch(ctx context.Context, queue string, kind string, maxAttempts int, limit int) (int64, int64, error) {
+	if err := memory.store.lock(ctx); err != nil {
+		return 0, 0, err
+	}
+	defer memory.store.mu.Unlock()
+
+	webhooks := make([]*Webhook, 0, len(memory.store.webhooks))
+	for _, webhook := range memory.store.webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+
+	now := nowSeconds()
+
+	var events, deliveries int64
+	for _, event := range memory.store.events {
+		if int(events) == limit {
+			break
+		}
+
+		if !event.dispatchedAt.IsZero() {
+			continue
+		}
+
+		event.dispatchedAt = now
+		events++
+
+		for _, webhook := range webhooks {
+			if !webhook.Active || !containsAll(webhook.Events, []string{event.Type}) {
+				continue
+			}
+
+			payload, err := json.Marshal(WebhookJob{WebhookID: webhook.ID, EventID: event.ID})
+			if err != nil {
+				return 0, 0, err
+			}
+
+			err = memory.store.enqueue(&Job{
+				Queue:       queue,
+				Kind:        kind,
+				Payload:     payload,
+				MaxAttempts: maxAttempts,
+				TraceParent: event.TraceParent,
+			})
+			if err != nil {
+				return 0, 0, err
+			}
+
+			deliveries++
+		}
+	}
+
+	return events, deliveries, nil
+}
+
+// Delete the events dispatched before a time, along with their delivery logs.
+func (memory MemoryEventModel) DeleteDispatched(ctx context.Context, before time.Time) (int64, error) {
+	if err := memory.store.lock(ctx); err != nil {
+		return 0, err
+	}
+	defer memory.store.mu.Unlock()
+
+	kept := memory.store.events[:0]
+	deleted := map[int64]bool{}
+
+	for _, event := range memory.store.events {
+		if !event.dispatchedAt.IsZero() && event.dispatchedAt.Before(before) {
+			deleted[event.ID] = true
+			continue
+		}
+
+		kept = append(kept, event)
+	}
+
+	memory.store.events = kept
+
+	for id, delivery := range memory.store.deliveries {
+		if deleted[delivery.EventID] {
+			delete(memory.store.deliveries, id)
+		}
+	}
+
+	return int64(len(deleted)), nil
+}
+
+// ----------------------------------------------------------------------------------
+// Webhooks
+
+type MemoryWebhookModel struct {
+	store *memoryStore
+}
+
+// Return a copy of a webhook.
+func copyWebhook(webhook *Webhook) *Webhook {
+	copied := *webhook
+	copied.Events = append([]string{}, webhook.Events...)
+
+	return &copied
+}
+
+func (memory MemoryWebhookModel) Insert(ctx context.Context, webhook *Webhook) error {
+	if err := memory.store.lock(ctx); err != nil {
+		return err
+	}
+	defer memory.store.mu.Unlock()
+
+	webhook.ID = memory.store.nextID("webhooks")
+	webhook.CreatedAt = nowSeconds()
+	webhook.Version = 1
+
+	memory.store.webhooks[webhook.ID] = copyWebhook(webhook)
+	return nil
+}
+
+func (memory MemoryWebhookModel) Get(ctx context.Context, id int64) (*Webhook, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	if err := memory.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer memory.store.mu.Unlock()
+
+	webhook, found := memory.store.webhooks[id]
+	if !found {
+		return nil, ErrRecordNotFound
+	}
+
+	return copyWebhook(webhook), nil
+}
+
+func (memory MemoryWebhookModel) GetAll(ctx context.Context) ([]*Webhook, error) {
+	if err := memory.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer memory.store.mu.Unlock()
+
+	webhooks := []*Webhook{}
+	for _, webhook := range memory.store.webhooks {
+		webhooks = append(webhooks, copyWebhook(webhook))
+	}
+
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+
+	return webhooks, nil
+}
+
+func (memory MemoryWebhookModel) Update(ctx context.Context, webhook *Webhook) error {
+	if err := memory.store.lock(ctx); err != nil {
+		return err
+	}
+	defer memory.store.mu.Unlock()
+
+	stored, found := memory.store.webhooks[webhook.ID]
+	if !found || stored.Version != webhook.Version {
+		return ErrEditConflict
+	}
+
+	webhook.Version++
+
+	updated := copyWebhook(webhook)
+	updated.CreatedAt = stored.CreatedAt
+
+	memory.store.webhooks[webhook.ID] = updated
+	return nil
+}
+
+func (memory MemoryWebhookModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	if err := memory.store.lock(ctx); err != nil {
+		return err
+	}
+	defer memory.store.mu.Unlock()
+
+	if _, found := memory.store.webhooks[id]; !found {
+		return ErrRecordNotFound
+	}
+
+	delete(memory.store.webhooks, id)
+
+	for deliveryID, delivery := range memory.store.deliveries {
+		if delivery.WebhookID == id {
+			delete(memory.store.deliveries, deliveryID)
+		}
+	}
+
+	return nil
+}
+
+func (memory MemoryWebhookModel) InsertDelivery(ctx context.Context, delivery *Delivery) error {
+	if err := memory.store.lock(ctx); err != nil {
+		return err
+	}
+	defer memory.store.mu.Unlock()
+
+	_, webhookFound := memory.store.webhooks[delivery.WebhookID]
+	i := memory.store.eventIndexAfter(delivery.EventID - 1)
+	eventFound := i < len(memory.store.events) && memory.store.events[i].ID == delivery.EventID
+
+	if !webhookFound || !eventFound {
+		return errMemoryDeliveryRefs
+	}
+
+	delivery.ID = memory.store.nextID("webhook_deliveries")
+	delivery.CreatedAt = nowSeconds()
+
+	copied := *delivery
+	memory.store.deliveries[delivery.ID] = &copied
+
+	return nil
+}
+
+func (memory MemoryWebhookModel) GetDelivery(ctx context.Context, webhookID int64, id int64) (*Delivery, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	if err := memory.store.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer memory.store.mu.Unlock()
+
+	delivery, found := memory.store.deliveries[id]
+	if !found || delivery.WebhookID != webhookID {
+		return nil, ErrRecordNotFound
+	}
+
+	copied := *delivery
+	return &copied, nil
+}
+
+func (memory MemoryWebhookModel) GetDeliveries(ctx context.Context, webhookID int64, filters Filters) ([]*Delivery, Metadata, error) {
+	if err := memory.store.lock(ctx); err != nil {
+		return nil, Metadata{}, err
+	}
+	defer memory.store.mu.Unlock()
+
+	deliveries := []*Delivery{}
+	for _, delivery := range memory.store.deliveries {
+		if delivery.WebhookID == webhookID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	sortRows(deliveries, filters, func(a *Delivery, b *Delivery, column string) int {
+		if column == "created_at" {
+			return compareTimes(a.CreatedAt, b.CreatedAt)
+		}
+
+		return compareValues(a.ID, b.ID)
+	}, func(delivery *Delivery) int64 { return delivery.ID }, "DESC")
+
+	page, metadata := memoryPage(deliveries, filters)
+
+	copies := make([]*Delivery, len(page))
+	for i, delivery := range page {
+		copied := *delivery
+		copies[i] = &copied
+	}
+
+	return copies, metadata, nil
+}
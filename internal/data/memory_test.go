@@ -0,0 +1,408 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var sortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+
+// Return memory models with four movies, whose IDs are 1 to 4 in this order.
+func newMemoryMovies(t *testing.T) Models {
+	models := NewMemoryModels()
+
+	movies := []*Movie{
+		{Title: "Casablanca", Year: 1942, Runtime: 102, Genres: []string{"drama", "romance"}},
+		{Title: "Vertigo", Year: 1958, Runtime: 128, Genres: []string{"thriller", "mystery"}},
+		{Title: "The Third Man", Year: 1949, Runtime: 104, Genres: []string{"thriller", "noir"}},
+		{Title: "Rear Window", Year: 1954, Runtime: 112, Genres: []string{"thriller", "mystery"}},
+	}
+
+	for _, movie := range movies {
+		if err := models.Movies.Insert(context.Background(), movie); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return models
+}
+
+func TestMemoryMovieErrors(t *testing.T) {
+	ctx := context.Background()
+	models := newMemoryMovies(t)
+
+	stale, err := models.Movies.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ := models.Movies.Get(ctx, 1)
+	current.Year = 1943
+	if err := models.Movies.Update(ctx, current); err != nil || current.Version != 2 {
+		t.Fatalf("got version %d (%v), want 2", current.Version, err)
+	}
+
+	tests := []struct {
+		name string
+		run  func() error
+		want error
+	}{
+		{"get missing", func() error { _, err := models.Movies.Get(ctx, 5); return err }, ErrRecordNotFound},
+		{"get zero", func() error { _, err := models.Movies.Get(ctx, 0); return err }, ErrRecordNotFound},
+		{"delete missing", func() error { return models.Movies.Delete(ctx, 5) }, ErrRecordNotFound},
+		{"update stale version", func() error { return models.Movies.Update(ctx, stale) }, ErrEditConflict},
+		{"update missing", func() error {
+			return models.Movies.Update(ctx, &Movie{ID: 5, Title: "M", Year: 1931, Genres: []string{"crime"}, Version: 1})
+		}, ErrEditConflict},
+		{"update current version", func() error { return models.Movies.Update(ctx, current) }, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.run(); !errors.Is(err, test.want) {
+				t.Fatalf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+
+	// The stale copy didn't overwrite the update.
+	movie, _ := models.Movies.Get(ctx, 1)
+	if movie.Year != 1943 || movie.Version != 3 {
+		t.Errorf("got movie %+v", movie)
+	}
+}
+
+func TestMemoryMovieGetAll(t *testing.T) {
+	models := newMemoryMovies(t)
+
+	tests := []struct {
+		name     string
+		title    string
+		genres   []string
+		filters  Filters
+		want     []int64
+		metadata Metadata
+	}{
+		{
+			name:     "all",
+			filters:  Filters{Page: 1, PageSize: 10, Sort: "id"},
+			want:     []int64{1, 2, 3, 4},
+			metadata: Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 4},
+		},
+		{
+			name:     "title word, any case",
+			title:    "WINDOW",
+			filters:  Filters{Page: 1, PageSize: 10, Sort: "id"},
+			want:     []int64{4},
+			metadata: Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 1},
+		},
+		{
+			name:     "title words in any order",
+			title:    "man third",
+			filters:  Filters{Page: 1, PageSize: 10, Sort: "id"},
+			want:     []int64{3},
+			metadata: Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 1},
+		},
+		{
+			name:     "part of a word",
+			title:    "wind",
+			filters:  Filters{Page: 1, PageSize: 10, Sort: "id"},
+			want:     []int64{},
+			metadata: Metadata{},
+		},
+		{
+			name:     "genre, newest first",
+			genres:   []string{"thriller"},
+			filters:  Filters{Page: 1, PageSize: 10, Sort: "-year"},
+			want:     []int64{2, 4, 3},
+			metadata: Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 3},
+		},
+		{
+			name:     "every genre, by title",
+			genres:   []string{"mystery", "thriller"},
+			filters:  Filters{Page: 1, PageSize: 10, Sort: "title"},
+			want:     []int64{4, 2},
+			metadata: Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 2},
+		},
+		{
+			name:     "longest first",
+			filters:  Filters{Page: 1, PageSize: 10, Sort: "-runtime"},
+			want:     []int64{2, 4, 3, 1},
+			metadata: Metadata{CurrentPage: 1, PageSize: 10, FirstPage: 1, LastPage: 1, TotalRecords: 4},
+		},
+		{
+			name:     "second page",
+			filters:  Filters{Page: 2, PageSize: 3, Sort: "id"},
+			want:     []int64{4},
+			metadata: Metadata{CurrentPage: 2, PageSize: 3, FirstPage: 1, LastPage: 2, TotalRecords: 4},
+		},
+		{
+			name:     "past the last page",
+			filters:  Filters{Page: 3, PageSize: 3, Sort: "-id"},
+			want:     []int64{},
+			metadata: Metadata{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.filters.SortSafeList = sortSafeList
+
+			movies, metadata, _, err := models.Movies.GetAll(context.Background(), test.title, test.genres, test.filters)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ids := []int64{}
+			for _, movie := range movies {
+				ids = append(ids, movie.ID)
+			}
+
+			if !reflect.DeepEqual(ids, test.want) {
+				t.Errorf("got movies %v, want %v", ids, test.want)
+			}
+
+			if metadata != test.metadata {
+				t.Errorf("got metadata %+v, want %+v", metadata, test.metadata)
+			}
+		})
+	}
+}
+
+func TestMemoryMovieGetAllFields(t *testing.T) {
+	models := newMemoryMovies(t)
+
+	movies, _, _, err := models.Movies.GetAll(context.Background(), "vertigo", nil, Filters{Page: 1, PageSize: 10, Sort: "id", SortSafeList: sortSafeList, Fields: []string{"id", "title"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*Movie{{ID: 2, Title: "Vertigo"}}
+	if !reflect.DeepEqual(movies, want) {
+		t.Errorf("got %+v, want %+v", movies[0], want[0])
+	}
+
+	// The returned movies are copies.
+	movies[0].Title = "Psycho"
+
+	movie, _ := models.Movies.Get(context.Background(), 2)
+	if movie.Title != "Vertigo" {
+		t.Errorf("got title %q, want Vertigo", movie.Title)
+	}
+}
+
+func newMemoryUser(t *testing.T, models Models, email string) *User {
+	t.Helper()
+
+	user := &User{Name: "Alice", Email: email, Activated: true}
+	if err := user.Password.Set("pa55word"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := models.Users.Insert(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+func TestMemoryUserErrors(t *testing.T) {
+	ctx := context.Background()
+	models := NewMemoryModels()
+
+	alice := newMemoryUser(t, models, "Alice@Example.com")
+	bob := newMemoryUser(t, models, "bob@example.com")
+
+	stale := *bob
+	bob.Name = "Robert"
+	if err := models.Users.Update(ctx, bob); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		run  func() error
+		want error
+	}{
+		{"insert same email", func() error { return models.Users.Insert(ctx, &User{Email: "Alice@Example.com"}) }, ErrDuplicateEmail},
+		{"insert email in another case", func() error { return models.Users.Insert(ctx, &User{Email: "alice@example.COM"}) }, ErrDuplicateEmail},
+		{"update to taken email", func() error {
+			changed := *bob
+			changed.Email = "ALICE@example.com"
+			return models.Users.Update(ctx, &changed)
+		}, ErrDuplicateEmail},
+		{"update stale version", func() error { return models.Users.Update(ctx, &stale) }, ErrRecordNotFound},
+		{"get missing email", func() error { _, err := models.Users.GetByEmail(ctx, "carol@example.com"); return err }, ErrRecordNotFound},
+		{"get email in another case", func() error {
+			user, err := models.Users.GetByEmail(ctx, "alice@EXAMPLE.com")
+			if err == nil && user.ID != alice.ID {
+				return errors.New("got another user")
+			}
+			return err
+		}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.run(); !errors.Is(err, test.want) {
+				t.Fatalf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+// A registration which fails at any step leaves no user behind.
+func TestMemoryRegister(t *testing.T) {
+	ctx := context.Background()
+	models := NewMemoryModels()
+
+	failed := errors.New("payload can't be encoded")
+
+	register := func(newJob func(*User) (*Job, error)) error {
+		user := &User{Name: "Alice", Email: "alice@example.com"}
+		return models.Users.Register(ctx, user, []string{"movies:read"}, newJob)
+	}
+
+	welcome := func(user *User) (*Job, error) {
+		return &Job{Queue: "mail", Kind: "send_welcome_email", Payload: []byte(fmt.Sprintf(`{"user_id": %d}`, user.ID)), MaxAttempts: 8}, nil
+	}
+
+	if err := register(func(*User) (*Job, error) { return nil, failed }); !errors.Is(err, failed) {
+		t.Fatalf("got %v, want %v", err, failed)
+	}
+
+	if _, err := models.Users.GetByEmail(ctx, "alice@example.com"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("got %v after a failed registration, want %v", err, ErrRecordNotFound)
+	}
+
+	if err := register(welcome); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := register(welcome); !errors.Is(err, ErrDuplicateEmail) {
+		t.Fatalf("got %v registering twice, want %v", err, ErrDuplicateEmail)
+	}
+
+	user, err := models.Users.GetByEmail(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	permissions, err := models.Permissions.GetAllForUser(ctx, user.ID)
+	if err != nil || !permissions.Include("movies:read") {
+		t.Errorf("got permissions %v (%v), want movies:read", permissions, err)
+	}
+
+	job, err := models.Jobs.Claim(ctx, "mail", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := fmt.Sprintf(`{"user_id": %d}`, user.ID); string(job.Payload) != want {
+		t.Errorf("got payload %s, want %s", job.Payload, want)
+	}
+
+	// Only one job was enqueued.
+	if _, err := models.Jobs.Claim(ctx, "mail", time.Minute); !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("got %v claiming another job, want %v", err, ErrRecordNotFound)
+	}
+}
+
+func TestMemoryGetForToken(t *testing.T) {
+	ctx := context.Background()
+	models := NewMemoryModels()
+
+	alice := newMemoryUser(t, models, "alice@example.com")
+
+	newToken := func(ttl time.Duration, scope string) string {
+		token, err := models.Tokens.New(ctx, alice.ID, ttl, scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return token.Plaintext
+	}
+
+	activation := newToken(time.Hour, ScopeActivation)
+	expired := newToken(-time.Minute, ScopeActivation)
+
+	tests := []struct {
+		name  string
+		scope string
+		token string
+		want  error
+	}{
+		{"valid", ScopeActivation, activation, nil},
+		{"other scope", ScopeAuthentication, activation, ErrRecordNotFound},
+		{"expired", ScopeActivation, expired, ErrRecordNotFound},
+		{"unknown", ScopeActivation, "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU", ErrRecordNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user, err := models.Users.GetForToken(ctx, test.scope, test.token)
+			if !errors.Is(err, test.want) {
+				t.Fatalf("got %v, want %v", err, test.want)
+			}
+
+			if err == nil && user.ID != alice.ID {
+				t.Fatalf("got user %d, want %d", user.ID, alice.ID)
+			}
+		})
+	}
+
+	// Deleting the tokens of the scope ends them.
+	if err := models.Tokens.DeleteAllForUser(ctx, ScopeActivation, alice.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := models.Users.GetForToken(ctx, ScopeActivation, activation); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("got %v after deleting the tokens, want %v", err, ErrRecordNotFound)
+	}
+}
+
+// A running job whose lock expired on its last attempt is failed, not claimed again.
+func TestMemoryClaimExhausted(t *testing.T) {
+	ctx := context.Background()
+	models := NewMemoryModels()
+
+	for _, maxAttempts := range []int{1, 2} {
+		job := &Job{Queue: "mail", Kind: "send_welcome_email", Payload: []byte(`{}`), MaxAttempts: maxAttempts}
+		if err := models.Jobs.Enqueue(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := models.Jobs.Claim(ctx, "mail", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Both locks expired: the job with attempts left is claimed again.
+	job, err := models.Jobs.Claim(ctx, "mail", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job.MaxAttempts != 2 || job.Attempts != 2 {
+		t.Errorf("got job %+v, want the second one on its second attempt", job)
+	}
+
+	if _, err := models.Jobs.Claim(ctx, "mail", time.Hour); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("got %v, want %v", err, ErrRecordNotFound)
+	}
+
+	failed, _, err := models.Jobs.GetFailed(ctx, "mail", Filters{Page: 1, PageSize: 10, Sort: "id", SortSafeList: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(failed) != 1 || failed[0].MaxAttempts != 1 || failed[0].Attempts != 1 || failed[0].LastError != ExhaustedJobError {
+		t.Errorf("got failed jobs %+v, want the first one", failed)
+	}
+}
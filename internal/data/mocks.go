@@ -3,68 +3,193 @@
 
 package data
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type MockMovieModel struct{}
 type MockUserModel struct{}
 type MockTokenModel struct{}
 type MockPermissionModel struct{}
+type MockJobModel struct{}
+type MockEventModel struct{}
+type MockWebhookModel struct{}
 
-func (mock MockMovieModel) Insert(movie *Movie) error {
+func (mock MockMovieModel) Insert(ctx context.Context, movie *Movie) error {
 	return nil
 }
 
-func (mock MockMovieModel) Get(id int64) (*Movie, error) {
+func (mock MockMovieModel) Get(ctx context.Context, id int64, fields ...string) (*Movie, error) {
 	return nil, nil
 }
 
-func (mock MockMovieModel) Update(movie *Movie) error {
+func (mock MockMovieModel) Update(ctx context.Context, movie *Movie) error {
 	return nil
 }
 
-func (mock MockMovieModel) Delete(id int64) error {
+func (mock MockMovieModel) Delete(ctx context.Context, id int64) error {
 	return nil
 }
 
-func (mock MockMovieModel) GetAll(title string, genres []string, f Filters) ([]*Movie, Metadata, error) {
-	return nil, Metadata{}, nil
+func (mock MockMovieModel) GetAll(ctx context.Context, title string, genres []string, f Filters) ([]*Movie, Metadata, Facets, error) {
+	return nil, Metadata{}, nil, nil
+}
+
+func (mock MockMovieModel) Suggest(ctx context.Context, query string, limit int) ([]*Suggestion, error) {
+	return nil, nil
+}
+
+func (mock MockMovieModel) RefreshFacets(ctx context.Context) error {
+	return nil
 }
 
 // for userModel
-func (mock MockUserModel) Insert(*User) error {
+func (mock MockUserModel) Insert(ctx context.Context, user *User) error {
 	return nil
 }
 
-func (mock MockUserModel) GetByEmail(string) (*User, error) {
+func (mock MockUserModel) Register(ctx context.Context, user *User, codes []string, newJob func(*User) (*Job, error)) error {
+	return nil
+}
+
+func (mock MockUserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	return nil, nil
 }
 
-func (mock MockUserModel) Update(*User) error {
+func (mock MockUserModel) Update(ctx context.Context, user *User) error {
 	return nil
 }
 
-func (mock MockUserModel) GetForToken(scope string, plainToken string) (*User, error) {
+func (mock MockUserModel) GetForToken(ctx context.Context, scope string, plainToken string) (*User, error) {
 	return nil, nil
 }
 
+func (mock MockUserModel) DeleteUnactivated(ctx context.Context, createdBefore time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (mock MockUserModel) SetEmailStatus(ctx context.Context, email string, status string) (bool, error) {
+	return false, nil
+}
+
+func (mock MockUserModel) Suppressed(ctx context.Context, email string, bulk bool) (bool, error) {
+	return false, nil
+}
+
 // For tokenModel
-func (mock MockTokenModel) DeleteAllForUser(scope string, userID int64) error {
+func (mock MockTokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
 	return nil
 }
 
-func (mock MockTokenModel) Insert(token *Token) error {
+func (mock MockTokenModel) Insert(ctx context.Context, token *Token) error {
 	return nil
 }
 
-func (mock MockTokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (mock MockTokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	return nil, nil
 }
 
+func (mock MockTokenModel) DeleteExpired(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
 // For PermissionModel
-func (mock MockPermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+func (mock MockPermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
 	return nil, nil
 }
 
-func (mock MockPermissionModel) AddForUsers(userID int64, codes ...string) error {
+func (mock MockPermissionModel) AddForUsers(ctx context.Context, userID int64, codes ...string) error {
+	return nil
+}
+
+// For JobModel
+func (mock MockJobModel) Enqueue(ctx context.Context, job *Job) error {
 	return nil
 }
+
+func (mock MockJobModel) Claim(ctx context.Context, queue string, lockTimeout time.Duration) (*Job, error) {
+	return nil, ErrRecordNotFound
+}
+
+func (mock MockJobModel) Complete(ctx context.Context, job *Job) error {
+	return nil
+}
+
+func (mock MockJobModel) Retry(ctx context.Context, job *Job, runAt time.Time, lastError string) error {
+	return nil
+}
+
+func (mock MockJobModel) Release(ctx context.Context, job *Job) error {
+	return nil
+}
+
+func (mock MockJobModel) Fail(ctx context.Context, job *Job, lastError string) error {
+	return nil
+}
+
+func (mock MockJobModel) Stats(ctx context.Context) ([]*QueueStats, error) {
+	return nil, nil
+}
+
+func (mock MockJobModel) GetFailed(ctx context.Context, queue string, filters Filters) ([]*Job, Metadata, error) {
+	return nil, Metadata{}, nil
+}
+
+func (mock MockJobModel) RetryFailed(ctx context.Context, id int64) (*Job, error) {
+	return nil, nil
+}
+
+func (mock MockJobModel) DeleteFailed(ctx context.Context, id int64) error {
+	return nil
+}
+
+// For EventModel
+func (mock MockEventModel) Get(ctx context.Context, id int64) (*Event, error) {
+	return nil, nil
+}
+
+func (mock MockEventModel) GetAfter(ctx context.Context, afterID int64, limit int) ([]*Event, error) {
+	return nil, nil
+}
+
+func (mock MockEventModel) Dispatch(ctx context.Context, queue string, kind string, maxAttempts int, limit int) (int64, int64, error) {
+	return 0, 0, nil
+}
+
+func (mock MockEventModel) DeleteDispatched(ctx context.Context, before time.Time) (int64, error) {
+	return 0, nil
+}
+
+// For WebhookModel
+func (mock MockWebhookModel) Insert(ctx context.Context, webhook *Webhook) error {
+	return nil
+}
+
+func (mock MockWebhookModel) Get(ctx context.Context, id int64) (*Webhook, error) {
+	return nil, nil
+}
+
+func (mock MockWebhookModel) GetAll(ctx context.Context) ([]*Webhook, error) {
+	return nil, nil
+}
+
+func (mock MockWebhookModel) Update(ctx context.Context, webhook *Webhook) error {
+	return nil
+}
+
+func (mock MockWebhookModel) Delete(ctx context.Context, id int64) error {
+	return nil
+}
+
+func (mock MockWebhookModel) InsertDelivery(ctx context.Context, delivery *Delivery) error {
+	return nil
+}
+
+func (mock MockWebhookModel) GetDelivery(ctx context.Context, webhookID int64, id int64) (*Delivery, error) {
+	return nil, nil
+}
+
+func (mock MockWebhookModel) GetDeliveries(ctx context.Context, webhookID int64, filters Filters) ([]*Delivery, Metadata, error) {
+	return nil, Metadata{}, nil
+}
@@ -1,9 +1,13 @@
 package data
 
 import (
+	"Meow/internal/tracing"
+	"context"
 	"database/sql"
 	"errors"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 var (
@@ -14,35 +18,98 @@ var (
 	ErrEditConflict = errors.New("edit conflict")
 )
 
-// Create a model struct which wraps the MovieModel.
+// Create a model struct which wraps the MovieModel. Every method takes the context of
+// the caller, so queries are cancelled with the request and traced as part of it.
 type Models struct {
 	Movies interface {
-		Insert(*Movie) error
-		Get(int64) (*Movie, error)
-		Update(*Movie) error
-		Delete(int64) error
-		GetAll(string, []string, Filters) ([]*Movie, Metadata, error)
+		Insert(context.Context, *Movie) error
+		Get(context.Context, int64, ...string) (*Movie, error)
+		Update(context.Context, *Movie) error
+		Delete(context.Context, int64) error
+		GetAll(context.Context, string, []string, Filters) ([]*Movie, Metadata, Facets, error)
+		Suggest(context.Context, string, int) ([]*Suggestion, error)
+		RefreshFacets(context.Context) error
 	}
 
 	Users interface {
-		Insert(*User) error
-		GetByEmail(string) (*User, error)
-		Update(*User) error
-		GetForToken(string, string) (*User, error)
+		Insert(context.Context, *User) error
+		Register(context.Context, *User, []string, func(*User) (*Job, error)) error
+		GetByEmail(context.Context, string) (*User, error)
+		Update(context.Context, *User) error
+		GetForToken(context.Context, string, string) (*User, error)
+		DeleteUnactivated(context.Context, time.Time) (int64, error)
+		SetEmailStatus(context.Context, string, string) (bool, error)
+		Suppressed(context.Context, string, bool) (bool, error)
 	}
 
 	Tokens interface {
-		DeleteAllForUser(scope string, userID int64) error
-		Insert(token *Token) error
-		New(int64, time.Duration, string) (*Token, error)
+		DeleteAllForUser(ctx context.Context, scope string, userID int64) error
+		Insert(ctx context.Context, token *Token) error
+		New(context.Context, int64, time.Duration, string) (*Token, error)
+		DeleteExpired(context.Context) (int64, error)
 	}
 
 	Permissions interface {
-		GetAllForUser(int64) (Permissions, error)
-		AddForUsers(int64, ...string) error
+		GetAllForUser(context.Context, int64) (Permissions, error)
+		AddForUsers(context.Context, int64, ...string) error
+	}
+
+	Jobs interface {
+		Enqueue(context.Context, *Job) error
+		Claim(context.Context, string, time.Duration) (*Job, error)
+		Complete(context.Context, *Job) error
+		Retry(context.Context, *Job, time.Time, string) error
+		Release(context.Context, *Job) error
+		Fail(context.Context, *Job, string) error
+		Stats(context.Context) ([]*QueueStats, error)
+		GetFailed(context.Context, string, Filters) ([]*Job, Metadata, error)
+		RetryFailed(context.Context, int64) (*Job, error)
+		DeleteFailed(context.Context, int64) error
+	}
+
+	Events interface {
+		Get(context.Context, int64) (*Event, error)
+		GetAfter(context.Context, int64, int) ([]*Event, error)
+		Dispatch(context.Context, string, string, int, int) (int64, int64, error)
+		DeleteDispatched(context.Context, time.Time) (int64, error)
+	}
+
+	Webhooks interface {
+		Insert(context.Context, *Webhook) error
+		Get(context.Context, int64) (*Webhook, error)
+		GetAll(context.Context) ([]*Webhook, error)
+		Update(context.Context, *Webhook) error
+		Delete(context.Context, int64) error
+		InsertDelivery(context.Context, *Delivery) error
+		GetDelivery(context.Context, int64, int64) (*Delivery, error)
+		GetDeliveries(context.Context, int64, Filters) ([]*Delivery, Metadata, error)
 	}
 }
 
+// The startQuerySpan() function starts a tracing span for a query of a model. The span
+// carries the name of the SQL statement variable, e.g. "GET_QUERY".
+func startQuerySpan(ctx context.Context, name string, statement string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, name, tracing.KindClient)
+	span.SetAttribute("db.system", "postgresql")
+	span.SetAttribute("db.statement.name", statement)
+
+	return ctx, span
+}
+
+// The isUniqueViolation() function reports whether err is a unique_violation (SQLSTATE
+// 23505) of constraint, which may be the name of a unique index.
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
+
+// The queryer interface is satisfied by *sql.DB and *sql.Tx, so a query can run on its
+// own or as part of a transaction.
+type queryer interface {
+	ExecContext(context.Context, string, ...interface{}) (sql.Result, error)
+	QueryRowContext(context.Context, string, ...interface{}) *sql.Row
+}
+
 // Add a New() method which returns a Models struct
 func NewModels(db *sql.DB) Models {
 	return Models{
@@ -60,6 +127,18 @@ func NewModels(db *sql.DB) Models {
 		Permissions: PermissionModel{
 			DB: db,
 		},
+
+		Jobs: JobModel{
+			DB: db,
+		},
+
+		Events: EventModel{
+			DB: db,
+		},
+
+		Webhooks: WebhookModel{
+			DB: db,
+		},
 	}
 }
 
@@ -71,5 +150,8 @@ func NewMockModels() Models {
 		Users:       MockUserModel{},
 		Tokens:      MockTokenModel{},
 		Permissions: MockPermissionModel{},
+		Jobs:        MockJobModel{},
+		Events:      MockEventModel{},
+		Webhooks:    MockWebhookModel{},
 	}
 }
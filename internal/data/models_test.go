@@ -0,0 +1,34 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestIsUniqueViolation(t *testing.T) {
+	duplicate := &pq.Error{Code: "23505", Constraint: "jobs_unique_key_idx", Message: "duplicate key value violates unique constraint \"jobs_unique_key_idx\""}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unique violation", duplicate, true},
+		{"wrapped", fmt.Errorf("retrying job 7: %w", duplicate), true},
+		{"other constraint", &pq.Error{Code: "23505", Constraint: "users_email_key"}, false},
+		{"other code", &pq.Error{Code: "23503", Constraint: "jobs_unique_key_idx"}, false},
+		{"same message, not from postgres", errors.New(duplicate.Error()), false},
+		{"nil", nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isUniqueViolation(test.err, "jobs_unique_key_idx"); got != test.want {
+				t.Fatalf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
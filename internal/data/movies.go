@@ -28,7 +28,7 @@ var (
 	`
 
 	// Define the SQL query for retrieving the movie data.
-	GET_QUERY = `SELECT id, created_at, title, year, runtime, genres, version
+	GET_QUERY = `SELECT %s
 	FROM movies WHERE id = $1
 	`
 
@@ -38,13 +38,20 @@ var (
 	genres = $4, version = version + 1 WHERE version = $6 AND id = $5 RETURNING version
 	`
 
-	// Delete sql query for delete a record.
-	DELETE_QUERY = `DELETE FROM movies WHERE id = $1`
+	// Delete sql query for delete a record. The deleted movie is returned for its
+	// event.
+	DELETE_QUERY = `DELETE FROM movies WHERE id = $1
+	RETURNING id, created_at, title, year, runtime, genres, version
+	`
+
+	// Define the condition for filtering movies by title ($1) and genres ($2). It is
+	// shared by GET_ALL_QUERY and GET_ALL_WITH_FACETS_QUERY.
+	FILTER_MOVIES_CONDITION = `(to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') AND 
+	(genres @> $2 OR $2 = '{}')`
 
 	// Define a query for get multiple movie based on query parameters and sort.
-	GET_ALL_QUERY = `SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
-	FROM movies WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') AND 
-	(genres @> $2 OR $2 = '{}')
+	GET_ALL_QUERY = `SELECT count(*) OVER(), %s
+	FROM movies WHERE ` + FILTER_MOVIES_CONDITION + `
 	ORDER BY %s %s, id ASC
 	LIMIT $3 OFFSET $4
 	`
@@ -56,7 +63,7 @@ type MovieModel struct {
 }
 
 // Add a placeholder method for inserting a new record in the movies table.
-func (movieModel MovieModel) Insert(movie *Movie) error {
+func (movieModel MovieModel) Insert(ctx context.Context, movie *Movie) error {
 	// Create an args slice containing the values for the placeholder parameters from
 	// the movie struct.
 	args := []interface{}{
@@ -66,16 +73,37 @@ func (movieModel MovieModel) Insert(movie *Movie) error {
 		pq.Array(movie.Genres),
 	}
 
+	ctx, span := startQuerySpan(ctx, "MovieModel.Insert", "INSERT_QUERY")
+	defer span.End()
+
 	// Create a context function for 3 second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
+	// The movie and its event are written in one transaction.
+	tx, err := movieModel.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	// Set returned values from database into movie instance or return any error if exists.
-	return movieModel.DB.QueryRowContext(ctx, INSERT_QUERY, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err = tx.QueryRowContext(ctx, INSERT_QUERY, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	err = insertEvent(ctx, tx, EventMovieCreated, movie.ID, map[string]interface{}{"movie": movie})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // Add a placeholder method for fetching a specific record from the movies table.
-func (movieModel MovieModel) Get(id int64) (*Movie, error) {
+// If any fields are given, only those columns are selected.
+func (movieModel MovieModel) Get(ctx context.Context, id int64, fields ...string) (*Movie, error) {
 	// The PostgreSQL bigserial type that we're using for the movie ID starts
 	// auto-incrementing at 1 by default, so we know that no movies will have ID values
 	// less than that. To avoid making an unnecessary database call, we take a shortcut
@@ -87,23 +115,19 @@ func (movieModel MovieModel) Get(id int64) (*Movie, error) {
 	// Declare an empty movie struct to hold the data returned by the query.
 	var movie Movie
 
+	ctx, span := startQuerySpan(ctx, "MovieModel.Get", "GET_QUERY")
+	defer span.End()
+
 	// Use the context.WithTimeout() function to create a context.Context which carries a
 	// 3-second timeout deadline.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// Execute the query using the QueryRow() method, passing in the provided id value
 	// as a placeholder parameter, and scan the response data into the fields of the
 	// Movie struct.
-	err := movieModel.DB.QueryRowContext(ctx, GET_QUERY, id).Scan(
-		&movie.ID,
-		&movie.CreatedAt,
-		&movie.Title,
-		&movie.Year,
-		&movie.Runtime,
-		pq.Array(&movie.Genres),
-		&movie.Version,
-	)
+	query := fmt.Sprintf(GET_QUERY, movieSelect(fields))
+	err := movieModel.DB.QueryRowContext(ctx, query, id).Scan(movie.scanDestinations(fields)...)
 
 	// Handle any errors. If there was no matching movie found, Scan() will return
 	// a sql.ErrNoRows error. We check for this and return our custom ErrRecordNotFound
@@ -123,7 +147,7 @@ func (movieModel MovieModel) Get(id int64) (*Movie, error) {
 }
 
 // Add a placeholder method for updating a specific record in the movies table.
-func (movieModel MovieModel) Update(movie *Movie) error {
+func (movieModel MovieModel) Update(ctx context.Context, movie *Movie) error {
 
 	// Create an slice of interfaces include parameters we want to pass QueryRow() function in follow.
 	args := []interface{}{
@@ -135,12 +159,22 @@ func (movieModel MovieModel) Update(movie *Movie) error {
 		movie.Version,
 	}
 
+	ctx, span := startQuerySpan(ctx, "MovieModel.Update", "UPDATE_QUERY")
+	defer span.End()
+
 	// Define context.Context and cancel for set 3-second timeout for UPDATE_QUERY.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
+	// The movie and its event are written in one transaction.
+	tx, err := movieModel.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	// Execute Update query and scan new version that returned from database to movie.Version
-	err := movieModel.DB.QueryRowContext(ctx, UPDATE_QUERY, args...).Scan(&movie.Version)
+	err = tx.QueryRowContext(ctx, UPDATE_QUERY, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -149,72 +183,111 @@ func (movieModel MovieModel) Update(movie *Movie) error {
 			return err
 		}
 	}
+
+	err = insertEvent(ctx, tx, EventMovieUpdated, movie.ID, map[string]interface{}{"movie": movie})
+	if err != nil {
+		return err
+	}
+
 	// Ok.
-	return nil
+	return tx.Commit()
 }
 
 // Add a placeholder method for deleting a specific record from the movies table.
-func (movieModel MovieModel) Delete(id int64) error {
+func (movieModel MovieModel) Delete(ctx context.Context, id int64) error {
 	// Return an error if the movie id less than 1.
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
+	ctx, span := startQuerySpan(ctx, "MovieModel.Delete", "DELETE_QUERY")
+	defer span.End()
+
 	// Declare a ctx (context) for define a 3-second timeout for Delete query.
 	// Also get a cancel function for cancel query when time riched 3 second.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	// Execute the SQL query using the Exec() method, passing in the id variable as
-	// the value for the placeholder parameter. The Exec() method returns a sql.Result
-	// object.
-	result, err := movieModel.DB.ExecContext(ctx, DELETE_QUERY, id)
+	// The movie is deleted and its event written in one transaction.
+	tx, err := movieModel.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	// Call the RowsAffected() method on the sql.Result object to get the number of rows
-	// affected by the query.
-	rows, err := result.RowsAffected()
+	// Execute the SQL query, passing in the id variable as the value for the
+	// placeholder parameter, and scan the deleted movie.
+	var movie Movie
+
+	err = tx.QueryRowContext(ctx, DELETE_QUERY, id).Scan(
+		&movie.ID,
+		&movie.CreatedAt,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+	)
 	if err != nil {
-		return err
+		switch {
+		// If no rows were returned, we know that the movies table didn't contain a
+		// record with the provided ID at the moment we tried to delete it.
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
 	}
 
-	// If no rows were affected, we know that the movies table didn't contain a record
-	// with the provided ID at the moment we tried to delete it.
-	if rows == 0 {
-		return ErrRecordNotFound
+	err = insertEvent(ctx, tx, EventMovieDeleted, id, map[string]interface{}{"movie": movie})
+	if err != nil {
+		return err
 	}
 
 	// Ok.
-	return nil
+	return tx.Commit()
 }
 
 // Define GetAll() nethod on MovieModel for get all movies based on query parameters.
-func (movieModel MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// The counts of filters.Facets over every matching movie come with the same query.
+func (movieModel MovieModel) GetAll(ctx context.Context, title string, genres []string, filters Filters) ([]*Movie, Metadata, Facets, error) {
 	// Create formatted query by placing order by parameters.
-	query := fmt.Sprintf(GET_ALL_QUERY, filters.sortColumn(), filters.sortDirection())
+	query := fmt.Sprintf(GET_ALL_QUERY, movieSelect(filters.Fields), filters.sortColumn(), filters.sortDirection())
+	statement := "GET_ALL_QUERY"
+
+	// The facets are counted by the same query if client asked for them.
+	facets, names := newFacets(filters.Facets)
+
+	var facetArgs []interface{}
+	if len(names) > 0 {
+		query, statement, facetArgs = facetsQuery(title, genres, names, filters)
+	}
+
+	ctx, span := startQuerySpan(ctx, "MovieModel.GetAll", statement)
+	defer span.End()
 
 	// Create a context with 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// As our SQL query now has quite a few placeholder parameters, let's collect the
 	// values for the placeholders in a slice. Notice here how we call the limit() and
 	// offset() methods on the Filters struct to get the appropriate values for the
 	// LIMIT and OFFSET clauses.
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
+	args := append([]interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}, facetArgs...)
 
 	// Using QueryContext() for execut query.
 	// Result is sql.Rows.
 	rows, err := movieModel.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, nil, err
 	}
 	defer rows.Close()
 
-	// Declare a totalRecords variable.
+	// Declare a totalRecords variable, and the facet counts which are the same on
+	// every row.
 	totlaRecords := 0
+	var facetCounts []byte
 
 	// Initialize an empty slice to hold the movie data.
 	movies := []*Movie{}
@@ -225,19 +298,15 @@ func (movieModel MovieModel) GetAll(title string, genres []string, filters Filte
 		var movie Movie
 
 		// Scan values from rows to movie instance.
-		err := rows.Scan(
-			&totlaRecords,
-			&movie.ID,
-			&movie.CreatedAt,
-			&movie.Title,
-			&movie.Year,
-			&movie.Runtime,
-			pq.Array(&movie.Genres),
-			&movie.Version,
-		)
+		destinations := append([]interface{}{&totlaRecords}, movie.scanDestinations(filters.Fields)...)
+		if len(names) > 0 {
+			destinations = append(destinations, &facetCounts)
+		}
+
+		err := rows.Scan(destinations...)
 
 		if err != nil {
-			return nil, Metadata{}, err
+			return nil, Metadata{}, nil, err
 		}
 
 		// Add new movie to movies slice.
@@ -247,12 +316,16 @@ func (movieModel MovieModel) GetAll(title string, genres []string, filters Filte
 	// When the rows.Next() loop has finished, call rows.Err() to retrieve any error
 	// that was encountered during the iteration.
 	if err = rows.Err(); err != nil {
-		return nil, Metadata{}, err
+		return nil, Metadata{}, nil, err
+	}
+
+	if err = decodeFacets(facets, facetCounts); err != nil {
+		return nil, Metadata{}, nil, err
 	}
 
 	// Create metatdata for pagination.
 	metadata := calculateMetadata(totlaRecords, filters.Page, filters.PageSize)
 
 	// Ok.
-	return movies, metadata, nil
+	return movies, metadata, facets, nil
 }
@@ -39,8 +39,11 @@ type PermissionModel struct {
 	DB *sql.DB
 }
 
-func (permissionModel PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (permissionModel PermissionModel) GetAllForUser(ctx context.Context, userID int64) (Permissions, error) {
+	ctx, span := startQuerySpan(ctx, "PermissionModel.GetAllForUser", "GET_ALL_FOR_USERS_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	rows, err := permissionModel.DB.QueryContext(ctx, GET_ALL_FOR_USERS_QUERY, userID)
@@ -70,10 +73,19 @@ func (permissionModel PermissionModel) GetAllForUser(userID int64) (Permissions,
 	return permissions, nil
 }
 
-func (permissionModel PermissionModel) AddForUsers(userID int64, codes ...string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (permissionModel PermissionModel) AddForUsers(ctx context.Context, userID int64, codes ...string) error {
+	return addPermissions(ctx, permissionModel.DB, userID, codes...)
+}
+
+// The addPermissions() function grants the permissions with codes to a user on q, the
+// pool or a transaction.
+func addPermissions(ctx context.Context, q queryer, userID int64, codes ...string) error {
+	ctx, span := startQuerySpan(ctx, "PermissionModel.AddForUsers", "ADD_FOR_USERS_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	_, err := permissionModel.DB.ExecContext(ctx, ADD_FOR_USERS_QUERY, userID, pq.Array(codes))
+	_, err := q.ExecContext(ctx, ADD_FOR_USERS_QUERY, userID, pq.Array(codes))
 	return err
 }
@@ -0,0 +1,39 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// The table golang-migrate records the applied schema version in.
+const SCHEMA_VERSION_QUERY = `
+	SELECT version, dirty
+	FROM schema_migrations
+	LIMIT 1`
+
+// SchemaVersion returns the migration version of the database and whether the last
+// migration failed half-way (dirty). A database without migrations has version 0.
+func SchemaVersion(ctx context.Context, db *sql.DB) (int64, bool, error) {
+	ctx, span := startQuerySpan(ctx, "SchemaVersion", "SCHEMA_VERSION_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var version int64
+	var dirty bool
+
+	err := db.QueryRowContext(ctx, SCHEMA_VERSION_QUERY).Scan(&version, &dirty)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, false, nil
+		default:
+			return 0, false, err
+		}
+	}
+
+	return version, dirty, nil
+}
@@ -0,0 +1,83 @@
+package data
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A Suggestion is the short form of a movie which is returned for typeahead queries.
+type Suggestion struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+	Year  int32  `json:"year,omitempty"`
+}
+
+var (
+	// Define the SQL query for prefix matching movie titles. The results are ranked by
+	// how well the title matches the query.
+	SUGGEST_QUERY = `SELECT id, title, year
+	FROM movies WHERE to_tsvector('simple', title) @@ to_tsquery('simple', $1)
+	ORDER BY ts_rank(to_tsvector('simple', title), to_tsquery('simple', $1)) DESC, title ASC, id ASC
+	LIMIT $2
+	`
+)
+
+// The prefixTSQuery() function converts the raw query of a user to a tsquery where every
+// word is matched as a prefix, e.g. "black pan" becomes "black:* & pan:*". Any character
+// which is not a letter or digit is treated as a separator so the user can't inject
+// tsquery operators.
+func prefixTSQuery(query string) string {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	for i := range words {
+		words[i] = words[i] + ":*"
+	}
+
+	return strings.Join(words, " & ")
+}
+
+// Suggest() returns at most limit movies whose title words start with the words of
+// query.
+func (movieModel MovieModel) Suggest(ctx context.Context, query string, limit int) ([]*Suggestion, error) {
+	suggestions := []*Suggestion{}
+
+	// If there is no word to search for, there is nothing to suggest.
+	tsQuery := prefixTSQuery(query)
+	if tsQuery == "" {
+		return suggestions, nil
+	}
+
+	ctx, span := startQuerySpan(ctx, "MovieModel.Suggest", "SUGGEST_QUERY")
+	defer span.End()
+
+	// Create a context with 3-second timeout.
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := movieModel.DB.QueryContext(ctx, SUGGEST_QUERY, tsQuery, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var suggestion Suggestion
+
+		err := rows.Scan(&suggestion.ID, &suggestion.Title, &suggestion.Year)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
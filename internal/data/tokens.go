@@ -17,6 +17,9 @@ const (
 
 	DELETE_ALL_FOR_USER_QUERY = `DELETE FROM tokens WHERE scope = $1 AND user_id = $2
 	`
+
+	DELETE_EXPIRED_TOKENS_QUERY = `DELETE FROM tokens WHERE expiry < NOW()
+	`
 )
 
 // Define constants for the token scope. For now we just define the scope "activation"
@@ -63,17 +66,17 @@ func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error
 	return token, nil
 }
 
-func (tokenModel TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
+func (tokenModel TokenModel) New(ctx context.Context, userID int64, ttl time.Duration, scope string) (*Token, error) {
 	token, err := generateToken(userID, ttl, scope)
 	if err != nil {
 		return nil, err
 	}
 
-	err = tokenModel.Insert(token)
+	err = tokenModel.Insert(ctx, token)
 	return token, err
 }
 
-func (tokenModel TokenModel) Insert(token *Token) error {
+func (tokenModel TokenModel) Insert(ctx context.Context, token *Token) error {
 	args := []interface{}{
 		token.Hash,
 		token.UserID,
@@ -81,17 +84,39 @@ func (tokenModel TokenModel) Insert(token *Token) error {
 		token.Scope,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuerySpan(ctx, "TokenModel.Insert", "INSERT_TOKEN_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	_, err := tokenModel.DB.ExecContext(ctx, INSERT_TOKEN_QUERY, args...)
 	return err
 }
 
-func (tokenModel TokenModel) DeleteAllForUser(scope string, userID int64) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (tokenModel TokenModel) DeleteAllForUser(ctx context.Context, scope string, userID int64) error {
+	ctx, span := startQuerySpan(ctx, "TokenModel.DeleteAllForUser", "DELETE_ALL_FOR_USER_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	_, err := tokenModel.DB.ExecContext(ctx, DELETE_ALL_FOR_USER_QUERY, scope, userID)
 	return err
 }
+
+// Delete the tokens which have expired and return how many there were.
+func (tokenModel TokenModel) DeleteExpired(ctx context.Context) (int64, error) {
+	ctx, span := startQuerySpan(ctx, "TokenModel.DeleteExpired", "DELETE_EXPIRED_TOKENS_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := tokenModel.DB.ExecContext(ctx, DELETE_EXPIRED_TOKENS_QUERY)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,77 @@
+package data
+
+import (
+	"Meow/internal/tracing"
+	"context"
+	"database/sql"
+	"net"
+	"testing"
+)
+
+// Every query runs in a client span, a child of the span in the context, even when the
+// database can't be reached.
+func TestQuerySpans(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	tracing.SetExporter(exporter, nil)
+	t.Cleanup(func() { tracing.Shutdown(context.Background()) })
+
+	// Take a free port and close it, so connecting is refused.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	db, err := sql.Open("postgres", "postgres://meow@"+addr+"/meow?sslmode=disable&connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	models := NewModels(db)
+
+	ctx, parent := tracing.Start(context.Background(), "GET /v1/movies/:id", tracing.KindServer)
+
+	if _, err := models.Movies.Get(ctx, 1); err == nil {
+		t.Fatal("got no error without a database")
+	}
+
+	if _, err := models.Users.GetByEmail(ctx, "alice@example.com"); err == nil {
+		t.Fatal("got no error without a database")
+	}
+
+	parent.End()
+	tracing.ForceFlush()
+
+	tests := []struct {
+		name      string
+		statement string
+	}{
+		{"MovieModel.Get", "GET_QUERY"},
+		{"UserModel.GetByEmail", "GET_USER_BY_EMAIL_QUERY"},
+	}
+
+	spans := exporter.Spans()
+	if len(spans) != len(tests)+1 {
+		t.Fatalf("got %d spans, want %d", len(spans), len(tests)+1)
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			span := spans[i]
+
+			if span.Name != test.name || span.Kind != tracing.KindClient {
+				t.Errorf("got span %s of kind %d, want %s of kind %d", span.Name, span.Kind, test.name, tracing.KindClient)
+			}
+
+			if span.TraceID != parent.SpanContext().TraceID || span.ParentSpanID != parent.SpanContext().SpanID {
+				t.Errorf("got trace %s parent %s, want a child of %+v", span.TraceID, span.ParentSpanID, parent.SpanContext())
+			}
+
+			if span.Attributes["db.system"] != "postgresql" || span.Attributes["db.statement.name"] != test.statement {
+				t.Errorf("got attributes %v, want the statement %s", span.Attributes, test.statement)
+			}
+		})
+	}
+}
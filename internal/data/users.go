@@ -17,6 +17,15 @@ var (
 
 var AnonymousUser = &User{}
 
+// The email statuses of a user, from weakest to strongest. A bounce or complaint makes
+// the address undeliverable; after unsubscribing only transactional emails are sent.
+const (
+	EmailOK           = "ok"
+	EmailUnsubscribed = "unsubscribed"
+	EmailBounced      = "bounced"
+	EmailComplained   = "complained"
+)
+
 // Define a User struct to represent an individual user.
 type User struct {
 	ID        int64     `json:"id"`
@@ -25,7 +34,18 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
-	Version   int       `json:"-"`
+	Locale    string    `json:"locale"`
+
+	// One of EmailOK, EmailUnsubscribed, EmailBounced or EmailComplained.
+	EmailStatus string `json:"-"`
+
+	Version int `json:"-"`
+}
+
+// Undeliverable reports whether the email address of the user bounced or complained,
+// so no email should be sent to it.
+func (user *User) Undeliverable() bool {
+	return user.EmailStatus == EmailBounced || user.EmailStatus == EmailComplained
 }
 
 // Check a User anonymous or not
@@ -35,22 +55,35 @@ func (user *User) IsAnonymous() bool {
 
 // Define Queries
 var (
-	INSERT_USER_QUERY = `INSERT INTO users (name, email, password_hash, activated) 
-	VALUES ($1, $2, $3, $4)
-	RETURNING id, created_at, version
+	INSERT_USER_QUERY = `INSERT INTO users (name, email, password_hash, activated, locale)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at, email_status, version
 	`
 
-	GET_USER_BY_EMAIL_QUERY = `SELECT id, created_at, name, email, password_hash, activated, version
+	GET_USER_BY_EMAIL_QUERY = `SELECT id, created_at, name, email, password_hash, activated, locale, email_status, version
 	FROM users WHERE email = $1
 	`
 
 	UPDATE_USER_QUERY = `UPDATE users SET
-	name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-	WHERE id = $5 AND version = $6
+	name = $1, email = $2, password_hash = $3, activated = $4, locale = $5, version = version + 1
+	WHERE id = $6 AND version = $7
 	RETURNING version
 	`
 
-	GET_FOR_TOKEN_QUERY = `SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	DELETE_UNACTIVATED_USERS_QUERY = `DELETE FROM users WHERE activated = false AND created_at < $1
+	`
+
+	// Only a stronger status replaces the current one, so e.g. unsubscribing doesn't
+	// make a bounced address deliverable again.
+	SET_EMAIL_STATUS_QUERY = `UPDATE users SET email_status = $2, version = version + 1
+	WHERE email = $1
+	AND array_position(ARRAY['ok', 'unsubscribed', 'bounced', 'complained'], email_status) < array_position(ARRAY['ok', 'unsubscribed', 'bounced', 'complained'], $2::text)
+	`
+
+	GET_EMAIL_STATUS_QUERY = `SELECT email_status FROM users WHERE email = $1
+	`
+
+	GET_FOR_TOKEN_QUERY = `SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.locale, users.email_status, users.version
 	FROM users
 	INNER JOIN tokens
 	ON users.id = tokens.user_id
@@ -69,21 +102,67 @@ type UserModel struct {
 // version fields are all automatically generated by our database, so we use the
 // RETURNING clause to read them into the User struct after the insert, in the same way
 // that we did when creating a movie.
-func (userModel UserModel) Insert(user *User) error {
+func (userModel UserModel) Insert(ctx context.Context, user *User) error {
+	return insertUser(ctx, userModel.DB, user)
+}
+
+// Register inserts a new user with the permissions of codes, and enqueues the job
+// returned by newJob for them, e.g. their welcome email. Everything is written in one
+// transaction, so a user is never left without permissions or without the job.
+func (userModel UserModel) Register(ctx context.Context, user *User, codes []string, newJob func(*User) (*Job, error)) error {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := userModel.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = insertUser(ctx, tx, user)
+	if err != nil {
+		return err
+	}
+
+	err = addPermissions(ctx, tx, user.ID, codes...)
+	if err != nil {
+		return err
+	}
+
+	job, err := newJob(user)
+	if err != nil {
+		return err
+	}
+
+	err = enqueueJob(ctx, tx, job)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The insertUser() function runs INSERT_USER_QUERY for user on q, the pool or a
+// transaction.
+func insertUser(ctx context.Context, q queryer, user *User) error {
 	args := []interface{}{
 		user.Name,
 		user.Email,
 		user.Password.Hash,
 		user.Activated,
+		user.Locale,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuerySpan(ctx, "UserModel.Insert", "INSERT_USER_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	// If the table already contains a record with this email address, then when we try
 	// to perform the insert there will be a violation of the UNIQUE "users_email_key"
 	// constraint that we set up in the previous chapter.
-	err := userModel.DB.QueryRowContext(ctx, INSERT_USER_QUERY, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
+	err := q.QueryRowContext(ctx, INSERT_USER_QUERY, args...).Scan(&user.ID, &user.CreatedAt, &user.EmailStatus, &user.Version)
 	if err != nil {
 		switch {
 		case err.Error() == `pq: duplicate key value violates unique constraint "users_email_key"`:
@@ -99,10 +178,13 @@ func (userModel UserModel) Insert(user *User) error {
 // Retrieve the User details from the database based on the user's email address.
 // Because we have a UNIQUE constraint on the email column, this SQL query will only
 // return one record (or none at all, in which case we return a ErrRecordNotFound error).
-func (userModel UserModel) GetByEmail(email string) (*User, error) {
+func (userModel UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuerySpan(ctx, "UserModel.GetByEmail", "GET_USER_BY_EMAIL_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := userModel.DB.QueryRowContext(ctx, GET_USER_BY_EMAIL_QUERY, email).Scan(
@@ -112,6 +194,8 @@ func (userModel UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.Hash,
 		&user.Activated,
+		&user.Locale,
+		&user.EmailStatus,
 		&user.Version,
 	)
 
@@ -127,17 +211,21 @@ func (userModel UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-func (userModel UserModel) Update(user *User) error {
+func (userModel UserModel) Update(ctx context.Context, user *User) error {
 	args := []interface{}{
 		user.Name,
 		user.Email,
 		user.Password.Hash,
 		user.Activated,
+		user.Locale,
 		user.ID,
 		user.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuerySpan(ctx, "UserModel.Update", "UPDATE_USER_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := userModel.DB.QueryRowContext(ctx, UPDATE_USER_QUERY, args...).Scan(&user.Version)
@@ -193,7 +281,7 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 }
 
 // Get a user related for a token.
-func (userModel UserModel) GetForToken(scope string, plainToken string) (*User, error) {
+func (userModel UserModel) GetForToken(ctx context.Context, scope string, plainToken string) (*User, error) {
 	// Calculate the SHA-256 hash of the plaintext
 	tokenHash := sha256.Sum256([]byte(plainToken))
 
@@ -206,7 +294,10 @@ func (userModel UserModel) GetForToken(scope string, plainToken string) (*User,
 	// Create a user variable for storing data from data base on that.
 	var user User
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, span := startQuerySpan(ctx, "UserModel.GetForToken", "GET_FOR_TOKEN_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
 	err := userModel.DB.QueryRowContext(ctx, GET_FOR_TOKEN_QUERY, args...).Scan(
@@ -216,6 +307,8 @@ func (userModel UserModel) GetForToken(scope string, plainToken string) (*User,
 		&user.Email,
 		&user.Password.Hash,
 		&user.Activated,
+		&user.Locale,
+		&user.EmailStatus,
 		&user.Version,
 	)
 
@@ -231,3 +324,70 @@ func (userModel UserModel) GetForToken(scope string, plainToken string) (*User,
 	// Returning user.
 	return &user, nil
 }
+
+// Delete the users who registered before createdBefore and never activated their
+// account, and return how many there were. Their tokens and permissions are deleted
+// with them.
+func (userModel UserModel) DeleteUnactivated(ctx context.Context, createdBefore time.Time) (int64, error) {
+	ctx, span := startQuerySpan(ctx, "UserModel.DeleteUnactivated", "DELETE_UNACTIVATED_USERS_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := userModel.DB.ExecContext(ctx, DELETE_UNACTIVATED_USERS_QUERY, createdBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// Set the email status of the user with an email address, unless it already has the
+// same or a stronger one, and report whether it changed.
+func (userModel UserModel) SetEmailStatus(ctx context.Context, email string, status string) (bool, error) {
+	ctx, span := startQuerySpan(ctx, "UserModel.SetEmailStatus", "SET_EMAIL_STATUS_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := userModel.DB.ExecContext(ctx, SET_EMAIL_STATUS_QUERY, email, status)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+// Report whether emails to an address must be skipped: all of them if it bounced or
+// complained, and bulk ones if its user unsubscribed. Addresses of no user aren't
+// suppressed.
+func (userModel UserModel) Suppressed(ctx context.Context, email string, bulk bool) (bool, error) {
+	ctx, span := startQuerySpan(ctx, "UserModel.Suppressed", "GET_EMAIL_STATUS_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var status string
+
+	err := userModel.DB.QueryRowContext(ctx, GET_EMAIL_STATUS_QUERY, email).Scan(&status)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	user := User{EmailStatus: status}
+
+	return user.Undeliverable() || (bulk && status == EmailUnsubscribed), nil
+}
@@ -0,0 +1,354 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Define Queries for webhooks and webhook_deliveries tables.
+const (
+	INSERT_WEBHOOK_QUERY = `INSERT INTO webhooks (url, secret, events, active)
+	VALUES ($1, $2, $3, $4)
+	RETURNING id, created_at, version
+	`
+
+	GET_WEBHOOK_QUERY = `SELECT id, url, secret, events, active, created_at, version
+	FROM webhooks WHERE id = $1
+	`
+
+	GET_ALL_WEBHOOKS_QUERY = `SELECT id, url, secret, events, active, created_at, version
+	FROM webhooks ORDER BY id
+	`
+
+	UPDATE_WEBHOOK_QUERY = `UPDATE webhooks SET url = $1, secret = $2, events = $3, active = $4,
+	version = version + 1
+	WHERE id = $5 AND version = $6
+	RETURNING version
+	`
+
+	DELETE_WEBHOOK_QUERY = `DELETE FROM webhooks WHERE id = $1`
+
+	DELIVERY_COLUMNS = `id, webhook_id, event_id, event, attempt, status_code, error, response_body,
+	duration_ms, created_at`
+
+	INSERT_DELIVERY_QUERY = `INSERT INTO webhook_deliveries (webhook_id, event_id, event, attempt, status_code, error, response_body, duration_ms)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	RETURNING id, created_at
+	`
+
+	GET_DELIVERY_QUERY = `SELECT ` + DELIVERY_COLUMNS + `
+	FROM webhook_deliveries WHERE id = $1 AND webhook_id = $2
+	`
+
+	GET_DELIVERIES_QUERY = `SELECT count(*) OVER(), ` + DELIVERY_COLUMNS + `
+	FROM webhook_deliveries WHERE webhook_id = $1
+	ORDER BY %s %s, id DESC
+	LIMIT $2 OFFSET $3
+	`
+)
+
+// A Webhook is an HTTP endpoint which receives the events it subscribes to. Every
+// request is signed with Secret, which is only shown when the webhook is created.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	Version   int32     `json:"version"`
+}
+
+// A Delivery logs one attempt of sending an event to a webhook. StatusCode is zero if
+// no response was received, and Error says why the attempt failed.
+type Delivery struct {
+	ID           int64     `json:"id"`
+	WebhookID    int64     `json:"webhook_id"`
+	EventID      int64     `json:"event_id"`
+	Event        string    `json:"event"`
+	Attempt      int       `json:"attempt"`
+	StatusCode   int       `json:"status_code"`
+	Error        string    `json:"error,omitempty"`
+	ResponseBody string    `json:"response_body,omitempty"`
+	DurationMs   int64     `json:"duration_ms"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type WebhookModel struct {
+	DB *sql.DB
+}
+
+// The GenerateWebhookSecret() function returns a random secret for signing the
+// requests of a webhook.
+func GenerateWebhookSecret() (string, error) {
+	randomBytes := make([]byte, 32)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return "whsec_" + hex.EncodeToString(randomBytes), nil
+}
+
+// Return the destinations for scanning a webhook row into webhook.
+func (webhook *Webhook) scanDestinations() []interface{} {
+	return []interface{}{
+		&webhook.ID,
+		&webhook.URL,
+		&webhook.Secret,
+		pq.Array(&webhook.Events),
+		&webhook.Active,
+		&webhook.CreatedAt,
+		&webhook.Version,
+	}
+}
+
+// Return the destinations for scanning DELIVERY_COLUMNS into delivery.
+func (delivery *Delivery) scanDestinations() []interface{} {
+	return []interface{}{
+		&delivery.ID,
+		&delivery.WebhookID,
+		&delivery.EventID,
+		&delivery.Event,
+		&delivery.Attempt,
+		&delivery.StatusCode,
+		&delivery.Error,
+		&delivery.ResponseBody,
+		&delivery.DurationMs,
+		&delivery.CreatedAt,
+	}
+}
+
+// Insert a new webhook.
+func (webhookModel WebhookModel) Insert(ctx context.Context, webhook *Webhook) error {
+	args := []interface{}{
+		webhook.URL,
+		webhook.Secret,
+		pq.Array(webhook.Events),
+		webhook.Active,
+	}
+
+	ctx, span := startQuerySpan(ctx, "WebhookModel.Insert", "INSERT_WEBHOOK_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return webhookModel.DB.QueryRowContext(ctx, INSERT_WEBHOOK_QUERY, args...).Scan(&webhook.ID, &webhook.CreatedAt, &webhook.Version)
+}
+
+// Return the webhook with id.
+func (webhookModel WebhookModel) Get(ctx context.Context, id int64) (*Webhook, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, span := startQuerySpan(ctx, "WebhookModel.Get", "GET_WEBHOOK_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var webhook Webhook
+
+	err := webhookModel.DB.QueryRowContext(ctx, GET_WEBHOOK_QUERY, id).Scan(webhook.scanDestinations()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &webhook, nil
+}
+
+// Return every webhook.
+func (webhookModel WebhookModel) GetAll(ctx context.Context) ([]*Webhook, error) {
+	ctx, span := startQuerySpan(ctx, "WebhookModel.GetAll", "GET_ALL_WEBHOOKS_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := webhookModel.DB.QueryContext(ctx, GET_ALL_WEBHOOKS_QUERY)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		var webhook Webhook
+
+		err := rows.Scan(webhook.scanDestinations()...)
+		if err != nil {
+			return nil, err
+		}
+
+		webhooks = append(webhooks, &webhook)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// Update a webhook, returning ErrEditConflict if its version changed since it was
+// read.
+func (webhookModel WebhookModel) Update(ctx context.Context, webhook *Webhook) error {
+	args := []interface{}{
+		webhook.URL,
+		webhook.Secret,
+		pq.Array(webhook.Events),
+		webhook.Active,
+		webhook.ID,
+		webhook.Version,
+	}
+
+	ctx, span := startQuerySpan(ctx, "WebhookModel.Update", "UPDATE_WEBHOOK_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	err := webhookModel.DB.QueryRowContext(ctx, UPDATE_WEBHOOK_QUERY, args...).Scan(&webhook.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete a webhook along with its delivery logs. Its pending deliveries are dropped
+// when they run.
+func (webhookModel WebhookModel) Delete(ctx context.Context, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, span := startQuerySpan(ctx, "WebhookModel.Delete", "DELETE_WEBHOOK_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := webhookModel.DB.ExecContext(ctx, DELETE_WEBHOOK_QUERY, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// Log an attempt of delivering an event to a webhook.
+func (webhookModel WebhookModel) InsertDelivery(ctx context.Context, delivery *Delivery) error {
+	args := []interface{}{
+		delivery.WebhookID,
+		delivery.EventID,
+		delivery.Event,
+		delivery.Attempt,
+		delivery.StatusCode,
+		delivery.Error,
+		delivery.ResponseBody,
+		delivery.DurationMs,
+	}
+
+	ctx, span := startQuerySpan(ctx, "WebhookModel.InsertDelivery", "INSERT_DELIVERY_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return webhookModel.DB.QueryRowContext(ctx, INSERT_DELIVERY_QUERY, args...).Scan(&delivery.ID, &delivery.CreatedAt)
+}
+
+// Return the delivery with id of a webhook.
+func (webhookModel WebhookModel) GetDelivery(ctx context.Context, webhookID int64, id int64) (*Delivery, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	ctx, span := startQuerySpan(ctx, "WebhookModel.GetDelivery", "GET_DELIVERY_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var delivery Delivery
+
+	err := webhookModel.DB.QueryRowContext(ctx, GET_DELIVERY_QUERY, id, webhookID).Scan(delivery.scanDestinations()...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &delivery, nil
+}
+
+// Return a page of the delivery logs of a webhook.
+func (webhookModel WebhookModel) GetDeliveries(ctx context.Context, webhookID int64, filters Filters) ([]*Delivery, Metadata, error) {
+	query := fmt.Sprintf(GET_DELIVERIES_QUERY, filters.sortColumn(), filters.sortDirection())
+
+	ctx, span := startQuerySpan(ctx, "WebhookModel.GetDeliveries", "GET_DELIVERIES_QUERY")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := webhookModel.DB.QueryContext(ctx, query, webhookID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	deliveries := []*Delivery{}
+
+	for rows.Next() {
+		var delivery Delivery
+
+		err := rows.Scan(append([]interface{}{&totalRecords}, delivery.scanDestinations()...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return deliveries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
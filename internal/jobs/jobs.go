@@ -0,0 +1,373 @@
+// Package jobs runs the jobs stored in the jobs table. Workers claim due jobs with
+// SELECT ... FOR UPDATE SKIP LOCKED, so any number of servers can share the queues.
+// Failed jobs are retried with exponential backoff until they run out of attempts and
+// are kept as failed jobs (dead letters) for inspection.
+package jobs
+
+import (
+	"Meow/internal/data"
+	"Meow/internal/metrics"
+	"Meow/internal/tracing"
+	jlog "Meow/log"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Count the outcome of every attempt and time the handlers.
+var (
+	attempts  = metrics.NewCounterVec("jobs_attempts_total", "Total number of job attempts by outcome.", "queue", "kind", "outcome")
+	durations = metrics.NewHistogramVec("jobs_duration_seconds", "Duration of job attempts.",
+		[]float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300}, "queue", "kind")
+)
+
+// Store is the part of the jobs model used by the Runner.
+type Store interface {
+	Claim(context.Context, string, time.Duration) (*data.Job, error)
+	Complete(context.Context, *data.Job) error
+	Retry(context.Context, *data.Job, time.Time, string) error
+	Release(context.Context, *data.Job) error
+	Fail(context.Context, *data.Job, string) error
+}
+
+// A Kind names a type of job, with T the type of its payload. Its jobs run on Queue
+// and are attempted up to MaxAttempts times.
+type Kind[T any] struct {
+	Name        string
+	Queue       string
+	MaxAttempts int
+}
+
+// The New() method returns a job of kind with payload, ready to be enqueued. The job
+// continues the trace of ctx when it runs.
+func (kind Kind[T]) New(ctx context.Context, payload T) (*data.Job, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &data.Job{
+		Queue:       kind.Queue,
+		Kind:        kind.Name,
+		Payload:     encoded,
+		MaxAttempts: kind.MaxAttempts,
+	}
+
+	if span := tracing.SpanFromContext(ctx); span != nil {
+		job.TraceParent = tracing.FormatTraceparent(span.SpanContext())
+	}
+
+	return job, nil
+}
+
+// A permanentError is an error which retrying won't fix.
+type permanentError struct {
+	err error
+}
+
+func (err permanentError) Error() string {
+	return err.err.Error()
+}
+
+func (err permanentError) Unwrap() error {
+	return err.err
+}
+
+// The Permanent() function wraps err so the job fails straight away instead of being
+// retried.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// Options configures a Runner.
+type Options struct {
+	// The number of workers of each queue. Queues of registered kinds which aren't
+	// listed get one worker.
+	Queues map[string]int
+
+	// How long workers sleep when their queue has no due jobs.
+	PollInterval time.Duration
+
+	// How long an attempt may take. After that the job is cancelled, and other
+	// workers may claim it again if its worker died.
+	LockTimeout time.Duration
+
+	// The delay before the first retry, doubling with every attempt up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// A handler runs a job with its payload.
+type handler func(ctx context.Context, payload json.RawMessage) error
+
+// A Runner runs the jobs of every queue with a fixed number of workers per queue.
+type Runner struct {
+	store    Store
+	logger   *jlog.Logger
+	options  Options
+	handlers map[string]handler
+	queues   map[string]int
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// The NewRunner() function returns a Runner which takes jobs from store. Handlers
+// are added with Handle() before calling Run().
+func NewRunner(store Store, logger *jlog.Logger, options Options) *Runner {
+	queues := make(map[string]int)
+	for queue, workers := range options.Queues {
+		queues[queue] = workers
+	}
+
+	return &Runner{
+		store:    store,
+		logger:   logger,
+		options:  options,
+		handlers: make(map[string]handler),
+		queues:   queues,
+		stop:     make(chan struct{}),
+	}
+}
+
+// The Handle() function registers the handler for the jobs of kind. The payload of
+// every job is decoded into a T before calling handler, and a payload which can't be
+// decoded fails the job without retrying.
+func Handle[T any](runner *Runner, kind Kind[T], fn func(ctx context.Context, payload T) error) {
+	runner.handlers[kind.Name] = func(ctx context.Context, raw json.RawMessage) error {
+		var payload T
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			return Permanent(fmt.Errorf("decoding payload: %w", err))
+		}
+
+		return fn(ctx, payload)
+	}
+
+	if _, found := runner.queues[kind.Queue]; !found {
+		runner.queues[kind.Queue] = 1
+	}
+}
+
+// Run starts the workers and blocks until Stop() is called or ctx is done and every
+// worker returned. Jobs which are running when Stop() is called run to completion,
+// while cancelling ctx interrupts them and puts them back in their queues.
+func (runner *Runner) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for queue, workers := range runner.queues {
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(queue string) {
+				defer wg.Done()
+				runner.work(ctx, queue)
+			}(queue)
+		}
+	}
+
+	runner.logger.Info("job runner started", jlog.Fields{"queues": FormatQueues(runner.queues)})
+
+	wg.Wait()
+}
+
+// Queues returns the number of workers of every queue.
+func (runner *Runner) Queues() map[string]int {
+	queues := make(map[string]int, len(runner.queues))
+	for queue, workers := range runner.queues {
+		queues[queue] = workers
+	}
+
+	return queues
+}
+
+// Stop makes the workers return after their current job.
+func (runner *Runner) Stop() {
+	runner.stopOnce.Do(func() {
+		close(runner.stop)
+	})
+}
+
+// Claim and run the jobs of queue until the runner stops.
+func (runner *Runner) work(ctx context.Context, queue string) {
+	for {
+		select {
+		case <-runner.stop:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		// Polling for jobs isn't traced, only the jobs are.
+		job, err := runner.store.Claim(tracing.Unsampled(ctx), queue, runner.options.LockTimeout)
+		switch {
+		case err == nil:
+			runner.run(ctx, job)
+			continue
+		case errors.Is(err, data.ErrRecordNotFound):
+		case ctx.Err() != nil:
+			return
+		default:
+			runner.logger.Error(err, jlog.Fields{"event": "claiming job failed", "queue": queue})
+		}
+
+		select {
+		case <-runner.stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-time.After(runner.options.PollInterval):
+		}
+	}
+}
+
+// Run one attempt of job and record its outcome.
+func (runner *Runner) run(ctx context.Context, job *data.Job) {
+	fields := jlog.Fields{
+		"job_id":   job.ID,
+		"queue":    job.Queue,
+		"kind":     job.Kind,
+		"attempt":  job.Attempts,
+		"attempts": job.MaxAttempts,
+	}
+
+	if parent, ok := tracing.ParseTraceparent(job.TraceParent); ok {
+		ctx = tracing.ContextWithRemoteParent(ctx, parent)
+	}
+
+	ctx, span := tracing.Start(ctx, "job "+job.Kind, tracing.KindConsumer)
+	defer span.End()
+
+	span.SetAttribute("job.id", job.ID)
+	span.SetAttribute("job.queue", job.Queue)
+	span.SetAttribute("job.attempt", job.Attempts)
+
+	jobCtx, cancel := context.WithTimeout(context.WithValue(ctx, jobContextKey{}, job), runner.options.LockTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := runner.call(jobCtx, job)
+	durations.Observe(time.Since(start).Seconds(), job.Queue, job.Kind)
+
+	// Record the outcome even when ctx was cancelled, so the job isn't left running.
+	recordCtx := context.WithoutCancel(ctx)
+
+	var outcome string
+	switch {
+	case err == nil:
+		outcome = "completed"
+		err = runner.store.Complete(recordCtx, job)
+		runner.logger.Debug("job completed", fields)
+
+	case ctx.Err() != nil:
+		outcome = "interrupted"
+		err = runner.store.Release(recordCtx, job)
+		runner.logger.Warn("job interrupted", fields)
+
+	case errors.As(err, new(permanentError)) || job.Attempts >= job.MaxAttempts:
+		outcome = "failed"
+		span.RecordError(err)
+		fields["event"] = "job failed"
+		runner.logger.Error(err, fields)
+		err = runner.store.Fail(recordCtx, job, err.Error())
+
+	default:
+		outcome = "retried"
+		span.RecordError(err)
+		runAt := time.Now().Add(runner.backoff(job.Attempts))
+		fields["error"] = err.Error()
+		fields["retry_at"] = runAt.Format(time.RFC3339)
+		runner.logger.Warn("job will be retried", fields)
+		err = runner.store.Retry(recordCtx, job, runAt, err.Error())
+	}
+
+	attempts.Inc(job.Queue, job.Kind, outcome)
+
+	if err != nil {
+		delete(fields, "error")
+		fields["event"] = "recording job " + outcome + " failed"
+		runner.logger.Error(err, fields)
+	}
+}
+
+// The key of the running job in the context of its handler.
+type jobContextKey struct{}
+
+// The Attempt() function returns the number of the current attempt of the job whose
+// handler was called with ctx, starting at 1. It returns 0 outside of job handlers.
+func Attempt(ctx context.Context) int {
+	job, ok := ctx.Value(jobContextKey{}).(*data.Job)
+	if !ok {
+		return 0
+	}
+
+	return job.Attempts
+}
+
+// Call the handler of job, turning a panic into an error.
+func (runner *Runner) call(ctx context.Context, job *data.Job) (err error) {
+	handler, found := runner.handlers[job.Kind]
+	if !found {
+		return Permanent(fmt.Errorf("no handler for jobs of kind %q", job.Kind))
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+
+	return handler(ctx, job.Payload)
+}
+
+// Return the delay before the retry after attempt: MinBackoff doubled for every
+// attempt up to MaxBackoff, with random jitter of up to a quarter either way so
+// failing jobs don't retry in lockstep.
+func (runner *Runner) backoff(attempt int) time.Duration {
+	delay := runner.options.MaxBackoff
+	if attempt < 32 {
+		delay = min(runner.options.MinBackoff<<(attempt-1), runner.options.MaxBackoff)
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(delay)/2+1)) - delay/4
+	return delay + jitter
+}
+
+// The ParseQueues() function parses worker counts like "default=2" into a map of
+// queue names to workers.
+func ParseQueues(values []string) (map[string]int, error) {
+	queues := make(map[string]int)
+
+	for _, value := range values {
+		name, count, found := strings.Cut(value, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("%q is not like queue=workers", value)
+		}
+
+		workers, err := strconv.Atoi(count)
+		if err != nil || workers < 1 {
+			return nil, fmt.Errorf("the workers of queue %q must be a positive number", name)
+		}
+
+		queues[name] = workers
+	}
+
+	return queues, nil
+}
+
+// The FormatQueues() function formats worker counts like ParseQueues() parses them.
+func FormatQueues(queues map[string]int) string {
+	values := make([]string, 0, len(queues))
+	for queue, workers := range queues {
+		values = append(values, fmt.Sprintf("%s=%d", queue, workers))
+	}
+
+	sort.Strings(values)
+	return strings.Join(values, " ")
+}
@@ -0,0 +1,207 @@
+package jobs
+
+import (
+	"Meow/internal/data"
+	jlog "Meow/log"
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testJob = Kind[string]{Name: "test", Queue: "default", MaxAttempts: 3}
+
+// A Store recording how the runner finished the last attempt.
+type recordingStore struct {
+	outcome   string
+	runAt     time.Time
+	lastError string
+}
+
+func (store *recordingStore) Claim(context.Context, string, time.Duration) (*data.Job, error) {
+	return nil, data.ErrRecordNotFound
+}
+
+func (store *recordingStore) Complete(ctx context.Context, job *data.Job) error {
+	store.outcome = "completed"
+	return nil
+}
+
+func (store *recordingStore) Retry(ctx context.Context, job *data.Job, runAt time.Time, lastError string) error {
+	store.outcome, store.runAt, store.lastError = "retried", runAt, lastError
+	return nil
+}
+
+func (store *recordingStore) Release(ctx context.Context, job *data.Job) error {
+	store.outcome = "released"
+	return nil
+}
+
+func (store *recordingStore) Fail(ctx context.Context, job *data.Job, lastError string) error {
+	store.outcome, store.lastError = "failed", lastError
+	return nil
+}
+
+func newRunner(store Store, options Options) *Runner {
+	return NewRunner(store, jlog.New(io.Discard, jlog.LevelOff), options)
+}
+
+func TestBackoff(t *testing.T) {
+	runner := newRunner(&recordingStore{}, Options{MinBackoff: time.Second, MaxBackoff: time.Minute})
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{6, 32 * time.Second},
+		{7, time.Minute},
+		{40, time.Minute},
+	}
+
+	for _, test := range tests {
+		// The jitter is up to a quarter of the delay either way.
+		low, high := test.want*3/4, test.want*5/4
+
+		for i := 0; i < 100; i++ {
+			if got := runner.backoff(test.attempt); got < low || got > high {
+				t.Fatalf("got %s after attempt %d, want %s to %s", got, test.attempt, low, high)
+			}
+		}
+	}
+}
+
+func TestRunOutcome(t *testing.T) {
+	failure := errors.New("connection refused")
+
+	tests := []struct {
+		name      string
+		attempt   int
+		err       error
+		panics    bool
+		outcome   string
+		lastError string
+	}{
+		{"success", 1, nil, false, "completed", ""},
+		{"failure", 1, failure, false, "retried", "connection refused"},
+		{"failure on the last attempt", 3, failure, false, "failed", "connection refused"},
+		{"permanent failure", 1, Permanent(failure), false, "failed", "connection refused"},
+		{"panic", 2, nil, true, "retried", "panic: nil map"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &recordingStore{}
+			runner := newRunner(store, Options{LockTimeout: time.Minute, MinBackoff: time.Minute, MaxBackoff: time.Hour})
+
+			Handle(runner, testJob, func(ctx context.Context, payload string) error {
+				if Attempt(ctx) != test.attempt {
+					t.Errorf("got attempt %d, want %d", Attempt(ctx), test.attempt)
+				}
+
+				if test.panics {
+					panic("nil map")
+				}
+
+				return test.err
+			})
+
+			job, err := testJob.New(context.Background(), "payload")
+			if err != nil {
+				t.Fatal(err)
+			}
+			job.Attempts = test.attempt
+
+			start := time.Now()
+			runner.run(context.Background(), job)
+
+			if store.outcome != test.outcome || store.lastError != test.lastError {
+				t.Fatalf("got %s with %q, want %s with %q", store.outcome, store.lastError, test.outcome, test.lastError)
+			}
+
+			// Retries wait for the backoff of the attempt.
+			if store.outcome == "retried" {
+				delay := time.Minute << (test.attempt - 1)
+				if earliest := start.Add(delay * 3 / 4); store.runAt.Before(earliest) {
+					t.Errorf("got retry at %s, want after %s", store.runAt, earliest)
+				}
+			}
+		})
+	}
+}
+
+// A payload which can't be decoded fails the job straight away.
+func TestRunUndecodablePayload(t *testing.T) {
+	store := &recordingStore{}
+	runner := newRunner(store, Options{LockTimeout: time.Minute, MinBackoff: time.Minute, MaxBackoff: time.Hour})
+
+	Handle(runner, testJob, func(ctx context.Context, payload string) error {
+		t.Error("the handler was called")
+		return nil
+	})
+
+	runner.run(context.Background(), &data.Job{Kind: testJob.Name, Queue: testJob.Queue, Payload: []byte(`17`), Attempts: 1, MaxAttempts: 3})
+
+	if store.outcome != "failed" {
+		t.Fatalf("got %s, want failed", store.outcome)
+	}
+}
+
+// A job which keeps failing is attempted MaxAttempts times and then kept as failed.
+func TestRunRetryExhaustion(t *testing.T) {
+	ctx := context.Background()
+	models := data.NewMemoryModels()
+
+	runner := newRunner(models.Jobs, Options{
+		PollInterval: time.Millisecond,
+		LockTimeout:  time.Minute,
+		MinBackoff:   time.Millisecond,
+		MaxBackoff:   2 * time.Millisecond,
+	})
+
+	var calls atomic.Int32
+	Handle(runner, testJob, func(ctx context.Context, payload string) error {
+		calls.Add(1)
+		return errors.New("connection refused")
+	})
+
+	job, err := testJob.New(ctx, "payload")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := models.Jobs.Enqueue(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		runner.Run(ctx)
+		close(done)
+	}()
+
+	filters := data.Filters{Page: 1, PageSize: 10, Sort: "id", SortSafeList: []string{"id"}}
+
+	var failed []*data.Job
+	for deadline := time.Now().Add(5 * time.Second); len(failed) == 0 && time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		failed, _, err = models.Jobs.GetFailed(ctx, "", filters)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runner.Stop()
+	<-done
+
+	if len(failed) != 1 || failed[0].Attempts != testJob.MaxAttempts || failed[0].LastError != "connection refused" {
+		t.Fatalf("got failed jobs %+v, want the job after %d attempts", failed, testJob.MaxAttempts)
+	}
+
+	if got := calls.Load(); got != int32(testJob.MaxAttempts) {
+		t.Errorf("got %d calls, want %d", got, testJob.MaxAttempts)
+	}
+}
@@ -0,0 +1,353 @@
+// Package metrics implements counters, gauges and histograms which are exposed in the
+// Prometheus text exposition format. Like expvar, every metric lives in a package
+// level registry, so any package can publish metrics without passing it around.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the upper bounds in seconds used for latency histograms.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// A collector is anything which can write its samples in the exposition format.
+type collector interface {
+	name() string
+	write(w *bufio.Writer)
+}
+
+var (
+	mu         sync.Mutex
+	collectors = make(map[string]collector)
+)
+
+// Register collector c, or return the collector already registered with the same
+// name. This makes it safe to create the same metric more than once, e.g. when
+// Routes() is called again.
+func register(c collector) collector {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if existing, found := collectors[c.name()]; found {
+		return existing
+	}
+
+	collectors[c.name()] = c
+	return c
+}
+
+// WriteTo writes every registered metric to w in the Prometheus text format.
+func WriteTo(w io.Writer) error {
+	mu.Lock()
+	names := make([]string, 0, len(collectors))
+	for name := range collectors {
+		names = append(names, name)
+	}
+
+	sorted := make([]collector, len(names))
+	sort.Strings(names)
+	for i, name := range names {
+		sorted[i] = collectors[name]
+	}
+	mu.Unlock()
+
+	buffered := bufio.NewWriter(w)
+	for _, c := range sorted {
+		c.write(buffered)
+	}
+
+	return buffered.Flush()
+}
+
+// Handler returns a http.Handler which serves the registered metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteTo(writer)
+	})
+}
+
+// Write the HELP and TYPE lines of a metric.
+func writeHeader(w *bufio.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// Format label names and values as {name="value",...}. extra is appended as is.
+func formatLabels(names []string, values []string, extra string) string {
+	if len(names) == 0 && extra == "" {
+		return ""
+	}
+
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+	parts := make([]string, 0, len(names)+1)
+	for i := range names {
+		parts = append(parts, names[i]+`="`+escaper.Replace(values[i])+`"`)
+	}
+
+	if extra != "" {
+		parts = append(parts, extra)
+	}
+
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Format a sample value.
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+// Join label values into a map key. Callers must only pass label values from a small,
+// known set, like route patterns, never raw client input: every value makes a new
+// series, and a value containing the unit separator could collide with another key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\x1f")
+}
+
+// A CounterVec is a set of counters with the same name partitioned by labels.
+type CounterVec struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+	series map[string][]string
+}
+
+// NewCounterVec registers and returns a counter partitioned by the given labels.
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return register(&CounterVec{
+		metricName: name,
+		help:       help,
+		kind:       "counter",
+		labels:     labels,
+		values:     make(map[string]float64),
+		series:     make(map[string][]string),
+	}).(*CounterVec)
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta to the counter with the given label values. The number of values
+// must match the number of labels.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	if len(labelValues) != len(c.labels) {
+		panic("metrics: wrong number of label values for " + c.metricName)
+	}
+
+	key := labelKey(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, found := c.series[key]; !found {
+		c.series[key] = append([]string(nil), labelValues...)
+	}
+	c.values[key] += delta
+}
+
+// Set the value with the given label values, for gauges.
+func (c *CounterVec) set(value float64, labelValues ...string) {
+	if len(labelValues) != len(c.labels) {
+		panic("metrics: wrong number of label values for " + c.metricName)
+	}
+
+	key := labelKey(labelValues)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, found := c.series[key]; !found {
+		c.series[key] = append([]string(nil), labelValues...)
+	}
+	c.values[key] = value
+}
+
+func (c *CounterVec) name() string {
+	return c.metricName
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeHeader(w, c.metricName, c.help, c.kind)
+
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, formatLabels(c.labels, c.series[key], ""), formatValue(c.values[key]))
+	}
+}
+
+// A GaugeVec is a set of gauges with the same name partitioned by labels. It works
+// like a CounterVec whose values can also be set.
+type GaugeVec struct {
+	CounterVec
+}
+
+// NewGaugeVec registers and returns a gauge partitioned by the given labels.
+func NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	return register(&GaugeVec{CounterVec{
+		metricName: name,
+		help:       help,
+		kind:       "gauge",
+		labels:     labels,
+		values:     make(map[string]float64),
+		series:     make(map[string][]string),
+	}}).(*GaugeVec)
+}
+
+// Set sets the gauge with the given label values to value.
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.set(value, labelValues...)
+}
+
+// A HistogramVec is a set of histograms with the same name and buckets partitioned by
+// labels.
+type HistogramVec struct {
+	metricName string
+	help       string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+// A histogram holds the observations of a single label combination.
+type histogram struct {
+	labelValues []string
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+// NewHistogramVec registers and returns a histogram with the given bucket upper bounds
+// partitioned by the given labels.
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+
+	return register(&HistogramVec{
+		metricName: name,
+		help:       help,
+		labels:     labels,
+		buckets:    sorted,
+		series:     make(map[string]*histogram),
+	}).(*HistogramVec)
+}
+
+// Observe records value in the histogram with the given label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic("metrics: wrong number of label values for " + h.metricName)
+	}
+
+	key := labelKey(labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	series, found := h.series[key]
+	if !found {
+		series = &histogram{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = series
+	}
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			series.counts[i]++
+		}
+	}
+
+	series.sum += value
+	series.count++
+}
+
+func (h *HistogramVec) name() string {
+	return h.metricName
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	writeHeader(w, h.metricName, h.help, "histogram")
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series := h.series[key]
+
+		for i, bound := range h.buckets {
+			le := `le="` + formatValue(bound) + `"`
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, formatLabels(h.labels, series.labelValues, le), series.counts[i])
+		}
+
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, formatLabels(h.labels, series.labelValues, `le="+Inf"`), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, formatLabels(h.labels, series.labelValues, ""), formatValue(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, formatLabels(h.labels, series.labelValues, ""), series.count)
+	}
+}
+
+// A funcMetric reads its value from a function each time metrics are written. It is
+// used for values which are kept elsewhere, like the stats of the database pool.
+type funcMetric struct {
+	metricName string
+	help       string
+	kind       string
+	fn         func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is returned by fn.
+func NewGaugeFunc(name string, help string, fn func() float64) {
+	register(&funcMetric{metricName: name, help: help, kind: "gauge", fn: fn})
+}
+
+// NewCounterFunc registers a counter whose value is returned by fn. fn must never
+// return a smaller value than before.
+func NewCounterFunc(name string, help string, fn func() float64) {
+	register(&funcMetric{metricName: name, help: help, kind: "counter", fn: fn})
+}
+
+func (f *funcMetric) name() string {
+	return f.metricName
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	writeHeader(w, f.metricName, f.help, f.kind)
+	fmt.Fprintf(w, "%s %s\n", f.metricName, formatValue(f.fn()))
+}
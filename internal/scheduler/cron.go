@@ -0,0 +1,208 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Schedule returns the next time a task runs after a given time.
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// An interval runs a task every fixed duration.
+type interval time.Duration
+
+func (every interval) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(every)).Truncate(time.Second)
+}
+
+// A cronSchedule runs a task on the minutes matching every field, like crontab(5).
+// Each field is a bit set of the allowed values.
+type cronSchedule struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+
+	// Like cron, if both day fields are restricted a day matching either runs.
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+// The bounds of a cron field.
+type field struct {
+	name string
+	min  int
+	max  int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+// Shorthands for common schedules.
+var shorthands = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// The Parse() function parses a schedule in crontab format ("*/15 * * * *"), one of
+// @hourly, @daily, @weekly or @monthly, or a fixed interval like "@every 10m".
+// Schedules are in the local time zone.
+func Parse(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if duration, found := strings.CutPrefix(spec, "@every "); found {
+		every, err := time.ParseDuration(strings.TrimSpace(duration))
+		if err != nil || every < time.Second {
+			return nil, fmt.Errorf("%q must be a duration of at least 1s", duration)
+		}
+
+		return interval(every), nil
+	}
+
+	if expanded, found := shorthands[spec]; found {
+		spec = expanded
+	}
+
+	parts := strings.Fields(spec)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("%q must have 5 fields: minute, hour, day of month, month and day of week", spec)
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, part := range parts {
+		set, err := parseField(part, fields[i])
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+
+	return &cronSchedule{
+		minute:        sets[0],
+		hour:          sets[1],
+		dayOfMonth:    sets[2],
+		month:         sets[3],
+		dayOfWeek:     sets[4],
+		anyDayOfMonth: parts[2] == "*",
+		anyDayOfWeek:  parts[4] == "*",
+	}, nil
+}
+
+// Parse a comma separated list of values, ranges ("1-5"), "*" and steps ("*/15" or
+// "0-30/10") into a bit set.
+func parseField(value string, bounds field) (uint64, error) {
+	var set uint64
+
+	for _, item := range strings.Split(value, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(item, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepPart, bounds.name)
+			}
+		}
+
+		low, high := bounds.min, bounds.max
+		if rangePart != "*" {
+			first, last, isRange := strings.Cut(rangePart, "-")
+
+			var err error
+			low, err = strconv.Atoi(first)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q in %s field", first, bounds.name)
+			}
+
+			high = low
+			if isRange {
+				high, err = strconv.Atoi(last)
+				if err != nil {
+					return 0, fmt.Errorf("invalid value %q in %s field", last, bounds.name)
+				}
+			} else if hasStep {
+				high = bounds.max
+			}
+		}
+
+		if low < bounds.min || high > bounds.max || low > high {
+			return 0, fmt.Errorf("%q is out of range %d-%d in %s field", item, bounds.min, bounds.max, bounds.name)
+		}
+
+		for i := low; i <= high; i += step {
+			set |= 1 << uint(i)
+		}
+	}
+
+	return set, nil
+}
+
+// Report whether bit i of set is set.
+func has(set uint64, i int) bool {
+	return set&(1<<uint(i)) != 0
+}
+
+// Report whether the schedule runs on the day of t.
+func (schedule *cronSchedule) matchesDay(t time.Time) bool {
+	dayOfMonth := has(schedule.dayOfMonth, t.Day())
+	dayOfWeek := has(schedule.dayOfWeek, int(t.Weekday()))
+
+	switch {
+	case schedule.anyDayOfMonth && schedule.anyDayOfWeek:
+		return true
+	case schedule.anyDayOfMonth:
+		return dayOfWeek
+	case schedule.anyDayOfWeek:
+		return dayOfMonth
+	default:
+		return dayOfMonth || dayOfWeek
+	}
+}
+
+// Next returns the first matching minute after after, skipping whole months, days
+// and hours which don't match. It returns the zero time if nothing matches within
+// five years, e.g. for "0 0 30 2 *".
+func (schedule *cronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if !has(schedule.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !schedule.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !has(schedule.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if !has(schedule.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
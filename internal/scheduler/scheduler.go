@@ -0,0 +1,248 @@
+// Package scheduler runs periodic maintenance tasks on a cron-like schedule. Every
+// replica of the server runs a Scheduler, but only the one holding the leader lock
+// runs the tasks; the others wait to take over if the leader goes away.
+package scheduler
+
+import (
+	"Meow/internal/metrics"
+	"Meow/internal/tracing"
+	jlog "Meow/log"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Publish the outcome and duration of every run, and which replica leads.
+var (
+	runs = metrics.NewCounterVec("scheduler_runs_total", "Total number of scheduled task runs by outcome.", "task", "outcome")
+
+	durations = metrics.NewHistogramVec("scheduler_run_duration_seconds", "Duration of scheduled task runs.",
+		[]float64{0.01, 0.1, 0.5, 1, 5, 10, 30, 60, 300}, "task")
+
+	lastSuccess = metrics.NewGaugeVec("scheduler_last_success_timestamp_seconds", "Unix time of the last successful run of each scheduled task.", "task")
+
+	leading atomic.Bool
+)
+
+func init() {
+	metrics.NewGaugeFunc("scheduler_leader", "Whether this replica runs the scheduled tasks (1) or not (0).", func() float64 {
+		if leading.Load() {
+			return 1
+		}
+		return 0
+	})
+}
+
+// A Lock elects the leader among the replicas. It is held until it is released or
+// the connection holding it is lost.
+type Lock interface {
+	// TryAcquire takes the lock if no one holds it, and reports whether it did.
+	TryAcquire(ctx context.Context) (bool, error)
+
+	// Check returns an error if the lock may have been lost.
+	Check(ctx context.Context) error
+
+	// Release gives the lock up.
+	Release(ctx context.Context) error
+}
+
+// A Task is a job run on a Schedule. Run returns fields describing what it did, like
+// the number of deleted rows, which are logged with the outcome.
+type Task struct {
+	Name     string
+	Schedule Schedule
+	Timeout  time.Duration
+	Run      func(ctx context.Context) (jlog.Fields, error)
+}
+
+// Options configures a Scheduler.
+type Options struct {
+	// How often a follower tries to become the leader, and the leader checks it still
+	// holds the lock.
+	LockInterval time.Duration
+}
+
+// A Scheduler runs its tasks while it holds the leader lock. Tasks run one at a time,
+// so a slow task delays the others rather than overlapping with itself.
+type Scheduler struct {
+	lock    Lock
+	logger  *jlog.Logger
+	options Options
+	tasks   []Task
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// The New() function returns a Scheduler for tasks which elects its leader with lock.
+func New(lock Lock, logger *jlog.Logger, options Options, tasks ...Task) *Scheduler {
+	return &Scheduler{
+		lock:    lock,
+		logger:  logger,
+		options: options,
+		tasks:   tasks,
+		stop:    make(chan struct{}),
+	}
+}
+
+// Run campaigns for the leader lock and runs the tasks while holding it, until Stop()
+// is called or ctx is done.
+func (scheduler *Scheduler) Run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-scheduler.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+		// Campaigning and checking the lock aren't traced, only the tasks are.
+		acquired, err := scheduler.lock.TryAcquire(tracing.Unsampled(ctx))
+		switch {
+		case err != nil && ctx.Err() == nil:
+			scheduler.logger.Error(err, jlog.Fields{"event": "acquiring scheduler lock failed"})
+		case acquired:
+			scheduler.lead(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(scheduler.options.LockInterval):
+		}
+	}
+}
+
+// Stop makes Run() return. A running task is cancelled.
+func (scheduler *Scheduler) Stop() {
+	scheduler.stopOnce.Do(func() {
+		close(scheduler.stop)
+	})
+}
+
+// Run the tasks when they are due until ctx is done or the lock is lost, then
+// release the lock.
+func (scheduler *Scheduler) lead(ctx context.Context) {
+	leading.Store(true)
+	scheduler.logger.Info("became scheduler leader", nil)
+
+	defer func() {
+		leading.Store(false)
+
+		if err := scheduler.lock.Release(context.WithoutCancel(ctx)); err != nil {
+			scheduler.logger.Error(err, jlog.Fields{"event": "releasing scheduler lock failed"})
+		}
+
+		scheduler.logger.Info("stopped leading scheduler", nil)
+	}()
+
+	next := make([]time.Time, len(scheduler.tasks))
+	for i, task := range scheduler.tasks {
+		next[i] = task.Schedule.Next(time.Now())
+	}
+
+	check := time.NewTicker(scheduler.options.LockInterval)
+	defer check.Stop()
+
+	for {
+		// Wait for the earliest task. Tasks whose schedule never matches again have a
+		// zero time and are skipped.
+		wait := time.Duration(-1)
+		for _, at := range next {
+			if !at.IsZero() && (wait < 0 || time.Until(at) < wait) {
+				wait = max(time.Until(at), 0)
+			}
+		}
+
+		var timer *time.Timer
+		var due <-chan time.Time
+		if wait >= 0 {
+			timer = time.NewTimer(wait)
+			due = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-check.C:
+			if err := scheduler.lock.Check(tracing.Unsampled(ctx)); err != nil {
+				if ctx.Err() == nil {
+					scheduler.logger.Error(err, jlog.Fields{"event": "scheduler lock lost"})
+				}
+				return
+			}
+
+		case <-due:
+			timer = nil
+			for i, task := range scheduler.tasks {
+				if next[i].IsZero() || time.Now().Before(next[i]) {
+					continue
+				}
+
+				scheduler.run(ctx, task)
+				next[i] = task.Schedule.Next(time.Now())
+
+				if ctx.Err() != nil {
+					return
+				}
+			}
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Run task once, then log and count its outcome.
+func (scheduler *Scheduler) run(ctx context.Context, task Task) {
+	ctx, span := tracing.Start(ctx, "scheduled "+task.Name, tracing.KindInternal)
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, task.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	fields, err := scheduler.call(ctx, task)
+	duration := time.Since(start)
+
+	durations.Observe(duration.Seconds(), task.Name)
+
+	if fields == nil {
+		fields = jlog.Fields{}
+	}
+	fields["task"] = task.Name
+	fields["duration"] = duration
+
+	if err != nil {
+		span.RecordError(err)
+		runs.Inc(task.Name, "failed")
+
+		fields["event"] = "scheduled task failed"
+		scheduler.logger.Error(err, fields)
+		return
+	}
+
+	runs.Inc(task.Name, "succeeded")
+	lastSuccess.Set(float64(time.Now().Unix()), task.Name)
+
+	scheduler.logger.Info("scheduled task completed", fields)
+}
+
+// Call the Run function of task, turning a panic into an error.
+func (scheduler *Scheduler) call(ctx context.Context, task Task) (fields jlog.Fields, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+
+	return task.Run(ctx)
+}
@@ -0,0 +1,120 @@
+package smtptest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+)
+
+// A Message is an email received by a Server. The plain text and HTML alternatives
+// are decoded from their transfer encoding; a part the message hasn't is empty.
+type Message struct {
+	// The envelope sender and recipients, as given to MAIL and RCPT.
+	From string
+	To   []string
+
+	Header    mail.Header
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+
+	// The message as received, with CRLF line endings.
+	Raw []byte
+
+	// Set when the message could not be parsed; the fields above but Raw may then
+	// be empty.
+	ParseError error
+}
+
+// SentTo reports whether recipient is one of the envelope recipients of the message.
+func (message *Message) SentTo(recipient string) bool {
+	for _, to := range message.To {
+		if strings.EqualFold(to, recipient) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parse a raw message. The parts of a multipart message are searched for the first
+// text/plain and text/html alternatives, nested multiparts included.
+func parseMessage(raw []byte) *Message {
+	message := &Message{Raw: raw}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		message.ParseError = err
+		return message
+	}
+
+	message.Header = parsed.Header
+
+	decoder := new(mime.WordDecoder)
+	message.Subject, err = decoder.DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		message.Subject = parsed.Header.Get("Subject")
+	}
+
+	message.ParseError = message.readPart(parsed.Header.Get("Content-Type"), parsed.Header.Get("Content-Transfer-Encoding"), parsed.Body)
+	return message
+}
+
+// Read one part of a message, recursing into multipart ones.
+func (message *Message) readPart(contentType string, encoding string, body io.Reader) error {
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := reader.NextRawPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			err = message.readPart(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	// Keep the line endings the templates were written with.
+	text := strings.ReplaceAll(string(content), "\r\n", "\n")
+
+	switch {
+	case mediaType == "text/plain" && message.PlainBody == "":
+		message.PlainBody = text
+	case mediaType == "text/html" && message.HTMLBody == "":
+		message.HTMLBody = text
+	}
+
+	return nil
+}
@@ -0,0 +1,292 @@
+// Package smtptest provides an in-process SMTP server for tests, in the spirit of
+// net/http/httptest. It listens on a random local port, accepts every message
+// without authentication or TLS, and keeps them with their MIME parts decoded, so a
+// test can register a user through the API, read the activation token from the
+// welcome email and go on to activate the account and log in:
+//
+//	server := smtptest.NewServer()
+//	defer server.Close()
+//
+//	// Send the emails of the application to server.Transport() ...
+//
+//	message, err := server.WaitFor("alice@example.com", 5*time.Second)
+//	token, err := smtptest.ActivationToken(message)
+package smtptest
+
+import (
+	"Meow/mailer"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The maximum size of a message, like the limit of most providers.
+const maxMessageSize = 10 << 20
+
+// ErrTimeout is returned by WaitFor() when no message arrived in time.
+var ErrTimeout = errors.New("smtptest: no message arrived in time")
+
+// A Server is a fake SMTP server capturing the messages sent to it.
+type Server struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	messages []*Message
+	arrived  chan struct{}
+	failNext []int
+
+	wg sync.WaitGroup
+}
+
+// The NewServer() function starts a Server on a random port of 127.0.0.1. It panics
+// if it can't listen, like httptest.NewServer().
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("smtptest: failed to listen on a port: %v", err))
+	}
+
+	server := &Server{
+		listener: listener,
+		arrived:  make(chan struct{}),
+	}
+
+	server.wg.Add(1)
+	go server.serve()
+
+	return server
+}
+
+// Host returns the host the server listens on.
+func (server *Server) Host() string {
+	return server.listener.Addr().(*net.TCPAddr).IP.String()
+}
+
+// Port returns the port the server listens on.
+func (server *Server) Port() int {
+	return server.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Addr returns the "host:port" address of the server.
+func (server *Server) Addr() string {
+	return server.listener.Addr().String()
+}
+
+// Transport returns a mail transport sending to the server.
+func (server *Server) Transport() *mailer.SMTP {
+	return mailer.NewSMTP(server.Host(), server.Port(), "", "")
+}
+
+// Close stops the server and waits for the open connections to end.
+func (server *Server) Close() {
+	server.listener.Close()
+	server.wg.Wait()
+}
+
+// Messages returns the messages received so far, oldest first.
+func (server *Server) Messages() []*Message {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return append([]*Message(nil), server.messages...)
+}
+
+// Reset forgets the messages received so far.
+func (server *Server) Reset() {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.messages = nil
+}
+
+// FailNext makes the server reject the next messages with the given reply codes, one
+// message per code, e.g. 451 for a temporary failure the mailer should retry.
+func (server *Server) FailNext(codes ...int) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.failNext = append(server.failNext, codes...)
+}
+
+// WaitFor returns the newest message to recipient, waiting up to timeout for one to
+// arrive. Emails are sent by background jobs, so they often arrive after the request
+// which caused them.
+func (server *Server) WaitFor(recipient string, timeout time.Duration) (*Message, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		server.mu.Lock()
+		arrived := server.arrived
+		for i := len(server.messages) - 1; i >= 0; i-- {
+			if server.messages[i].SentTo(recipient) {
+				message := server.messages[i]
+				server.mu.Unlock()
+				return message, nil
+			}
+		}
+		server.mu.Unlock()
+
+		select {
+		case <-arrived:
+		case <-deadline.C:
+			return nil, fmt.Errorf("%w for %s", ErrTimeout, recipient)
+		}
+	}
+}
+
+// Accept connections until the listener is closed.
+func (server *Server) serve() {
+	defer server.wg.Done()
+
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		server.wg.Add(1)
+		go func() {
+			defer server.wg.Done()
+			server.handle(conn)
+		}()
+	}
+}
+
+// Speak SMTP on one connection. Only the commands net/smtp uses are supported.
+func (server *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	reply := func(code int, message string) {
+		text.PrintfLine("%d %s", code, message)
+	}
+
+	reply(220, "localhost smtptest ready")
+
+	var from string
+	var to []string
+
+	for {
+		conn.SetDeadline(time.Now().Add(time.Minute))
+
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, argument, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			text.PrintfLine("250-localhost greets %s", argument)
+			text.PrintfLine("250-8BITMIME")
+			text.PrintfLine("250 SIZE %d", maxMessageSize)
+		case "HELO":
+			reply(250, "localhost")
+		case "MAIL":
+			from, to = envelopePath(argument), nil
+			reply(250, "OK")
+		case "RCPT":
+			if from == "" {
+				reply(503, "MAIL first")
+				continue
+			}
+			to = append(to, envelopePath(argument))
+			reply(250, "OK")
+		case "DATA":
+			if len(to) == 0 {
+				reply(503, "RCPT first")
+				continue
+			}
+
+			reply(354, "End data with <CR><LF>.<CR><LF>")
+
+			raw, err := io.ReadAll(io.LimitReader(text.DotReader(), maxMessageSize+1))
+			if err != nil {
+				return
+			}
+
+			if len(raw) > maxMessageSize {
+				reply(552, "message too big")
+			} else if code := server.nextFailure(); code != 0 {
+				reply(code, "failing on purpose")
+			} else {
+				server.receive(from, to, raw)
+				reply(250, "OK: queued")
+			}
+
+			from, to = "", nil
+		case "RSET":
+			from, to = "", nil
+			reply(250, "OK")
+		case "NOOP":
+			reply(250, "OK")
+		case "QUIT":
+			reply(221, "Bye")
+			return
+		default:
+			reply(502, "command not implemented")
+		}
+	}
+}
+
+// Return the reply code the next message must be rejected with, or 0.
+func (server *Server) nextFailure() int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if len(server.failNext) == 0 {
+		return 0
+	}
+
+	code := server.failNext[0]
+	server.failNext = server.failNext[1:]
+	return code
+}
+
+// Keep a message and wake up the callers of WaitFor().
+func (server *Server) receive(from string, to []string, raw []byte) {
+	// The DotReader turns line endings into LF; put the CRLF of the wire format back so
+	// the raw message can be checked, e.g. with mailer.VerifyDKIM().
+	raw = bytes.ReplaceAll(raw, []byte("\n"), []byte("\r\n"))
+
+	message := parseMessage(raw)
+	message.From = from
+	message.To = to
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.messages = append(server.messages, message)
+	close(server.arrived)
+	server.arrived = make(chan struct{})
+}
+
+// Return the address of a "FROM:<address> BODY=8BITMIME" like argument.
+func envelopePath(argument string) string {
+	_, path, _ := strings.Cut(argument, ":")
+	path, _, _ = strings.Cut(strings.TrimSpace(path), " ")
+
+	return strings.Trim(path, "<>")
+}
+
+// Matches the activation token in the JSON body shown in the welcome email.
+var tokenRX = regexp.MustCompile(`"token":\s*"([A-Z2-7]{26})"`)
+
+// The ActivationToken() function returns the activation token in a welcome email.
+func ActivationToken(message *Message) (string, error) {
+	matches := tokenRX.FindStringSubmatch(message.PlainBody)
+	if matches == nil {
+		return "", fmt.Errorf("smtptest: no token in message %q", message.Subject)
+	}
+
+	return matches[1], nil
+}
@@ -0,0 +1,215 @@
+package smtptest
+
+import (
+	"errors"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-gomail/gomail"
+)
+
+// A welcome email as the templates render it, with a line longer than quoted-printable
+// allows so it gets soft line breaks.
+const (
+	plainBody = "Hi Zoë,\n\nThanks for signing up. To activate your account, send your token:\n\n" +
+		`{"token": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"}` + "\n\n" +
+		"This line is long enough to be wrapped by the quoted-printable encoding, which allows no more than seventy-six characters.\n"
+	htmlBody = "<p>Hi Zoë,</p>\n<pre>{\"token\": \"Y3QMGX3PJ3WLRL2YRTQGQ6KRHU\"}</pre>\n"
+)
+
+func newServer(t *testing.T) *Server {
+	server := NewServer()
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func wait(t *testing.T, server *Server, recipient string) *Message {
+	t.Helper()
+
+	message, err := server.WaitFor(recipient, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if message.ParseError != nil {
+		t.Fatalf("parse error: %v", message.ParseError)
+	}
+
+	return message
+}
+
+func checkBodies(t *testing.T, message *Message) {
+	t.Helper()
+
+	if message.PlainBody != plainBody {
+		t.Errorf("got plain body %q, want %q", message.PlainBody, plainBody)
+	}
+
+	if message.HTMLBody != htmlBody {
+		t.Errorf("got HTML body %q, want %q", message.HTMLBody, htmlBody)
+	}
+}
+
+// Send a multipart/alternative message written by hand through net/smtp, with the
+// plain text quoted-printable and the HTML base64.
+func TestNetSMTP(t *testing.T) {
+	server := newServer(t)
+
+	raw := strings.Join([]string{
+		"From: Meow <no-reply@meow.test>",
+		"To: alice@example.com",
+		"Subject: =?UTF-8?q?Welcome_to_Meow,_Zo=C3=AB!?=",
+		"MIME-Version: 1.0",
+		`Content-Type: multipart/alternative; boundary="b1"`,
+		"",
+		"--b1",
+		"Content-Type: text/plain; charset=UTF-8",
+		"Content-Transfer-Encoding: quoted-printable",
+		"",
+		"Hi Zo=C3=AB,",
+		"",
+		"Thanks for signing up. To activate your account, send your token:",
+		"",
+		`{"token": "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"}`,
+		"",
+		"This line is long enough to be wrapped by the quoted-printable encoding, wh=",
+		"ich allows no more than seventy-six characters.",
+		"--b1",
+		"Content-Type: text/html; charset=UTF-8",
+		"Content-Transfer-Encoding: base64",
+		"",
+		"PHA+SGkgWm/Dqyw8L3A+CjxwcmU+eyJ0b2tlbiI6ICJZM1FNR1gzUEozV0xSTDJZUlRRR1E2S1JIVSJ9PC9wcmU+Cg==",
+		"--b1--",
+		"",
+	}, "\r\n")
+
+	err := smtp.SendMail(server.Addr(), nil, "no-reply@meow.test", []string{"alice@example.com", "bob@example.com"}, []byte(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := wait(t, server, "bob@example.com")
+
+	if message.From != "no-reply@meow.test" || !message.SentTo("ALICE@example.com") || !message.SentTo("bob@example.com") {
+		t.Errorf("got envelope %s to %v", message.From, message.To)
+	}
+
+	if message.Subject != "Welcome to Meow, Zoë!" {
+		t.Errorf("got subject %q", message.Subject)
+	}
+
+	// The part ends before the CRLF preceding the boundary, so the last line of the
+	// plain text has no newline of its own here.
+	if want := strings.TrimSuffix(plainBody, "\n"); message.PlainBody != want {
+		t.Errorf("got plain body %q, want %q", message.PlainBody, want)
+	}
+
+	if message.HTMLBody != htmlBody {
+		t.Errorf("got HTML body %q, want %q", message.HTMLBody, htmlBody)
+	}
+
+	if !strings.Contains(string(message.Raw), "\r\nContent-Transfer-Encoding: base64\r\n") {
+		t.Error("raw message lacks CRLF line endings")
+	}
+}
+
+// Send a message through gomail, as the mailer builds them.
+func TestGomail(t *testing.T) {
+	server := newServer(t)
+
+	m := gomail.NewMessage()
+	m.SetHeader("From", "no-reply@meow.test")
+	m.SetHeader("To", "alice@example.com")
+	m.SetHeader("Subject", "Welcome to Meow, Zoë!")
+	m.SetBody("text/plain", plainBody)
+	m.AddAlternative("text/html", htmlBody)
+
+	err := gomail.NewDialer(server.Host(), server.Port(), "", "").DialAndSend(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := wait(t, server, "alice@example.com")
+
+	if message.Subject != "Welcome to Meow, Zoë!" {
+		t.Errorf("got subject %q", message.Subject)
+	}
+
+	checkBodies(t, message)
+
+	// Both parts really were quoted-printable, so they were decoded.
+	if strings.Count(string(message.Raw), "Content-Transfer-Encoding: quoted-printable") != 2 {
+		t.Errorf("got raw message %s", message.Raw)
+	}
+
+	token, err := ActivationToken(message)
+	if err != nil || token != "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU" {
+		t.Errorf("got token %q (%v)", token, err)
+	}
+}
+
+func TestFailNext(t *testing.T) {
+	server := newServer(t)
+	server.FailNext(451)
+
+	send := func() error {
+		return smtp.SendMail(server.Addr(), nil, "no-reply@meow.test", []string{"alice@example.com"}, []byte("Subject: Hi\r\n\r\nHello\r\n"))
+	}
+
+	var protocolError *textproto.Error
+	if err := send(); !errors.As(err, &protocolError) || protocolError.Code != 451 {
+		t.Fatalf("got %v, want a 451 reply", err)
+	}
+
+	if err := send(); err != nil {
+		t.Fatal(err)
+	}
+
+	if messages := server.Messages(); len(messages) != 1 || messages[0].PlainBody != "Hello\n" {
+		t.Errorf("got messages %+v", messages)
+	}
+}
+
+func TestWaitForTimeout(t *testing.T) {
+	server := newServer(t)
+
+	_, err := server.WaitFor("nobody@example.com", 20*time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want %v", err, ErrTimeout)
+	}
+}
+
+func TestActivationToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		plain string
+		want  string
+	}{
+		{"welcome", plainBody, "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"},
+		{"no space", `{"token":"Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"}`, "Y3QMGX3PJ3WLRL2YRTQGQ6KRHU"},
+		{"no token", "Hi Zoë,\n\nYour password was changed.\n", ""},
+		{"too short", `{"token": "Y3QMGX3PJ3WLRL2YRTQGQ6KRH"}`, ""},
+		{"lowercase", `{"token": "y3qmgx3pj3wlrl2yrtqgq6krhu"}`, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token, err := ActivationToken(&Message{Subject: "Welcome", PlainBody: test.plain})
+
+			if test.want == "" {
+				if err == nil {
+					t.Fatalf("got token %q, want an error", token)
+				}
+				return
+			}
+
+			if err != nil || token != test.want {
+				t.Fatalf("got %q (%v), want %q", token, err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package stream
+
+import (
+	"Meow/internal/data"
+	"Meow/internal/tracing"
+	jlog "Meow/log"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Store is the part of the events model used by the Listener.
+type Store interface {
+	Get(context.Context, int64) (*data.Event, error)
+	GetAfter(context.Context, int64, int) ([]*data.Event, error)
+}
+
+// How often the listening connection is checked when no notifications arrive.
+const pingInterval = 90 * time.Second
+
+// A Listener publishes the events of every replica on a Broker. It listens on
+// data.EventsChannel on a dedicated connection, which lib/pq reconnects when it is
+// lost. Events committed while it was disconnected are read from the outbox table.
+type Listener struct {
+	dsn    string
+	store  Store
+	broker *Broker
+	logger *jlog.Logger
+
+	// The ID of the newest event published, where catching up starts.
+	last int64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// The NewListener() function returns a Listener connecting to the database at dsn,
+// which reads the notified events from store and publishes them on broker.
+func NewListener(dsn string, store Store, broker *Broker, logger *jlog.Logger) *Listener {
+	return &Listener{
+		dsn:    dsn,
+		store:  store,
+		broker: broker,
+		logger: logger,
+		stop:   make(chan struct{}),
+	}
+}
+
+// Run publishes events until Stop() is called or ctx is done.
+func (listener *Listener) Run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pqListener := pq.NewListener(listener.dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		fields := jlog.Fields{}
+		if err != nil {
+			fields["error"] = err.Error()
+		}
+
+		switch event {
+		case pq.ListenerEventDisconnected:
+			listener.logger.Warn("event listener disconnected", fields)
+		case pq.ListenerEventReconnected:
+			listener.logger.Info("event listener reconnected", nil)
+		case pq.ListenerEventConnectionAttemptFailed:
+			listener.logger.Warn("event listener connection failed", fields)
+		}
+	})
+
+	// Closing the listener makes Listen() and the notification loop return.
+	go func() {
+		select {
+		case <-listener.stop:
+		case <-ctx.Done():
+		}
+
+		cancel()
+		pqListener.Close()
+	}()
+
+	// Listen() blocks until the first connection is made.
+	if err := pqListener.Listen(data.EventsChannel); err != nil {
+		if ctx.Err() == nil {
+			listener.logger.Error(err, jlog.Fields{"event": "listening for movie events failed"})
+		}
+		return
+	}
+
+	listener.logger.Info("event listener started", jlog.Fields{"channel": data.EventsChannel})
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case notification, ok := <-pqListener.Notify:
+			if !ok {
+				return
+			}
+
+			// A nil notification is sent after reconnecting, when notifications may
+			// have been missed.
+			if notification == nil {
+				listener.catchUp(ctx)
+				continue
+			}
+
+			listener.publish(ctx, notification.Extra)
+
+		case <-ping.C:
+			go pqListener.Ping()
+		}
+	}
+}
+
+// Stop makes Run() return.
+func (listener *Listener) Stop() {
+	listener.stopOnce.Do(func() {
+		close(listener.stop)
+	})
+}
+
+// Read the event whose ID was notified and publish it.
+func (listener *Listener) publish(ctx context.Context, payload string) {
+	id, err := strconv.ParseInt(payload, 10, 64)
+	if err != nil {
+		listener.logger.Warn("invalid movie event notification", jlog.Fields{"payload": payload})
+		return
+	}
+
+	// Reading the events isn't traced; there is one read per event and replica.
+	event, err := listener.store.Get(tracing.Unsampled(ctx), id)
+	if err != nil {
+		switch {
+		// The event may have been purged meanwhile.
+		case errors.Is(err, data.ErrRecordNotFound):
+		case ctx.Err() == nil:
+			listener.logger.Error(err, jlog.Fields{"event": "reading movie event failed", "event_id": id})
+		}
+		return
+	}
+
+	listener.broker.Publish(event)
+	listener.last = max(listener.last, event.ID)
+}
+
+// Publish the events after the newest one published, which were committed while the
+// listener was disconnected. Nothing was published before the first connection, so
+// there is nothing to catch up with then.
+func (listener *Listener) catchUp(ctx context.Context) {
+	for listener.last > 0 {
+		events, err := listener.store.GetAfter(tracing.Unsampled(ctx), listener.last, 100)
+		if err != nil {
+			if ctx.Err() == nil {
+				listener.logger.Error(err, jlog.Fields{"event": "catching up with movie events failed"})
+			}
+			return
+		}
+
+		for _, event := range events {
+			listener.broker.Publish(event)
+			listener.last = event.ID
+		}
+
+		if len(events) < 100 {
+			return
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Package stream fans the movie events out to the clients of the event stream. A
+// Listener receives the events of every replica from PostgreSQL LISTEN/NOTIFY and
+// publishes them on a Broker, which hands them to the subscriptions of the clients
+// connected to this replica.
+package stream
+
+import (
+	"Meow/internal/data"
+	"Meow/internal/metrics"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrSlowConsumer ends a subscription whose buffer was full when an event was
+	// published. The client should resume from the last event it received.
+	ErrSlowConsumer = errors.New("subscriber too slow")
+
+	// ErrClosed ends every subscription when the broker is closed on shutdown, and is
+	// returned by Subscribe() after that.
+	ErrClosed = errors.New("event stream closed")
+)
+
+// Count the published events, the open subscriptions and the subscriptions which
+// were ended early.
+var (
+	published     = metrics.NewCounterVec("stream_events_published_total", "Total number of movie events published to the event streams of this server.")
+	subscriptions = metrics.NewGaugeVec("stream_subscriptions", "Number of open event stream subscriptions.")
+	dropped       = metrics.NewCounterVec("stream_subscriptions_dropped_total", "Total number of event stream subscriptions ended by the server.", "reason")
+)
+
+// A Subscription receives the events published after it was made, until it is
+// unsubscribed or ended by the broker.
+type Subscription struct {
+	events chan *data.Event
+	done   chan struct{}
+	err    error
+}
+
+// Events returns the channel of the events of the subscription.
+func (subscription *Subscription) Events() <-chan *data.Event {
+	return subscription.events
+}
+
+// Done returns a channel which is closed when the broker ended the subscription.
+func (subscription *Subscription) Done() <-chan struct{} {
+	return subscription.done
+}
+
+// Err returns why the broker ended the subscription. It must only be called after
+// Done() is closed.
+func (subscription *Subscription) Err() error {
+	return subscription.err
+}
+
+// A Broker publishes events to its subscriptions. Publishing never blocks: every
+// subscription has its own buffer, and a subscription whose buffer is full is ended
+// with ErrSlowConsumer instead of holding up the others.
+type Broker struct {
+	mu            sync.Mutex
+	subscriptions map[*Subscription]struct{}
+	closed        bool
+}
+
+// The NewBroker() function returns a Broker without subscriptions.
+func NewBroker() *Broker {
+	return &Broker{
+		subscriptions: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscribe returns a subscription buffering up to buffer events.
+func (broker *Broker) Subscribe(buffer int) (*Subscription, error) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	if broker.closed {
+		return nil, ErrClosed
+	}
+
+	subscription := &Subscription{
+		events: make(chan *data.Event, buffer),
+		done:   make(chan struct{}),
+	}
+
+	broker.subscriptions[subscription] = struct{}{}
+	subscriptions.Set(float64(len(broker.subscriptions)))
+
+	return subscription, nil
+}
+
+// Unsubscribe stops the events of a subscription.
+func (broker *Broker) Unsubscribe(subscription *Subscription) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	delete(broker.subscriptions, subscription)
+	subscriptions.Set(float64(len(broker.subscriptions)))
+}
+
+// Publish hands event to every subscription.
+func (broker *Broker) Publish(event *data.Event) {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	published.Inc()
+
+	for subscription := range broker.subscriptions {
+		select {
+		case subscription.events <- event:
+		default:
+			broker.end(subscription, ErrSlowConsumer)
+			dropped.Inc("slow")
+		}
+	}
+}
+
+// Close ends every subscription with ErrClosed and refuses new ones.
+func (broker *Broker) Close() {
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+
+	broker.closed = true
+
+	for subscription := range broker.subscriptions {
+		broker.end(subscription, ErrClosed)
+		dropped.Inc("closed")
+	}
+}
+
+// End a subscription with err. The caller holds broker.mu.
+func (broker *Broker) end(subscription *Subscription, err error) {
+	delete(broker.subscriptions, subscription)
+	subscriptions.Set(float64(len(broker.subscriptions)))
+
+	subscription.err = err
+	close(subscription.done)
+}
@@ -0,0 +1,111 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"time"
+)
+
+// The SelfSigned() function returns a PEM encoded certificate and key for hosts (DNS
+// names or IP addresses), valid for a day. The certificate can also sign other
+// certificates, so tests can use it as the CA for client certificates.
+func SelfSigned(hosts ...string) (certPEM []byte, keyPEM []byte, err error) {
+	template, err := newTemplate("Meow test CA")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage |= x509.KeyUsageCertSign
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encode(template, template, &key.PublicKey, key, key)
+}
+
+// The ClientCertificate() function returns a PEM encoded client certificate and key
+// for commonName, signed by the CA certificate and key (e.g. from SelfSigned()).
+func ClientCertificate(caCertPEM []byte, caKeyPEM []byte, commonName string) (certPEM []byte, keyPEM []byte, err error) {
+	ca, err := tls.X509KeyPair(caCertPEM, caKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	caKey, ok := ca.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("the CA key must be an ECDSA key")
+	}
+
+	template, err := newTemplate(commonName)
+	if err != nil {
+		return nil, nil, err
+	}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return encode(template, caCert, &key.PublicKey, caKey, key)
+}
+
+// Return a certificate template for commonName with a random serial number.
+func newTemplate(commonName string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"Meow"}},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}, nil
+}
+
+// Sign template with parent and signer, and PEM encode the certificate and key.
+func encode(template *x509.Certificate, parent *x509.Certificate, public *ecdsa.PublicKey, signer *ecdsa.PrivateKey, key *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, public, signer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM, nil
+}
@@ -0,0 +1,252 @@
+// Package tlsconfig builds the TLS configuration of the server: certificates which are
+// reloaded when their files change, protocol versions, cipher suites and client
+// certificate verification.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Options describes the TLS settings of the server. The certificate files are handled
+// by a Reloader.
+type Options struct {
+	MinVersion   string
+	CipherSuites []string
+	ClientAuth   string
+	HTTP2        bool
+}
+
+// Map the names of the -tls-min-version setting to versions.
+var versions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Map the names of the -tls-client-auth setting to client authentication types.
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":     tls.NoClientCert,
+	"optional": tls.VerifyClientCertIfGiven,
+	"require":  tls.RequireAndVerifyClientCert,
+}
+
+// The ParseVersion() function returns the TLS version named like "1.2".
+func ParseVersion(name string) (uint16, error) {
+	version, found := versions[name]
+	if !found {
+		return 0, fmt.Errorf("unknown TLS version %q", name)
+	}
+
+	return version, nil
+}
+
+// The ParseClientAuth() function returns the client authentication type named none,
+// optional or require.
+func ParseClientAuth(name string) (tls.ClientAuthType, error) {
+	clientAuth, found := clientAuthTypes[name]
+	if !found {
+		return 0, fmt.Errorf("unknown client authentication %q", name)
+	}
+
+	return clientAuth, nil
+}
+
+// The ParseCipherSuites() function returns the ids of cipher suites named like
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Only secure suites are accepted.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, found := known[name]
+		if !found {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// A Reloader holds the server certificate and the client CA pool, and loads them
+// again when their files change on disk.
+type Reloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	certificate atomic.Pointer[tls.Certificate]
+	clientCAs   atomic.Pointer[x509.CertPool]
+
+	mu       sync.Mutex
+	modTimes map[string]time.Time
+}
+
+// NewReloader loads the certificate and key, and the client CAs if clientCAFile isn't
+// empty.
+func NewReloader(certFile string, keyFile string, clientCAFile string) (*Reloader, error) {
+	reloader := &Reloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+		modTimes:     make(map[string]time.Time),
+	}
+
+	if _, err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// Return the files watched by the reloader.
+func (reloader *Reloader) files() []string {
+	files := []string{reloader.certFile, reloader.keyFile}
+	if reloader.clientCAFile != "" {
+		files = append(files, reloader.clientCAFile)
+	}
+
+	return files
+}
+
+// Reload loads the files again if any of them changed since the last load, and reports
+// whether it did. On error the current certificate and CAs are kept.
+func (reloader *Reloader) Reload() (bool, error) {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+
+	modTimes := make(map[string]time.Time)
+	changed := false
+
+	for _, file := range reloader.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+
+		modTimes[file] = info.ModTime()
+		if !info.ModTime().Equal(reloader.modTimes[file]) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return false, nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return false, err
+	}
+
+	var clientCAs *x509.CertPool
+	if reloader.clientCAFile != "" {
+		pem, err := os.ReadFile(reloader.clientCAFile)
+		if err != nil {
+			return false, err
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return false, errors.New("no certificates found in " + reloader.clientCAFile)
+		}
+	}
+
+	reloader.certificate.Store(&certificate)
+	reloader.clientCAs.Store(clientCAs)
+	reloader.modTimes = modTimes
+
+	return true, nil
+}
+
+// Watch checks the files for changes every interval until stop is closed. onReload is
+// called after every reload attempt of changed files, with the error if it failed.
+func (reloader *Reloader) Watch(interval time.Duration, stop <-chan struct{}, onReload func(err error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			reloaded, err := reloader.Reload()
+			if (reloaded || err != nil) && onReload != nil {
+				onReload(err)
+			}
+		}
+	}
+}
+
+// GetCertificate returns the current certificate, for tls.Config.GetCertificate.
+func (reloader *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return reloader.certificate.Load(), nil
+}
+
+// The New() function returns a server TLS configuration which takes its certificates
+// from reloader.
+func New(options Options, reloader *Reloader) (*tls.Config, error) {
+	minVersion, err := ParseVersion(options.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	cipherSuites, err := ParseCipherSuites(options.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	clientAuth, err := ParseClientAuth(options.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	base := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		ClientAuth:     clientAuth,
+		GetCertificate: reloader.GetCertificate,
+		CurvePreferences: []tls.CurveID{
+			tls.X25519,
+			tls.CurveP256,
+		},
+	}
+
+	// net/http only adds "h2" to the configuration it is given, not to the ones
+	// returned by GetConfigForClient, so set the protocols explicitly.
+	if options.HTTP2 {
+		base.NextProtos = []string{"h2", "http/1.1"}
+	} else {
+		base.NextProtos = []string{"http/1.1"}
+	}
+
+	// An empty but non-nil list would disable every TLS 1.2 cipher suite.
+	if len(cipherSuites) == 0 {
+		base.CipherSuites = nil
+	}
+
+	if clientAuth == tls.NoClientCert {
+		return base, nil
+	}
+
+	// Use the current client CAs for every handshake so they can be reloaded too.
+	config := base.Clone()
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		perClient := base.Clone()
+		perClient.ClientCAs = reloader.clientCAs.Load()
+		return perClient, nil
+	}
+
+	return config, nil
+}
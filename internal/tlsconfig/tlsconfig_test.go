@@ -0,0 +1,412 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Files of a certificate and key, and of the CA of the client certificates.
+type files struct {
+	cert, key, clientCA string
+}
+
+// Write a new self signed certificate for 127.0.0.1 to dir, with the modification time
+// modTime so a rewrite is seen as a change however fast it happens. The certificate
+// is also the client CA.
+func writeCertificate(t *testing.T, dir string, modTime time.Time) (files, []byte, []byte) {
+	t.Helper()
+
+	certPEM, keyPEM, err := SelfSigned("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := files{
+		cert:     filepath.Join(dir, "cert.pem"),
+		key:      filepath.Join(dir, "key.pem"),
+		clientCA: filepath.Join(dir, "ca.pem"),
+	}
+
+	for name, content := range map[string][]byte{f.cert: certPEM, f.key: keyPEM, f.clientCA: certPEM} {
+		if err := os.WriteFile(name, content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return f, certPEM, keyPEM
+}
+
+// Return the serial number of the certificate the reloader serves.
+func servedSerial(t *testing.T, reloader *Reloader) string {
+	t.Helper()
+
+	certificate, _ := reloader.GetCertificate(nil)
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return leaf.SerialNumber.String()
+}
+
+// Return the serial number of a PEM encoded certificate.
+func pemSerial(t *testing.T, certPEM []byte, keyPEM []byte) string {
+	t.Helper()
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return leaf.SerialNumber.String()
+}
+
+func TestReloaderRotation(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+
+	f, certPEM, keyPEM := writeCertificate(t, dir, start)
+
+	reloader, err := NewReloader(f.cert, f.key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := pemSerial(t, certPEM, keyPEM)
+	if got := servedSerial(t, reloader); got != first {
+		t.Fatalf("got serial %s, want %s", got, first)
+	}
+
+	// Nothing changed yet.
+	if reloaded, err := reloader.Reload(); reloaded || err != nil {
+		t.Fatalf("got reloaded %v, %v without a change", reloaded, err)
+	}
+
+	// Rotate the pair.
+	_, certPEM, keyPEM = writeCertificate(t, dir, start.Add(time.Minute))
+	second := pemSerial(t, certPEM, keyPEM)
+
+	if reloaded, err := reloader.Reload(); !reloaded || err != nil {
+		t.Fatalf("got reloaded %v, %v after the rotation", reloaded, err)
+	}
+
+	if got := servedSerial(t, reloader); got != second {
+		t.Fatalf("got serial %s after the rotation, want %s", got, second)
+	}
+
+	// A broken key keeps the current certificate.
+	os.WriteFile(f.key, []byte("not a key"), 0o600)
+	os.Chtimes(f.key, start.Add(2*time.Minute), start.Add(2*time.Minute))
+
+	if _, err := reloader.Reload(); err == nil {
+		t.Fatal("got no error for a broken key")
+	}
+
+	if got := servedSerial(t, reloader); got != second {
+		t.Fatalf("got serial %s after a failed reload, want %s", got, second)
+	}
+}
+
+func TestReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+
+	f, _, _ := writeCertificate(t, dir, start)
+
+	reloader, err := NewReloader(f.cert, f.key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloads := make(chan error, 10)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go reloader.Watch(10*time.Millisecond, stop, func(err error) { reloads <- err })
+
+	_, certPEM, keyPEM := writeCertificate(t, dir, start.Add(time.Minute))
+
+	select {
+	case err := <-reloads:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the rotation wasn't picked up")
+	}
+
+	if got, want := servedSerial(t, reloader), pemSerial(t, certPEM, keyPEM); got != want {
+		t.Fatalf("got serial %s, want %s", got, want)
+	}
+}
+
+// Serve TLS handshakes with config on a random port. Every connection gets "ok" after
+// a successful handshake.
+func serve(t *testing.T, config *tls.Config) string {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				if conn.(*tls.Conn).Handshake() == nil {
+					conn.Write([]byte("ok"))
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// Connect to addr with config and return the state of the connection. With TLS 1.3
+// the server rejects a client certificate after the client finished its handshake,
+// so the error may only come when reading.
+func connect(addr string, config *tls.Config) (tls.ConnectionState, error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", addr, config)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return tls.ConnectionState{}, err
+	}
+
+	if string(reply) != "ok" {
+		return tls.ConnectionState{}, errors.New("unexpected reply " + string(reply))
+	}
+
+	return conn.ConnectionState(), nil
+}
+
+// Return a client configuration trusting the server certificate.
+func clientConfig(t *testing.T, certPEM []byte) *tls.Config {
+	t.Helper()
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(certPEM) {
+		t.Fatal("no certificate")
+	}
+
+	return &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+}
+
+func TestClientCertificates(t *testing.T) {
+	f, certPEM, keyPEM := writeCertificate(t, t.TempDir(), time.Now())
+
+	reloader, err := NewReloader(f.cert, f.key, f.clientCA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := New(Options{MinVersion: "1.2", ClientAuth: "require"}, reloader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr := serve(t, config)
+
+	// Signed by the configured CA.
+	clientCert, clientKey, err := ClientCertificate(certPEM, keyPEM, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Signed by another CA.
+	otherCAPEM, otherKeyPEM, err := SelfSigned("127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strangerCert, strangerKey, err := ClientCertificate(otherCAPEM, otherKeyPEM, "mallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cert    []byte
+		key     []byte
+		version uint16
+		ok      bool
+	}{
+		{"no certificate", nil, nil, tls.VersionTLS13, false},
+		{"no certificate with TLS 1.2", nil, nil, tls.VersionTLS12, false},
+		{"certificate of another CA", strangerCert, strangerKey, tls.VersionTLS13, false},
+		{"certificate of the CA", clientCert, clientKey, tls.VersionTLS13, true},
+		{"certificate of the CA with TLS 1.2", clientCert, clientKey, tls.VersionTLS12, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := clientConfig(t, certPEM)
+			client.MaxVersion = test.version
+
+			if test.cert != nil {
+				pair, err := tls.X509KeyPair(test.cert, test.key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				client.Certificates = []tls.Certificate{pair}
+			}
+
+			_, err := connect(addr, client)
+			if test.ok && err != nil {
+				t.Fatalf("got %v, want a connection", err)
+			}
+
+			if !test.ok && err == nil {
+				t.Fatal("got a connection, want it rejected")
+			}
+		})
+	}
+}
+
+func TestALPN(t *testing.T) {
+	tests := []struct {
+		name       string
+		http2      bool
+		clientAuth string
+		want       string
+	}{
+		{"HTTP/2", true, "none", "h2"},
+		{"HTTP/1.1 only", false, "none", "http/1.1"},
+		{"HTTP/2 with client certificates", true, "optional", "h2"},
+		{"HTTP/1.1 only with client certificates", false, "optional", "http/1.1"},
+	}
+
+	f, certPEM, _ := writeCertificate(t, t.TempDir(), time.Now())
+
+	reloader, err := NewReloader(f.cert, f.key, f.clientCA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := New(Options{MinVersion: "1.2", ClientAuth: test.clientAuth, HTTP2: test.http2}, reloader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			client := clientConfig(t, certPEM)
+			client.NextProtos = []string{"h2", "http/1.1"}
+
+			state, err := connect(serve(t, config), client)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if state.NegotiatedProtocol != test.want {
+				t.Fatalf("got protocol %q, want %q", state.NegotiatedProtocol, test.want)
+			}
+		})
+	}
+}
+
+func TestMinVersion(t *testing.T) {
+	f, certPEM, _ := writeCertificate(t, t.TempDir(), time.Now())
+
+	reloader, err := NewReloader(f.cert, f.key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := New(Options{MinVersion: "1.3", ClientAuth: "none"}, reloader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := clientConfig(t, certPEM)
+	client.MaxVersion = tls.VersionTLS12
+
+	if _, err := connect(serve(t, config), client); err == nil {
+		t.Fatal("got a TLS 1.2 connection to a TLS 1.3 server")
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	tests := []struct {
+		name  string
+		names []string
+		want  []uint16
+		ok    bool
+	}{
+		{"none", nil, []uint16{}, true},
+		{"secure", []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"},
+			[]uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256}, true},
+		{"unknown", []string{"TLS_MADE_UP"}, nil, false},
+		{"insecure RC4", []string{"TLS_ECDHE_RSA_WITH_RC4_128_SHA"}, nil, false},
+		{"insecure 3DES", []string{"TLS_RSA_WITH_3DES_EDE_CBC_SHA"}, nil, false},
+		{"insecure among secure", []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256", "TLS_RSA_WITH_RC4_128_SHA"}, nil, false},
+		{"lower case", []string{"tls_ecdhe_ecdsa_with_aes_128_gcm_sha256"}, nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ids, err := ParseCipherSuites(test.names)
+			if (err == nil) != test.ok {
+				t.Fatalf("got error %v, want ok %v", err, test.ok)
+			}
+
+			if len(ids) != len(test.want) {
+				t.Fatalf("got %v, want %v", ids, test.want)
+			}
+
+			for i := range ids {
+				if ids[i] != test.want[i] {
+					t.Fatalf("got %v, want %v", ids, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseVersionAndClientAuth(t *testing.T) {
+	if version, err := ParseVersion("1.2"); err != nil || version != tls.VersionTLS12 {
+		t.Errorf("got %v, %v for 1.2", version, err)
+	}
+
+	if _, err := ParseVersion("1.4"); err == nil {
+		t.Error("got no error for 1.4")
+	}
+
+	if auth, err := ParseClientAuth("require"); err != nil || auth != tls.RequireAndVerifyClientCert {
+		t.Errorf("got %v, %v for require", auth, err)
+	}
+
+	if _, err := ParseClientAuth("always"); err == nil {
+		t.Error("got no error for always")
+	}
+}
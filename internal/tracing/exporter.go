@@ -0,0 +1,362 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Exporter sends ended spans to a tracing backend.
+type Exporter interface {
+	Export(ctx context.Context, spans []SpanData) error
+}
+
+// Define the limits of the batch processor. Spans ended while the queue is full are
+// dropped, so a slow collector can never block requests.
+const (
+	queueSize     = 2048
+	batchSize     = 512
+	batchInterval = 5 * time.Second
+)
+
+// processor batches ended spans and hands them to the exporter from a background
+// goroutine.
+type processor struct {
+	exporter Exporter
+	queue    chan SpanData
+	flush    chan chan struct{}
+	done     chan struct{}
+	onError  func(error)
+}
+
+var (
+	mu      sync.RWMutex
+	current *processor
+)
+
+// SetExporter starts exporting ended spans to exporter. Errors returned by the exporter
+// are passed to onError, which may be nil. Calling it again replaces the previous
+// exporter after flushing it.
+func SetExporter(exporter Exporter, onError func(error)) {
+	p := &processor{
+		exporter: exporter,
+		queue:    make(chan SpanData, queueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+		onError:  onError,
+	}
+
+	go p.run()
+
+	mu.Lock()
+	previous := current
+	current = p
+	mu.Unlock()
+
+	if previous != nil {
+		previous.shutdown(context.Background())
+	}
+}
+
+// Add an ended span to the queue of the current processor, if there is one.
+func enqueue(span SpanData) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if current == nil {
+		return
+	}
+
+	select {
+	case current.queue <- span:
+	default:
+	}
+}
+
+// ForceFlush exports every queued span before returning. Tests use it to read spans
+// from an InMemoryExporter right after a request.
+func ForceFlush() {
+	mu.RLock()
+	p := current
+	mu.RUnlock()
+
+	if p == nil {
+		return
+	}
+
+	reply := make(chan struct{})
+	select {
+	case p.flush <- reply:
+		<-reply
+	case <-p.done:
+	}
+}
+
+// Shutdown exports the queued spans and stops the exporter. It returns early if ctx is
+// done first.
+func Shutdown(ctx context.Context) {
+	mu.Lock()
+	p := current
+	current = nil
+	mu.Unlock()
+
+	if p != nil {
+		p.shutdown(ctx)
+	}
+}
+
+// Collect spans from the queue and export them in batches.
+func (p *processor) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, batchSize)
+
+	export := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := p.exporter.Export(ctx, batch); err != nil && p.onError != nil {
+			p.onError(err)
+		}
+
+		batch = make([]SpanData, 0, batchSize)
+	}
+
+	// Move every span waiting in the queue to the batch.
+	drain := func() {
+		for {
+			select {
+			case span, ok := <-p.queue:
+				if !ok {
+					return
+				}
+
+				batch = append(batch, span)
+				if len(batch) >= batchSize {
+					export()
+				}
+			default:
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case span, ok := <-p.queue:
+			if !ok {
+				export()
+				return
+			}
+
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				export()
+			}
+
+		case reply := <-p.flush:
+			drain()
+			export()
+			close(reply)
+
+		case <-ticker.C:
+			export()
+		}
+	}
+}
+
+// Stop the processor after exporting everything in the queue.
+func (p *processor) shutdown(ctx context.Context) {
+	close(p.queue)
+
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+	}
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP/HTTP with JSON
+// encoding.
+type OTLPExporter struct {
+	endpoint    string
+	serviceName string
+	client      *http.Client
+}
+
+// NewOTLPExporter returns an exporter which posts spans to endpoint, e.g.
+// "http://localhost:4318/v1/traces".
+func NewOTLPExporter(endpoint string, serviceName string) *OTLPExporter {
+	return &OTLPExporter{
+		endpoint:    endpoint,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Define the parts of the OTLP JSON request we use. Ids are hex encoded and times are
+// nanoseconds since the epoch sent as strings, as required by the OTLP JSON mapping.
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+// Convert an attribute value to its OTLP form.
+func toOTLPValue(value interface{}) otlpValue {
+	switch value := value.(type) {
+	case string:
+		return otlpValue{StringValue: &value}
+	case bool:
+		return otlpValue{BoolValue: &value}
+	case int:
+		s := strconv.Itoa(value)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		return otlpValue{IntValue: &s}
+	case float64:
+		return otlpValue{DoubleValue: &value}
+	default:
+		s := fmt.Sprint(value)
+		return otlpValue{StringValue: &s}
+	}
+}
+
+// Export sends the spans in a single request.
+func (exporter *OTLPExporter) Export(ctx context.Context, spans []SpanData) error {
+	converted := make([]otlpSpan, len(spans))
+	for i, span := range spans {
+		converted[i] = otlpSpan{
+			TraceID:           span.TraceID.String(),
+			SpanID:            span.SpanID.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Status:            otlpStatus{Code: 1},
+		}
+
+		if span.ParentSpanID.IsValid() {
+			converted[i].ParentSpanID = span.ParentSpanID.String()
+		}
+
+		if span.Error != "" {
+			converted[i].Status = otlpStatus{Code: 2, Message: span.Error}
+		}
+
+		for key, value := range span.Attributes {
+			converted[i].Attributes = append(converted[i].Attributes, otlpAttribute{Key: key, Value: toOTLPValue(value)})
+		}
+	}
+
+	body := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttribute{{Key: "service.name", Value: toOTLPValue(exporter.serviceName)}},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "Meow/internal/tracing"},
+						"spans": converted,
+					},
+				},
+			},
+		},
+	}
+
+	js, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, exporter.endpoint, bytes.NewReader(js))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := exporter.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("otlp exporter: collector responded with %s", response.Status)
+	}
+
+	return nil
+}
+
+// InMemoryExporter keeps exported spans in memory for tests.
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewInMemoryExporter returns an empty InMemoryExporter.
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+// Export stores the spans.
+func (exporter *InMemoryExporter) Export(ctx context.Context, spans []SpanData) error {
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+
+	exporter.spans = append(exporter.spans, spans...)
+	return nil
+}
+
+// Spans returns a copy of every span exported so far. Call ForceFlush() first to
+// include spans still waiting in the queue.
+func (exporter *InMemoryExporter) Spans() []SpanData {
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+
+	return append([]SpanData(nil), exporter.spans...)
+}
+
+// Reset removes every stored span.
+func (exporter *InMemoryExporter) Reset() {
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+
+	exporter.spans = nil
+}
@@ -0,0 +1,297 @@
+// Package tracing implements a small subset of OpenTelemetry tracing: spans kept in a
+// context.Context, W3C Trace Context propagation with the traceparent header and
+// exporters for OTLP/HTTP collectors and tests.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a whole trace.
+type TraceID [16]byte
+
+// SpanID identifies a single span of a trace.
+type SpanID [8]byte
+
+// Return the lowercase hex form of the trace id.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the trace id is not all zeros.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// Return the lowercase hex form of the span id.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid reports whether the span id is not all zeros.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext is the part of a span which is propagated to other services.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid reports whether both ids are set.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// SpanKind describes the relationship of a span to its parent, using the same values
+// as OTLP.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+	KindProducer SpanKind = 4
+	KindConsumer SpanKind = 5
+)
+
+// Span is a single timed operation of a trace.
+type Span struct {
+	mu         sync.Mutex
+	name       string
+	kind       SpanKind
+	context    SpanContext
+	parentID   SpanID
+	start      time.Time
+	end        time.Time
+	attributes map[string]interface{}
+	err        string
+	ended      bool
+}
+
+// SpanData is a read-only copy of an ended span which is handed to exporters.
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	TraceID      TraceID
+	SpanID       SpanID
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	Error        string
+}
+
+// Define the context keys for the current span and a remote parent.
+type contextKey string
+
+const (
+	spanContextKey   = contextKey("span")
+	remoteContextKey = contextKey("remote")
+	unsampledKey     = contextKey("unsampled")
+)
+
+// Start creates a span as a child of the span in ctx (or the remote parent added with
+// ContextWithRemoteParent) and returns a copy of ctx holding the new span. If there is
+// no parent a new trace is started. The span must be ended with End().
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	span := &Span{
+		name:       name,
+		kind:       kind,
+		start:      time.Now(),
+		attributes: make(map[string]interface{}),
+	}
+
+	parent, found := parentContext(ctx)
+	if found {
+		span.context.TraceID = parent.TraceID
+		span.context.Sampled = parent.Sampled
+		span.parentID = parent.SpanID
+	} else {
+		rand.Read(span.context.TraceID[:])
+		span.context.Sampled = ctx.Value(unsampledKey) == nil
+	}
+
+	rand.Read(span.context.SpanID[:])
+
+	return context.WithValue(ctx, spanContextKey, span), span
+}
+
+// Return the span context of the parent for a new span started from ctx.
+func parentContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.context, true
+	}
+
+	if remote, ok := ctx.Value(remoteContextKey).(SpanContext); ok && remote.IsValid() {
+		return remote, true
+	}
+
+	return SpanContext{}, false
+}
+
+// SpanFromContext returns the current span of ctx, or nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey).(*Span)
+	return span
+}
+
+// ContextWithRemoteParent returns a copy of ctx in which the next started span is a
+// child of the span of another service.
+func ContextWithRemoteParent(ctx context.Context, parent SpanContext) context.Context {
+	return context.WithValue(ctx, remoteContextKey, parent)
+}
+
+// Unsampled returns a copy of ctx in which new traces aren't exported, for frequent
+// operations like polling which would flood the collector.
+func Unsampled(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unsampledKey, true)
+}
+
+// SpanContext returns the propagated part of the span.
+func (span *Span) SpanContext() SpanContext {
+	return span.context
+}
+
+// SetName changes the name of the span, e.g. once the route of a request is known.
+func (span *Span) SetName(name string) {
+	span.mu.Lock()
+	defer span.mu.Unlock()
+
+	span.name = name
+}
+
+// SetAttribute sets an attribute of the span. Values should be strings, integers,
+// floats or booleans.
+func (span *Span) SetAttribute(key string, value interface{}) {
+	span.mu.Lock()
+	defer span.mu.Unlock()
+
+	span.attributes[key] = value
+}
+
+// RecordError marks the span as failed with the message of err. A nil err is ignored.
+func (span *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+
+	span.mu.Lock()
+	defer span.mu.Unlock()
+
+	span.err = err.Error()
+}
+
+// End records the end time of the span and hands it to the exporter. Calling End more
+// than once has no effect.
+func (span *Span) End() {
+	span.mu.Lock()
+	if span.ended {
+		span.mu.Unlock()
+		return
+	}
+
+	span.ended = true
+	span.end = time.Now()
+
+	data := SpanData{
+		Name:         span.name,
+		Kind:         span.kind,
+		TraceID:      span.context.TraceID,
+		SpanID:       span.context.SpanID,
+		ParentSpanID: span.parentID,
+		Start:        span.start,
+		End:          span.end,
+		Attributes:   make(map[string]interface{}, len(span.attributes)),
+		Error:        span.err,
+	}
+
+	for key, value := range span.attributes {
+		data.Attributes[key] = value
+	}
+	span.mu.Unlock()
+
+	if span.context.Sampled {
+		enqueue(data)
+	}
+}
+
+// ParseTraceparent parses a W3C traceparent header value, e.g.
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func ParseTraceparent(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}, false
+	}
+
+	// Version 00 has exactly four parts. Later versions may add more.
+	if parts[0] == "00" && len(parts) != 4 {
+		return SpanContext{}, false
+	}
+
+	var sc SpanContext
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}, false
+	}
+
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}, false
+	}
+
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return SpanContext{}, false
+	}
+
+	sc.Sampled = flags[0]&0x01 == 0x01
+
+	if !sc.IsValid() {
+		return SpanContext{}, false
+	}
+
+	return sc, true
+}
+
+// FormatTraceparent returns the W3C traceparent header value for sc.
+func FormatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+// Extract returns a copy of ctx with the remote parent from the traceparent header, if
+// the header is present and valid.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, ok := ParseTraceparent(header.Get("traceparent"))
+	if !ok {
+		return ctx
+	}
+
+	return ContextWithRemoteParent(ctx, sc)
+}
+
+// Inject sets the traceparent header for an outgoing request made from ctx.
+func Inject(ctx context.Context, header http.Header) {
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+
+	header.Set("traceparent", FormatTraceparent(span.context))
+}
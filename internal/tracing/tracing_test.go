@@ -0,0 +1,146 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+// Export the spans ended during the test to a new InMemoryExporter.
+func newExporter(t *testing.T) *InMemoryExporter {
+	exporter := NewInMemoryExporter()
+	SetExporter(exporter, nil)
+	t.Cleanup(func() { Shutdown(context.Background()) })
+
+	return exporter
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		valid   bool
+		sampled bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"not sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"other flags", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03", true, true},
+		{"spaces", " 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01 ", true, true},
+		{"later version with more parts", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"version 00 with more parts", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"invalid version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"zero trace id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"zero span id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"short trace id", "00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01", false, false},
+		{"not hex", "00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", false, false},
+		{"missing flags", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, false},
+		{"empty", "", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sc, ok := ParseTraceparent(test.value)
+			if ok != test.valid {
+				t.Fatalf("got valid %t, want %t", ok, test.valid)
+			}
+
+			if !ok {
+				return
+			}
+
+			if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+				t.Errorf("got trace %s span %s", sc.TraceID, sc.SpanID)
+			}
+
+			if sc.Sampled != test.sampled {
+				t.Errorf("got sampled %t, want %t", sc.Sampled, test.sampled)
+			}
+		})
+	}
+}
+
+func TestFormatTraceparent(t *testing.T) {
+	for _, value := range []string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+	} {
+		sc, ok := ParseTraceparent(value)
+		if !ok {
+			t.Fatalf("%s is invalid", value)
+		}
+
+		if got := FormatTraceparent(sc); got != value {
+			t.Errorf("got %s, want %s", got, value)
+		}
+	}
+}
+
+// A trace started by another service is continued, and passed on to the next one.
+func TestPropagation(t *testing.T) {
+	exporter := newExporter(t)
+
+	incoming := http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}}
+
+	ctx, server := Start(Extract(context.Background(), incoming), "GET /v1/movies", KindServer)
+	ctx, client := Start(ctx, "POST webhook", KindClient)
+
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+
+	client.RecordError(errors.New("connection refused"))
+	client.End()
+
+	// Ending a span twice exports it once.
+	server.End()
+	server.End()
+
+	sc, ok := ParseTraceparent(outgoing.Get("traceparent"))
+	if !ok || sc != client.SpanContext() {
+		t.Fatalf("got traceparent %q, want the client span %+v", outgoing.Get("traceparent"), client.SpanContext())
+	}
+
+	ForceFlush()
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+
+	got, want := spans[1], "00f067aa0ba902b7"
+	if got.Name != "GET /v1/movies" || got.Kind != KindServer || got.ParentSpanID.String() != want {
+		t.Errorf("got server span %+v, want a child of %s", got, want)
+	}
+
+	got = spans[0]
+	if got.Name != "POST webhook" || got.ParentSpanID != server.SpanContext().SpanID || got.Error != "connection refused" {
+		t.Errorf("got client span %+v, want a failed child of the server span", got)
+	}
+
+	for _, span := range spans {
+		if span.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("got trace %s for %s", span.TraceID, span.Name)
+		}
+	}
+}
+
+// A remote parent which isn't sampled, and unsampled new traces, export nothing.
+func TestUnsampled(t *testing.T) {
+	exporter := newExporter(t)
+
+	incoming := http.Header{"Traceparent": {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"}}
+	_, span := Start(Extract(context.Background(), incoming), "GET /v1/movies", KindServer)
+	span.End()
+
+	_, span = Start(Unsampled(context.Background()), "JobModel.Claim", KindClient)
+	span.End()
+
+	// An invalid header starts a new trace.
+	_, span = Start(Extract(context.Background(), http.Header{"Traceparent": {"garbage"}}), "GET /v1/healthcheck", KindServer)
+	span.End()
+
+	ForceFlush()
+	spans := exporter.Spans()
+	if len(spans) != 1 || spans[0].Name != "GET /v1/healthcheck" || spans[0].ParentSpanID.IsValid() {
+		t.Fatalf("got spans %+v, want the new trace only", spans)
+	}
+}
@@ -14,3 +14,13 @@ func ValidateFilters(v *Validator, f data.Filters) {
 	// Check the sort parameter matches a value from safe list.
 	v.Check(In(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
 }
+
+// The ValidateFacets() function checks every requested facet
+// is one that can be calculated.
+func ValidateFacets(v *Validator, facets []string) {
+	for _, facet := range facets {
+		v.Check(In(facet, data.FacetSafeList...), "facets", "invalid facet value")
+	}
+
+	v.Check(Unique(facets), "facets", "must not contain duplicate values")
+}
@@ -26,3 +26,26 @@ func MovieValidator(v *Validator, movie *data.Movie) {
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
 	v.Check(Unique(movie.Genres), "genres", "must not contain duplicate values")
 }
+
+// The ValidateSuggestion() function checks query
+// and limit parameters of a typeahead request.
+func ValidateSuggestion(v *Validator, query string, limit int) {
+	v.Check(query != "", "q", "must be provided")
+	v.Check(len(query) <= 100, "q", "must not be more than 100 bytes long")
+
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 10, "limit", "must not be more than 10")
+}
+
+// Fields of a movie which a client can ask for with the fields query parameter.
+var MovieFieldSafeList = []string{"id", "title", "year", "runtime", "genres", "version"}
+
+// The ValidateMovieFields() function checks every requested
+// field is in MovieFieldSafeList.
+func ValidateMovieFields(v *Validator, fields []string) {
+	for _, field := range fields {
+		v.Check(In(field, MovieFieldSafeList...), "fields", "invalid field value")
+	}
+
+	v.Check(Unique(fields), "fields", "must not contain duplicate values")
+}
@@ -0,0 +1,26 @@
+package validator
+
+import (
+	"Meow/internal/data"
+	"net/url"
+)
+
+// The ValidateWebhook() function checks the URL, events and secret of a webhook.
+func ValidateWebhook(v *Validator, webhook *data.Webhook) {
+	// URL
+	u, err := url.Parse(webhook.URL)
+	v.Check(webhook.URL != "", "url", "must be provided")
+	v.Check(len(webhook.URL) <= 2048, "url", "must not be more than 2048 bytes long")
+	v.Check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "url", "must be an http:// or https:// URL")
+
+	// Events
+	v.Check(len(webhook.Events) >= 1, "events", "must contain at least 1 event")
+	for _, event := range webhook.Events {
+		v.Check(In(event, data.EventSafeList...), "events", "invalid event value")
+	}
+	v.Check(Unique(webhook.Events), "events", "must not contain duplicate values")
+
+	// Secret
+	v.Check(len(webhook.Secret) >= 16, "secret", "must be at least 16 bytes long")
+	v.Check(len(webhook.Secret) <= 200, "secret", "must not be more than 200 bytes long")
+}
@@ -0,0 +1,283 @@
+// Package webhooks delivers movie events to the HTTP endpoints registered as
+// webhooks. Events are written to the outbox table in the same transaction as the
+// change of the movie. A Relay hands them over to the job queue, one job per webhook,
+// and the jobs send them with a Sender, so failed deliveries are retried with the
+// backoff of the job queue.
+package webhooks
+
+import (
+	"Meow/internal/data"
+	"Meow/internal/metrics"
+	"Meow/internal/tracing"
+	jlog "Meow/log"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Count the dispatched events and the outcome of every delivery.
+var (
+	dispatched = metrics.NewCounterVec("webhook_events_dispatched_total", "Total number of events handed over to webhooks.")
+	deliveries = metrics.NewCounterVec("webhook_deliveries_total", "Total number of webhook delivery attempts by outcome.", "outcome")
+	durations  = metrics.NewHistogramVec("webhook_delivery_duration_seconds", "Duration of webhook delivery attempts.",
+		[]float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30})
+)
+
+// The headers of a delivery. Receivers should use EventIDHeader to drop events they
+// received before, since an event is delivered again if the response was lost.
+const (
+	SignatureHeader = "X-Meow-Signature"
+	EventHeader     = "X-Meow-Event"
+	EventIDHeader   = "X-Meow-Event-Id"
+)
+
+// The size of the start of a response body which is kept in the delivery log.
+const maxResponseBody = 1024
+
+var (
+	// ErrInvalidSignature is returned by Verify() when a signature doesn't match.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+
+	// ErrExpiredSignature is returned by Verify() when a signature is too old.
+	ErrExpiredSignature = errors.New("expired webhook signature")
+)
+
+// The Sign() function returns the signature header of body sent at timestamp, like
+// "t=1700000000,v1=5257a869...". v1 is the hex encoded HMAC-SHA256 of the timestamp,
+// a dot and the body, keyed with the secret of the webhook.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + unix + ",v1=" + signature(secret, unix, body)
+}
+
+// The Verify() function checks a signature header made by Sign() for body, and that
+// it was made at most tolerance ago, so captured requests can't be replayed later.
+func Verify(secret string, header string, body []byte, tolerance time.Duration) error {
+	var unix, signed string
+
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			unix = value
+		case "v1":
+			signed = value
+		}
+	}
+
+	timestamp, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil || signed == "" {
+		return ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(signed), []byte(signature(secret, unix, body))) {
+		return ErrInvalidSignature
+	}
+
+	if time.Since(time.Unix(timestamp, 0)) > tolerance {
+		return ErrExpiredSignature
+	}
+
+	return nil
+}
+
+// Return the hex encoded HMAC-SHA256 of "<unix>.<body>".
+func signature(secret string, unix string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unix))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// A Sender sends events to webhooks.
+type Sender struct {
+	client *http.Client
+}
+
+// The NewSender() function returns a Sender whose requests time out after timeout.
+// Redirects aren't followed, so a webhook must be registered with its final URL.
+func NewSender(timeout time.Duration) *Sender {
+	return &Sender{
+		client: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Send POSTs event to webhook as JSON and returns the log of the attempt. The error is
+// non-nil unless the webhook responded with a 2xx status code.
+func (sender *Sender) Send(ctx context.Context, webhook *data.Webhook, event *data.Event) (*data.Delivery, error) {
+	delivery := &data.Delivery{
+		WebhookID: webhook.ID,
+		EventID:   event.ID,
+		Event:     event.Type,
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return delivery, err
+	}
+
+	ctx, span := tracing.Start(ctx, "POST webhook", tracing.KindClient)
+	defer span.End()
+
+	span.SetAttribute("http.method", http.MethodPost)
+	span.SetAttribute("http.url", webhook.URL)
+	span.SetAttribute("webhook.id", webhook.ID)
+
+	start := time.Now()
+	err = sender.post(ctx, webhook, event, body, delivery)
+	duration := time.Since(start)
+
+	delivery.DurationMs = duration.Milliseconds()
+	durations.Observe(duration.Seconds())
+
+	if delivery.StatusCode != 0 {
+		span.SetAttribute("http.status_code", delivery.StatusCode)
+	}
+
+	if err != nil {
+		span.RecordError(err)
+		delivery.Error = err.Error()
+		deliveries.Inc("failed")
+		return delivery, err
+	}
+
+	deliveries.Inc("delivered")
+	return delivery, nil
+}
+
+// Make the request of a delivery and record the response in delivery.
+func (sender *Sender) post(ctx context.Context, webhook *data.Webhook, event *data.Event, body []byte, delivery *data.Delivery) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "Meow-Webhooks/1.0")
+	request.Header.Set(EventHeader, event.Type)
+	request.Header.Set(EventIDHeader, strconv.FormatInt(event.ID, 10))
+	request.Header.Set(SignatureHeader, Sign(webhook.Secret, time.Now(), body))
+	tracing.Inject(ctx, request.Header)
+
+	response, err := sender.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	delivery.StatusCode = response.StatusCode
+
+	// Keep the start of the body for the log, and read a little more of the rest so
+	// the connection can be reused.
+	start, _ := io.ReadAll(io.LimitReader(response.Body, maxResponseBody))
+	delivery.ResponseBody = strings.ToValidUTF8(string(start), "")
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", response.Status)
+	}
+
+	return nil
+}
+
+// Dispatcher is the part of the events model used by the Relay.
+type Dispatcher interface {
+	Dispatch(context.Context, string, string, int, int) (int64, int64, error)
+}
+
+// RelayOptions configures a Relay.
+type RelayOptions struct {
+	// The queue, kind and attempts of the delivery jobs.
+	Queue       string
+	Kind        string
+	MaxAttempts int
+
+	// The number of events dispatched at once.
+	BatchSize int
+
+	// How long the relay sleeps when there are no events.
+	Interval time.Duration
+}
+
+// A Relay dispatches the events in the outbox table to the job queue. Every server
+// runs one; they skip the events another one is dispatching.
+type Relay struct {
+	store   Dispatcher
+	logger  *jlog.Logger
+	options RelayOptions
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// The NewRelay() function returns a Relay which dispatches the events of store.
+func NewRelay(store Dispatcher, logger *jlog.Logger, options RelayOptions) *Relay {
+	return &Relay{
+		store:   store,
+		logger:  logger,
+		options: options,
+		stop:    make(chan struct{}),
+	}
+}
+
+// Run dispatches events until Stop() is called or ctx is done.
+func (relay *Relay) Run(ctx context.Context) {
+	for {
+		select {
+		case <-relay.stop:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		// Polling the outbox isn't traced. The deliveries continue the traces of the
+		// requests which changed the movies.
+		events, jobs, err := relay.store.Dispatch(tracing.Unsampled(ctx), relay.options.Queue, relay.options.Kind, relay.options.MaxAttempts, relay.options.BatchSize)
+		switch {
+		case err != nil && ctx.Err() == nil:
+			relay.logger.Error(err, jlog.Fields{"event": "dispatching events failed"})
+		case events > 0:
+			dispatched.Add(float64(events))
+			relay.logger.Debug("events dispatched", jlog.Fields{"events": events, "deliveries": jobs})
+
+			// There may be more events waiting.
+			if events == int64(relay.options.BatchSize) {
+				continue
+			}
+		}
+
+		select {
+		case <-relay.stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-time.After(relay.options.Interval):
+		}
+	}
+}
+
+// Stop makes Run() return.
+func (relay *Relay) Stop() {
+	relay.stopOnce.Do(func() {
+		close(relay.stop)
+	})
+}
@@ -0,0 +1,177 @@
+package webhooks
+
+import (
+	"Meow/internal/data"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"id":1,"type":"movie.created"}`)
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		secret    string
+		header    string
+		body      []byte
+		tolerance time.Duration
+		want      error
+	}{
+		{"valid", "secret", Sign("secret", now, body), body, 5 * time.Minute, nil},
+		{"tampered body", "secret", Sign("secret", now, body), []byte(`{"id":2,"type":"movie.created"}`), 5 * time.Minute, ErrInvalidSignature},
+		{"wrong secret", "other", Sign("secret", now, body), body, 5 * time.Minute, ErrInvalidSignature},
+		{"expired", "secret", Sign("secret", now.Add(-10*time.Minute), body), body, 5 * time.Minute, ErrExpiredSignature},
+		{"tampered timestamp", "secret", strings.Replace(Sign("secret", now.Add(-10*time.Minute), body), "t=", "t=1", 1), body, 5 * time.Minute, ErrInvalidSignature},
+		{"no signature", "secret", "t=1700000000", body, 5 * time.Minute, ErrInvalidSignature},
+		{"garbage", "secret", "nonsense", body, 5 * time.Minute, ErrInvalidSignature},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Verify(test.secret, test.header, test.body, test.tolerance)
+			if !errors.Is(err, test.want) {
+				t.Fatalf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+// A receiver records the requests it gets and responds with status and body.
+type receiver struct {
+	status int
+	body   string
+
+	requests chan *http.Request
+	bodies   chan []byte
+}
+
+func newReceiver(t *testing.T, status int, body string) (*receiver, *httptest.Server) {
+	receiver := &receiver{status: status, body: body, requests: make(chan *http.Request, 1), bodies: make(chan []byte, 1)}
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		content, _ := io.ReadAll(request.Body)
+		receiver.requests <- request
+		receiver.bodies <- content
+
+		writer.WriteHeader(receiver.status)
+		io.WriteString(writer, receiver.body)
+	}))
+	t.Cleanup(server.Close)
+
+	return receiver, server
+}
+
+func testEvent() *data.Event {
+	return &data.Event{
+		ID:      42,
+		Type:    data.EventMovieCreated,
+		MovieID: 7,
+		Data:    json.RawMessage(`{"movie":{"id":7,"title":"Casablanca"}}`),
+	}
+}
+
+func TestSendHeaders(t *testing.T) {
+	receiver, server := newReceiver(t, http.StatusNoContent, "")
+
+	webhook := &data.Webhook{ID: 3, URL: server.URL, Secret: "s3cret", Active: true}
+
+	delivery, err := NewSender(5*time.Second).Send(context.Background(), webhook, testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request, body := <-receiver.requests, <-receiver.bodies
+
+	if request.Method != http.MethodPost {
+		t.Errorf("got method %s, want POST", request.Method)
+	}
+
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		EventHeader:    data.EventMovieCreated,
+		EventIDHeader:  "42",
+	}
+	for name, want := range headers {
+		if got := request.Header.Get(name); got != want {
+			t.Errorf("got %s %q, want %q", name, got, want)
+		}
+	}
+
+	err = Verify("s3cret", request.Header.Get(SignatureHeader), body, time.Minute)
+	if err != nil {
+		t.Errorf("signature: %v", err)
+	}
+
+	var event data.Event
+	if err := json.Unmarshal(body, &event); err != nil || event.ID != 42 || event.Type != data.EventMovieCreated {
+		t.Errorf("got body %s (%v)", body, err)
+	}
+
+	if delivery.WebhookID != 3 || delivery.EventID != 42 || delivery.StatusCode != http.StatusNoContent || delivery.Error != "" {
+		t.Errorf("got delivery %+v", delivery)
+	}
+}
+
+func TestSendFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{"server error", http.StatusInternalServerError},
+		{"gone", http.StatusGone},
+		{"redirect", http.StatusFound},
+		{"unauthorized", http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, server := newReceiver(t, test.status, "nope")
+
+			delivery, err := NewSender(5*time.Second).Send(context.Background(), &data.Webhook{ID: 1, URL: server.URL}, testEvent())
+			if err == nil {
+				t.Fatal("got no error")
+			}
+
+			if delivery.StatusCode != test.status || delivery.Error == "" || delivery.ResponseBody != "nope" {
+				t.Errorf("got delivery %+v", delivery)
+			}
+		})
+	}
+}
+
+func TestSendTruncatesResponseBody(t *testing.T) {
+	_, server := newReceiver(t, http.StatusOK, strings.Repeat("a", 3*maxResponseBody))
+
+	delivery, err := NewSender(5*time.Second).Send(context.Background(), &data.Webhook{ID: 1, URL: server.URL}, testEvent())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(delivery.ResponseBody) != maxResponseBody {
+		t.Errorf("got %d bytes of the response body, want %d", len(delivery.ResponseBody), maxResponseBody)
+	}
+}
+
+func TestSendTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	t.Cleanup(server.Close)
+
+	delivery, err := NewSender(50*time.Millisecond).Send(context.Background(), &data.Webhook{ID: 1, URL: server.URL}, testEvent())
+	if err == nil {
+		t.Fatal("got no error")
+	}
+
+	if delivery.StatusCode != 0 || delivery.Error == "" {
+		t.Errorf("got delivery %+v", delivery)
+	}
+}
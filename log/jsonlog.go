@@ -1,11 +1,13 @@
 package jlog
 
 import (
-	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,104 +16,304 @@ type Level int8
 
 // Initialize constants which represent a specific severity level.
 const (
-	LevelInfo  Level = iota // 0
-	LevelError              // 1
-	LevelFatal              // 2
-	LevelOff                // 3
+	LevelDebug Level = iota // 0
+	LevelInfo               // 1
+	LevelWarn               // 2
+	LevelError              // 3
+	LevelFatal              // 4
+	LevelOff                // 5
 )
 
 // Return a human-friendly string for the severity level.
 func (level Level) String() string {
 	switch level {
+	case LevelDebug:
+		return "DEBUG"
 	case LevelInfo:
 		return "INFO"
+	case LevelWarn:
+		return "WARN"
 	case LevelError:
 		return "ERROR"
 	case LevelFatal:
 		return "FATAL"
+	case LevelOff:
+		return "OFF"
 	default:
 		return ""
 	}
 }
 
-// Define a custom Logger type. This holds the output destination that the log entries
-// will be written to, the minimum severity level that log entries will be written for,
-// plus a mutex for coordinating the writes.
-type Logger struct {
-	out      io.Writer
-	minLevel Level
+// ParseLevel returns the level for a name such as "debug" or "WARN".
+func ParseLevel(name string) (Level, error) {
+	for level := LevelDebug; level <= LevelOff; level++ {
+		if strings.EqualFold(name, level.String()) {
+			return level, nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// Fields holds the typed properties of a log entry. Values are encoded as JSON, except
+// errors which are written as their message.
+type Fields map[string]interface{}
+
+// The output holds everything shared by a logger and its children: the sink receiving
+// the entries, the minimum severity level, the sampler, plus a mutex for coordinating
+// the writes.
+type output struct {
+	sink     Sink
+	minLevel atomic.Int32
+	sampler  atomic.Pointer[sampler]
 	mu       sync.Mutex
 }
 
+// Define a custom Logger type. Loggers created with With() share the output of their
+// parent and add their own fields to every entry.
+type Logger struct {
+	output *output
+	fields Fields
+}
+
 // Return a new Logger instance which writes log entries at or above a minimum severity
-// level to a specific output destination.
+// level to a specific output destination as JSON lines.
 func New(out io.Writer, minLevel Level) *Logger {
+	return NewWithSink(NewJSONSink(out), minLevel)
+}
+
+// Return a new Logger instance which hands log entries at or above a minimum severity
+// level to sink.
+func NewWithSink(sink Sink, minLevel Level) *Logger {
+	logger := &Logger{
+		output: &output{sink: sink},
+	}
+	logger.output.minLevel.Store(int32(minLevel))
+
+	return logger
+}
+
+// Close flushes and closes the sink of the logger. Entries written afterwards are lost.
+func (logger *Logger) Close() error {
+	logger.output.mu.Lock()
+	defer logger.output.mu.Unlock()
+
+	return logger.output.sink.Close()
+}
+
+// With returns a child logger which adds fields to every entry. The child shares the
+// output, level and sampling of its parent.
+func (logger *Logger) With(fields Fields) *Logger {
+	merged := make(Fields, len(logger.fields)+len(fields))
+	for key, value := range logger.fields {
+		merged[key] = value
+	}
+
+	for key, value := range fields {
+		merged[key] = value
+	}
+
 	return &Logger{
-		out:      out,
-		minLevel: minLevel,
+		output: logger.output,
+		fields: merged,
 	}
 }
 
+// SetLevel changes the minimum severity level at runtime, for the logger and every
+// logger sharing its output.
+func (logger *Logger) SetLevel(level Level) {
+	logger.output.minLevel.Store(int32(level))
+}
+
+// Level returns the current minimum severity level.
+func (logger *Logger) Level() Level {
+	return Level(logger.output.minLevel.Load())
+}
+
+// Enabled reports whether entries at level are written.
+func (logger *Logger) Enabled(level Level) bool {
+	return level >= logger.Level() && level < LevelOff
+}
+
+// Declare Debug, Info, Warn and Error helper methods for writing entries with typed
+// fields.
+func (logger *Logger) Debug(message string, fields Fields) {
+	logger.print(LevelDebug, message, fields, false)
+}
+
+func (logger *Logger) Info(message string, fields Fields) {
+	logger.print(LevelInfo, message, fields, false)
+}
+
+func (logger *Logger) Warn(message string, fields Fields) {
+	logger.print(LevelWarn, message, fields, false)
+}
+
+func (logger *Logger) Error(err error, fields Fields) {
+	logger.print(LevelError, err.Error(), fields, false)
+}
+
+// ErrorStack writes an entry at the ERROR level including a stack trace. Use it for
+// unexpected failures such as recovered panics, where the trace is worth its size.
+func (logger *Logger) ErrorStack(err error, fields Fields) {
+	logger.print(LevelError, err.Error(), fields, true)
+}
+
 // Declare PrintInfo helper methods for writing log entries at the INFO level.
 func (logger *Logger) PrintInfo(message string, properties map[string]string) {
-	logger.print(LevelInfo, message, properties)
+	logger.print(LevelInfo, message, toFields(properties), false)
 }
 
 // Declare PrintError helper methods for writing log entries at the ERROR level.
 func (logger *Logger) PrintError(err error, properties map[string]string) {
-	logger.print(LevelError, err.Error(), properties)
+	logger.print(LevelError, err.Error(), toFields(properties), false)
 }
 
 // Declare PrintFatal helper methods for writing log entries at the FATAL level.
 func (logger *Logger) PrintFatal(err error, properties map[string]string) {
-	logger.print(LevelFatal, err.Error(), properties)
+	logger.print(LevelFatal, err.Error(), toFields(properties), true)
+	logger.Close()
 	os.Exit(1)
 }
 
+// Convert string properties to fields.
+func toFields(properties map[string]string) Fields {
+	if properties == nil {
+		return nil
+	}
+
+	fields := make(Fields, len(properties))
+	for key, value := range properties {
+		fields[key] = value
+	}
+
+	return fields
+}
+
 // Print is an internal method for writing the log entry.
-func (logger *Logger) print(level Level, message string, properties map[string]string) (int, error) {
+func (logger *Logger) print(level Level, message string, fields Fields, trace bool) error {
 	// If the severity level of the log entry is below the minimum severity for the
 	// logger, then return with no further action.
-	if logger.minLevel > level {
-		return 0, nil
-	}
-
-	// Declare an struct holding the data for the log entry.
-	st := struct {
-		Level      string            `json:"level"`
-		Time       string            `json:"time"`
-		Message    string            `json:"message"`
-		Properties map[string]string `json:"properties,omitempty"`
-		Trace      string            `json:"trace,omitempty"`
-	}{
-		Level:      level.String(),
-		Time:       time.Now().UTC().Format(time.RFC3339),
-		Message:    message,
-		Properties: properties,
+	if !logger.Enabled(level) {
+		return nil
 	}
 
-	// Include a stack trace for entries at the ERROR and FATAL levels.
-	if level >= LevelError {
-		st.Trace = string(debug.Stack())
+	// Drop the entry if the sampler decides this message is too noisy.
+	if s := logger.output.sampler.Load(); s != nil && level < LevelError && !s.allow(level, message) {
+		return nil
 	}
 
-	// Marshal the anonymous struct to JSON and store it in the line variable.
-	line, err := json.Marshal(st)
-	if err != nil {
-		line = []byte(LevelError.String() + ": unable to marshal log message: " + err.Error())
+	// Merge the fields of the logger with the fields of the entry.
+	properties := make(map[string]interface{}, len(logger.fields)+len(fields))
+	for key, value := range logger.fields {
+		properties[key] = fieldValue(value)
+	}
+
+	for key, value := range fields {
+		properties[key] = fieldValue(value)
+	}
+
+	entry := Entry{
+		Level:      level,
+		Time:       time.Now().UTC(),
+		Message:    message,
+		Properties: properties,
+	}
+
+	// Include a stack trace for FATAL entries and when the caller asked for it.
+	if trace {
+		entry.Trace = string(debug.Stack())
 	}
 
-	// Lock the mutex so that no two writes to the output destination can happen
-	// concurrently.
-	logger.mu.Lock()
-	defer logger.mu.Unlock()
+	// Lock the mutex so that no two writes to the sink can happen concurrently.
+	logger.output.mu.Lock()
+	defer logger.output.mu.Unlock()
 
 	// Log the write entry.
-	return logger.out.Write(append(line, '\n'))
+	return logger.output.sink.WriteEntry(entry)
+}
+
+// Convert values which don't encode well as JSON.
+func fieldValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case error:
+		return value.Error()
+	case time.Duration:
+		return value.String()
+	case fmt.Stringer:
+		return value.String()
+	default:
+		return value
+	}
 }
 
 // We also implement a Write() method on our Logger type so that it satisfies the
 // io.Writer interface.
 func (logger *Logger) Write(message []byte) (int, error) {
-	return logger.print(LevelError, string(message), nil)
+	err := logger.print(LevelError, strings.TrimSuffix(string(message), "\n"), nil, false)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(message), nil
+}
+
+// SetSampling limits how often the same message is written at levels below ERROR.
+// Within every tick the first initial entries with the same level and message are
+// written, then only every thereafter-th one. An initial of zero turns sampling off.
+func (logger *Logger) SetSampling(initial int, thereafter int, tick time.Duration) {
+	if initial <= 0 {
+		logger.output.sampler.Store(nil)
+		return
+	}
+
+	logger.output.sampler.Store(&sampler{
+		initial:    initial,
+		thereafter: thereafter,
+		tick:       tick,
+		counts:     make(map[string]*sampleCount),
+	})
+}
+
+// The sampler counts entries per level and message.
+type sampler struct {
+	initial    int
+	thereafter int
+	tick       time.Duration
+
+	mu     sync.Mutex
+	counts map[string]*sampleCount
+}
+
+// Count of entries with the same level and message since start.
+type sampleCount struct {
+	start time.Time
+	n     int
+}
+
+// Report whether an entry with level and message should be written.
+func (s *sampler) allow(level Level, message string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	key := level.String() + "\x00" + message
+
+	count, found := s.counts[key]
+	if !found || now.Sub(count.start) >= s.tick {
+		// Don't let unique messages grow the map forever.
+		if !found && len(s.counts) >= 10_000 {
+			s.counts = make(map[string]*sampleCount)
+		}
+
+		count = &sampleCount{start: now}
+		s.counts[key] = count
+	}
+
+	count.n++
+	if count.n <= s.initial {
+		return true
+	}
+
+	return s.thereafter > 0 && (count.n-s.initial)%s.thereafter == 0
 }
@@ -0,0 +1,274 @@
+package jlog
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The layout of the time added to the names of rotated files. It sorts in time order.
+const backupTimeLayout = "20060102T150405.000"
+
+// RotateOptions controls when a RotatingFile starts a new file and how long old ones
+// are kept. Zero values turn the corresponding behaviour off.
+type RotateOptions struct {
+	// MaxSize is the size in bytes a file may reach before it is rotated.
+	MaxSize int64
+	// Interval is the time after which a file is rotated, e.g. 24h.
+	Interval time.Duration
+	// MaxAge is how long rotated files are kept.
+	MaxAge time.Duration
+	// MaxBackups is how many rotated files are kept.
+	MaxBackups int
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// RotatingFile is an io.WriteCloser appending to a file which is renamed once it gets
+// too big or too old, e.g. "api.log" becomes "api-20240102T150405.000.log". Rotated
+// files are compressed and removed by a background goroutine.
+type RotatingFile struct {
+	path    string
+	options RotateOptions
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	cleanup chan struct{}
+	done    chan struct{}
+}
+
+// OpenRotatingFile opens path for appending, creating it and its directory if needed.
+func OpenRotatingFile(path string, options RotateOptions) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		path:    path,
+		options: options,
+		cleanup: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+
+	go rf.runCleanup()
+
+	// Apply the retention rules to files left by earlier runs.
+	rf.cleanup <- struct{}{}
+
+	return rf, nil
+}
+
+// Open the file for appending and record its current size.
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+	rf.openedAt = time.Now()
+
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p doesn't fit or the file is too
+// old.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if rf.shouldRotate(int64(len(p))) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+
+	return n, err
+}
+
+// Report whether the file must be rotated before writing size more bytes. An empty file
+// is never rotated, so a single entry larger than MaxSize still gets written.
+func (rf *RotatingFile) shouldRotate(size int64) bool {
+	if rf.size == 0 {
+		return false
+	}
+
+	if rf.options.MaxSize > 0 && rf.size+size > rf.options.MaxSize {
+		return true
+	}
+
+	return rf.options.Interval > 0 && time.Since(rf.openedAt) >= rf.options.Interval
+}
+
+// Rotate renames the current file and opens a new one.
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(rf.path, rf.backupName(time.Now())); err != nil {
+		return err
+	}
+
+	if err := rf.open(); err != nil {
+		rf.file = nil
+		return err
+	}
+
+	select {
+	case rf.cleanup <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Return the name of a rotated file, e.g. "logs/api-20240102T150405.000.log".
+func (rf *RotatingFile) backupName(t time.Time) string {
+	ext := filepath.Ext(rf.path)
+	prefix := strings.TrimSuffix(rf.path, ext)
+
+	return prefix + "-" + t.UTC().Format(backupTimeLayout) + ext
+}
+
+// Close closes the file and waits for a running cleanup to finish.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+
+	close(rf.cleanup)
+	<-rf.done
+
+	err := rf.file.Close()
+	rf.file = nil
+
+	return err
+}
+
+// Compress and remove rotated files whenever a rotation happens.
+func (rf *RotatingFile) runCleanup() {
+	defer close(rf.done)
+
+	for range rf.cleanup {
+		rf.compressAndPrune()
+	}
+}
+
+// A rotatedFile is a file created by rotation and the time it was rotated.
+type rotatedFile struct {
+	path string
+	time time.Time
+}
+
+// Return the rotated files of rf, newest first.
+func (rf *RotatingFile) rotatedFiles() ([]rotatedFile, error) {
+	ext := filepath.Ext(rf.path)
+	prefix := filepath.Base(strings.TrimSuffix(rf.path, ext)) + "-"
+	dir := filepath.Dir(rf.path)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []rotatedFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".gz"), ext)
+		t, err := time.Parse(backupTimeLayout, stamp)
+		if err != nil {
+			continue
+		}
+
+		files = append(files, rotatedFile{path: filepath.Join(dir, name), time: t})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].time.After(files[j].time)
+	})
+
+	return files, nil
+}
+
+// Remove rotated files beyond MaxBackups or older than MaxAge, and gzip the rest if
+// Compress is set. Errors are ignored; the next rotation tries again.
+func (rf *RotatingFile) compressAndPrune() {
+	files, err := rf.rotatedFiles()
+	if err != nil {
+		return
+	}
+
+	for i, file := range files {
+		tooMany := rf.options.MaxBackups > 0 && i >= rf.options.MaxBackups
+		tooOld := rf.options.MaxAge > 0 && time.Since(file.time) > rf.options.MaxAge
+
+		if tooMany || tooOld {
+			os.Remove(file.path)
+			continue
+		}
+
+		if rf.options.Compress && !strings.HasSuffix(file.path, ".gz") {
+			gzipFile(file.path)
+		}
+	}
+}
+
+// Replace path with a gzipped copy named path + ".gz".
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	writer := gzip.NewWriter(out)
+	_, err = io.Copy(writer, in)
+
+	err = errors.Join(err, writer.Close(), out.Close())
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,264 @@
+package jlog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Entry is a single log entry as handed to sinks.
+type Entry struct {
+	Level      Level
+	Time       time.Time
+	Message    string
+	Properties map[string]interface{}
+	Trace      string
+}
+
+// Sink receives log entries and writes them somewhere, like a terminal, a file or a
+// syslog daemon. The logger never calls WriteEntry concurrently.
+type Sink interface {
+	WriteEntry(entry Entry) error
+	Close() error
+}
+
+// Return the JSON line of an entry, ending with a newline.
+func marshalEntry(entry Entry) []byte {
+	// Declare an struct holding the data for the log entry.
+	st := struct {
+		Level      string                 `json:"level"`
+		Time       string                 `json:"time"`
+		Message    string                 `json:"message"`
+		Properties map[string]interface{} `json:"properties,omitempty"`
+		Trace      string                 `json:"trace,omitempty"`
+	}{
+		Level:      entry.Level.String(),
+		Time:       entry.Time.Format(time.RFC3339),
+		Message:    entry.Message,
+		Properties: entry.Properties,
+		Trace:      entry.Trace,
+	}
+
+	// Marshal the anonymous struct to JSON and store it in the line variable.
+	line, err := json.Marshal(st)
+	if err != nil {
+		line = []byte(LevelError.String() + ": unable to marshal log message: " + err.Error())
+	}
+
+	return append(line, '\n')
+}
+
+// The writerSink writes formatted entries to an io.Writer. It doesn't own the writer,
+// so Close() leaves it open; close files after the logger instead.
+type writerSink struct {
+	out    io.Writer
+	format func(entry Entry) []byte
+}
+
+// NewJSONSink returns a sink which writes every entry as a line of JSON to out.
+func NewJSONSink(out io.Writer) Sink {
+	return &writerSink{out: out, format: marshalEntry}
+}
+
+// NewConsoleSink returns a sink which writes human-readable lines to out, e.g.
+//
+//	2024-01-02T15:04:05Z INFO  request completed method=GET status=200
+//
+// meant for a terminal during development. Levels are coloured if colour is true.
+func NewConsoleSink(out io.Writer, colour bool) Sink {
+	return &writerSink{out: out, format: func(entry Entry) []byte {
+		return formatConsole(entry, colour)
+	}}
+}
+
+// WriteEntry formats the entry and writes it.
+func (sink *writerSink) WriteEntry(entry Entry) error {
+	_, err := sink.out.Write(sink.format(entry))
+	return err
+}
+
+// Close does nothing, see writerSink.
+func (sink *writerSink) Close() error {
+	return nil
+}
+
+// Define the ANSI colours of the levels in the console format.
+var levelColours = map[Level]string{
+	LevelDebug: "\x1b[90m",
+	LevelInfo:  "\x1b[36m",
+	LevelWarn:  "\x1b[33m",
+	LevelError: "\x1b[31m",
+	LevelFatal: "\x1b[35m",
+}
+
+// Format an entry for the console sink. Properties are sorted by key so lines of the
+// same kind line up.
+func formatConsole(entry Entry, colour bool) []byte {
+	var b strings.Builder
+
+	b.WriteString(entry.Time.Format(time.RFC3339))
+	b.WriteByte(' ')
+
+	level := fmt.Sprintf("%-5s", entry.Level.String())
+	if colour {
+		b.WriteString(levelColours[entry.Level] + level + "\x1b[0m")
+	} else {
+		b.WriteString(level)
+	}
+
+	b.WriteByte(' ')
+	b.WriteString(entry.Message)
+
+	keys := make([]string, 0, len(entry.Properties))
+	for key := range entry.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := fmt.Sprint(entry.Properties[key])
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+
+		b.WriteByte(' ')
+		if colour {
+			b.WriteString("\x1b[2m" + key + "=\x1b[0m" + value)
+		} else {
+			b.WriteString(key + "=" + value)
+		}
+	}
+
+	b.WriteByte('\n')
+
+	if entry.Trace != "" {
+		b.WriteString(entry.Trace)
+		if !strings.HasSuffix(entry.Trace, "\n") {
+			b.WriteByte('\n')
+		}
+	}
+
+	return []byte(b.String())
+}
+
+// The multiSink hands every entry to several sinks.
+type multiSink struct {
+	sinks []Sink
+}
+
+// NewMultiSink returns a sink which writes every entry to all of sinks. A failing sink
+// doesn't stop the entry reaching the others; wrap slow sinks with NewAsyncSink() so
+// they don't hold up the rest either.
+func NewMultiSink(sinks ...Sink) Sink {
+	return &multiSink{sinks: sinks}
+}
+
+// WriteEntry writes the entry to every sink and returns their errors joined.
+func (sink *multiSink) WriteEntry(entry Entry) error {
+	var errs []error
+	for _, s := range sink.sinks {
+		if err := s.WriteEntry(entry); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Close closes every sink.
+func (sink *multiSink) Close() error {
+	var errs []error
+	for _, s := range sink.sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// AsyncSink writes entries to another sink from a background goroutine, so a slow or
+// unreachable destination never blocks the goroutine logging. Entries arriving while
+// the queue is full are dropped and counted.
+type AsyncSink struct {
+	sink    Sink
+	queue   chan Entry
+	done    chan struct{}
+	onError func(error)
+	dropped atomic.Int64
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewAsyncSink returns an AsyncSink queueing up to size entries for sink. Errors of
+// sink are passed to onError, which may be nil.
+func NewAsyncSink(sink Sink, size int, onError func(error)) *AsyncSink {
+	async := &AsyncSink{
+		sink:    sink,
+		queue:   make(chan Entry, size),
+		done:    make(chan struct{}),
+		onError: onError,
+	}
+
+	go async.run()
+
+	return async
+}
+
+// Write the queued entries to the sink until the queue is closed.
+func (async *AsyncSink) run() {
+	defer close(async.done)
+
+	for entry := range async.queue {
+		if err := async.sink.WriteEntry(entry); err != nil && async.onError != nil {
+			async.onError(err)
+		}
+	}
+}
+
+// WriteEntry queues the entry without waiting.
+func (async *AsyncSink) WriteEntry(entry Entry) error {
+	async.mu.RLock()
+	defer async.mu.RUnlock()
+
+	if async.closed {
+		return nil
+	}
+
+	select {
+	case async.queue <- entry:
+	default:
+		async.dropped.Add(1)
+	}
+
+	return nil
+}
+
+// Dropped returns the number of entries dropped because the queue was full.
+func (async *AsyncSink) Dropped() int64 {
+	return async.dropped.Load()
+}
+
+// Close writes the queued entries and closes the sink.
+func (async *AsyncSink) Close() error {
+	async.mu.Lock()
+	if async.closed {
+		async.mu.Unlock()
+		return nil
+	}
+
+	async.closed = true
+	close(async.queue)
+	async.mu.Unlock()
+
+	<-async.done
+
+	return async.sink.Close()
+}
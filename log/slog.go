@@ -0,0 +1,92 @@
+package jlog
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Handler returns a slog.Handler which writes records through the logger, so code
+// using the standard library's log/slog ends up in the same output with the same
+// level and fields.
+func (logger *Logger) Handler() slog.Handler {
+	return &slogHandler{logger: logger}
+}
+
+// slogHandler adapts a Logger to slog.Handler. Attributes of groups are written with
+// the group names as dotted prefixes, e.g. "request.method".
+type slogHandler struct {
+	logger *Logger
+	prefix string
+}
+
+// Convert a slog level to the nearest jlog level.
+func fromSlogLevel(level slog.Level) Level {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+// Enabled reports whether the logger writes records at level.
+func (handler *slogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return handler.logger.Enabled(fromSlogLevel(level))
+}
+
+// Handle writes the record.
+func (handler *slogHandler) Handle(ctx context.Context, record slog.Record) error {
+	fields := make(Fields, record.NumAttrs())
+	record.Attrs(func(attr slog.Attr) bool {
+		addAttr(fields, handler.prefix, attr)
+		return true
+	})
+
+	return handler.logger.print(fromSlogLevel(record.Level), record.Message, fields, false)
+}
+
+// WithAttrs returns a handler whose entries include attrs.
+func (handler *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make(Fields, len(attrs))
+	for _, attr := range attrs {
+		addAttr(fields, handler.prefix, attr)
+	}
+
+	return &slogHandler{logger: handler.logger.With(fields), prefix: handler.prefix}
+}
+
+// WithGroup returns a handler which prefixes the keys of later attributes with name.
+func (handler *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return handler
+	}
+
+	return &slogHandler{logger: handler.logger, prefix: handler.prefix + name + "."}
+}
+
+// Add a slog attribute to fields, flattening groups.
+func addAttr(fields Fields, prefix string, attr slog.Attr) {
+	value := attr.Value.Resolve()
+
+	if value.Kind() == slog.KindGroup {
+		groupPrefix := prefix
+		if attr.Key != "" {
+			groupPrefix = prefix + attr.Key + "."
+		}
+
+		for _, groupAttr := range value.Group() {
+			addAttr(fields, groupPrefix, groupAttr)
+		}
+		return
+	}
+
+	if attr.Key == "" {
+		return
+	}
+
+	fields[prefix+attr.Key] = value.Any()
+}
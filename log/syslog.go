@@ -0,0 +1,199 @@
+package jlog
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The facility of syslog messages (local0) and the SD-ID of the structured data
+// element holding the properties. 32473 is the private enterprise number reserved
+// for documentation.
+const (
+	syslogFacility = 16
+	syslogSDID     = "meow@32473"
+)
+
+// Map the levels to syslog severities.
+var syslogSeverities = map[Level]int{
+	LevelDebug: 7,
+	LevelInfo:  6,
+	LevelWarn:  4,
+	LevelError: 3,
+	LevelFatal: 2,
+}
+
+// SyslogSink sends entries as RFC 5424 messages over UDP, TCP or a unix socket. It
+// reconnects after a failed write, so put it behind NewAsyncSink() to keep an
+// unreachable daemon from slowing down requests.
+type SyslogSink struct {
+	network  string
+	address  string
+	appName  string
+	hostname string
+	conn     net.Conn
+}
+
+// NewSyslogSink returns a sink for the daemon at rawURL, one of "udp://host:514",
+// "tcp://host:601" or "unix:///dev/log". The connection is made on the first write.
+func NewSyslogSink(rawURL string, appName string) (*SyslogSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	sink := &SyslogSink{network: u.Scheme, appName: appName}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("syslog: missing host in %q", rawURL)
+		}
+		sink.address = u.Host
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("syslog: missing socket path in %q", rawURL)
+		}
+		sink.address = u.Path
+	default:
+		return nil, fmt.Errorf("syslog: unsupported network %q", u.Scheme)
+	}
+
+	sink.hostname, err = os.Hostname()
+	if err != nil || sink.hostname == "" {
+		sink.hostname = "-"
+	}
+
+	return sink, nil
+}
+
+// Connect to the daemon. Unix sockets are tried as datagram sockets first, which is
+// what /dev/log usually is.
+func (sink *SyslogSink) connect() error {
+	var err error
+	if sink.network == "unix" {
+		sink.conn, err = net.DialTimeout("unixgram", sink.address, 5*time.Second)
+		if err == nil {
+			sink.network = "unixgram"
+			return nil
+		}
+	}
+
+	sink.conn, err = net.DialTimeout(sink.network, sink.address, 5*time.Second)
+	return err
+}
+
+// WriteEntry sends the entry, reconnecting once if the write fails.
+func (sink *SyslogSink) WriteEntry(entry Entry) error {
+	message := sink.frame(sink.format(entry))
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if sink.conn == nil {
+			if err = sink.connect(); err != nil {
+				continue
+			}
+		}
+
+		sink.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err = sink.conn.Write(message); err == nil {
+			return nil
+		}
+
+		sink.conn.Close()
+		sink.conn = nil
+	}
+
+	return fmt.Errorf("syslog: %w", err)
+}
+
+// Frame the message for the transport. Stream transports need to know where one
+// message ends: TCP uses octet counting (RFC 6587) and unix streams a newline.
+func (sink *SyslogSink) frame(message string) []byte {
+	switch sink.network {
+	case "tcp":
+		return []byte(strconv.Itoa(len(message)) + " " + message)
+	case "unix":
+		return []byte(message + "\n")
+	default:
+		return []byte(message)
+	}
+}
+
+// Format an entry as an RFC 5424 message, e.g.
+//
+//	<134>1 2024-01-02T15:04:05.000Z host meow 42 - [meow@32473 method="GET"] message
+func (sink *SyslogSink) format(entry Entry) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d - ",
+		syslogFacility*8+syslogSeverities[entry.Level],
+		entry.Time.UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		sink.hostname,
+		sink.appName,
+		os.Getpid(),
+	)
+
+	keys := make([]string, 0, len(entry.Properties))
+	for key := range entry.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if len(keys) == 0 && entry.Trace == "" {
+		b.WriteString("-")
+	} else {
+		b.WriteString("[" + syslogSDID)
+		for _, key := range keys {
+			b.WriteString(" " + sdName(key) + `="` + sdEscape(fmt.Sprint(entry.Properties[key])) + `"`)
+		}
+
+		if entry.Trace != "" {
+			b.WriteString(` trace="` + sdEscape(entry.Trace) + `"`)
+		}
+		b.WriteString("]")
+	}
+
+	b.WriteString(" " + entry.Message)
+
+	return b.String()
+}
+
+// Return key as a valid SD-NAME: printable ASCII without '=', ' ', ']' and '"', at most
+// 32 characters.
+func sdName(key string) string {
+	name := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+
+	if len(name) > 32 {
+		name = name[:32]
+	}
+
+	return name
+}
+
+// Escape '"', '\' and ']' in a PARAM-VALUE.
+func sdEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+// Close closes the connection.
+func (sink *SyslogSink) Close() error {
+	if sink.conn == nil {
+		return nil
+	}
+
+	err := sink.conn.Close()
+	sink.conn = nil
+
+	return err
+}
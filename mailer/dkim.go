@@ -0,0 +1,270 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The headers signed when a message has them. From is always there.
+var dkimHeaders = []string{"From", "To", "Subject", "Date", "Message-ID", "MIME-Version", "Content-Type", "List-Unsubscribe", "List-Unsubscribe-Post"}
+
+var (
+	// ErrNoDKIMSignature is returned by VerifyDKIM() for a message without signature.
+	ErrNoDKIMSignature = errors.New("message has no DKIM signature")
+
+	// ErrInvalidDKIMSignature is returned by VerifyDKIM() when the signature or the
+	// body hash doesn't match.
+	ErrInvalidDKIMSignature = errors.New("invalid DKIM signature")
+)
+
+// DKIM signs messages for a domain (RFC 6376), with relaxed canonicalization of the
+// headers and the body. The public key must be published in DNS as a TXT record at
+// "<selector>._domainkey.<domain>".
+type DKIM struct {
+	domain   string
+	selector string
+	key      crypto.Signer
+}
+
+// The NewDKIM() function returns a DKIM signer using an RSA or Ed25519 key.
+func NewDKIM(domain string, selector string, key crypto.Signer) (*DKIM, error) {
+	switch key.(type) {
+	case *rsa.PrivateKey, ed25519.PrivateKey:
+	default:
+		return nil, fmt.Errorf("unsupported DKIM key type %T", key)
+	}
+
+	return &DKIM{domain: domain, selector: selector, key: key}, nil
+}
+
+// The LoadDKIM() function returns a DKIM signer using the PEM encoded PKCS #1 or
+// PKCS #8 key in keyFile.
+func LoadDKIM(domain string, selector string, keyFile string) (*DKIM, error) {
+	pemBytes, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", keyFile)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("DKIM key %s: %w", keyFile, err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported DKIM key type %T", key)
+	}
+
+	return NewDKIM(domain, selector, signer)
+}
+
+// Return the name of the signing algorithm.
+func (dkim *DKIM) algorithm() string {
+	if _, ok := dkim.key.(ed25519.PrivateKey); ok {
+		return "ed25519-sha256"
+	}
+
+	return "rsa-sha256"
+}
+
+// Sign returns the DKIM-Signature header line, CRLF included, for a message in MIME
+// format. The line goes before the other headers.
+func (dkim *DKIM) Sign(message []byte) (string, error) {
+	headers, body := splitMessage(message)
+
+	signed := []string{}
+	for _, name := range dkimHeaders {
+		if _, found := findHeader(headers, name); found {
+			signed = append(signed, strings.ToLower(name))
+		}
+	}
+
+	bodyHash := sha256.Sum256(canonicalBody(body))
+
+	value := strings.Join([]string{
+		"v=1",
+		"a=" + dkim.algorithm(),
+		"c=relaxed/relaxed",
+		"d=" + dkim.domain,
+		"s=" + dkim.selector,
+		"t=" + strconv.FormatInt(time.Now().Unix(), 10),
+		"h=" + strings.Join(signed, ":"),
+		"bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]),
+		"b=",
+	}, ";\r\n\t")
+
+	digest := headerHash(headers, signed, "DKIM-Signature: "+value)
+
+	var opts crypto.SignerOpts = crypto.SHA256
+	if dkim.algorithm() == "ed25519-sha256" {
+		opts = crypto.Hash(0)
+	}
+
+	signature, err := dkim.key.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return "", err
+	}
+
+	return "DKIM-Signature: " + value + base64.StdEncoding.EncodeToString(signature) + "\r\n", nil
+}
+
+// The VerifyDKIM() function checks the DKIM signature of a message in MIME format
+// with publicKey, without looking the key up in DNS. It is meant for checking the
+// messages caught by a test SMTP server.
+func VerifyDKIM(message []byte, publicKey crypto.PublicKey) error {
+	headers, body := splitMessage(message)
+
+	header, found := findHeader(headers, "DKIM-Signature")
+	if !found {
+		return ErrNoDKIMSignature
+	}
+
+	_, value, _ := strings.Cut(header, ":")
+
+	tags := make(map[string]string)
+	for _, tag := range strings.Split(value, ";") {
+		key, value, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(key)] = strings.Join(strings.Fields(value), "")
+	}
+
+	if tags["v"] != "1" || tags["c"] != "relaxed/relaxed" {
+		return fmt.Errorf("%w: unsupported version or canonicalization", ErrInvalidDKIMSignature)
+	}
+
+	bodyHash := sha256.Sum256(canonicalBody(body))
+	if tags["bh"] != base64.StdEncoding.EncodeToString(bodyHash[:]) {
+		return fmt.Errorf("%w: body hash mismatch", ErrInvalidDKIMSignature)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDKIMSignature, err)
+	}
+
+	// The signature was made over its own header with an empty "b=" tag, which is
+	// the last one.
+	index := strings.LastIndex(header, ";")
+	tag := strings.TrimLeft(header[index+1:], " \t\r\n")
+	if !strings.HasPrefix(tag, "b=") {
+		return fmt.Errorf("%w: the b= tag must be the last one", ErrInvalidDKIMSignature)
+	}
+	digest := headerHash(headers, strings.Split(tags["h"], ":"), header[:len(header)-len(tag)+2])
+
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		if tags["a"] != "rsa-sha256" || rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, signature) != nil {
+			return ErrInvalidDKIMSignature
+		}
+	case ed25519.PublicKey:
+		if tags["a"] != "ed25519-sha256" || !ed25519.Verify(key, digest, signature) {
+			return ErrInvalidDKIMSignature
+		}
+	default:
+		return fmt.Errorf("unsupported DKIM key type %T", publicKey)
+	}
+
+	return nil
+}
+
+// Return the SHA-256 hash of the signed headers followed by the signature header,
+// all in relaxed canonicalization.
+func headerHash(headers []string, signed []string, signatureHeader string) []byte {
+	hash := sha256.New()
+
+	for _, name := range signed {
+		if header, found := findHeader(headers, name); found {
+			hash.Write([]byte(canonicalHeader(header) + "\r\n"))
+		}
+	}
+
+	hash.Write([]byte(canonicalHeader(signatureHeader)))
+	return hash.Sum(nil)
+}
+
+// Split a message into its header fields, with their continuation lines, and its body.
+func splitMessage(message []byte) ([]string, []byte) {
+	head, body, _ := bytes.Cut(message, []byte("\r\n\r\n"))
+
+	headers := []string{}
+	for _, line := range strings.Split(string(head), "\r\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(headers) > 0 {
+			headers[len(headers)-1] += "\r\n" + line
+			continue
+		}
+
+		headers = append(headers, line)
+	}
+
+	return headers, body
+}
+
+// Return the last header field named name.
+func findHeader(headers []string, name string) (string, bool) {
+	for i := len(headers) - 1; i >= 0; i-- {
+		key, _, _ := strings.Cut(headers[i], ":")
+		if strings.EqualFold(strings.TrimSpace(key), name) {
+			return headers[i], true
+		}
+	}
+
+	return "", false
+}
+
+// Canonicalize a header field with the relaxed algorithm: a lower case name, unfolded
+// lines and single spaces.
+func canonicalHeader(header string) string {
+	name, value, _ := strings.Cut(header, ":")
+
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Join(strings.FieldsFunc(value, func(r rune) bool { return r == ' ' || r == '\t' }), " ")
+
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + value
+}
+
+// Canonicalize a body with the relaxed algorithm: single spaces, no whitespace at the
+// end of lines and no empty lines at the end.
+func canonicalBody(body []byte) []byte {
+	lines := strings.Split(string(body), "\r\n")
+
+	for i, line := range lines {
+		collapsed := strings.Join(strings.FieldsFunc(line, func(r rune) bool { return r == ' ' || r == '\t' }), " ")
+		if collapsed != "" && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			collapsed = " " + collapsed
+		}
+		lines[i] = collapsed
+	}
+
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if len(lines) == 0 {
+		return nil
+	}
+
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File saves every message as a .eml file in a directory, where it can be opened
+// with any mail client. It is meant for development, where no SMTP server is needed.
+type File struct {
+	dir string
+}
+
+// The NewFile() function returns a File transport writing to dir, which is created
+// when needed.
+func NewFile(dir string) *File {
+	return &File{dir: dir}
+}
+
+// Deliver writes message to a new file named after the time it was sent. The file
+// is written under a temporary name and renamed, so readers never see half of it.
+func (transport *File) Deliver(ctx context.Context, message *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(transport.dir, 0o755); err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + hex.EncodeToString(suffix) + ".eml"
+
+	file, err := os.CreateTemp(transport.dir, ".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := message.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), filepath.Join(transport.dir, name))
+}
+
+// Ping checks that the directory exists or can be created.
+func (transport *File) Ping(ctx context.Context) error {
+	return os.MkdirAll(transport.dir, 0o755)
+}
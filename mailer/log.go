@@ -0,0 +1,37 @@
+package mailer
+
+import (
+	jlog "Meow/log"
+	"context"
+)
+
+// Log only logs the messages, with their plain text body, instead of sending them.
+type Log struct {
+	logger *jlog.Logger
+}
+
+// The NewLog() function returns a Log transport writing to logger.
+func NewLog(logger *jlog.Logger) *Log {
+	return &Log{logger: logger}
+}
+
+// Deliver logs message at the info level.
+func (transport *Log) Deliver(ctx context.Context, message *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	transport.logger.Info("email not sent", jlog.Fields{
+		"to":       message.To,
+		"subject":  message.Subject,
+		"template": message.Template,
+		"body":     message.PlainBody,
+	})
+
+	return nil
+}
+
+// Ping always succeeds.
+func (transport *Log) Ping(ctx context.Context) error {
+	return nil
+}
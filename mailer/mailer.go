@@ -1,9 +1,21 @@
+// Package mailer renders the email templates and hands the messages to a Transport:
+// an SMTP server, a directory of .eml files, memory or the log, chosen by the
+// -mail-driver setting.
 package mailer
 
 import (
+	"Meow/internal/metrics"
+	"Meow/internal/tracing"
 	"bytes"
+	"context"
+	"crypto/rand"
 	"embed"
-	"text/template"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
 	"time"
 
 	"github.com/go-gomail/gomail"
@@ -12,71 +24,262 @@ import (
 //go:embed "templates/*"
 var templateFS embed.FS
 
-type Mailer struct {
-	dialer *gomail.Dialer
-	sender string
+// Count the outcome of every Send() call.
+var sends = metrics.NewCounterVec("mailer_sends_total", "Total number of emails by outcome.", "template", "outcome")
+
+// ErrSuppressed is returned by Send() when the recipient must not get the message.
+var ErrSuppressed = errors.New("recipient is suppressed")
+
+// The names of the transports accepted by -mail-driver.
+var Drivers = []string{"smtp", "file", "memory", "log"}
+
+// A Mailer sends emails rendered from the embedded templates.
+type Mailer interface {
+	// Send renders the template name in locale with data and sends it to recipient. It
+	// gives up when ctx is done or the per-message timeout passes.
+	Send(ctx context.Context, recipient string, locale string, name string, data interface{}) error
+
+	// Ping checks that the transport can take messages.
+	Ping(ctx context.Context) error
 }
 
-func New(host string, port int, username string, password string, sender string) Mailer {
-	// Initialize a new mail.Dialer instance with the given SMTP server settings.
-	dialer := gomail.NewDialer(host, port, username, password)
+// A Message is a rendered email.
+type Message struct {
+	From      string
+	To        string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
 
-	// Return a Mailer instance containing the dialer and sender information.
-	return Mailer{
-		dialer: dialer,
-		sender: sender,
-	}
+	// The Date and Message-ID headers, set once by Send() so retries don't change
+	// them.
+	Date      time.Time
+	MessageID string
+
+	// The one-click unsubscribe URL of bulk emails, sent as List-Unsubscribe.
+	UnsubscribeURL string
+
+	// The template the message was rendered from, e.g. "user_welcome".
+	Template string
+
+	// Whether the template is in the bulk category rather than transactional.
+	Bulk bool
+
+	// Signs the message when it is written, if set.
+	dkim *DKIM
 }
 
-// Define a Send() method on the Mailer type.
-func (mailer Mailer) Send(recipient string, templateFile string, data interface{}) error {
-	// Use the ParseFS() method to parse the required template file from the embedded
-	// file system.
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+// WriteTo writes the message in MIME format, as sent over SMTP or saved in a .eml
+// file, signed with DKIM if configured.
+func (message *Message) WriteTo(w io.Writer) (int64, error) {
+	m := gomail.NewMessage()
+	m.SetHeader("To", message.To)
+	m.SetHeader("From", message.From)
+	m.SetHeader("Subject", message.Subject)
+
+	date := message.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	m.SetDateHeader("Date", date)
+
+	if message.MessageID != "" {
+		m.SetHeader("Message-ID", message.MessageID)
+	}
+
+	if message.UnsubscribeURL != "" {
+		m.SetHeader("List-Unsubscribe", "<"+message.UnsubscribeURL+">")
+		m.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+
+	m.SetBody("text/plain", message.PlainBody)
+	m.AddAlternative("text/html", message.HTMLBody)
+
+	if message.dkim == nil {
+		return m.WriteTo(w)
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := m.WriteTo(buf); err != nil {
+		return 0, err
+	}
+
+	signature, err := message.dkim.Sign(buf.Bytes())
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	// Execute the named template "subject", passing in the dynamic data and storing the
-	// result in a bytes.Buffer variable.
-	subject := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	n, err := io.WriteString(w, signature)
 	if err != nil {
+		return int64(n), err
+	}
+
+	rest, err := buf.WriteTo(w)
+	return int64(n) + rest, err
+}
+
+// A Transport delivers rendered messages.
+type Transport interface {
+	Deliver(ctx context.Context, message *Message) error
+	Ping(ctx context.Context) error
+}
+
+// Suppressions tells which recipients must not get emails, e.g. because their address
+// bounced.
+type Suppressions interface {
+	Suppressed(ctx context.Context, recipient string, bulk bool) (bool, error)
+}
+
+// Options configures a Mailer.
+type Options struct {
+	// The From address, like "Meow <no-reply@meow.com>". Its domain is used in the
+	// Message-ID header.
+	Sender string
+
+	// The maximum time for sending one message, retries included.
+	Timeout time.Duration
+
+	// Signs the messages, unless nil.
+	DKIM *DKIM
+
+	// The recipients who are skipped, unless nil.
+	Suppressions Suppressions
+
+	// The one-click unsubscribe endpoint in the List-Unsubscribe header of bulk
+	// messages, and the secret of its tokens. Bulk messages have no such header if it
+	// is empty.
+	UnsubscribeURL    string
+	UnsubscribeSecret string
+}
+
+type mailer struct {
+	transport Transport
+	templates *Templates
+	options   Options
+}
+
+// The New() function returns a Mailer sending the messages rendered from templates
+// with transport.
+func New(transport Transport, templates *Templates, options Options) Mailer {
+	return &mailer{
+		transport: transport,
+		templates: templates,
+		options:   options,
+	}
+}
+
+// Define a Send() method on the mailer type. It gives up retrying when ctx is done and
+// returns the last error. Sending is traced in a child span of ctx, with one span per
+// delivery attempt.
+func (mailer *mailer) Send(ctx context.Context, recipient string, locale string, name string, data interface{}) error {
+	ctx, span := tracing.Start(ctx, "send email "+name, tracing.KindInternal)
+	defer span.End()
+
+	span.SetAttribute("mail.template", name)
+	span.SetAttribute("mail.locale", locale)
+
+	err := mailer.send(ctx, span, recipient, locale, name, data)
+	if errors.Is(err, ErrSuppressed) {
+		span.SetAttribute("mail.suppressed", true)
 		return err
 	}
 
-	plainBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
+	span.RecordError(err)
+	return err
+}
+
+// Render and deliver one message for Send().
+func (mailer *mailer) send(ctx context.Context, span *tracing.Span, recipient string, locale string, name string, data interface{}) error {
+	message, err := mailer.templates.Render(name, locale, data)
 	if err != nil {
+		sends.Inc(name, "failed")
 		return err
 	}
 
-	htmlBody := new(bytes.Buffer)
-	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
+	if mailer.options.Suppressions != nil {
+		suppressed, err := mailer.options.Suppressions.Suppressed(ctx, recipient, message.Bulk)
+		if err != nil {
+			sends.Inc(name, "failed")
+			return err
+		}
+
+		if suppressed {
+			sends.Inc(name, "suppressed")
+			return ErrSuppressed
+		}
+	}
+
+	message.From = mailer.options.Sender
+	message.To = recipient
+	message.Date = time.Now()
+	message.dkim = mailer.options.DKIM
+
+	message.MessageID, err = newMessageID(mailer.options.Sender)
 	if err != nil {
+		sends.Inc(name, "failed")
 		return err
 	}
 
-	// Use the mail.NewMessage() function to initialize a new mail.Message instance.
-	message := gomail.NewMessage()
-	message.SetHeader("To", recipient)
-	message.SetHeader("From", mailer.sender)
-	message.SetHeader("Subject", subject.String())
-	message.SetBody("text/plain", plainBody.String())
-	message.AddAlternative("text/html", htmlBody.String())
+	if message.Bulk && mailer.options.UnsubscribeURL != "" {
+		message.UnsubscribeURL = UnsubscribeURL(mailer.options.UnsubscribeURL, mailer.options.UnsubscribeSecret, recipient)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, mailer.options.Timeout)
+	defer cancel()
+
+	span.SetAttribute("mail.message_id", message.MessageID)
 
-	// Call the DialAndSend() method on the dialer, passing in the message to send. This
-	// opens a connection to the SMTP server, sends the message, then closes the
-	// connection.
 	for i := 0; i < 4; i++ {
-		err = mailer.dialer.DialAndSend(message)
+		err = mailer.deliver(ctx, message, i+1)
 		if err == nil {
+			sends.Inc(name, "sent")
 			return nil
 		}
 
-		// Wait 500 millisecond
-		time.Sleep(500 * time.Millisecond)
+		// Wait 500 millisecond, unless we are told to stop.
+		select {
+		case <-ctx.Done():
+			sends.Inc(name, "cancelled")
+			return fmt.Errorf("%w (last error: %v)", ctx.Err(), err)
+		case <-time.After(500 * time.Millisecond):
+		}
 	}
 
+	sends.Inc(name, "failed")
 	return err
 }
+
+// Hand message to the transport in a span of its own, for the given attempt.
+func (mailer *mailer) deliver(ctx context.Context, message *Message, attempt int) error {
+	ctx, span := tracing.Start(ctx, "deliver email", tracing.KindClient)
+	defer span.End()
+
+	span.SetAttribute("mail.attempt", attempt)
+
+	err := mailer.transport.Deliver(ctx, message)
+	span.RecordError(err)
+
+	return err
+}
+
+// Return a new Message-ID like "<5f2c...@meow.com>", in the domain of sender.
+func newMessageID(sender string) (string, error) {
+	domain := "localhost"
+	if address, err := mail.ParseAddress(sender); err == nil {
+		if _, host, found := strings.Cut(address.Address, "@"); found {
+			domain = host
+		}
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return "<" + hex.EncodeToString(random) + "@" + domain + ">", nil
+}
+
+// Ping checks the transport.
+func (mailer *mailer) Ping(ctx context.Context) error {
+	return mailer.transport.Ping(ctx)
+}
@@ -0,0 +1,278 @@
+package mailer_test
+
+import (
+	"Meow/internal/smtptest"
+	"Meow/internal/tracing"
+	"Meow/mailer"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// A layout, a transactional template and a bulk one.
+var templateFS = fstest.MapFS{
+	"layout.tmpl": {Data: []byte(`{{define "layout"}}<html lang="{{lang}}">{{template "htmlBody" .}}</html>{{end}}`)},
+	"en/user_welcome.tmpl": {Data: []byte(`{{define "subject"}}Welcome to Meow!{{end}}
+{{define "plainBody"}}Hi {{.name}},  thanks for signing up.{{end}}
+{{define "htmlBody"}}<p>Hi {{.name}}, thanks for signing up.</p>{{end}}`)},
+	"en/newsletter.tmpl": {Data: []byte(`{{define "category"}}bulk{{end}}
+{{define "subject"}}New movies this week{{end}}
+{{define "plainBody"}}Vertigo, Rear Window and Casablanca.{{end}}
+{{define "htmlBody"}}<p>Vertigo, Rear Window and Casablanca.</p>{{end}}`)},
+}
+
+// Suppress the addresses in the map; a true value suppresses transactional emails too.
+type suppressions map[string]bool
+
+func (s suppressions) Suppressed(ctx context.Context, recipient string, bulk bool) (bool, error) {
+	all, found := s[recipient]
+	return found && (bulk || all), nil
+}
+
+// Return a mailer sending to a new smtptest server with options, and the server.
+func newMailer(t *testing.T, options mailer.Options) (mailer.Mailer, *smtptest.Server) {
+	t.Helper()
+
+	templates, err := mailer.ParseTemplates(templateFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := smtptest.NewServer()
+	t.Cleanup(server.Close)
+
+	options.Sender = "Meow <no-reply@meow.test>"
+	options.Timeout = 5 * time.Second
+
+	return mailer.New(server.Transport(), templates, options), server
+}
+
+func wait(t *testing.T, server *smtptest.Server, recipient string) *smtptest.Message {
+	t.Helper()
+
+	message, err := server.WaitFor(recipient, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if message.ParseError != nil {
+		t.Fatalf("parse error: %v", message.ParseError)
+	}
+
+	return message
+}
+
+func TestSendDKIM(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		key      crypto.Signer
+		template string
+	}{
+		{"rsa", rsaKey, "user_welcome"},
+		{"ed25519", ed25519Key, "user_welcome"},
+		{"bulk", ed25519Key, "newsletter"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			signer, err := mailer.NewDKIM("meow.test", "mail", test.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			m, server := newMailer(t, mailer.Options{
+				DKIM:              signer,
+				UnsubscribeURL:    "https://meow.test/v1/unsubscribe",
+				UnsubscribeSecret: "secret",
+			})
+
+			if err := m.Send(context.Background(), "alice@example.com", "en", test.template, map[string]string{"name": "Alice"}); err != nil {
+				t.Fatal(err)
+			}
+
+			message := wait(t, server, "alice@example.com")
+			if err := mailer.VerifyDKIM(message.Raw, test.key.Public()); err != nil {
+				t.Fatalf("got %v verifying the signature", err)
+			}
+
+			// Changing a signed header breaks the signature.
+			tampered := strings.Replace(string(message.Raw), "alice@example.com", "mallory@example.com", 1)
+			if err := mailer.VerifyDKIM([]byte(tampered), test.key.Public()); !errors.Is(err, mailer.ErrInvalidDKIMSignature) {
+				t.Errorf("got %v verifying a changed message, want %v", err, mailer.ErrInvalidDKIMSignature)
+			}
+		})
+	}
+}
+
+func TestSendHeaders(t *testing.T) {
+	m, server := newMailer(t, mailer.Options{
+		UnsubscribeURL:    "https://meow.test/v1/unsubscribe",
+		UnsubscribeSecret: "secret",
+	})
+
+	for _, name := range []string{"user_welcome", "newsletter"} {
+		if err := m.Send(context.Background(), name+"@example.com", "en", name, map[string]string{"name": "Alice"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	welcome := wait(t, server, "user_welcome@example.com")
+	newsletter := wait(t, server, "newsletter@example.com")
+
+	for _, message := range []*smtptest.Message{welcome, newsletter} {
+		if id := message.Header.Get("Message-ID"); !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@meow.test>") {
+			t.Errorf("got Message-ID %q", id)
+		}
+
+		if _, err := message.Header.Date(); err != nil {
+			t.Errorf("got %v parsing the Date header %q", err, message.Header.Get("Date"))
+		}
+	}
+
+	if welcome.Header.Get("Message-ID") == newsletter.Header.Get("Message-ID") {
+		t.Errorf("got the same Message-ID %q twice", welcome.Header.Get("Message-ID"))
+	}
+
+	// Only bulk emails can be unsubscribed from.
+	if unsubscribe := welcome.Header.Get("List-Unsubscribe"); unsubscribe != "" {
+		t.Errorf("got List-Unsubscribe %q on a transactional email", unsubscribe)
+	}
+
+	unsubscribe := newsletter.Header.Get("List-Unsubscribe")
+	if !strings.HasPrefix(unsubscribe, "<") || !strings.HasSuffix(unsubscribe, ">") {
+		t.Fatalf("got List-Unsubscribe %q", unsubscribe)
+	}
+
+	link, err := url.Parse(strings.Trim(unsubscribe, "<>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := link.Query()
+	if query.Get("email") != "newsletter@example.com" || !mailer.VerifyUnsubscribeToken("secret", query.Get("email"), query.Get("token")) {
+		t.Errorf("got unsubscribe URL %s", link)
+	}
+
+	if post := newsletter.Header.Get("List-Unsubscribe-Post"); post != "List-Unsubscribe=One-Click" {
+		t.Errorf("got List-Unsubscribe-Post %q, want List-Unsubscribe=One-Click", post)
+	}
+}
+
+func TestSendSuppressed(t *testing.T) {
+	m, server := newMailer(t, mailer.Options{
+		Suppressions: suppressions{"bounced@example.com": true, "unsubscribed@example.com": false},
+	})
+
+	tests := []struct {
+		recipient string
+		template  string
+		want      error
+	}{
+		{"bounced@example.com", "user_welcome", mailer.ErrSuppressed},
+		{"bounced@example.com", "newsletter", mailer.ErrSuppressed},
+		{"unsubscribed@example.com", "newsletter", mailer.ErrSuppressed},
+		{"unsubscribed@example.com", "user_welcome", nil},
+		{"alice@example.com", "newsletter", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.recipient+" "+test.template, func(t *testing.T) {
+			server.Reset()
+
+			err := m.Send(context.Background(), test.recipient, "en", test.template, map[string]string{"name": "Alice"})
+			if !errors.Is(err, test.want) {
+				t.Fatalf("got %v, want %v", err, test.want)
+			}
+
+			// Send() returns once the server accepted the message.
+			sent := len(server.Messages()) == 1
+			if sent != (test.want == nil) {
+				t.Errorf("got %d messages sent", len(server.Messages()))
+			}
+		})
+	}
+}
+
+// Sending is traced in a child span of the context, with one span per attempt.
+func TestSendSpans(t *testing.T) {
+	exporter := tracing.NewInMemoryExporter()
+	tracing.SetExporter(exporter, nil)
+	t.Cleanup(func() { tracing.Shutdown(context.Background()) })
+
+	m, server := newMailer(t, mailer.Options{
+		Suppressions: suppressions{"bounced@example.com": true},
+	})
+
+	ctx, parent := tracing.Start(context.Background(), "job send_welcome_email", tracing.KindConsumer)
+
+	// The first attempt fails temporarily.
+	server.FailNext(451)
+	if err := m.Send(ctx, "alice@example.com", "en", "user_welcome", map[string]string{"name": "Alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Send(ctx, "bounced@example.com", "en", "user_welcome", nil); !errors.Is(err, mailer.ErrSuppressed) {
+		t.Fatalf("got %v, want %v", err, mailer.ErrSuppressed)
+	}
+
+	parent.End()
+	tracing.ForceFlush()
+
+	spans := exporter.Spans()
+	if len(spans) != 5 {
+		t.Fatalf("got %d spans, want 5", len(spans))
+	}
+
+	first, second, sent, suppressed := spans[0], spans[1], spans[2], spans[3]
+
+	for _, span := range spans {
+		if span.TraceID != parent.SpanContext().TraceID {
+			t.Errorf("got trace %s for %s, want %s", span.TraceID, span.Name, parent.SpanContext().TraceID)
+		}
+	}
+
+	if sent.Name != "send email user_welcome" || sent.ParentSpanID != parent.SpanContext().SpanID || sent.Error != "" {
+		t.Errorf("got span %+v, want a child of the job span", sent)
+	}
+
+	if id, _ := sent.Attributes["mail.message_id"].(string); !strings.HasSuffix(id, "@meow.test>") {
+		t.Errorf("got message id %v", sent.Attributes["mail.message_id"])
+	}
+
+	for i, span := range []tracing.SpanData{first, second} {
+		if span.Name != "deliver email" || span.Kind != tracing.KindClient || span.ParentSpanID != sent.SpanID {
+			t.Errorf("got span %+v, want a delivery of %s", span, sent.SpanID)
+		}
+
+		if span.Attributes["mail.attempt"] != i+1 {
+			t.Errorf("got attempt %v, want %d", span.Attributes["mail.attempt"], i+1)
+		}
+	}
+
+	if first.Error == "" || second.Error != "" {
+		t.Errorf("got errors %q and %q, want the first attempt to fail", first.Error, second.Error)
+	}
+
+	// Skipping a recipient isn't an error.
+	if suppressed.Attributes["mail.suppressed"] != true || suppressed.Error != "" {
+		t.Errorf("got span %+v, want a suppressed send", suppressed)
+	}
+}
@@ -0,0 +1,66 @@
+package mailer
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory keeps the messages in memory instead of sending them, so tests can check
+// what would have been sent.
+type Memory struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+// The NewMemory() function returns an empty Memory transport.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Deliver records message.
+func (transport *Memory) Deliver(ctx context.Context, message *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	transport.messages = append(transport.messages, message)
+	return nil
+}
+
+// Ping always succeeds.
+func (transport *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Messages returns the recorded messages, oldest first.
+func (transport *Memory) Messages() []*Message {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	return append([]*Message(nil), transport.messages...)
+}
+
+// Last returns the newest message sent to recipient, or nil if there is none.
+func (transport *Memory) Last(recipient string) *Message {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	for i := len(transport.messages) - 1; i >= 0; i-- {
+		if transport.messages[i].To == recipient {
+			return transport.messages[i]
+		}
+	}
+
+	return nil
+}
+
+// Reset forgets the recorded messages.
+func (transport *Memory) Reset() {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	transport.messages = nil
+}
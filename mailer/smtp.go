@@ -0,0 +1,228 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+)
+
+// SMTP delivers messages to an SMTP server, one connection per message. Servers on
+// port 465 are spoken to with implicit TLS, others are upgraded with STARTTLS when
+// they offer it.
+type SMTP struct {
+	host     string
+	port     int
+	username string
+	password string
+}
+
+// The NewSMTP() function returns an SMTP transport for the server at host and port,
+// authenticating with username and password unless username is empty.
+func NewSMTP(host string, port int, username string, password string) *SMTP {
+	return &SMTP{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+	}
+}
+
+// Deliver sends message. The connection is closed when ctx is done.
+func (transport *SMTP) Deliver(ctx context.Context, message *Message) error {
+	conn, err := transport.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Close the connection when ctx is done, so a hanging server doesn't outlive it.
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	err = transport.send(conn, message)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return err
+}
+
+// Speak SMTP on conn to send message.
+func (transport *SMTP) send(conn net.Conn, message *Message) error {
+	client, err := smtp.NewClient(conn, transport.host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if !transport.implicitTLS() {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: transport.host}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if transport.username != "" {
+		if ok, mechanisms := client.Extension("AUTH"); ok {
+			if err := client.Auth(transport.auth(mechanisms)); err != nil {
+				return err
+			}
+		}
+	}
+
+	from, err := envelopeAddress(message.From)
+	if err != nil {
+		return err
+	}
+
+	to, err := envelopeAddress(message.To)
+	if err != nil {
+		return err
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := message.WriteTo(writer); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// Ping checks that the SMTP server accepts connections and greets us with a 220 reply.
+func (transport *SMTP) Ping(ctx context.Context) error {
+	conn, err := transport.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	greeting, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+
+	if !strings.HasPrefix(greeting, "220") {
+		return fmt.Errorf("unexpected SMTP greeting: %s", strings.TrimSpace(greeting))
+	}
+
+	return nil
+}
+
+// Connect to the server, with TLS on port 465, and apply the deadline of ctx to the
+// connection.
+func (transport *SMTP) dial(ctx context.Context) (net.Conn, error) {
+	address := net.JoinHostPort(transport.host, strconv.Itoa(transport.port))
+
+	// Servers using implicit TLS (port 465) only greet after the handshake.
+	var conn net.Conn
+	var err error
+	if transport.implicitTLS() {
+		dialer := tls.Dialer{Config: &tls.Config{ServerName: transport.host}}
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	return conn, nil
+}
+
+// Report whether the server expects TLS from the start.
+func (transport *SMTP) implicitTLS() bool {
+	return transport.port == 465
+}
+
+// Pick the authentication mechanism among those the server offers, preferring
+// CRAM-MD5, then PLAIN, then LOGIN.
+func (transport *SMTP) auth(mechanisms string) smtp.Auth {
+	switch {
+	case strings.Contains(mechanisms, "CRAM-MD5"):
+		return smtp.CRAMMD5Auth(transport.username, transport.password)
+	case strings.Contains(mechanisms, "LOGIN") && !strings.Contains(mechanisms, "PLAIN"):
+		return &loginAuth{username: transport.username, password: transport.password, host: transport.host}
+	default:
+		return smtp.PlainAuth("", transport.username, transport.password, transport.host)
+	}
+}
+
+// Return the bare address of an address like "Meow <no-reply@meow.com>", for the SMTP
+// envelope.
+func envelopeAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", err
+	}
+
+	return parsed.Address, nil
+}
+
+// loginAuth is an smtp.Auth for the LOGIN mechanism, which some servers offer
+// instead of PLAIN.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+// Start refuses to send the credentials in the clear or to another host.
+func (auth *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS {
+		return "", nil, errors.New("unencrypted connection")
+	}
+
+	if server.Name != auth.host {
+		return "", nil, errors.New("wrong host name")
+	}
+
+	return "LOGIN", nil, nil
+}
+
+// Next answers the username and password challenges.
+func (auth *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch {
+	case bytes.Equal(fromServer, []byte("Username:")):
+		return []byte(auth.username), nil
+	case bytes.Equal(fromServer, []byte("Password:")):
+		return []byte(auth.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+	}
+}
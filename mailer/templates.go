@@ -0,0 +1,285 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+)
+
+// The locale used when a user has none, or theirs has no variant of a template.
+const DefaultLocale = "en"
+
+// ErrUnknownTemplate is returned when rendering a template which doesn't exist.
+var ErrUnknownTemplate = errors.New("unknown email template")
+
+// Locales written right to left.
+var rtlLocales = map[string]bool{"ar": true, "fa": true, "he": true, "ur": true}
+
+// Templates holds the parsed email templates. The layout of the HTML part is shared by
+// every template and locale and lives in "layout.tmpl". The templates live in one
+// directory per locale, like "en/user_welcome.tmpl", and define "subject",
+// "plainBody" and "htmlBody", the content of the layout. Emails which aren't needed to
+// use the account, like newsletters, define "category" as "bulk": they get a
+// List-Unsubscribe header and aren't sent to users who unsubscribed. Sample data for
+// previews is read from "samples/<name>.json".
+type Templates struct {
+	sets    map[string]map[string]*templateSet
+	samples map[string]map[string]interface{}
+}
+
+// The parts of one template in one locale. The HTML part is autoescaped.
+type templateSet struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+	bulk bool
+}
+
+// The LoadTemplates() function parses the templates embedded in the binary.
+func LoadTemplates() (*Templates, error) {
+	fsys, err := fs.Sub(templateFS, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseTemplates(fsys)
+}
+
+// The ParseTemplates() function parses every template in fsys. It fails if a template
+// misses a part, or exists in some locale but not in DefaultLocale, which is where
+// the other locales fall back to.
+func ParseTemplates(fsys fs.FS) (*Templates, error) {
+	templates := &Templates{
+		sets:    make(map[string]map[string]*templateSet),
+		samples: make(map[string]map[string]interface{}),
+	}
+
+	files, err := fs.Glob(fsys, "*/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		locale, name := path.Dir(file), strings.TrimSuffix(path.Base(file), ".tmpl")
+
+		set, err := parseTemplateSet(fsys, locale, file)
+		if err != nil {
+			return nil, fmt.Errorf("email template %s: %w", file, err)
+		}
+
+		if templates.sets[locale] == nil {
+			templates.sets[locale] = make(map[string]*templateSet)
+		}
+		templates.sets[locale][name] = set
+	}
+
+	for locale, sets := range templates.sets {
+		for name := range sets {
+			if _, found := templates.sets[DefaultLocale][name]; !found {
+				return nil, fmt.Errorf("email template %s/%s.tmpl has no %s variant", locale, name, DefaultLocale)
+			}
+		}
+	}
+
+	samples, err := fs.Glob(fsys, "samples/*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range samples {
+		js, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		var sample map[string]interface{}
+		if err := json.Unmarshal(js, &sample); err != nil {
+			return nil, fmt.Errorf("email sample %s: %w", file, err)
+		}
+
+		templates.samples[strings.TrimSuffix(path.Base(file), ".json")] = sample
+	}
+
+	return templates, nil
+}
+
+// Parse the text and HTML parts of the template in file, for locale.
+func parseTemplateSet(fsys fs.FS, locale string, file string) (*templateSet, error) {
+	dir := "ltr"
+	if rtlLocales[primaryLanguage(locale)] {
+		dir = "rtl"
+	}
+
+	funcs := map[string]interface{}{
+		"lang": func() string { return locale },
+		"dir":  func() string { return dir },
+	}
+
+	text, err := texttemplate.New("email").Funcs(funcs).ParseFS(fsys, file)
+	if err != nil {
+		return nil, err
+	}
+
+	html, err := htmltemplate.New("email").Funcs(funcs).ParseFS(fsys, "layout.tmpl", file)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, part := range []string{"subject", "plainBody", "htmlBody"} {
+		if text.Lookup(part) == nil {
+			return nil, fmt.Errorf("missing %q", part)
+		}
+	}
+
+	if html.Lookup("layout") == nil {
+		return nil, errors.New(`layout.tmpl must define "layout"`)
+	}
+
+	category := "transactional"
+	if text.Lookup("category") != nil {
+		buf := new(bytes.Buffer)
+		if err := text.ExecuteTemplate(buf, "category", nil); err != nil {
+			return nil, err
+		}
+		category = strings.TrimSpace(buf.String())
+	}
+
+	if category != "transactional" && category != "bulk" {
+		return nil, fmt.Errorf(`"category" must be transactional or bulk, not %q`, category)
+	}
+
+	return &templateSet{text: text, html: html, bulk: category == "bulk"}, nil
+}
+
+// Names returns the names of the templates, like "user_welcome", sorted.
+func (templates *Templates) Names() []string {
+	names := []string{}
+	for name := range templates.sets[DefaultLocale] {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Locales returns the locales having templates, sorted.
+func (templates *Templates) Locales() []string {
+	locales := []string{}
+	for locale := range templates.sets {
+		locales = append(locales, locale)
+	}
+
+	sort.Strings(locales)
+	return locales
+}
+
+// HasLocale reports whether there are templates in locale.
+func (templates *Templates) HasLocale(locale string) bool {
+	_, found := templates.sets[locale]
+	return found
+}
+
+// MatchLocale returns the locale with templates which is preferred most by an
+// Accept-Language header like "fa-IR,fa;q=0.9,en;q=0.8". A language matches the
+// locale of its primary subtag too, so "fa-IR" matches "fa". It returns DefaultLocale
+// if nothing matches.
+func (templates *Templates) MatchLocale(acceptLanguage string) string {
+	type languageRange struct {
+		tag string
+		q   float64
+	}
+
+	ranges := []languageRange{}
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+
+		q := 1.0
+		if key, value, found := strings.Cut(strings.TrimSpace(params), "="); found && strings.TrimSpace(key) == "q" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || parsed < 0 || parsed > 1 {
+				parsed = 0
+			}
+			q = parsed
+		}
+
+		ranges = append(ranges, languageRange{tag: tag, q: q})
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+
+	for _, r := range ranges {
+		if r.q == 0 || r.tag == "*" {
+			break
+		}
+
+		if templates.HasLocale(r.tag) {
+			return r.tag
+		}
+
+		if templates.HasLocale(primaryLanguage(r.tag)) {
+			return primaryLanguage(r.tag)
+		}
+	}
+
+	return DefaultLocale
+}
+
+// Sample returns the sample data of a template, for previews.
+func (templates *Templates) Sample(name string) map[string]interface{} {
+	return templates.samples[name]
+}
+
+// Render renders the template name in locale, or in DefaultLocale if there is no
+// such variant, into a message without sender and recipient.
+func (templates *Templates) Render(name string, locale string, data interface{}) (*Message, error) {
+	set, found := templates.sets[locale][name]
+	if !found {
+		set, found = templates.sets[DefaultLocale][name]
+		if !found {
+			return nil, fmt.Errorf("%w %q", ErrUnknownTemplate, name)
+		}
+	}
+
+	subject := new(bytes.Buffer)
+	if err := set.text.ExecuteTemplate(subject, "subject", data); err != nil {
+		return nil, err
+	}
+
+	plainBody := new(bytes.Buffer)
+	if err := set.text.ExecuteTemplate(plainBody, "plainBody", data); err != nil {
+		return nil, err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	if err := set.html.ExecuteTemplate(htmlBody, "layout", data); err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		Subject:   strings.TrimSpace(subject.String()),
+		PlainBody: strings.TrimSpace(plainBody.String()) + "\n",
+		HTMLBody:  strings.TrimSpace(htmlBody.String()) + "\n",
+		Template:  name,
+		Bulk:      set.bulk,
+	}, nil
+}
+
+// Return the primary language subtag of a language tag, e.g. "fa" for "fa-IR".
+func primaryLanguage(tag string) string {
+	primary, _, _ := strings.Cut(tag, "-")
+	return primary
+}
@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strings"
+)
+
+// The UnsubscribeURL() function returns the one-click unsubscribe URL of recipient:
+// endpoint with the email address and a token proving it came from us.
+func UnsubscribeURL(endpoint string, secret string, recipient string) string {
+	query := url.Values{}
+	query.Set("email", recipient)
+	query.Set("token", UnsubscribeToken(secret, recipient))
+
+	separator := "?"
+	if strings.Contains(endpoint, "?") {
+		separator = "&"
+	}
+
+	return endpoint + separator + query.Encode()
+}
+
+// The UnsubscribeToken() function returns the hex encoded HMAC-SHA256 of the lower
+// case address of recipient, keyed with secret.
+func UnsubscribeToken(secret string, recipient string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.ToLower(recipient)))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// The VerifyUnsubscribeToken() function reports whether token is the unsubscribe
+// token of recipient.
+func VerifyUnsubscribeToken(secret string, recipient string, token string) bool {
+	return hmac.Equal([]byte(token), []byte(UnsubscribeToken(secret, recipient)))
+}
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS jobs (
+    id bigserial PRIMARY KEY,
+    queue text NOT NULL,
+    kind text NOT NULL,
+    payload jsonb NOT NULL,
+    status text NOT NULL DEFAULT 'pending',
+    attempts integer NOT NULL DEFAULT 0,
+    max_attempts integer NOT NULL,
+    run_at timestamp with time zone NOT NULL DEFAULT NOW(),
+    locked_at timestamp with time zone,
+    last_error text NOT NULL DEFAULT '',
+    unique_key text,
+    trace_parent text NOT NULL DEFAULT '',
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT jobs_status_check CHECK (status IN ('pending', 'running', 'failed')),
+    CONSTRAINT jobs_max_attempts_check CHECK (max_attempts > 0)
+);
+
+CREATE INDEX IF NOT EXISTS jobs_ready_idx ON jobs (queue, run_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS jobs_running_idx ON jobs (queue, locked_at) WHERE status = 'running';
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_unique_key_idx ON jobs (unique_key) WHERE status <> 'failed';
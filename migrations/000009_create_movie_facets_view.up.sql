@@ -0,0 +1,15 @@
+CREATE MATERIALIZED VIEW IF NOT EXISTS movie_facets AS
+SELECT 'genres' AS facet, genre AS value, count(*) AS count
+FROM movies, unnest(genres) AS genre GROUP BY genre
+UNION ALL
+SELECT 'decade', ((year / 10) * 10)::text || 's', count(*)
+FROM movies GROUP BY year / 10
+UNION ALL
+SELECT 'runtime_bucket', CASE
+    WHEN runtime < 90 THEN '0-89'
+    WHEN runtime < 120 THEN '90-119'
+    WHEN runtime < 150 THEN '120-149'
+    ELSE '150+' END AS bucket, count(*)
+FROM movies GROUP BY bucket;
+
+CREATE UNIQUE INDEX IF NOT EXISTS movie_facets_facet_value_idx ON movie_facets (facet, value);
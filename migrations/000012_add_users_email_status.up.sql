@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_status text NOT NULL DEFAULT 'ok';
+
+ALTER TABLE users ADD CONSTRAINT users_email_status_check CHECK (email_status IN ('ok', 'unsubscribed', 'bounced', 'complained'));
@@ -0,0 +1,38 @@
+// Package migrations embeds the SQL migrations applied by golang-migrate, so the
+// application knows the schema version it expects.
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+//go:embed *.sql
+var FS embed.FS
+
+// Latest returns the version of the newest migration, e.g. 7 for
+// "000007_add_admin_permission.up.sql".
+func Latest() (int64, error) {
+	names, err := fs.Glob(FS, "*.up.sql")
+	if err != nil {
+		return 0, err
+	}
+
+	var latest int64
+	for _, name := range names {
+		prefix, _, _ := strings.Cut(name, "_")
+
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return 0, err
+		}
+
+		if version > latest {
+			latest = version
+		}
+	}
+
+	return latest, nil
+}